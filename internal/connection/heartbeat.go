@@ -0,0 +1,75 @@
+package connection
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultMaxMissedHeartbeats is how many heartbeats in a row may go
+// unanswered before the connection is treated as dead and re-established
+const DefaultMaxMissedHeartbeats = 3
+
+// Stats reports the health of the connection
+type Stats struct {
+	LastRTT          time.Duration // round-trip time of the last answered heartbeat
+	MissedHeartbeats int           // consecutive heartbeats not yet answered
+	Reconnects       int           // connections established after the first
+}
+
+// heartbeatTracker matches heartbeats to the server's acks. Servers that never
+// ack heartbeats are never considered unresponsive: misses only count once an
+// ack has been seen on the current connection.
+type heartbeatTracker struct {
+	seq      uint64    // sequence number of the last heartbeat sent
+	sentAt   time.Time // when it was sent, zero once acked
+	missed   int
+	acksSeen bool
+	lastRTT  time.Duration
+	mu       sync.Mutex
+}
+
+// reset starts tracking a new connection, keeping the last RTT
+func (h *heartbeatTracker) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sentAt = time.Time{}
+	h.missed = 0
+	h.acksSeen = false
+}
+
+// sent records a heartbeat going out and returns its sequence number and how
+// many heartbeats in a row have now gone unanswered on a connection that
+// acks them
+func (h *heartbeatTracker) sent(now time.Time) (seq uint64, missed int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.sentAt.IsZero() && h.acksSeen {
+		h.missed++
+	}
+	h.seq++
+	h.sentAt = now
+	return h.seq, h.missed
+}
+
+// acked records the server's answer to heartbeat seq. Acks for anything but
+// the last heartbeat sent are ignored.
+func (h *heartbeatTracker) acked(seq uint64, now time.Time) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if seq != h.seq || h.sentAt.IsZero() {
+		return 0, false
+	}
+	h.lastRTT = max(now.Sub(h.sentAt), 0)
+	h.sentAt = time.Time{}
+	h.missed = 0
+	h.acksSeen = true
+	return h.lastRTT, true
+}
+
+func (h *heartbeatTracker) stats() (time.Duration, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastRTT, h.missed
+}
@@ -0,0 +1,66 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatTracker_RTT(t *testing.T) {
+	var h heartbeatTracker
+	start := time.Now()
+
+	seq, missed := h.sent(start)
+	if seq != 1 || missed != 0 {
+		t.Fatalf("expected seq 1 with no misses, got %d, %d", seq, missed)
+	}
+
+	if _, ok := h.acked(seq+1, start.Add(time.Second)); ok {
+		t.Error("expected an ack for an unknown heartbeat to be ignored")
+	}
+
+	rtt, ok := h.acked(seq, start.Add(40*time.Millisecond))
+	if !ok || rtt != 40*time.Millisecond {
+		t.Errorf("expected a 40ms RTT, got %v (ok=%v)", rtt, ok)
+	}
+	if _, ok := h.acked(seq, start.Add(time.Second)); ok {
+		t.Error("expected a duplicate ack to be ignored")
+	}
+
+	if lastRTT, missed := h.stats(); lastRTT != 40*time.Millisecond || missed != 0 {
+		t.Errorf("unexpected stats: rtt %v, missed %d", lastRTT, missed)
+	}
+}
+
+func TestHeartbeatTracker_CountsMisses(t *testing.T) {
+	var h heartbeatTracker
+	now := time.Now()
+
+	// Unanswered heartbeats don't count until the server has acked one
+	h.sent(now)
+	if _, missed := h.sent(now); missed != 0 {
+		t.Fatalf("expected no misses before any ack, got %d", missed)
+	}
+
+	seq, _ := h.sent(now)
+	h.acked(seq, now)
+
+	h.sent(now)
+	h.sent(now)
+	if _, missed := h.sent(now); missed != 2 {
+		t.Errorf("expected 2 misses, got %d", missed)
+	}
+
+	// A late ack of the latest heartbeat clears the count
+	seq, _ = h.sent(now)
+	h.acked(seq, now)
+	if _, missed := h.stats(); missed != 0 {
+		t.Errorf("expected misses reset by an ack, got %d", missed)
+	}
+
+	// A new connection starts over but keeps the last RTT
+	h.sent(now)
+	h.reset()
+	if _, missed := h.sent(now); missed != 0 {
+		t.Errorf("expected no misses after reset, got %d", missed)
+	}
+}
@@ -0,0 +1,97 @@
+package connection
+
+import (
+	"sync"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// MaxJournalEntries is how many unacknowledged command results are kept for
+// resending; the oldest are dropped beyond it
+const MaxJournalEntries = 1000
+
+// resultJournal keeps the messages that report how a command ended until the
+// server acknowledges them, so results produced or in flight while the
+// connection was down are resent after reconnecting
+type resultJournal struct {
+	entries map[string][]byte // command ID -> marshaled result
+	order   []string          // command IDs, oldest first
+	mu      sync.Mutex
+}
+
+func newResultJournal() *resultJournal {
+	return &resultJournal{entries: make(map[string][]byte)}
+}
+
+// add records a command's result, replacing any earlier one for the command
+func (j *resultJournal) add(id string, data []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, ok := j.entries[id]; !ok {
+		j.order = append(j.order, id)
+	}
+	j.entries[id] = data
+
+	for len(j.order) > MaxJournalEntries {
+		delete(j.entries, j.order[0])
+		j.order = j.order[1:]
+	}
+}
+
+// ack forgets the results the server has received
+func (j *resultJournal) ack(ids []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	removed := false
+	for _, id := range ids {
+		if _, ok := j.entries[id]; ok {
+			delete(j.entries, id)
+			removed = true
+		}
+	}
+	if !removed {
+		return
+	}
+
+	order := j.order[:0]
+	for _, id := range j.order {
+		if _, ok := j.entries[id]; ok {
+			order = append(order, id)
+		}
+	}
+	j.order = order
+}
+
+// clear forgets every result, for a server that doesn't acknowledge them
+func (j *resultJournal) clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = make(map[string][]byte)
+	j.order = nil
+}
+
+// pending returns the unacknowledged results, oldest first
+func (j *resultJournal) pending() [][]byte {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	pending := make([][]byte, 0, len(j.order))
+	for _, id := range j.order {
+		pending = append(pending, j.entries[id])
+	}
+	return pending
+}
+
+// resultID returns the command ID of a message that reports how a command
+// ended, or "" for any other message
+func resultID(msg interface{}) string {
+	switch m := msg.(type) {
+	case *messages.CompleteMessage:
+		return m.ID
+	case *messages.RejectedMessage:
+		return m.ID
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package connection
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestResultJournal_AddAndAck(t *testing.T) {
+	j := newResultJournal()
+
+	j.add("cmd_1", []byte("one"))
+	j.add("cmd_2", []byte("two"))
+	j.add("cmd_1", []byte("one again")) // replaces, keeps its place
+
+	pending := j.pending()
+	if len(pending) != 2 || string(pending[0]) != "one again" || string(pending[1]) != "two" {
+		t.Fatalf("unexpected pending results %q", pending)
+	}
+
+	j.ack([]string{"cmd_1", "cmd_unknown"})
+	pending = j.pending()
+	if len(pending) != 1 || string(pending[0]) != "two" {
+		t.Errorf("expected only cmd_2 after ack, got %q", pending)
+	}
+}
+
+func TestResultJournal_Bounded(t *testing.T) {
+	j := newResultJournal()
+
+	for i := 0; i < MaxJournalEntries+5; i++ {
+		j.add(fmt.Sprintf("cmd_%d", i), []byte(fmt.Sprintf("result %d", i)))
+	}
+
+	pending := j.pending()
+	if len(pending) != MaxJournalEntries {
+		t.Fatalf("expected %d entries, got %d", MaxJournalEntries, len(pending))
+	}
+	if string(pending[0]) != "result 5" {
+		t.Errorf("expected the oldest entries to be dropped, first is %q", pending[0])
+	}
+}
+
+func TestResultJournal_Clear(t *testing.T) {
+	j := newResultJournal()
+	j.add("cmd_1", []byte("one"))
+	j.clear()
+
+	if pending := j.pending(); len(pending) != 0 {
+		t.Errorf("expected no results after clear, got %q", pending)
+	}
+	j.add("cmd_2", []byte("two"))
+	if pending := j.pending(); len(pending) != 1 {
+		t.Errorf("expected the journal to keep working after clear, got %q", pending)
+	}
+}
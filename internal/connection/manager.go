@@ -2,15 +2,21 @@ package connection
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/codebasehealth/antidote-agent/internal/crash"
 	"github.com/codebasehealth/antidote-agent/internal/messages"
 	"github.com/gorilla/websocket"
 )
@@ -31,11 +37,53 @@ const (
 
 	// Heartbeat interval
 	HeartbeatInterval = 30 * time.Second
+
+	// DefaultKeepAlivePeriod is the TCP keepalive period for the connection
+	DefaultKeepAlivePeriod = 30 * time.Second
+
+	// DefaultHandshakeTimeout bounds the TCP, TLS, and WebSocket handshake
+	DefaultHandshakeTimeout = 10 * time.Second
+
+	// DefaultAuthTimeout is how long to wait for the auth response
+	DefaultAuthTimeout = 10 * time.Second
+
+	// SendBufferSize is how many outgoing messages can be queued
+	SendBufferSize = 100
+
+	// PriorityReserve is how much of the send buffer only priority messages
+	// (command completions and rejections) may use, so a flood of output or
+	// health messages can't crowd them out
+	PriorityReserve = 10
 )
 
+// sendRetryInterval is how often SendWithTimeout retries a full send buffer
+const sendRetryInterval = 10 * time.Millisecond
+
+// setKeepAlive configures TCP keepalive on a dialed connection (a period of 0
+// disables it). Tests replace it to observe the dial.
+var setKeepAlive = func(conn *net.TCPConn, period time.Duration) error {
+	if period <= 0 {
+		return conn.SetKeepAlive(false)
+	}
+	if err := conn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	return conn.SetKeepAlivePeriod(period)
+}
+
+// ErrSendBufferFull is returned by Send when the outgoing queue is full, and
+// by SendWithTimeout when it stays full
+var ErrSendBufferFull = errors.New("send buffer full")
+
 // MessageHandler is called when a message is received
 type MessageHandler func(msgType string, data []byte)
 
+// ConnectHandler is called after each successful connection and authentication
+type ConnectHandler func()
+
+// HeartbeatFunc builds the heartbeat sent each HeartbeatInterval
+type HeartbeatFunc func() *messages.HeartbeatMessage
+
 // Manager manages the WebSocket connection to the server
 type Manager struct {
 	token    string
@@ -45,22 +93,133 @@ type Manager struct {
 	serverID string
 	handler  MessageHandler
 
-	sendCh chan []byte
-	doneCh chan struct{}
-	mu     sync.RWMutex
-	wg     sync.WaitGroup
+	onConnect   ConnectHandler
+	onHeartbeat HeartbeatFunc
+	tlsConfig   *tls.Config
+	proxy       *url.URL // nil = from the environment
+	keepAlive   time.Duration
+	handshake   time.Duration
+	authTimeout time.Duration
+	diagnostics messages.ConnectionDiagnostics
+
+	sendCh  chan []byte
+	sendMu  sync.Mutex    // serializes queueing so the priority reserve holds
+	dropped atomic.Uint64 // messages not queued because the buffer was full
+	doneCh  chan struct{}
+
+	// Command results awaiting the server's ack. Results are journaled until
+	// a server says it doesn't send acks, since one that does may be next.
+	journal      *resultJournal
+	resultAcks   bool
+	noResultAcks bool // the current connection's server doesn't ack results
+
+	heartbeat         heartbeatTracker
+	heartbeatInterval time.Duration
+	maxMissed         int
+	reconnects        int
+
+	mu sync.RWMutex
+	wg sync.WaitGroup
 }
 
 // NewManager creates a new connection manager
 func NewManager(token, endpoint string, handler MessageHandler) *Manager {
 	return &Manager{
-		token:    token,
-		endpoint: endpoint,
-		state:    StateDisconnected,
-		handler:  handler,
-		sendCh:   make(chan []byte, 100),
-		doneCh:   make(chan struct{}),
+		token:             token,
+		endpoint:          endpoint,
+		state:             StateDisconnected,
+		handler:           handler,
+		tlsConfig:         &tls.Config{MinVersion: DefaultMinTLSVersion},
+		keepAlive:         DefaultKeepAlivePeriod,
+		heartbeatInterval: HeartbeatInterval,
+		handshake:         DefaultHandshakeTimeout,
+		authTimeout:       DefaultAuthTimeout,
+		sendCh:            make(chan []byte, SendBufferSize),
+		journal:           newResultJournal(),
+		maxMissed:         DefaultMaxMissedHeartbeats,
+		doneCh:            make(chan struct{}),
+		diagnostics: messages.ConnectionDiagnostics{
+			Endpoint: endpoint,
+		},
+	}
+}
+
+// SetConnectHandler sets the handler called after each successful connection
+func (m *Manager) SetConnectHandler(handler ConnectHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConnect = handler
+}
+
+// SetHeartbeatFunc sets the builder of heartbeats, so they can carry a load
+// summary (without one, heartbeats report no load)
+func (m *Manager) SetHeartbeatFunc(heartbeat HeartbeatFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onHeartbeat = heartbeat
+}
+
+// SetTLSOptions sets how the server's certificate is verified for new
+// connections
+func (m *Manager) SetTLSOptions(opts TLSOptions) error {
+	config, err := NewTLSConfig(opts)
+	if err != nil {
+		return err
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tlsConfig = config
+	return nil
+}
+
+// SetProxy sets the proxy the connection goes through (nil uses the proxy
+// environment variables)
+func (m *Manager) SetProxy(proxyURL *url.URL) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proxy = proxyURL
+}
+
+// SetKeepAlivePeriod sets the TCP keepalive period used for new connections
+// (0 disables TCP keepalive)
+func (m *Manager) SetKeepAlivePeriod(period time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keepAlive = period
+}
+
+// SetHandshakeTimeout sets how long dialing and the WebSocket handshake may
+// take (0 or negative restores the default)
+func (m *Manager) SetHandshakeTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	m.handshake = timeout
+}
+
+// SetAuthTimeout sets how long to wait for the server's auth response (0 or
+// negative restores the default)
+func (m *Manager) SetAuthTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if timeout <= 0 {
+		timeout = DefaultAuthTimeout
+	}
+	m.authTimeout = timeout
+}
+
+// SetMaxMissedHeartbeats sets how many heartbeats in a row may go unacked
+// before the connection is re-established (0 disables the check). Only
+// servers that ack heartbeats are affected.
+func (m *Manager) SetMaxMissedHeartbeats(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxMissed = n
 }
 
 // Start begins the connection manager
@@ -82,19 +241,108 @@ func (m *Manager) Stop() {
 	m.mu.Unlock()
 }
 
-// Send queues a message to be sent
+// Send queues a message to be sent, failing with ErrSendBufferFull (and
+// counting it as dropped) if there is no room
 func (m *Manager) Send(msg interface{}) error {
+	return m.SendWithTimeout(msg, 0)
+}
+
+// SendWithTimeout queues a message to be sent, waiting up to timeout for room
+// in a full send buffer. If the buffer stays full the message is dropped and
+// ErrSendBufferFull returned; messages still queue while disconnected and are
+// sent after reconnecting, so a persistently full buffer usually means the
+// connection is down (see State).
+func (m *Manager) SendWithTimeout(msg interface{}, timeout time.Duration) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	select {
-	case m.sendCh <- data:
-		return nil
-	default:
-		return fmt.Errorf("send buffer full")
+	// Journaled even if it can't be queued, or before the server has said
+	// whether it acks: it is resent after reconnecting
+	if id := resultID(msg); id != "" && m.mayAckResults() {
+		m.journal.add(id, data)
 	}
+
+	priority := isPriority(msg)
+	deadline := time.Now().Add(timeout)
+	for !m.enqueue(data, priority) {
+		if !time.Now().Before(deadline) {
+			m.dropped.Add(1)
+			return ErrSendBufferFull
+		}
+		time.Sleep(sendRetryInterval)
+	}
+	return nil
+}
+
+// enqueue adds a message to the send buffer if there is room for it; all but
+// priority messages leave PriorityReserve slots free
+func (m *Manager) enqueue(data []byte, priority bool) bool {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	limit := cap(m.sendCh)
+	if !priority {
+		limit -= PriorityReserve
+	}
+	if len(m.sendCh) >= limit {
+		return false
+	}
+
+	// Only enqueue sends, under sendMu, so this can't block
+	m.sendCh <- data
+	return true
+}
+
+// isPriority reports whether a message may use the send buffer's priority
+// reserve: the messages that tell the cloud how a command ended
+func isPriority(msg interface{}) bool {
+	switch msg.(type) {
+	case *messages.CompleteMessage, *messages.RejectedMessage:
+		return true
+	}
+	return false
+}
+
+// acksResults reports whether the server acknowledges command results
+func (m *Manager) acksResults() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.resultAcks
+}
+
+// mayAckResults reports whether the server may acknowledge command results:
+// it does, or no server has said otherwise since the last disconnect
+func (m *Manager) mayAckResults() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return !m.noResultAcks
+}
+
+// resendResults queues the command results the server hasn't acknowledged,
+// oldest first. Any that don't fit are resent after the next reconnect.
+func (m *Manager) resendResults() {
+	if !m.acksResults() {
+		return
+	}
+
+	pending := m.journal.pending()
+	for i, data := range pending {
+		if !m.enqueue(data, true) {
+			log.Printf("Send buffer full, %d unacknowledged command results not resent", len(pending)-i)
+			return
+		}
+	}
+	if len(pending) > 0 {
+		log.Printf("Resent %d unacknowledged command results", len(pending))
+	}
+}
+
+// DroppedMessages returns how many messages have been dropped because the
+// send buffer was full, since the agent started
+func (m *Manager) DroppedMessages() uint64 {
+	return m.dropped.Load()
 }
 
 // State returns the current connection state
@@ -111,11 +359,35 @@ func (m *Manager) ServerID() string {
 	return m.serverID
 }
 
+// Stats returns heartbeat round-trip and reconnection statistics
+func (m *Manager) Stats() Stats {
+	lastRTT, missed := m.heartbeat.stats()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Stats{
+		LastRTT:          lastRTT,
+		MissedHeartbeats: missed,
+		Reconnects:       m.reconnects,
+	}
+}
+
+// Diagnostics returns details of the current (or last) connection
+func (m *Manager) Diagnostics() messages.ConnectionDiagnostics {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	diagnostics := m.diagnostics
+	diagnostics.MessagesDropped = m.dropped.Load()
+	return diagnostics
+}
+
 // connectionLoop manages the connection lifecycle
 func (m *Manager) connectionLoop(ctx context.Context) {
 	defer m.wg.Done()
 
 	delay := InitialDelay
+	connected := false
 
 	for {
 		select {
@@ -132,6 +404,7 @@ func (m *Manager) connectionLoop(ctx context.Context) {
 
 		if err != nil {
 			log.Printf("Connection failed: %v", err)
+			m.recordDisconnect(err)
 			m.setState(StateDisconnected)
 
 			// Wait before reconnecting
@@ -153,28 +426,92 @@ func (m *Manager) connectionLoop(ctx context.Context) {
 
 		// Reset delay on successful connection
 		delay = InitialDelay
+		if connected {
+			m.mu.Lock()
+			m.reconnects++
+			m.mu.Unlock()
+		}
+		connected = true
+
+		m.mu.RLock()
+		onConnect := m.onConnect
+		m.mu.RUnlock()
+		if onConnect != nil {
+			onConnect()
+		}
+		m.resendResults()
 
 		// Run the connection
-		m.runConnection(ctx)
+		err = m.runConnection(ctx)
+		m.recordDisconnect(err)
+		m.mu.Lock()
+		m.noResultAcks = false
+		m.mu.Unlock()
 		m.setState(StateDisconnected)
 	}
 }
 
+// netDialContext dials the TCP connection under the WebSocket and configures
+// keepalive on it, so dead peers are detected and NAT mappings stay open even
+// between WebSocket pings
+func (m *Manager) netDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	m.mu.RLock()
+	period := m.keepAlive
+	m.mu.RUnlock()
+
+	// Keepalive is configured below rather than by the dialer's default
+	dialer := net.Dialer{KeepAlive: -1}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := setKeepAlive(tcpConn, period); err != nil {
+			log.Printf("Warning: failed to configure TCP keepalive: %v", err)
+		}
+	}
+
+	return conn, nil
+}
+
+// dialer returns the WebSocket dialer for a new connection
+func (m *Manager) dialer() *websocket.Dialer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return &websocket.Dialer{
+		HandshakeTimeout: m.handshake,
+		TLSClientConfig:  m.tlsConfig,
+		NetDialContext:   m.netDialContext,
+		Proxy:            ProxyFunc(m.proxy),
+	}
+}
+
 // connect establishes a WebSocket connection and authenticates
 func (m *Manager) connect(ctx context.Context) error {
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
-	}
+	m.mu.RLock()
+	authTimeout := m.authTimeout
+	m.mu.RUnlock()
+
+	dialer := m.dialer()
 
 	log.Printf("Connecting to %s...", m.endpoint)
 
+	dialStart := time.Now()
 	conn, _, err := dialer.DialContext(ctx, m.endpoint, http.Header{})
+	if errors.Is(err, ErrCertificatePinMismatch) {
+		log.Printf("SECURITY WARNING: %v; the connection may be intercepted, refusing to connect to %s", err, m.endpoint)
+		return fmt.Errorf("certificate pinning failed: %w", err)
+	}
 	if err != nil {
 		return fmt.Errorf("dial failed: %w", err)
 	}
+	handshakeDuration := time.Since(dialStart)
 
 	m.mu.Lock()
 	m.conn = conn
+	m.recordConnectDiagnostics(conn, handshakeDuration)
 	m.mu.Unlock()
 
 	// Send auth message
@@ -193,7 +530,7 @@ func (m *Manager) connect(ctx context.Context) error {
 	}
 
 	// Wait for auth response
-	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
 	messageType, data, err := conn.ReadMessage()
 	if err != nil {
 		conn.Close()
@@ -229,39 +566,97 @@ func (m *Manager) connect(ctx context.Context) error {
 
 	m.mu.Lock()
 	m.serverID = authOK.ServerID
+	m.resultAcks = authOK.ResultAcks
+	m.noResultAcks = !authOK.ResultAcks
 	m.mu.Unlock()
 
+	// Results journaled before it was known that this server won't ack them
+	// are already queued, and would never be cleared
+	if !authOK.ResultAcks {
+		m.journal.clear()
+	}
+
 	m.setState(StateConnected)
 	log.Printf("Connected! Server ID: %s", authOK.ServerID)
 
+	// Let the handler see settings carried by auth_ok
+	if m.handler != nil {
+		m.handler(msgType, data)
+	}
+
 	return nil
 }
 
-// runConnection handles the connection after authentication
-func (m *Manager) runConnection(ctx context.Context) {
+// recordConnectDiagnostics captures TLS and address details of a new connection
+// (caller must hold lock)
+func (m *Manager) recordConnectDiagnostics(conn *websocket.Conn, handshakeDuration time.Duration) {
+	m.diagnostics.RemoteAddr = conn.RemoteAddr().String()
+	m.diagnostics.HandshakeMs = handshakeDuration.Milliseconds()
+	m.diagnostics.ConnectedAt = time.Now().UTC().Format(time.RFC3339)
+	m.diagnostics.TLSVersion = ""
+	m.diagnostics.CipherSuite = ""
+
+	if tlsConn, ok := conn.UnderlyingConn().(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		m.diagnostics.TLSVersion = tls.VersionName(state.Version)
+		m.diagnostics.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	}
+}
+
+// recordDisconnect records why the last connection attempt or session ended
+func (m *Manager) recordDisconnect(reason error) {
+	if reason == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.diagnostics.LastDisconnectReason = reason.Error()
+	m.diagnostics.LastDisconnectAt = time.Now().UTC().Format(time.RFC3339)
+}
+
+// runConnection handles the connection after authentication and returns why it ended
+func (m *Manager) runConnection(ctx context.Context) error {
+	m.mu.RLock()
+	interval, maxMissed, onHeartbeat := m.heartbeatInterval, m.maxMissed, m.onHeartbeat
+	m.mu.RUnlock()
+
 	// Start heartbeat
-	heartbeatTicker := time.NewTicker(HeartbeatInterval)
+	m.heartbeat.reset()
+	heartbeatTicker := time.NewTicker(interval)
 	defer heartbeatTicker.Stop()
 
 	// Start read goroutine
-	readDone := make(chan struct{})
+	readDone := make(chan error, 1)
 	go func() {
-		defer close(readDone)
-		m.readLoop()
+		readDone <- m.readLoop()
 	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return ctx.Err()
 		case <-m.doneCh:
-			return
-		case <-readDone:
-			return
+			return fmt.Errorf("agent shutting down")
+		case err := <-readDone:
+			return err
 		case <-heartbeatTicker.C:
-			if err := m.sendMessage(messages.NewHeartbeatMessage()); err != nil {
+			var heartbeat *messages.HeartbeatMessage
+			if onHeartbeat != nil {
+				heartbeat = onHeartbeat()
+			} else {
+				heartbeat = messages.NewHeartbeatMessage(0, 0, time.Time{})
+			}
+			seq, missed := m.heartbeat.sent(time.Now())
+			if maxMissed > 0 && missed >= maxMissed {
+				log.Printf("Server missed %d heartbeat acks, reconnecting", missed)
+				m.closeConn()
+				return fmt.Errorf("missed %d heartbeat acks", missed)
+			}
+			heartbeat.Seq = seq
+			if err := m.sendMessage(heartbeat); err != nil {
 				log.Printf("Failed to send heartbeat: %v", err)
-				return
+				return fmt.Errorf("heartbeat failed: %w", err)
 			}
 		case data := <-m.sendCh:
 			m.mu.RLock()
@@ -274,21 +669,21 @@ func (m *Manager) runConnection(ctx context.Context) {
 
 			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
 				log.Printf("Failed to send message: %v", err)
-				return
+				return fmt.Errorf("write failed: %w", err)
 			}
 		}
 	}
 }
 
-// readLoop reads messages from the WebSocket
-func (m *Manager) readLoop() {
+// readLoop reads messages from the WebSocket until the connection fails
+func (m *Manager) readLoop() error {
 	for {
 		m.mu.RLock()
 		conn := m.conn
 		m.mu.RUnlock()
 
 		if conn == nil {
-			return
+			return fmt.Errorf("not connected")
 		}
 
 		_, data, err := conn.ReadMessage()
@@ -298,7 +693,7 @@ func (m *Manager) readLoop() {
 			} else {
 				log.Printf("Read error: %v", err)
 			}
-			return
+			return fmt.Errorf("read failed: %w", err)
 		}
 
 		msgType, err := messages.ParseMessage(data)
@@ -307,9 +702,57 @@ func (m *Manager) readLoop() {
 			continue
 		}
 
-		if m.handler != nil {
-			m.handler(msgType, data)
+		if msgType == messages.TypeAck {
+			m.handleAck(data)
+			continue
+		}
+		if msgType == messages.TypeHeartbeatAck {
+			m.handleHeartbeatAck(data)
+			continue
 		}
+
+		m.dispatch(msgType, data)
+	}
+}
+
+// dispatch hands a message to the handler. A panic while handling one message
+// is logged and the connection keeps reading.
+func (m *Manager) dispatch(msgType string, data []byte) {
+	if m.handler == nil {
+		return
+	}
+	defer crash.Recover(fmt.Sprintf("%s message handler", msgType))
+	m.handler(msgType, data)
+}
+
+// handleAck forgets command results the server has received
+func (m *Manager) handleAck(data []byte) {
+	ack, err := messages.ParseAckMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse ack message: %v", err)
+		return
+	}
+	m.journal.ack(ack.IDs)
+}
+
+// handleHeartbeatAck records the round-trip time of an answered heartbeat
+func (m *Manager) handleHeartbeatAck(data []byte) {
+	ack, err := messages.ParseHeartbeatAckMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse heartbeat ack: %v", err)
+		return
+	}
+	m.heartbeat.acked(ack.Seq, time.Now())
+}
+
+// closeConn closes the current connection, ending its read loop
+func (m *Manager) closeConn() {
+	m.mu.RLock()
+	conn := m.conn
+	m.mu.RUnlock()
+
+	if conn != nil {
+		conn.Close()
 	}
 }
 
@@ -0,0 +1,711 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer starts a WebSocket server that accepts auth and then idles
+func newTestServer(t *testing.T, useTLS bool) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// Read auth message
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+
+		if err := conn.WriteJSON(messages.AuthOKMessage{
+			Type:     messages.TypeAuthOK,
+			ServerID: "srv_test",
+		}); err != nil {
+			return
+		}
+
+		// Drain until the client disconnects
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	var server *httptest.Server
+	if useTLS {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+// wsURL converts an httptest server URL to a WebSocket URL
+func wsURL(server *httptest.Server) string {
+	if strings.HasPrefix(server.URL, "https://") {
+		return "wss://" + strings.TrimPrefix(server.URL, "https://")
+	}
+	return "ws://" + strings.TrimPrefix(server.URL, "http://")
+}
+
+// certPool returns a pool trusting the test server's self-signed certificate
+func certPool(server *httptest.Server) *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return pool
+}
+
+// waitForState polls until the manager reaches the given state
+func waitForState(t *testing.T, m *Manager, state string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if m.State() == state {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for state %q (current: %q)", state, m.State())
+}
+
+func TestManager_Diagnostics_TLS(t *testing.T) {
+	server := newTestServer(t, true)
+
+	m := NewManager("ant_test", wsURL(server), nil)
+	m.tlsConfig = &tls.Config{RootCAs: certPool(server)}
+
+	connected := make(chan struct{}, 1)
+	m.SetConnectHandler(func() {
+		connected <- struct{}{}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m.Start(ctx)
+	defer m.Stop()
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for connection")
+	}
+
+	diag := m.Diagnostics()
+
+	if diag.TLSVersion == "" {
+		t.Error("expected TLS version to be reported")
+	}
+	if !strings.HasPrefix(diag.TLSVersion, "TLS ") {
+		t.Errorf("unexpected TLS version %q", diag.TLSVersion)
+	}
+	if diag.CipherSuite == "" {
+		t.Error("expected cipher suite to be reported")
+	}
+	if diag.RemoteAddr != server.Listener.Addr().String() {
+		t.Errorf("expected remote addr %q, got %q", server.Listener.Addr().String(), diag.RemoteAddr)
+	}
+	if diag.ConnectedAt == "" {
+		t.Error("expected connected_at to be set")
+	}
+	if diag.Endpoint != wsURL(server) {
+		t.Errorf("expected endpoint %q, got %q", wsURL(server), diag.Endpoint)
+	}
+}
+
+func TestManager_Diagnostics_PlainConnection(t *testing.T) {
+	server := newTestServer(t, false)
+
+	m := NewManager("ant_test", wsURL(server), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m.Start(ctx)
+	defer m.Stop()
+
+	waitForState(t, m, StateConnected)
+
+	diag := m.Diagnostics()
+	if diag.TLSVersion != "" || diag.CipherSuite != "" {
+		t.Errorf("expected no TLS details for ws://, got %q / %q", diag.TLSVersion, diag.CipherSuite)
+	}
+}
+
+func TestManager_Diagnostics_DisconnectReason(t *testing.T) {
+	server := newTestServer(t, false)
+	endpoint := wsURL(server)
+	server.Close()
+
+	m := NewManager("ant_test", endpoint, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m.Start(ctx)
+	defer m.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if m.Diagnostics().LastDisconnectReason != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	diag := m.Diagnostics()
+	if !strings.Contains(diag.LastDisconnectReason, "dial failed") {
+		t.Errorf("expected dial failure reason, got %q", diag.LastDisconnectReason)
+	}
+	if diag.LastDisconnectAt == "" {
+		t.Error("expected last_disconnect_at to be set")
+	}
+}
+
+func TestManager_TCPKeepAlive(t *testing.T) {
+	server := newTestServer(t, false)
+
+	type keepAliveCall struct {
+		remoteAddr string
+		period     time.Duration
+		err        error
+	}
+	calls := make(chan keepAliveCall, 1)
+
+	original := setKeepAlive
+	setKeepAlive = func(conn *net.TCPConn, period time.Duration) error {
+		err := original(conn, period)
+		select {
+		case calls <- keepAliveCall{remoteAddr: conn.RemoteAddr().String(), period: period, err: err}:
+		default:
+		}
+		return err
+	}
+	defer func() { setKeepAlive = original }()
+
+	m := NewManager("ant_test", wsURL(server), nil)
+	m.SetKeepAlivePeriod(45 * time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m.Start(ctx)
+	defer m.Stop()
+
+	select {
+	case call := <-calls:
+		if call.remoteAddr != server.Listener.Addr().String() {
+			t.Errorf("expected keepalive on connection to %s, got %s", server.Listener.Addr().String(), call.remoteAddr)
+		}
+		if call.period != 45*time.Second {
+			t.Errorf("expected keepalive period 45s, got %v", call.period)
+		}
+		if call.err != nil {
+			t.Errorf("failed to set keepalive: %v", call.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the custom dial")
+	}
+
+	waitForState(t, m, StateConnected)
+}
+
+func TestManager_AuthTimeout(t *testing.T) {
+	// Accepts the WebSocket and the auth message but never answers
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewManager("ant_test", wsURL(server), nil)
+	m.SetAuthTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	err := m.connect(context.Background())
+	elapsed := time.Since(start)
+
+	if err == nil || !strings.Contains(err.Error(), "auth response") {
+		t.Fatalf("expected auth response error, got %v", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("expected failure after the 200ms auth timeout, took %v", elapsed)
+	}
+}
+
+func TestManager_HandshakeTimeout(t *testing.T) {
+	// Accepts TCP connections but never completes the WebSocket handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(io.Discard, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	m := NewManager("ant_test", "ws://"+listener.Addr().String()+"/agent/ws", nil)
+	m.SetHandshakeTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	err = m.connect(context.Background())
+	elapsed := time.Since(start)
+
+	if err == nil || !strings.Contains(err.Error(), "dial failed") {
+		t.Fatalf("expected dial error, got %v", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("expected failure after the 200ms handshake timeout, took %v", elapsed)
+	}
+}
+
+func TestManager_SendBufferPriorityReserve(t *testing.T) {
+	m := NewManager("token", "ws://unused", nil)
+
+	// Nothing drains the buffer while disconnected
+	for i := 0; i < SendBufferSize-PriorityReserve; i++ {
+		if err := m.Send(messages.NewHeartbeatMessage(0, 0, time.Time{})); err != nil {
+			t.Fatalf("send %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := m.Send(messages.NewOutputMessage("cmd_1", "stdout", "line\n")); err != ErrSendBufferFull {
+		t.Fatalf("expected ErrSendBufferFull once only the reserve is left, got %v", err)
+	}
+
+	// Completions may still use the reserve
+	for i := 0; i < PriorityReserve; i++ {
+		if err := m.Send(messages.NewCompleteMessage("cmd_1", 0, 0)); err != nil {
+			t.Fatalf("priority send %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := m.Send(messages.NewCompleteMessage("cmd_2", 0, 0)); err != ErrSendBufferFull {
+		t.Errorf("expected ErrSendBufferFull with the reserve used up, got %v", err)
+	}
+
+	if got := m.DroppedMessages(); got != 2 {
+		t.Errorf("expected 2 dropped messages, got %d", got)
+	}
+	if got := m.Diagnostics().MessagesDropped; got != 2 {
+		t.Errorf("expected diagnostics to report 2 dropped messages, got %d", got)
+	}
+}
+
+func TestManager_SendWithTimeout(t *testing.T) {
+	m := NewManager("token", "ws://unused", nil)
+	for i := 0; i < SendBufferSize-PriorityReserve; i++ {
+		m.Send(messages.NewHeartbeatMessage(0, 0, time.Time{}))
+	}
+
+	// Stays full: gives up after the timeout
+	start := time.Now()
+	if err := m.SendWithTimeout(messages.NewHeartbeatMessage(0, 0, time.Time{}), 50*time.Millisecond); err != ErrSendBufferFull {
+		t.Fatalf("expected ErrSendBufferFull, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected the send to wait for the timeout, returned after %v", elapsed)
+	}
+	if got := m.DroppedMessages(); got != 1 {
+		t.Errorf("expected 1 dropped message, got %d", got)
+	}
+
+	// Room frees up while waiting: queued without a drop
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-m.sendCh
+	}()
+	if err := m.SendWithTimeout(messages.NewHeartbeatMessage(0, 0, time.Time{}), time.Second); err != nil {
+		t.Errorf("expected the send to succeed once room frees up, got %v", err)
+	}
+	if got := m.DroppedMessages(); got != 1 {
+		t.Errorf("expected no further drops, got %d", got)
+	}
+}
+
+func TestManager_ResendsUnacknowledgedResults(t *testing.T) {
+	received := make(chan string, 10)
+	connections := 0
+	var connMu sync.Mutex
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		connMu.Lock()
+		connections++
+		attempt := connections
+		connMu.Unlock()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if err := conn.WriteJSON(messages.AuthOKMessage{Type: messages.TypeAuthOK, ServerID: "srv_test", ResultAcks: true}); err != nil {
+			return
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var complete messages.CompleteMessage
+			if json.Unmarshal(data, &complete) != nil || complete.Type != messages.TypeComplete {
+				continue
+			}
+			received <- fmt.Sprintf("%d:%s", attempt, complete.ID)
+
+			// The first connection drops before acking; the second acks
+			if attempt == 1 {
+				return
+			}
+			conn.WriteJSON(messages.AckMessage{Type: messages.TypeAck, IDs: []string{complete.ID}})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewManager("token", wsURL(server), nil)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+	waitForState(t, m, StateConnected)
+
+	if err := m.Send(messages.NewCompleteMessage("cmd_deploy", 0, 1200)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, want := range []string{"1:cmd_deploy", "2:cmd_deploy"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("expected %s, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", want)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(m.journal.pending()) != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := m.journal.pending(); len(pending) != 0 {
+		t.Errorf("expected the ack to clear the journal, %d results pending", len(pending))
+	}
+}
+
+func TestManager_ResendsResultsSentBeforeConnecting(t *testing.T) {
+	received := make(chan string, 10)
+	var connections atomic.Int32
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		attempt := connections.Add(1)
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if err := conn.WriteJSON(messages.AuthOKMessage{Type: messages.TypeAuthOK, ServerID: "srv_test", ResultAcks: true}); err != nil {
+			return
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var complete messages.CompleteMessage
+			if json.Unmarshal(data, &complete) != nil || complete.Type != messages.TypeComplete {
+				continue
+			}
+			received <- fmt.Sprintf("%d:%s", attempt, complete.ID)
+
+			// The first connection drops before acking; the second acks
+			if attempt == 1 {
+				return
+			}
+			conn.WriteJSON(messages.AckMessage{Type: messages.TypeAck, IDs: []string{complete.ID}})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	// Finished before the agent has ever connected
+	m := NewManager("token", wsURL(server), nil)
+	if err := m.Send(messages.NewCompleteMessage("cmd_early", 0, 10)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	var got []string
+	for len(got) == 0 || !strings.HasPrefix(got[len(got)-1], "2:") {
+		select {
+		case id := <-received:
+			got = append(got, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for the result to be resent, got %v", got)
+		}
+	}
+	if got[0] != "1:cmd_early" || got[len(got)-1] != "2:cmd_early" {
+		t.Errorf("expected cmd_early on both connections, got %v", got)
+	}
+}
+
+func TestManager_JournalDroppedWithoutResultAcks(t *testing.T) {
+	server := newTestServer(t, false)
+
+	m := NewManager("token", wsURL(server), nil)
+	m.Send(messages.NewCompleteMessage("cmd_early", 0, 10))
+	if pending := m.journal.pending(); len(pending) != 1 {
+		t.Fatalf("expected the result to be journaled before connecting, got %d", len(pending))
+	}
+
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+	waitForState(t, m, StateConnected)
+
+	if pending := m.journal.pending(); len(pending) != 0 {
+		t.Errorf("expected the journal to be dropped for a server without acks, got %d", len(pending))
+	}
+}
+
+func TestManager_NoJournalWithoutResultAcks(t *testing.T) {
+	server := newTestServer(t, false)
+
+	m := NewManager("token", wsURL(server), nil)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+	waitForState(t, m, StateConnected)
+
+	m.Send(messages.NewCompleteMessage("cmd_1", 0, 10))
+	if pending := m.journal.pending(); len(pending) != 0 {
+		t.Errorf("expected nothing journaled for a server without acks, got %d", len(pending))
+	}
+}
+
+func TestManager_ReconnectsAfterMissedHeartbeatAcks(t *testing.T) {
+	var connections atomic.Int32
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		attempt := connections.Add(1)
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if err := conn.WriteJSON(messages.AuthOKMessage{Type: messages.TypeAuthOK, ServerID: "srv_test"}); err != nil {
+			return
+		}
+
+		acked := 0
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var heartbeat messages.HeartbeatMessage
+			if json.Unmarshal(data, &heartbeat) != nil || heartbeat.Type != messages.TypeHeartbeat {
+				continue
+			}
+
+			// The first connection goes quiet after acking one heartbeat
+			if attempt == 1 && acked == 1 {
+				continue
+			}
+			acked++
+			conn.WriteJSON(messages.HeartbeatAckMessage{Type: messages.TypeHeartbeatAck, Seq: heartbeat.Seq})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewManager("token", wsURL(server), nil)
+	m.heartbeatInterval = 20 * time.Millisecond
+	m.SetMaxMissedHeartbeats(2)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.Stats().Reconnects == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := m.Stats()
+	if stats.Reconnects != 1 {
+		t.Fatalf("expected a reconnect after missed heartbeat acks, got %d", stats.Reconnects)
+	}
+	if connections.Load() != 2 {
+		t.Errorf("expected 2 connections, got %d", connections.Load())
+	}
+	if stats.LastRTT <= 0 {
+		t.Errorf("expected a measured RTT, got %v", stats.LastRTT)
+	}
+	if !strings.Contains(m.Diagnostics().LastDisconnectReason, "missed 2 heartbeat acks") {
+		t.Errorf("unexpected disconnect reason %q", m.Diagnostics().LastDisconnectReason)
+	}
+}
+
+func TestManager_IgnoresMissedHeartbeatsWithoutAcks(t *testing.T) {
+	server := newTestServer(t, false)
+
+	m := NewManager("token", wsURL(server), nil)
+	m.heartbeatInterval = 10 * time.Millisecond
+	m.SetMaxMissedHeartbeats(1)
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+	waitForState(t, m, StateConnected)
+
+	time.Sleep(100 * time.Millisecond)
+	if stats := m.Stats(); stats.Reconnects != 0 || m.State() != StateConnected {
+		t.Errorf("expected a server that never acks heartbeats to keep the connection, got %+v", stats)
+	}
+}
+
+func TestManager_HeartbeatFunc(t *testing.T) {
+	heartbeats := make(chan messages.HeartbeatMessage, 10)
+
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if err := conn.WriteJSON(messages.AuthOKMessage{Type: messages.TypeAuthOK, ServerID: "srv_test"}); err != nil {
+			return
+		}
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var heartbeat messages.HeartbeatMessage
+			if json.Unmarshal(data, &heartbeat) == nil && heartbeat.Type == messages.TypeHeartbeat {
+				heartbeats <- heartbeat
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewManager("token", wsURL(server), nil)
+	m.heartbeatInterval = 20 * time.Millisecond
+	m.SetHeartbeatFunc(func() *messages.HeartbeatMessage {
+		return messages.NewHeartbeatMessage(2, 3, time.Time{})
+	})
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	select {
+	case heartbeat := <-heartbeats:
+		if heartbeat.Running != 2 || heartbeat.Queued != 3 || heartbeat.Seq != 1 {
+			t.Errorf("expected the built heartbeat with seq 1, got %+v", heartbeat)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a heartbeat")
+	}
+}
+
+func TestManager_RecoversHandlerPanic(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+		if err := conn.WriteJSON(messages.AuthOKMessage{Type: messages.TypeAuthOK, ServerID: "srv_test"}); err != nil {
+			return
+		}
+		conn.WriteJSON(map[string]string{"type": "boom"})
+		conn.WriteJSON(map[string]string{"type": "after"})
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	received := make(chan string, 2)
+	m := NewManager("token", wsURL(server), func(msgType string, data []byte) {
+		switch msgType {
+		case "boom":
+			panic("handler bug")
+		case "after":
+			received <- msgType
+		}
+	})
+	m.Start(context.Background())
+	t.Cleanup(m.Stop)
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the message after the panic")
+	}
+	if m.Stats().Reconnects != 0 {
+		t.Errorf("expected the connection to survive the panic, got %d reconnects", m.Stats().Reconnects)
+	}
+}
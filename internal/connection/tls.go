@@ -0,0 +1,97 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultMinTLSVersion is the oldest TLS version accepted from the cloud
+const DefaultMinTLSVersion = tls.VersionTLS12
+
+// ErrCertificatePinMismatch is returned when the server's certificate key
+// matches none of the configured pins, which may mean the connection is being
+// intercepted
+var ErrCertificatePinMismatch = errors.New("server certificate does not match any pinned key")
+
+// TLSOptions configures how the cloud endpoint's certificate is verified
+type TLSOptions struct {
+	CAFile     string   // PEM bundle of extra root CAs, e.g. a TLS-terminating appliance's
+	Pins       []string // base64 SHA-256 hashes of acceptable server public keys (SPKI), optionally prefixed "sha256/"
+	MinVersion uint16   // 0 = DefaultMinTLSVersion
+}
+
+// NewTLSConfig builds the TLS config for the cloud connection
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: opts.MinVersion}
+	if config.MinVersion == 0 {
+		config.MinVersion = DefaultMinTLSVersion
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+
+		// Added to the system roots, so public endpoints still verify
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if len(opts.Pins) > 0 {
+		pins := make(map[string]bool, len(opts.Pins))
+		for _, pin := range opts.Pins {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid certificate pin %q: must be a base64 SHA-256 hash", pin)
+			}
+			pins[pin] = true
+		}
+
+		// Runs after normal chain verification, so a pin narrows trust
+		// rather than replacing it
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return ErrCertificatePinMismatch
+			}
+			got := SPKIPin(state.PeerCertificates[0])
+			if !pins[got] {
+				return fmt.Errorf("%w: server key is sha256/%s", ErrCertificatePinMismatch, got)
+			}
+			return nil
+		}
+	}
+
+	return config, nil
+}
+
+// SPKIPin returns the pin of a certificate: the base64 SHA-256 hash of its
+// public key info
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ParseTLSVersion parses a minimum TLS version like "1.2" or "1.3"
+func ParseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "tls") {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q (use 1.2 or 1.3)", version)
+	}
+}
@@ -0,0 +1,134 @@
+package connection
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCABundle writes the test server's self-signed certificate as a PEM file
+func writeCABundle(t *testing.T, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestManager_TLSOptions_CustomCA(t *testing.T) {
+	server := newTestServer(t, true)
+
+	m := NewManager("ant_test", wsURL(server), nil)
+	if err := m.connect(context.Background()); err == nil {
+		t.Fatal("expected the self-signed certificate to be rejected without the CA bundle")
+	}
+
+	if err := m.SetTLSOptions(TLSOptions{CAFile: writeCABundle(t, server.Certificate().Raw)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.connect(context.Background()); err != nil {
+		t.Fatalf("expected to connect with the CA bundle: %v", err)
+	}
+	m.closeConn()
+}
+
+func TestManager_TLSOptions_Pinning(t *testing.T) {
+	server := newTestServer(t, true)
+	caFile := writeCABundle(t, server.Certificate().Raw)
+
+	m := NewManager("ant_test", wsURL(server), nil)
+	if err := m.SetTLSOptions(TLSOptions{CAFile: caFile, Pins: []string{"sha256/" + SPKIPin(server.Certificate())}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.connect(context.Background()); err != nil {
+		t.Fatalf("expected to connect with a matching pin: %v", err)
+	}
+	m.closeConn()
+
+	otherKey := sha256.Sum256([]byte("some other key"))
+	if err := m.SetTLSOptions(TLSOptions{CAFile: caFile, Pins: []string{base64.StdEncoding.EncodeToString(otherKey[:])}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := m.connect(context.Background())
+	if !errors.Is(err, ErrCertificatePinMismatch) {
+		t.Fatalf("expected a pin mismatch error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "certificate pinning failed") {
+		t.Errorf("expected a distinct pinning error, got %v", err)
+	}
+}
+
+func TestManager_TLSOptions_MinVersion(t *testing.T) {
+	server := newTestServer(t, true)
+	server.TLS.MaxVersion = tls.VersionTLS12
+	caFile := writeCABundle(t, server.Certificate().Raw)
+
+	m := NewManager("ant_test", wsURL(server), nil)
+	if err := m.SetTLSOptions(TLSOptions{CAFile: caFile}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.connect(context.Background()); err != nil {
+		t.Fatalf("expected a TLS 1.2 server to be accepted by default: %v", err)
+	}
+	m.closeConn()
+
+	if err := m.SetTLSOptions(TLSOptions{CAFile: caFile, MinVersion: tls.VersionTLS13}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.connect(context.Background()); err == nil {
+		m.closeConn()
+		t.Fatal("expected a TLS 1.2 server to be rejected when 1.3 is required")
+	}
+}
+
+func TestNewTLSConfig_Defaults(t *testing.T) {
+	config, err := NewTLSConfig(TLSOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("expected TLS 1.2 minimum by default, got %x", config.MinVersion)
+	}
+	if config.VerifyConnection != nil || config.RootCAs != nil {
+		t.Error("expected system roots and no pinning by default")
+	}
+}
+
+func TestNewTLSConfig_Invalid(t *testing.T) {
+	if _, err := NewTLSConfig(TLSOptions{Pins: []string{"not-a-hash"}}); err == nil {
+		t.Error("expected an invalid pin to be rejected")
+	}
+	if _, err := NewTLSConfig(TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("expected a missing CA bundle to be rejected")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("not a certificate"), 0644)
+	if _, err := NewTLSConfig(TLSOptions{CAFile: empty}); err == nil {
+		t.Error("expected a CA bundle without certificates to be rejected")
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for input, want := range map[string]uint16{"1.2": tls.VersionTLS12, "TLS1.3": tls.VersionTLS13} {
+		got, err := ParseTLSVersion(input)
+		if err != nil || got != want {
+			t.Errorf("ParseTLSVersion(%q) = %x, %v; expected %x", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"1.1", "1.0", "bogus"} {
+		if _, err := ParseTLSVersion(input); err == nil {
+			t.Errorf("expected %q to be rejected", input)
+		}
+	}
+}
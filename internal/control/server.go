@@ -0,0 +1,343 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/executor"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// DefaultSocketMode restricts the control socket to the agent's user
+const DefaultSocketMode os.FileMode = 0600
+
+// Handlers supply the agent state and actions exposed on the control socket.
+// Nil handlers are reported as unavailable.
+type Handlers struct {
+	ConnectionState func() string
+	Diagnostics     func() messages.ConnectionDiagnostics
+	Running         func() []executor.RunningCommand
+	Discovery       func() *messages.DiscoveryMessage
+	Metrics         func() *messages.HealthMessage
+
+	// Write actions (only served when writes are enabled)
+	Cancel      func(id string) bool
+	Interrupt   func(id string) bool // SIGINT first, kill after a grace period
+	SetDraining func(draining bool)
+	IsDraining  func() bool
+}
+
+// Config configures the control server
+type Config struct {
+	Path       string      // unix socket path
+	Mode       os.FileMode // socket file permissions (default 0600)
+	AllowWrite bool        // serve cancel/interrupt/drain actions
+}
+
+// StateResponse is returned by GET /v1/state
+type StateResponse struct {
+	Version    string                          `json:"version"`
+	State      string                          `json:"state"`
+	Draining   bool                            `json:"draining"`
+	Running    int                             `json:"running"`
+	Connection *messages.ConnectionDiagnostics `json:"connection,omitempty"`
+}
+
+// DrainRequest is the body of POST /v1/drain
+type DrainRequest struct {
+	Draining bool `json:"draining"`
+}
+
+// Server serves a local HTTP API on a unix domain socket so tools on the
+// host can query the agent without going through the cloud
+type Server struct {
+	config   Config
+	handlers Handlers
+	version  string
+
+	listener net.Listener
+	server   *http.Server
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a control server
+func NewServer(config Config, handlers Handlers, version string) *Server {
+	if config.Mode == 0 {
+		config.Mode = DefaultSocketMode
+	}
+
+	s := &Server{
+		config:   config,
+		handlers: handlers,
+		version:  version,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/state", s.handleState)
+	mux.HandleFunc("/v1/commands", s.handleCommands)
+	mux.HandleFunc("/v1/commands/", s.handleCommandAction)
+	mux.HandleFunc("/v1/discovery", s.handleDiscovery)
+	mux.HandleFunc("/v1/metrics", s.handleMetrics)
+	mux.HandleFunc("/v1/drain", s.handleDrain)
+
+	s.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start listens on the socket and serves requests in the background
+func (s *Server) Start() error {
+	if err := removeStaleSocket(s.config.Path); err != nil {
+		return err
+	}
+
+	listener, err := listenPrivate(s.config.Path, s.config.Mode)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Control socket server error: %v", err)
+		}
+	}()
+
+	log.Printf("Control socket listening on %s (writes %s)", s.config.Path, enabledString(s.config.AllowWrite))
+	return nil
+}
+
+// Stop shuts down the server and removes the socket file
+func (s *Server) Stop() {
+	if s.listener == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	s.server.Shutdown(ctx)
+	s.wg.Wait()
+	os.Remove(s.config.Path)
+}
+
+// listenPrivate listens on a unix socket that is never reachable with looser
+// permissions than mode: the socket is created in a new 0700 directory next
+// to path, given its permissions, and only then moved into place
+func listenPrivate(path string, mode os.FileMode) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".ctl-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a private directory for %s: %w", path, err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpPath := filepath.Join(dir, "s")
+	listener, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	// The socket is removed from its final path on Stop
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions on %s: %w", path, err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to move socket to %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket left behind by a previous run, refusing
+// to delete anything that isn't a socket
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	return os.Remove(path)
+}
+
+func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	resp := StateResponse{
+		Version: s.version,
+	}
+	if s.handlers.ConnectionState != nil {
+		resp.State = s.handlers.ConnectionState()
+	}
+	if s.handlers.IsDraining != nil {
+		resp.Draining = s.handlers.IsDraining()
+	}
+	if s.handlers.Running != nil {
+		resp.Running = len(s.handlers.Running())
+	}
+	if s.handlers.Diagnostics != nil {
+		diag := s.handlers.Diagnostics()
+		resp.Connection = &diag
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleCommands(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	running := []executor.RunningCommand{}
+	if s.handlers.Running != nil {
+		running = s.handlers.Running()
+	}
+	writeJSON(w, http.StatusOK, running)
+}
+
+// handleCommandAction serves POST /v1/commands/{id}/cancel and
+// POST /v1/commands/{id}/interrupt
+func (s *Server) handleCommandAction(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/commands/"), "/")
+	if !ok || id == "" || (action != "cancel" && action != "interrupt") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !requireMethod(w, r, http.MethodPost) || !s.requireWrite(w) {
+		return
+	}
+
+	handler := s.handlers.Cancel
+	if action == "interrupt" {
+		handler = s.handlers.Interrupt
+	}
+	if handler == nil {
+		writeError(w, http.StatusServiceUnavailable, action+" not available")
+		return
+	}
+
+	if !handler(id) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("command %s is not running", id))
+		return
+	}
+
+	if action == "interrupt" {
+		log.Printf("Command %s interrupted via control socket", id)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "interrupted": true})
+		return
+	}
+
+	log.Printf("Command %s cancelled via control socket", id)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "cancelled": true})
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	var discovery *messages.DiscoveryMessage
+	if s.handlers.Discovery != nil {
+		discovery = s.handlers.Discovery()
+	}
+	if discovery == nil {
+		writeError(w, http.StatusNotFound, "discovery has not run yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, discovery)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	var metrics *messages.HealthMessage
+	if s.handlers.Metrics != nil {
+		metrics = s.handlers.Metrics()
+	}
+	if metrics == nil {
+		writeError(w, http.StatusNotFound, "no metrics collected yet")
+		return
+	}
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+func (s *Server) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) || !s.requireWrite(w) {
+		return
+	}
+	if s.handlers.SetDraining == nil {
+		writeError(w, http.StatusServiceUnavailable, "drain not available")
+		return
+	}
+
+	var req DrainRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	log.Printf("Drain mode set to %t via control socket", req.Draining)
+	s.handlers.SetDraining(req.Draining)
+	writeJSON(w, http.StatusOK, req)
+}
+
+// requireWrite rejects write actions unless they are enabled
+func (s *Server) requireWrite(w http.ResponseWriter) bool {
+	if !s.config.AllowWrite {
+		writeError(w, http.StatusForbidden, "write actions are disabled")
+		return false
+	}
+	return true
+}
+
+// requireMethod rejects requests with the wrong HTTP method
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
@@ -0,0 +1,251 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/executor"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// startServer starts a control server on a temporary socket
+func startServer(t *testing.T, config Config, handlers Handlers) (*Server, *http.Client) {
+	t.Helper()
+
+	// Keep the path short: unix socket paths are limited to ~100 bytes
+	dir, err := os.MkdirTemp("", "antidote-ctl")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	config.Path = filepath.Join(dir, "agent.sock")
+	s := NewServer(config, handlers, "v1.2.3")
+	if err := s.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(s.Stop)
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", config.Path)
+			},
+		},
+	}
+	return s, client
+}
+
+func getJSON(t *testing.T, client *http.Client, path string, v interface{}) int {
+	t.Helper()
+
+	resp, err := client.Get("http://agent" + path)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode %s response: %v", path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func post(t *testing.T, client *http.Client, path, body string) int {
+	t.Helper()
+
+	resp, err := client.Post("http://agent"+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestServer_State(t *testing.T) {
+	_, client := startServer(t, Config{}, Handlers{
+		ConnectionState: func() string { return "connected" },
+		Diagnostics: func() messages.ConnectionDiagnostics {
+			return messages.ConnectionDiagnostics{Endpoint: "wss://example.com/agent/ws", TLSVersion: "TLS 1.3"}
+		},
+		Running: func() []executor.RunningCommand {
+			return []executor.RunningCommand{{ID: "cmd_1", Command: "sleep 10"}}
+		},
+		IsDraining: func() bool { return true },
+	})
+
+	var state StateResponse
+	if status := getJSON(t, client, "/v1/state", &state); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+
+	if state.State != "connected" {
+		t.Errorf("expected state connected, got %q", state.State)
+	}
+	if state.Version != "v1.2.3" {
+		t.Errorf("expected version v1.2.3, got %q", state.Version)
+	}
+	if !state.Draining || state.Running != 1 {
+		t.Errorf("expected draining with 1 running command, got %+v", state)
+	}
+	if state.Connection == nil || state.Connection.TLSVersion != "TLS 1.3" {
+		t.Errorf("expected connection diagnostics, got %+v", state.Connection)
+	}
+}
+
+func TestServer_SocketPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix file permissions not supported on windows")
+	}
+
+	s, _ := startServer(t, Config{}, Handlers{})
+
+	info, err := os.Stat(s.config.Path)
+	if err != nil {
+		t.Fatalf("failed to stat socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("expected socket permissions 0600, got %o", perm)
+	}
+
+	// The private directory it was created in is gone
+	entries, err := os.ReadDir(filepath.Dir(s.config.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(s.config.Path) {
+		t.Errorf("expected only the socket next to it, got %v", entries)
+	}
+
+	s.Stop()
+	if _, err := os.Lstat(s.config.Path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed on stop, got %v", err)
+	}
+}
+
+func TestServer_ReadEndpoints(t *testing.T) {
+	_, client := startServer(t, Config{}, Handlers{
+		Running: func() []executor.RunningCommand {
+			return []executor.RunningCommand{{ID: "cmd_1", Command: "php artisan queue:work"}}
+		},
+		Discovery: func() *messages.DiscoveryMessage {
+			return &messages.DiscoveryMessage{Type: messages.TypeDiscovery, Hostname: "web-1"}
+		},
+		Metrics: func() *messages.HealthMessage { return nil },
+	})
+
+	var running []executor.RunningCommand
+	if status := getJSON(t, client, "/v1/commands", &running); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if len(running) != 1 || running[0].ID != "cmd_1" {
+		t.Errorf("unexpected running commands %+v", running)
+	}
+
+	var discovery messages.DiscoveryMessage
+	if status := getJSON(t, client, "/v1/discovery", &discovery); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if discovery.Hostname != "web-1" {
+		t.Errorf("expected discovery snapshot, got %+v", discovery)
+	}
+
+	if status := getJSON(t, client, "/v1/metrics", nil); status != http.StatusNotFound {
+		t.Errorf("expected 404 before metrics are collected, got %d", status)
+	}
+}
+
+func TestServer_WritesDisabledByDefault(t *testing.T) {
+	cancelled := false
+	_, client := startServer(t, Config{}, Handlers{
+		Cancel:      func(id string) bool { cancelled = true; return true },
+		SetDraining: func(bool) {},
+	})
+
+	if status := post(t, client, "/v1/commands/cmd_1/cancel", ""); status != http.StatusForbidden {
+		t.Errorf("expected 403 for cancel, got %d", status)
+	}
+	if status := post(t, client, "/v1/drain", `{"draining":true}`); status != http.StatusForbidden {
+		t.Errorf("expected 403 for drain, got %d", status)
+	}
+	if cancelled {
+		t.Error("cancel handler must not run when writes are disabled")
+	}
+}
+
+func TestServer_WriteActions(t *testing.T) {
+	var cancelledID, interruptedID string
+	var draining bool
+	_, client := startServer(t, Config{AllowWrite: true}, Handlers{
+		Cancel: func(id string) bool {
+			if id != "cmd_1" {
+				return false
+			}
+			cancelledID = id
+			return true
+		},
+		Interrupt: func(id string) bool {
+			interruptedID = id
+			return true
+		},
+		SetDraining: func(d bool) { draining = d },
+	})
+
+	if status := post(t, client, "/v1/commands/cmd_1/cancel", ""); status != http.StatusOK {
+		t.Errorf("expected 200 for cancel, got %d", status)
+	}
+	if cancelledID != "cmd_1" {
+		t.Errorf("expected cmd_1 to be cancelled, got %q", cancelledID)
+	}
+	if status := post(t, client, "/v1/commands/unknown/cancel", ""); status != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown command, got %d", status)
+	}
+
+	if status := post(t, client, "/v1/commands/cmd_2/interrupt", ""); status != http.StatusOK {
+		t.Errorf("expected 200 for interrupt, got %d", status)
+	}
+	if interruptedID != "cmd_2" {
+		t.Errorf("expected cmd_2 to be interrupted, got %q", interruptedID)
+	}
+
+	if status := post(t, client, "/v1/drain", `{"draining":true}`); status != http.StatusOK {
+		t.Errorf("expected 200 for drain, got %d", status)
+	}
+	if !draining {
+		t.Error("expected drain mode to be enabled")
+	}
+
+	// Writes require POST
+	if status := getJSON(t, client, "/v1/drain", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET drain, got %d", status)
+	}
+}
+
+func TestServer_RefusesToReplaceNonSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	if err := os.WriteFile(path, []byte("not a socket"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	s := NewServer(Config{Path: path}, Handlers{}, "dev")
+	if err := s.Start(); err == nil {
+		s.Stop()
+		t.Fatal("expected error when socket path is a regular file")
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "not a socket" {
+		t.Error("existing file must not be removed")
+	}
+}
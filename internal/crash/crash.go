@@ -0,0 +1,68 @@
+package crash
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var (
+	reportDir string
+	version   string
+	reportMu  sync.Mutex
+)
+
+// SetReportDir sets where crash reports are written ("" writes none) and the
+// agent version recorded in them
+func SetReportDir(dir, agentVersion string) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	reportDir = dir
+	version = agentVersion
+}
+
+// Recover logs a panic in the goroutine it is deferred in and lets the rest
+// of the agent keep running. It must be deferred directly:
+//
+//	defer crash.Recover("log tailer")
+func Recover(where string) {
+	if r := recover(); r != nil {
+		Report(where, r)
+	}
+}
+
+// Report logs a recovered panic with its stack trace and writes a crash
+// report if a report directory is set. It returns the report's path, or ""
+// if none was written.
+func Report(where string, value any) string {
+	stack := debug.Stack()
+	log.Printf("PANIC in %s (recovered): %v\n%s", where, value, stack)
+
+	reportMu.Lock()
+	dir, agentVersion := reportDir, version
+	reportMu.Unlock()
+	if dir == "" {
+		return ""
+	}
+
+	now := time.Now().UTC()
+	path := filepath.Join(dir, fmt.Sprintf("antidote-crash-%s-%d.txt", now.Format("20060102T150405.000000000Z"), os.Getpid()))
+	report := fmt.Sprintf("time: %s\nversion: %s\ngo: %s %s/%s\nwhere: %s\npanic: %v\n\n%s",
+		now.Format(time.RFC3339Nano), agentVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH, where, value, stack)
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("Failed to create crash report directory: %v", err)
+		return ""
+	}
+	if err := os.WriteFile(path, []byte(report), 0600); err != nil {
+		log.Printf("Failed to write crash report: %v", err)
+		return ""
+	}
+	log.Printf("Crash report written to %s", path)
+	return path
+}
@@ -0,0 +1,88 @@
+package crash
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureLog redirects the log to a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func TestRecover_LogsAndContinues(t *testing.T) {
+	logs := captureLog(t)
+	SetReportDir("", "")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer Recover("test handler")
+		panic("boom")
+	}()
+	<-done
+
+	if !strings.Contains(logs.String(), "PANIC in test handler (recovered): boom") {
+		t.Errorf("expected the panic to be logged, got %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "crash_test.go") {
+		t.Error("expected the stack trace to be logged")
+	}
+}
+
+func TestRecover_WritesReport(t *testing.T) {
+	captureLog(t)
+	dir := filepath.Join(t.TempDir(), "crashes")
+	SetReportDir(dir, "v1.2.3")
+	t.Cleanup(func() { SetReportDir("", "") })
+
+	func() {
+		defer Recover("log tailer")
+		var m map[string]int
+		m["x"] = 1
+	}()
+
+	reports, err := filepath.Glob(filepath.Join(dir, "antidote-crash-*.txt"))
+	if err != nil || len(reports) != 1 {
+		t.Fatalf("expected one crash report, got %v (%v)", reports, err)
+	}
+	data, err := os.ReadFile(reports[0])
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	report := string(data)
+	for _, want := range []string{"version: v1.2.3", "where: log tailer", "panic: assignment to entry in nil map", "crash_test.go"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("expected report to contain %q, got:\n%s", want, report)
+		}
+	}
+
+	info, err := os.Stat(reports[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected report mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestRecover_NoPanic(t *testing.T) {
+	logs := captureLog(t)
+
+	func() {
+		defer Recover("quiet")
+	}()
+
+	if logs.Len() != 0 {
+		t.Errorf("expected nothing logged without a panic, got %q", logs.String())
+	}
+}
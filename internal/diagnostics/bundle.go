@@ -0,0 +1,227 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/executor"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/security"
+)
+
+// Size limits for a bundle
+const (
+	MaxSectionSize = 1024 * 1024     // largest JSON section
+	MaxLogTail     = 256 * 1024      // bytes taken from the end of each log file
+	MaxLogFiles    = 20              // log files included
+	MaxBundleSize  = 8 * 1024 * 1024 // total uncompressed size; later sections are skipped
+)
+
+// RedactorFunc returns the redactor for a path ("" for the default)
+type RedactorFunc func(path string) *security.Redactor
+
+// Sources are the snapshots a bundle is assembled from. Every section is
+// redacted before it is written, log tails with their app's redactor.
+type Sources struct {
+	Version   string
+	Discovery *messages.DiscoveryMessage
+	Metrics   *messages.HealthMessage
+	AgentInfo *messages.AgentInfoMessage
+	History   []executor.CommandRecord
+	LogFiles  []string
+	Redactor  RedactorFunc
+}
+
+// Result describes a written bundle
+type Result struct {
+	Path      string
+	Size      int64
+	Sections  []string
+	Truncated []string
+}
+
+// Manifest is the first section of every bundle
+type Manifest struct {
+	Version   string    `json:"version"`
+	Hostname  string    `json:"hostname"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// bundleWriter writes redacted, size-bounded sections to a tar archive
+type bundleWriter struct {
+	tw       *tar.Writer
+	redactor RedactorFunc
+	now      time.Time
+	written  int64
+	result   *Result
+}
+
+// Collect writes a gzipped tarball of the sources into dir and returns its path
+func Collect(dir string, src Sources) (*Result, error) {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+
+	name := fmt.Sprintf("antidote-bundle-%s-%s.tar.gz", safeName(hostname), now.UTC().Format("20060102-150405"))
+	path := filepath.Join(dir, name)
+
+	// The bundle holds server details, so only the agent's user may read it
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+
+	result := &Result{Path: path}
+	gz := gzip.NewWriter(f)
+	w := &bundleWriter{tw: tar.NewWriter(gz), redactor: src.Redactor, now: now, result: result}
+
+	w.writeJSON("manifest.json", Manifest{Version: src.Version, Hostname: hostname, CreatedAt: now.UTC()})
+	if src.Discovery != nil {
+		w.writeJSON("discovery.json", src.Discovery)
+		w.writeJSON("config.json", appConfigs(src.Discovery))
+	}
+	if src.Metrics != nil {
+		w.writeJSON("metrics.json", src.Metrics)
+	}
+	if src.AgentInfo != nil {
+		w.writeJSON("agent_info.json", src.AgentInfo)
+	}
+	w.writeJSON("history.json", src.History)
+
+	logFiles := src.LogFiles
+	if len(logFiles) > MaxLogFiles {
+		logFiles = logFiles[:MaxLogFiles]
+		result.Truncated = append(result.Truncated, "logs")
+	}
+	for _, logPath := range logFiles {
+		w.writeLogTail(logPath)
+	}
+
+	err = w.tw.Close()
+	if gzErr := gz.Close(); err == nil {
+		err = gzErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		result.Size = info.Size()
+	}
+	return result, nil
+}
+
+// appConfigs maps app paths to their antidote.yml config
+func appConfigs(discovery *messages.DiscoveryMessage) map[string]*messages.AppConfig {
+	configs := make(map[string]*messages.AppConfig)
+	for _, app := range discovery.Apps {
+		if app.Config != nil {
+			configs[app.Path] = app.Config
+		}
+	}
+	return configs
+}
+
+// writeJSON writes a value as an indented JSON section
+func (w *bundleWriter) writeJSON(name string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"error": %q}`, err.Error()))
+	}
+	w.write(name, data, "", MaxSectionSize)
+}
+
+// writeLogTail writes the end of a log file as a section
+func (w *bundleWriter) writeLogTail(path string) {
+	name := "logs/" + safeName(strings.TrimPrefix(filepath.ToSlash(path), "/"))
+
+	data, truncated, err := readTail(path, MaxLogTail)
+	if err != nil {
+		data = []byte(fmt.Sprintf("failed to read %s: %v\n", path, err))
+	}
+	if truncated {
+		w.result.Truncated = append(w.result.Truncated, name)
+	}
+	w.write(name, data, path, MaxLogTail)
+}
+
+// write adds a section redacted for path, cut to limit bytes, unless the
+// bundle is full
+func (w *bundleWriter) write(name string, data []byte, path string, limit int) {
+	if w.redactor != nil {
+		data = []byte(w.redactor(path).Redact(string(data)))
+	}
+
+	if len(data) > limit {
+		data = data[:limit]
+		w.result.Truncated = append(w.result.Truncated, name)
+	}
+	if w.written+int64(len(data)) > MaxBundleSize {
+		w.result.Truncated = append(w.result.Truncated, name)
+		return
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: w.now,
+	}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return
+	}
+
+	w.written += int64(len(data))
+	w.result.Sections = append(w.result.Sections, name)
+}
+
+// readTail reads up to max bytes from the end of a file
+func readTail(path string, max int64) ([]byte, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	truncated := info.Size() > max
+	if truncated {
+		if _, err := f.Seek(-max, io.SeekEnd); err != nil {
+			return nil, false, err
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, max))
+	return data, truncated, err
+}
+
+// safeName replaces characters that don't belong in a file name
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		case r == '/':
+			return '_'
+		default:
+			return '-'
+		}
+	}, s)
+}
@@ -0,0 +1,151 @@
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/executor"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/security"
+)
+
+// readBundle returns the sections of a bundle keyed by name
+func readBundle(t *testing.T, path string) map[string]string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read gzip: %v", err)
+	}
+
+	sections := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read tar: %v", err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", header.Name, err)
+		}
+		sections[header.Name] = string(data)
+	}
+	return sections
+}
+
+func defaultRedactor(string) *security.Redactor {
+	return security.NewRedactor(security.DefaultRedactPatterns)
+}
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+
+	logPath := filepath.Join(dir, "laravel.log")
+	if err := os.WriteFile(logPath, []byte("ERROR: db failed DB_PASSWORD=hunter2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := Sources{
+		Version: "v1.2.3",
+		Discovery: &messages.DiscoveryMessage{
+			Apps: []messages.AppInfo{{
+				Path:   "/var/www/app",
+				Config: &messages.AppConfig{TrustLevel: "standard"},
+			}},
+		},
+		Metrics:  &messages.HealthMessage{},
+		History:  []executor.CommandRecord{{ID: "cmd-1", Command: "php artisan migrate"}},
+		LogFiles: []string{logPath},
+		Redactor: defaultRedactor,
+	}
+
+	result, err := Collect(dir, src)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Size == 0 || !strings.HasPrefix(filepath.Base(result.Path), "antidote-bundle-") {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(result.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("expected mode 0600, got %o", perm)
+		}
+	}
+
+	sections := readBundle(t, result.Path)
+	for _, name := range []string{"manifest.json", "discovery.json", "config.json", "metrics.json", "history.json"} {
+		if _, ok := sections[name]; !ok {
+			t.Errorf("expected section %s, got %v", name, result.Sections)
+		}
+	}
+	if !strings.Contains(sections["manifest.json"], "v1.2.3") {
+		t.Errorf("expected version in manifest, got %s", sections["manifest.json"])
+	}
+	if !strings.Contains(sections["history.json"], "php artisan migrate") {
+		t.Errorf("expected command history, got %s", sections["history.json"])
+	}
+
+	var logSection string
+	for name, data := range sections {
+		if strings.HasPrefix(name, "logs/") {
+			logSection = data
+		}
+	}
+	if !strings.Contains(logSection, "ERROR: db failed") {
+		t.Errorf("expected log tail, got %q", logSection)
+	}
+	if strings.Contains(logSection, "hunter2") {
+		t.Errorf("expected log tail to be redacted, got %q", logSection)
+	}
+}
+
+func TestCollectTruncatesLogs(t *testing.T) {
+	dir := t.TempDir()
+
+	logPath := filepath.Join(dir, "big.log")
+	data := strings.Repeat("old line\n", MaxLogTail/9) + strings.Repeat("new line\n", MaxLogTail/9)
+	if err := os.WriteFile(logPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Collect(dir, Sources{LogFiles: []string{logPath}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Truncated) != 1 || !strings.HasPrefix(result.Truncated[0], "logs/") {
+		t.Errorf("expected the log to be truncated, got %v", result.Truncated)
+	}
+
+	for name, section := range readBundle(t, result.Path) {
+		if !strings.HasPrefix(name, "logs/") {
+			continue
+		}
+		if len(section) > MaxLogTail {
+			t.Errorf("expected at most %d bytes, got %d", MaxLogTail, len(section))
+		}
+		if strings.Contains(section, "old line") {
+			t.Error("expected only the end of the log")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// SELinux modes and AppArmor states reported in discovery
+const (
+	SELinuxEnforcing  = "enforcing"
+	SELinuxPermissive = "permissive"
+	SELinuxDisabled   = "disabled"
+
+	AppArmorEnabled  = "enabled"
+	AppArmorDisabled = "disabled"
+)
+
+// getenforce runs getenforce, for hosts where selinuxfs isn't readable
+// (replaced in tests)
+var getenforce = func() (string, error) {
+	out, err := probeCommand(nil, "getenforce").Output()
+	return string(out), err
+}
+
+// discoverAccessControl reports the host's mandatory access control status,
+// or nil when neither SELinux nor AppArmor is present
+func discoverAccessControl() *messages.AccessControlInfo {
+	return discoverAccessControlAt("/")
+}
+
+// discoverAccessControlAt reports the mandatory access control status of the
+// filesystem rooted at root
+func discoverAccessControlAt(root string) *messages.AccessControlInfo {
+	info := &messages.AccessControlInfo{
+		SELinux:  selinuxMode(root),
+		AppArmor: apparmorState(root),
+	}
+
+	if info.AppArmor == AppArmorEnabled {
+		// Only readable as root; counts stay zero otherwise
+		if data, err := os.ReadFile(filepath.Join(root, "sys/kernel/security/apparmor/profiles")); err == nil {
+			info.AppArmorEnforce, info.AppArmorComplain = parseAppArmorProfiles(string(data))
+		}
+	}
+
+	if info.SELinux == "" && info.AppArmor == "" {
+		return nil
+	}
+	return info
+}
+
+// selinuxMode reads the SELinux mode from selinuxfs, falling back to
+// getenforce; empty when SELinux isn't present
+func selinuxMode(root string) string {
+	if data, err := os.ReadFile(filepath.Join(root, "sys/fs/selinux/enforce")); err == nil {
+		switch strings.TrimSpace(string(data)) {
+		case "1":
+			return SELinuxEnforcing
+		case "0":
+			return SELinuxPermissive
+		}
+	}
+
+	out, err := getenforce()
+	if err != nil {
+		return ""
+	}
+	return parseGetenforce(out)
+}
+
+// parseGetenforce turns getenforce output ("Enforcing", "Permissive" or
+// "Disabled") into a mode
+func parseGetenforce(output string) string {
+	switch strings.ToLower(strings.TrimSpace(output)) {
+	case "enforcing":
+		return SELinuxEnforcing
+	case "permissive":
+		return SELinuxPermissive
+	case "disabled":
+		return SELinuxDisabled
+	default:
+		return ""
+	}
+}
+
+// apparmorState reads whether AppArmor is enabled from the kernel module
+// parameter (world-readable); empty when the module isn't loaded
+func apparmorState(root string) string {
+	data, err := os.ReadFile(filepath.Join(root, "sys/module/apparmor/parameters/enabled"))
+	if err != nil {
+		if _, err := os.Stat(filepath.Join(root, "sys/kernel/security/apparmor")); err == nil {
+			return AppArmorEnabled
+		}
+		return ""
+	}
+
+	if strings.TrimSpace(string(data)) == "Y" {
+		return AppArmorEnabled
+	}
+	return AppArmorDisabled
+}
+
+// parseAppArmorProfiles counts enforced and complain-mode profiles in the
+// kernel's profile list, one "name (mode)" per line
+func parseAppArmorProfiles(content string) (enforce, complain int) {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasSuffix(line, "(enforce)"):
+			enforce++
+		case strings.HasSuffix(line, "(complain)"):
+			complain++
+		}
+	}
+	return enforce, complain
+}
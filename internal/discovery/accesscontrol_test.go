@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeGetenforce makes getenforce return the given output and error
+func fakeGetenforce(t *testing.T, output string, err error) {
+	t.Helper()
+
+	orig := getenforce
+	getenforce = func() (string, error) { return output, err }
+	t.Cleanup(func() { getenforce = orig })
+}
+
+// writeRootFile creates a file under a fake filesystem root
+func writeRootFile(t *testing.T, root, path, content string) {
+	t.Helper()
+
+	full := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestParseGetenforce(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"Enforcing\n", SELinuxEnforcing},
+		{"Permissive\n", SELinuxPermissive},
+		{"Disabled\n", SELinuxDisabled},
+		{"", ""},
+		{"getenforce: command not found", ""},
+	}
+
+	for _, tt := range tests {
+		if got := parseGetenforce(tt.output); got != tt.want {
+			t.Errorf("parseGetenforce(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestParseAppArmorProfiles(t *testing.T) {
+	content := `/usr/sbin/mysqld (enforce)
+/usr/sbin/nginx (complain)
+docker-default (enforce)
+lsb_release (enforce)
+nvidia_modprobe (complain)
+`
+	enforce, complain := parseAppArmorProfiles(content)
+	if enforce != 3 || complain != 2 {
+		t.Errorf("expected 3 enforce and 2 complain profiles, got %d and %d", enforce, complain)
+	}
+}
+
+func TestDiscoverAccessControl_SELinux(t *testing.T) {
+	fakeGetenforce(t, "", errors.New("not found"))
+
+	root := t.TempDir()
+	writeRootFile(t, root, "sys/fs/selinux/enforce", "1")
+
+	info := discoverAccessControlAt(root)
+	if info == nil {
+		t.Fatal("expected access control info")
+	}
+	if info.SELinux != SELinuxEnforcing {
+		t.Errorf("expected SELinux %q, got %q", SELinuxEnforcing, info.SELinux)
+	}
+	if info.AppArmor != "" {
+		t.Errorf("expected no AppArmor, got %q", info.AppArmor)
+	}
+}
+
+func TestDiscoverAccessControl_GetenforceFallback(t *testing.T) {
+	fakeGetenforce(t, "Permissive\n", nil)
+
+	info := discoverAccessControlAt(t.TempDir())
+	if info == nil || info.SELinux != SELinuxPermissive {
+		t.Errorf("expected SELinux from getenforce, got %+v", info)
+	}
+}
+
+func TestDiscoverAccessControl_AppArmor(t *testing.T) {
+	fakeGetenforce(t, "", errors.New("not found"))
+
+	root := t.TempDir()
+	writeRootFile(t, root, "sys/module/apparmor/parameters/enabled", "Y\n")
+	writeRootFile(t, root, "sys/kernel/security/apparmor/profiles", "/usr/sbin/mysqld (enforce)\n/usr/sbin/nginx (complain)\n")
+
+	info := discoverAccessControlAt(root)
+	if info == nil {
+		t.Fatal("expected access control info")
+	}
+	if info.AppArmor != AppArmorEnabled {
+		t.Errorf("expected AppArmor %q, got %q", AppArmorEnabled, info.AppArmor)
+	}
+	if info.AppArmorEnforce != 1 || info.AppArmorComplain != 1 {
+		t.Errorf("expected 1 enforce and 1 complain profile, got %d and %d", info.AppArmorEnforce, info.AppArmorComplain)
+	}
+	if info.SELinux != "" {
+		t.Errorf("expected no SELinux, got %q", info.SELinux)
+	}
+}
+
+func TestDiscoverAccessControl_Absent(t *testing.T) {
+	fakeGetenforce(t, "", errors.New("not found"))
+
+	if info := discoverAccessControlAt(t.TempDir()); info != nil {
+		t.Errorf("expected nil without SELinux or AppArmor, got %+v", info)
+	}
+}
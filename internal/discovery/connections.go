@@ -0,0 +1,136 @@
+package discovery
+
+import (
+	"log"
+	"net"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// processInfo is a running process and the directory it runs in
+type processInfo struct {
+	pid  int32
+	ppid int32
+	cwd  string
+}
+
+// Process and socket listing (replaced in tests)
+var (
+	listProcesses   = runningProcesses
+	listConnections = func() ([]psnet.ConnectionStat, error) { return psnet.Connections("tcp") }
+)
+
+// runningProcesses lists the processes whose working directory can be read.
+// Without root that is only the agent user's own.
+func runningProcesses() ([]processInfo, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []processInfo
+	for _, p := range procs {
+		cwd, err := p.Cwd()
+		if err != nil || cwd == "" {
+			continue
+		}
+		ppid, _ := p.Ppid()
+		infos = append(infos, processInfo{pid: p.Pid, ppid: ppid, cwd: cwd})
+	}
+	return infos, nil
+}
+
+// discoverConnections sets each app's main process and the remote endpoints
+// it has established outbound connections to. Apps whose process can't be
+// identified, e.g. because the agent lacks the privileges to see it, are
+// left without them.
+func discoverConnections(apps []messages.AppInfo) {
+	if len(apps) == 0 {
+		return
+	}
+
+	procs, err := listProcesses()
+	if err != nil {
+		log.Printf("Skipping app connection discovery: failed to list processes: %v", err)
+		return
+	}
+	conns, err := listConnections()
+	if err != nil {
+		log.Printf("Skipping app connection discovery: failed to list connections: %v", err)
+		return
+	}
+
+	for i := range apps {
+		pid := mainProcess(apps[i].Path, procs)
+		if pid == 0 {
+			continue
+		}
+		apps[i].PID = pid
+		apps[i].Connections = outboundConnections(pid, conns)
+	}
+}
+
+// mainProcess returns the process an app runs as: of the processes running in
+// the app's directory, the one with the lowest PID whose parent runs
+// elsewhere (so workers it forked aren't picked). 0 if there is none.
+func mainProcess(appPath string, procs []processInfo) int32 {
+	// Process working directories are real paths, e.g. a Forge release
+	// rather than the "current" symlink to it
+	if resolved, err := filepath.EvalSymlinks(appPath); err == nil {
+		appPath = resolved
+	}
+
+	inApp := make(map[int32]bool)
+	for _, p := range procs {
+		if withinDir(filepath.Clean(p.cwd), appPath) {
+			inApp[p.pid] = true
+		}
+	}
+
+	var main int32
+	for _, p := range procs {
+		if inApp[p.pid] && !inApp[p.ppid] && (main == 0 || p.pid < main) {
+			main = p.pid
+		}
+	}
+	return main
+}
+
+// outboundConnections returns the sorted, distinct remote "host:port"s of a
+// process's established connections, leaving out those accepted on ports it
+// listens on
+func outboundConnections(pid int32, conns []psnet.ConnectionStat) []string {
+	listening := make(map[uint32]bool)
+	for _, c := range conns {
+		if c.Pid == pid && c.Status == "LISTEN" {
+			listening[c.Laddr.Port] = true
+		}
+	}
+
+	var remotes []string
+	for _, c := range conns {
+		if c.Pid != pid || c.Status != "ESTABLISHED" || c.Raddr.Port == 0 || listening[c.Laddr.Port] {
+			continue
+		}
+		remote := net.JoinHostPort(c.Raddr.IP, strconv.FormatUint(uint64(c.Raddr.Port), 10))
+		if !slices.Contains(remotes, remote) {
+			remotes = append(remotes, remote)
+		}
+	}
+	slices.Sort(remotes)
+	return remotes
+}
+
+// withinDir reports whether a clean directory is root or a subdirectory of it
+func withinDir(dir, root string) bool {
+	if dir == root {
+		return true
+	}
+	return strings.HasPrefix(dir, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
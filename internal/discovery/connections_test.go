@@ -0,0 +1,121 @@
+package discovery
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+func TestDiscoverConnections_KnownConnection(t *testing.T) {
+	if _, err := listConnections(); err != nil {
+		t.Skipf("connections can't be listed here: %v", err)
+	}
+
+	// Run the test process from an app directory of its own
+	appDir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(appDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accepted.Close()
+
+	apps := []messages.AppInfo{{Path: appDir}}
+	discoverConnections(apps)
+
+	if apps[0].PID != int32(os.Getpid()) {
+		t.Fatalf("expected the test process (%d) as the app's main process, got %d", os.Getpid(), apps[0].PID)
+	}
+	// The dialed connection is reported; the accepted end of it is inbound
+	if want := []string{listener.Addr().String()}; !reflect.DeepEqual(apps[0].Connections, want) {
+		t.Errorf("expected connections %v, got %v", want, apps[0].Connections)
+	}
+}
+
+func TestMainProcess(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "releases", "20260113")
+	if err := os.MkdirAll(filepath.Join(release, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "current")
+	if err := os.Symlink(release, current); err != nil {
+		t.Fatal(err)
+	}
+	release, _ = filepath.EvalSymlinks(release)
+
+	procs := []processInfo{
+		{pid: 1, ppid: 0, cwd: "/"},
+		{pid: 200, ppid: 1, cwd: "/home/forge"},
+		{pid: 310, ppid: 1, cwd: filepath.Join(release, "public")},
+		{pid: 300, ppid: 310, cwd: release}, // a worker the main process forked
+		{pid: 400, ppid: 1, cwd: release},
+	}
+
+	if pid := mainProcess(current, procs); pid != 310 {
+		t.Errorf("expected main process 310, got %d", pid)
+	}
+	if pid := mainProcess(filepath.Join(dir, "other"), procs); pid != 0 {
+		t.Errorf("expected no main process for an app nothing runs in, got %d", pid)
+	}
+}
+
+func TestOutboundConnections(t *testing.T) {
+	conns := []psnet.ConnectionStat{
+		{Pid: 10, Status: "LISTEN", Laddr: psnet.Addr{IP: "0.0.0.0", Port: 3000}},
+		{Pid: 10, Status: "ESTABLISHED", Laddr: psnet.Addr{IP: "10.0.0.5", Port: 3000}, Raddr: psnet.Addr{IP: "10.0.0.9", Port: 51000}},
+		{Pid: 10, Status: "ESTABLISHED", Laddr: psnet.Addr{IP: "10.0.0.5", Port: 41000}, Raddr: psnet.Addr{IP: "10.0.0.20", Port: 5432}},
+		{Pid: 10, Status: "ESTABLISHED", Laddr: psnet.Addr{IP: "10.0.0.5", Port: 41001}, Raddr: psnet.Addr{IP: "10.0.0.20", Port: 5432}},
+		{Pid: 10, Status: "ESTABLISHED", Laddr: psnet.Addr{IP: "::1", Port: 41002}, Raddr: psnet.Addr{IP: "::1", Port: 6379}},
+		{Pid: 10, Status: "TIME_WAIT", Laddr: psnet.Addr{IP: "10.0.0.5", Port: 41003}, Raddr: psnet.Addr{IP: "10.0.0.30", Port: 443}},
+		{Pid: 11, Status: "ESTABLISHED", Laddr: psnet.Addr{IP: "10.0.0.5", Port: 41004}, Raddr: psnet.Addr{IP: "10.0.0.40", Port: 3306}},
+	}
+
+	want := []string{"10.0.0.20:5432", "[::1]:6379"}
+	if got := outboundConnections(10, conns); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestDiscoverConnections_Unprivileged(t *testing.T) {
+	origProcesses, origConnections := listProcesses, listConnections
+	defer func() { listProcesses, listConnections = origProcesses, origConnections }()
+
+	listProcesses = func() ([]processInfo, error) {
+		return []processInfo{{pid: 42, ppid: 1, cwd: "/var/www/app"}}, nil
+	}
+	listConnections = func() ([]psnet.ConnectionStat, error) {
+		return nil, errors.New("permission denied")
+	}
+
+	apps := []messages.AppInfo{{Path: "/var/www/app"}}
+	discoverConnections(apps)
+
+	if apps[0].PID != 0 || apps[0].Connections != nil {
+		t.Errorf("expected the app left as is when connections can't be read, got %+v", apps[0])
+	}
+}
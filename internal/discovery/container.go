@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// Container runtimes reported in discovery
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd"
+	RuntimeCRIO       = "cri-o"
+	RuntimeLXC        = "lxc"
+	RuntimeUnknown    = "unknown"
+)
+
+// cgroupMarkers identify the runtime from PID 1's cgroup paths (cgroup v1,
+// and v2 hosts that don't namespace cgroups), checked in order
+var cgroupMarkers = []struct {
+	runtime string
+	markers []string
+}{
+	{RuntimePodman, []string{"libpod"}},
+	{RuntimeCRIO, []string{"crio-"}},
+	{RuntimeContainerd, []string{"cri-containerd", "containerd"}},
+	{RuntimeDocker, []string{"docker"}},
+	{RuntimeLXC, []string{"lxc"}},
+}
+
+var (
+	// detectContainer finds the container the agent runs in (replaced in tests)
+	detectContainer = func() *messages.Containerization { return detectContainerAt("/", os.Getenv) }
+
+	containerOnce sync.Once
+	container     *messages.Containerization
+)
+
+// Container returns the container the agent runs in, or nil on a plain host.
+// Detected once per process.
+func Container() *messages.Containerization {
+	containerOnce.Do(func() {
+		container = detectContainer()
+	})
+	return container
+}
+
+// detectContainerAt detects containerization of the filesystem rooted at
+// root, with getenv reading the agent's environment
+func detectContainerAt(root string, getenv func(string) string) *messages.Containerization {
+	runtime := containerRuntime(root, getenv)
+
+	var k8s *messages.KubernetesInfo
+	if getenv("KUBERNETES_SERVICE_HOST") != "" {
+		k8s = kubernetesInfo(root, getenv)
+	}
+
+	if runtime == "" && k8s == nil {
+		return nil
+	}
+	if runtime == "" {
+		runtime = RuntimeUnknown
+	}
+	return &messages.Containerization{Runtime: runtime, Kubernetes: k8s}
+}
+
+// containerRuntime identifies the container runtime from its marker files,
+// the "container" env var runtimes set, and PID 1's cgroups; empty when none
+// are found
+func containerRuntime(root string, getenv func(string) string) string {
+	if _, err := os.Stat(filepath.Join(root, ".dockerenv")); err == nil {
+		return RuntimeDocker
+	}
+	if _, err := os.Stat(filepath.Join(root, "run/.containerenv")); err == nil {
+		return RuntimePodman
+	}
+
+	switch env := getenv("container"); env {
+	case "":
+	case "oci":
+		// Set by several runtimes; the cgroups may tell which
+	default:
+		return env
+	}
+
+	if data, err := os.ReadFile(filepath.Join(root, "proc/1/cgroup")); err == nil {
+		cgroups := string(data)
+		for _, marker := range cgroupMarkers {
+			for _, m := range marker.markers {
+				if strings.Contains(cgroups, m) {
+					return marker.runtime
+				}
+			}
+		}
+	}
+
+	if getenv("container") != "" {
+		return RuntimeUnknown
+	}
+	return ""
+}
+
+// kubernetesInfo reads the pod name and namespace, from downward API env vars
+// where set, else the hostname and the service account's namespace
+func kubernetesInfo(root string, getenv func(string) string) *messages.KubernetesInfo {
+	info := &messages.KubernetesInfo{
+		Pod:       getenv("POD_NAME"),
+		Namespace: getenv("POD_NAMESPACE"),
+	}
+
+	if info.Pod == "" {
+		info.Pod = getenv("HOSTNAME")
+	}
+	if info.Namespace == "" {
+		if data, err := os.ReadFile(filepath.Join(root, "var/run/secrets/kubernetes.io/serviceaccount/namespace")); err == nil {
+			info.Namespace = strings.TrimSpace(string(data))
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"testing"
+)
+
+// fakeEnv returns a getenv reading from vars
+func fakeEnv(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func TestDetectContainerAt(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		env     map[string]string
+		runtime string // empty for no container
+	}{
+		{"plain host", map[string]string{"proc/1/cgroup": "0::/init.scope\n"}, nil, ""},
+		{"dockerenv", map[string]string{".dockerenv": ""}, nil, RuntimeDocker},
+		{"containerenv", map[string]string{"run/.containerenv": ""}, nil, RuntimePodman},
+		{"docker cgroup", map[string]string{"proc/1/cgroup": "12:pids:/docker/4f1c2b\n"}, nil, RuntimeDocker},
+		{"containerd cgroup", map[string]string{"proc/1/cgroup": "1:name=systemd:/kubepods/burstable/pod1/cri-containerd-ab12\n"}, nil, RuntimeContainerd},
+		{"cri-o cgroup", map[string]string{"proc/1/cgroup": "0::/kubepods.slice/crio-ab12.scope\n"}, nil, RuntimeCRIO},
+		{"container env", nil, map[string]string{"container": "lxc"}, RuntimeLXC},
+		{"oci env without cgroup marker", nil, map[string]string{"container": "oci"}, RuntimeUnknown},
+		{"kubernetes env only", nil, map[string]string{"KUBERNETES_SERVICE_HOST": "10.0.0.1"}, RuntimeUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for path, content := range tt.files {
+				writeRootFile(t, root, path, content)
+			}
+
+			info := detectContainerAt(root, fakeEnv(tt.env))
+			if tt.runtime == "" {
+				if info != nil {
+					t.Errorf("expected no container, got %+v", info)
+				}
+				return
+			}
+			if info == nil || info.Runtime != tt.runtime {
+				t.Errorf("expected runtime %s, got %+v", tt.runtime, info)
+			}
+		})
+	}
+}
+
+func TestDetectContainerAt_Kubernetes(t *testing.T) {
+	root := t.TempDir()
+	writeRootFile(t, root, "proc/1/cgroup", "0::/kubepods/besteffort/pod1/cri-containerd-ab12\n")
+	writeRootFile(t, root, "var/run/secrets/kubernetes.io/serviceaccount/namespace", "shop\n")
+
+	info := detectContainerAt(root, fakeEnv(map[string]string{
+		"KUBERNETES_SERVICE_HOST": "10.0.0.1",
+		"HOSTNAME":                "web-7d9f-abcde",
+	}))
+	if info == nil || info.Kubernetes == nil {
+		t.Fatalf("expected kubernetes info, got %+v", info)
+	}
+	if info.Runtime != RuntimeContainerd {
+		t.Errorf("expected runtime %s, got %s", RuntimeContainerd, info.Runtime)
+	}
+	if info.Kubernetes.Pod != "web-7d9f-abcde" || info.Kubernetes.Namespace != "shop" {
+		t.Errorf("expected pod from hostname and namespace from service account, got %+v", info.Kubernetes)
+	}
+
+	// Downward API env vars take precedence
+	info = detectContainerAt(root, fakeEnv(map[string]string{
+		"KUBERNETES_SERVICE_HOST": "10.0.0.1",
+		"HOSTNAME":                "web-7d9f-abcde",
+		"POD_NAME":                "web-0",
+		"POD_NAMESPACE":           "staging",
+	}))
+	if info.Kubernetes.Pod != "web-0" || info.Kubernetes.Namespace != "staging" {
+		t.Errorf("expected pod and namespace from env, got %+v", info.Kubernetes)
+	}
+}
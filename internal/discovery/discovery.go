@@ -1,12 +1,14 @@
 package discovery
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/codebasehealth/antidote-agent/internal/messages"
@@ -36,14 +38,26 @@ func Discover() *messages.DiscoveryMessage {
 	// System info
 	msg.System = gatherSystemInfo()
 
+	// Mandatory access control
+	msg.AccessControl = discoverAccessControl()
+
+	// Containerization
+	msg.Container = Container()
+
 	// Services
+	msg.InitSystem = InitSystem()
 	msg.Services = discoverServices()
 
-	// Languages
+	// Languages, and the PATH they were found on
 	msg.Languages = discoverLanguages()
+	msg.SearchPath = searchPath()
 
 	// Apps
 	msg.Apps = discoverApps()
+	discoverConnections(msg.Apps)
+
+	// Web server sites, tied to the apps they serve
+	msg.VirtualHosts = discoverVirtualHosts(msg.Apps)
 
 	// Log discovery summary
 	appsWithConfig := 0
@@ -61,23 +75,45 @@ func Discover() *messages.DiscoveryMessage {
 	return msg
 }
 
+// System metric collectors (replaced in tests)
+var (
+	virtualMemory = mem.VirtualMemory
+	diskUsage     = func() (*disk.UsageStat, error) { return disk.Usage("/") }
+	loadAvg       = load.Avg
+)
+
 func gatherSystemInfo() messages.SystemInfo {
 	info := messages.SystemInfo{}
 
 	info.CPUCores = runtime.NumCPU()
 
-	if mem, err := mem.VirtualMemory(); err == nil {
-		info.MemoryTotal = mem.Total
-		info.MemoryFree = mem.Available
+	var unavailable []string
+
+	if memInfo, err := virtualMemory(); err == nil {
+		info.MemoryTotal = memInfo.Total
+		info.MemoryFree = memInfo.Available
+		info.MemoryAvailable = true
+	} else {
+		unavailable = append(unavailable, fmt.Sprintf("memory (%v)", err))
 	}
 
-	if disk, err := disk.Usage("/"); err == nil {
-		info.DiskTotal = disk.Total
-		info.DiskFree = disk.Free
+	if diskInfo, err := diskUsage(); err == nil {
+		info.DiskTotal = diskInfo.Total
+		info.DiskFree = diskInfo.Free
+		info.DiskAvailable = true
+	} else {
+		unavailable = append(unavailable, fmt.Sprintf("disk (%v)", err))
 	}
 
-	if avg, err := load.Avg(); err == nil {
+	if avg, err := loadAvg(); err == nil {
 		info.LoadAvg = avg.Load1
+		info.LoadAvailable = true
+	} else {
+		unavailable = append(unavailable, fmt.Sprintf("load (%v)", err))
+	}
+
+	if len(unavailable) > 0 {
+		log.Printf("System info unavailable, reported as zero: %s", strings.Join(unavailable, ", "))
 	}
 
 	return info
@@ -122,24 +158,22 @@ func discoverServices() []messages.ServiceInfo {
 }
 
 func checkServiceStatus(name string) string {
-	// Try systemctl first
-	cmd := exec.Command("systemctl", "is-active", name)
-	out, err := cmd.Output()
-	if err == nil {
-		status := strings.TrimSpace(string(out))
-		if status == "active" {
-			return "running"
+	initSystem := InitSystem()
+	if initSystem == InitUnknown {
+		// Fall back to trying systemctl, then the service command
+		if status := runServiceStatus(InitSystemd, []string{"systemctl", "is-active", name}); status != "" {
+			return status
 		}
-		return status
+		return runServiceStatus(InitSysV, []string{"service", name, "status"})
 	}
 
-	// Try service command
-	cmd = exec.Command("service", name, "status")
-	if err := cmd.Run(); err == nil {
-		return "running"
-	}
+	return runServiceStatus(initSystem, ServiceStatusCommand(name))
+}
 
-	return ""
+// runServiceStatus runs a status command and parses its output
+func runServiceStatus(initSystem string, args []string) string {
+	out, err := probeCommand(nil, args[0], args[1:]...).Output()
+	return parseServiceStatus(initSystem, string(out), err == nil)
 }
 
 func getServiceVersion(name string) string {
@@ -147,15 +181,15 @@ func getServiceVersion(name string) string {
 
 	switch {
 	case strings.HasPrefix(name, "php"):
-		cmd = exec.Command("php", "-v")
+		cmd = probeCommand(nil, "php", "-v")
 	case name == "nginx":
-		cmd = exec.Command("nginx", "-v")
+		cmd = probeCommand(nil, "nginx", "-v")
 	case name == "mysql" || name == "mariadb":
-		cmd = exec.Command("mysql", "--version")
+		cmd = probeCommand(nil, "mysql", "--version")
 	case name == "postgresql":
-		cmd = exec.Command("psql", "--version")
+		cmd = probeCommand(nil, "psql", "--version")
 	case name == "redis" || name == "redis-server":
-		cmd = exec.Command("redis-server", "--version")
+		cmd = probeCommand(nil, "redis-server", "--version")
 	default:
 		return ""
 	}
@@ -178,42 +212,32 @@ func discoverLanguages() []messages.LanguageInfo {
 	languages := []messages.LanguageInfo{}
 
 	// PHP
-	if path, err := exec.LookPath("php"); err == nil {
-		if out, err := exec.Command("php", "-v").Output(); err == nil {
+	if info, ok := locateBinary("php"); ok {
+		if out, err := probeCommand(nil, info.Path, "-v").Output(); err == nil {
 			re := regexp.MustCompile(`PHP ([\d]+\.[\d]+\.[\d]+)`)
 			if match := re.FindStringSubmatch(string(out)); len(match) > 1 {
-				languages = append(languages, messages.LanguageInfo{
-					Name:    "php",
-					Version: match[1],
-					Path:    path,
-				})
+				info.Name, info.Version = "php", match[1]
+				languages = append(languages, info)
 			}
 		}
 	}
 
 	// Node
-	if path, err := exec.LookPath("node"); err == nil {
-		if out, err := exec.Command("node", "-v").Output(); err == nil {
-			version := strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
-			languages = append(languages, messages.LanguageInfo{
-				Name:    "node",
-				Version: version,
-				Path:    path,
-			})
+	if info, ok := locateBinary("node"); ok {
+		if out, err := probeCommand(nil, info.Path, "-v").Output(); err == nil {
+			info.Name, info.Version = "node", strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
+			languages = append(languages, info)
 		}
 	}
 
 	// Python
 	for _, pyCmd := range []string{"python3", "python"} {
-		if path, err := exec.LookPath(pyCmd); err == nil {
-			if out, err := exec.Command(pyCmd, "--version").Output(); err == nil {
+		if info, ok := locateBinary(pyCmd); ok {
+			if out, err := probeCommand(nil, info.Path, "--version").Output(); err == nil {
 				re := regexp.MustCompile(`Python ([\d]+\.[\d]+\.[\d]+)`)
 				if match := re.FindStringSubmatch(string(out)); len(match) > 1 {
-					languages = append(languages, messages.LanguageInfo{
-						Name:    "python",
-						Version: match[1],
-						Path:    path,
-					})
+					info.Name, info.Version = "python", match[1]
+					languages = append(languages, info)
 					break
 				}
 			}
@@ -221,29 +245,23 @@ func discoverLanguages() []messages.LanguageInfo {
 	}
 
 	// Ruby
-	if path, err := exec.LookPath("ruby"); err == nil {
-		if out, err := exec.Command("ruby", "-v").Output(); err == nil {
+	if info, ok := locateBinary("ruby"); ok {
+		if out, err := probeCommand(nil, info.Path, "-v").Output(); err == nil {
 			re := regexp.MustCompile(`ruby ([\d]+\.[\d]+\.[\d]+)`)
 			if match := re.FindStringSubmatch(string(out)); len(match) > 1 {
-				languages = append(languages, messages.LanguageInfo{
-					Name:    "ruby",
-					Version: match[1],
-					Path:    path,
-				})
+				info.Name, info.Version = "ruby", match[1]
+				languages = append(languages, info)
 			}
 		}
 	}
 
 	// Go
-	if path, err := exec.LookPath("go"); err == nil {
-		if out, err := exec.Command("go", "version").Output(); err == nil {
+	if info, ok := locateBinary("go"); ok {
+		if out, err := probeCommand(nil, info.Path, "version").Output(); err == nil {
 			re := regexp.MustCompile(`go([\d]+\.[\d]+\.?[\d]*)`)
 			if match := re.FindStringSubmatch(string(out)); len(match) > 1 {
-				languages = append(languages, messages.LanguageInfo{
-					Name:    "go",
-					Version: match[1],
-					Path:    path,
-				})
+				info.Name, info.Version = "go", match[1]
+				languages = append(languages, info)
 			}
 		}
 	}
@@ -251,19 +269,86 @@ func discoverLanguages() []messages.LanguageInfo {
 	return languages
 }
 
-func discoverApps() []messages.AppInfo {
-	apps := []messages.AppInfo{}
+// searchPath returns the PATH entries binaries are resolved with
+func searchPath() []string {
+	return filepath.SplitList(os.Getenv("PATH"))
+}
+
+// locateBinary finds a binary on PATH, returning where it is, where it
+// resolves to through symlinks, and the binaries of the same name it hides
+// later on PATH. The version probe runs the returned Path, so the reported
+// version and path always belong to the same binary.
+func locateBinary(name string) (messages.LanguageInfo, bool) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return messages.LanguageInfo{}, false
+	}
+
+	info := messages.LanguageInfo{Path: path}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil && resolved != path {
+		info.ResolvedPath = resolved
+	}
+
+	first, err := os.Stat(path)
+	if err != nil {
+		return info, true
+	}
 
-	// Common app directories to check
-	searchPaths := []string{
-		"/home/forge",
-		"/home/deploy",
-		"/var/www",
-		"/srv",
-		"/app",
-		"/opt/apps",
+	for _, dir := range searchPath() {
+		// Relative entries resolve against the agent's working directory;
+		// LookPath refuses them too
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+
+		candidate, err := exec.LookPath(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+
+		// Skip the binary itself, also when reached through a symlinked
+		// directory (e.g. /bin -> /usr/bin)
+		if stat, err := os.Stat(candidate); err != nil || os.SameFile(first, stat) {
+			continue
+		}
+		if !slices.Contains(info.Shadowed, candidate) {
+			info.Shadowed = append(info.Shadowed, candidate)
+		}
 	}
 
+	return info, true
+}
+
+// appSearchPaths are the common app directories checked for apps
+var appSearchPaths = []string{
+	"/home/forge",
+	"/home/deploy",
+	"/var/www",
+	"/srv",
+	"/app",
+	"/opt/apps",
+}
+
+// AppSearchPaths returns the base directories checked for apps
+func AppSearchPaths() []string {
+	return append([]string(nil), appSearchPaths...)
+}
+
+func discoverApps() []messages.AppInfo {
+	return DiscoverAppsIn(appSearchPaths...)
+}
+
+// DiscoverApps runs just app discovery, for refreshing the apps (and the
+// paths commands may run in) without a full discovery
+func DiscoverApps() []messages.AppInfo {
+	return discoverApps()
+}
+
+// DiscoverAppsIn finds apps in the given base directories, one level deep
+// (or their "current" release directory for Forge/Capistrano deploys)
+func DiscoverAppsIn(searchPaths ...string) []messages.AppInfo {
+	apps := []messages.AppInfo{}
+
 	for _, basePath := range searchPaths {
 		if _, err := os.Stat(basePath); os.IsNotExist(err) {
 			continue
@@ -306,6 +391,8 @@ func analyzeApp(path string) *messages.AppInfo {
 		Path: path,
 	}
 
+	var pkg *packageJSON
+
 	// Check for antidote.yml first - this takes priority
 	configPath := filepath.Join(path, "antidote.yml")
 	if config := readAntidoteConfig(configPath); config != nil {
@@ -316,20 +403,8 @@ func analyzeApp(path string) *messages.AppInfo {
 		if _, err := os.Stat(filepath.Join(path, "artisan")); err == nil {
 			app.Framework = "laravel"
 		} else if _, err := os.Stat(filepath.Join(path, "package.json")); err == nil {
-			// Check for specific frameworks
-			if _, err := os.Stat(filepath.Join(path, "next.config.js")); err == nil {
-				app.Framework = "nextjs"
-			} else if _, err := os.Stat(filepath.Join(path, "next.config.mjs")); err == nil {
-				app.Framework = "nextjs"
-			} else if _, err := os.Stat(filepath.Join(path, "next.config.ts")); err == nil {
-				app.Framework = "nextjs"
-			} else if _, err := os.Stat(filepath.Join(path, "nuxt.config.js")); err == nil {
-				app.Framework = "nuxt"
-			} else if _, err := os.Stat(filepath.Join(path, "nuxt.config.ts")); err == nil {
-				app.Framework = "nuxt"
-			} else {
-				app.Framework = "node"
-			}
+			pkg = readPackageJSON(filepath.Join(path, "package.json"))
+			app.Framework = detectNodeFramework(path, pkg)
 		} else if _, err := os.Stat(filepath.Join(path, "Gemfile")); err == nil {
 			app.Framework = "rails"
 		} else if _, err := os.Stat(filepath.Join(path, "manage.py")); err == nil {
@@ -342,6 +417,18 @@ func analyzeApp(path string) *messages.AppInfo {
 		}
 	}
 
+	app.DefaultLogs = DefaultLogConfig(app.Framework)
+
+	// Node apps configured by antidote.yml haven't read package.json yet
+	if isNodeFramework(app.Framework) {
+		if pkg == nil && app.Config != nil {
+			pkg = readPackageJSON(filepath.Join(path, "package.json"))
+		}
+		if pkg != nil {
+			applyPackageJSON(app, pkg)
+		}
+	}
+
 	// Git info
 	if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
 		app.GitRemote = getGitRemote(path)
@@ -352,6 +439,20 @@ func analyzeApp(path string) *messages.AppInfo {
 	return app
 }
 
+// appMarkers are the files analyzeApp recognizes an app by
+var appMarkers = []string{"antidote.yml", "artisan", "package.json", "Gemfile", "manage.py", "go.mod"}
+
+// LooksLikeApp reports whether path is a directory app discovery would
+// report, without the cost of analyzing it
+func LooksLikeApp(path string) bool {
+	for _, marker := range appMarkers {
+		if _, err := os.Stat(filepath.Join(path, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
 // readAntidoteConfig reads and parses an antidote.yml file
 func readAntidoteConfig(path string) *messages.AppConfig {
 	data, err := os.ReadFile(path)
@@ -359,19 +460,29 @@ func readAntidoteConfig(path string) *messages.AppConfig {
 		return nil
 	}
 
+	config, err := parseAntidoteConfig(data)
+	if err != nil {
+		log.Printf("Invalid antidote.yml at %s: %v", path, err)
+		return nil
+	}
+	applyProfile(config, getEnvironment())
+
+	return config
+}
+
+// parseAntidoteConfig parses antidote.yml content and checks required fields
+func parseAntidoteConfig(data []byte) (*messages.AppConfig, error) {
 	var config messages.AppConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
-		log.Printf("Failed to parse antidote.yml at %s: %v", path, err)
-		return nil
+		return nil, fmt.Errorf("failed to parse: %w", err)
 	}
 
 	// Validate minimum required fields
 	if config.App.Name == "" || config.App.Framework == "" {
-		log.Printf("Invalid antidote.yml at %s: missing name or framework", path)
-		return nil
+		return nil, fmt.Errorf("missing name or framework")
 	}
 
-	return &config
+	return &config, nil
 }
 
 func getGitRemote(path string) string {
@@ -410,7 +521,7 @@ func discoverDocker() *messages.DockerInfo {
 	docker := &messages.DockerInfo{}
 
 	// Get version
-	if out, err := exec.Command("docker", "--version").Output(); err == nil {
+	if out, err := probeCommand(nil, "docker", "--version").Output(); err == nil {
 		re := regexp.MustCompile(`Docker version ([\d]+\.[\d]+\.[\d]+)`)
 		if match := re.FindStringSubmatch(string(out)); len(match) > 1 {
 			docker.Version = match[1]
@@ -418,7 +529,7 @@ func discoverDocker() *messages.DockerInfo {
 	}
 
 	// Get containers
-	cmd := exec.Command("docker", "ps", "--format", "{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Status}}")
+	cmd := probeCommand([]string{"docker"}, "docker", "ps", "--format", "{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Status}}")
 	out, err := cmd.Output()
 	if err != nil {
 		return docker
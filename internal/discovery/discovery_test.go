@@ -1,9 +1,15 @@
 package discovery
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
 )
 
 func TestReadAntidoteConfig(t *testing.T) {
@@ -270,6 +276,157 @@ app:
 			if tt.expectHasConfig && app.Config == nil {
 				t.Error("Expected config to be set, got nil")
 			}
+
+			if !reflect.DeepEqual(app.DefaultLogs, DefaultLogConfig(tt.expectedFW)) {
+				t.Errorf("DefaultLogs = %+v, expected the %s defaults", app.DefaultLogs, tt.expectedFW)
+			}
 		})
 	}
 }
+
+func TestReadAntidoteConfigProfiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "antidote.yml")
+
+	config := `version: 1
+app:
+  name: myapp
+  framework: laravel
+trust_level: balanced
+actions:
+  clear_cache:
+    command: php artisan cache:clear
+    label: Clear Cache
+deny:
+  - "rm -rf"
+health:
+  endpoint: /health
+  interval: 60s
+profiles:
+  production:
+    trust_level: strict
+    actions:
+      migrate:
+        command: php artisan migrate --force
+        label: Migrate
+    deny:
+      - "rm -rf"
+      - "migrate:fresh"
+    health:
+      endpoint: /health
+      interval: 15s
+`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	t.Cleanup(func() { SetEnvironment("") })
+
+	// Production profile overrides the base
+	SetEnvironment("Production")
+	result := readAntidoteConfig(configPath)
+	if result == nil {
+		t.Fatal("Expected config to be parsed")
+	}
+	if result.Profile != "production" {
+		t.Errorf("Expected profile production, got %q", result.Profile)
+	}
+	if result.Health == nil || result.Health.Interval != "15s" {
+		t.Errorf("Expected production health interval 15s, got %+v", result.Health)
+	}
+	if result.TrustLevel != "strict" {
+		t.Errorf("Expected trust_level strict, got %s", result.TrustLevel)
+	}
+	if len(result.Deny) != 2 {
+		t.Errorf("Expected 2 deny patterns, got %v", result.Deny)
+	}
+	if _, ok := result.Actions["clear_cache"]; !ok {
+		t.Error("Expected base action to be kept")
+	}
+	if _, ok := result.Actions["migrate"]; !ok {
+		t.Error("Expected profile action to be merged")
+	}
+
+	// Other environments keep the base config
+	SetEnvironment("staging")
+	result = readAntidoteConfig(configPath)
+	if result == nil {
+		t.Fatal("Expected config to be parsed")
+	}
+	if result.Profile != "" {
+		t.Errorf("Expected no profile, got %q", result.Profile)
+	}
+	if result.Health == nil || result.Health.Interval != "60s" {
+		t.Errorf("Expected base health interval 60s, got %+v", result.Health)
+	}
+	if result.TrustLevel != "balanced" || len(result.Deny) != 1 || len(result.Actions) != 1 {
+		t.Errorf("Expected base config, got %+v", result)
+	}
+}
+
+func TestGatherSystemInfoUnavailable(t *testing.T) {
+	origMem, origDisk, origLoad := virtualMemory, diskUsage, loadAvg
+	t.Cleanup(func() {
+		virtualMemory, diskUsage, loadAvg = origMem, origDisk, origLoad
+	})
+
+	virtualMemory = func() (*mem.VirtualMemoryStat, error) {
+		return &mem.VirtualMemoryStat{Total: 4 << 30, Available: 0}, nil
+	}
+	diskUsage = func() (*disk.UsageStat, error) {
+		return nil, errors.New("statfs /: operation not permitted")
+	}
+	loadAvg = func() (*load.AvgStat, error) {
+		return nil, errors.New("not implemented yet")
+	}
+
+	info := gatherSystemInfo()
+
+	// Zero free memory is a real reading
+	if !info.MemoryAvailable || info.MemoryFree != 0 {
+		t.Errorf("Expected memory to be available, got %+v", info)
+	}
+	if info.DiskAvailable || info.LoadAvailable {
+		t.Errorf("Expected disk and load to be flagged unavailable, got %+v", info)
+	}
+}
+
+func TestDiscoverAppsIn(t *testing.T) {
+	root := t.TempDir()
+
+	mkApp := func(dir, marker string) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, marker), []byte(""), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mkApp(filepath.Join(root, "api"), "go.mod")
+	mkApp(filepath.Join(root, "site", "current"), "artisan")
+	if err := os.MkdirAll(filepath.Join(root, "not-an-app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	apps := DiscoverAppsIn(root, filepath.Join(root, "missing"))
+	if len(apps) != 2 {
+		t.Fatalf("expected 2 apps, got %+v", apps)
+	}
+
+	paths := map[string]string{}
+	for _, app := range apps {
+		paths[app.Path] = app.Framework
+	}
+	if paths[filepath.Join(root, "api")] != "go" {
+		t.Errorf("expected go app at api, got %+v", paths)
+	}
+	if paths[filepath.Join(root, "site", "current")] != "laravel" {
+		t.Errorf("expected laravel app at site/current, got %+v", paths)
+	}
+
+	if !LooksLikeApp(filepath.Join(root, "api")) {
+		t.Error("expected api to look like an app")
+	}
+	if LooksLikeApp(filepath.Join(root, "not-an-app")) || LooksLikeApp(filepath.Join(root, "missing")) {
+		t.Error("expected plain and missing directories not to look like apps")
+	}
+}
@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Init systems the agent knows how to query and control services with
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+	InitRunit   = "runit"
+	InitS6      = "s6"
+	InitSysV    = "sysvinit"
+	InitUnknown = "unknown"
+)
+
+// s6ServiceDir is the scan directory s6 services are controlled through
+const s6ServiceDir = "/run/service"
+
+// initMarkers are paths whose presence identifies the running init system,
+// checked in order (systemd hosts often also have /etc/init.d)
+var initMarkers = []struct {
+	initSystem string
+	paths      []string
+}{
+	{InitSystemd, []string{"run/systemd/system"}},
+	{InitOpenRC, []string{"run/openrc"}},
+	{InitRunit, []string{"run/runit", "etc/runit/runsvdir"}},
+	{InitS6, []string{"run/s6", "run/s6-rc"}},
+	{InitSysV, []string{"etc/init.d"}},
+}
+
+var (
+	// detectInitSystem finds the host's init system (replaced in tests)
+	detectInitSystem = func() string { return detectInitSystemAt("/") }
+
+	initSystemOnce sync.Once
+	initSystem     string
+)
+
+// InitSystem returns the host's init system, detected once per process
+func InitSystem() string {
+	initSystemOnce.Do(func() {
+		initSystem = detectInitSystem()
+	})
+	return initSystem
+}
+
+// detectInitSystemAt detects the init system of the filesystem rooted at root
+func detectInitSystemAt(root string) string {
+	for _, marker := range initMarkers {
+		for _, path := range marker.paths {
+			if _, err := os.Stat(filepath.Join(root, path)); err == nil {
+				return marker.initSystem
+			}
+		}
+	}
+	return InitUnknown
+}
+
+// ServiceStatusCommand returns the command that reports a service's status
+// under the detected init system
+func ServiceStatusCommand(name string) []string {
+	switch InitSystem() {
+	case InitSystemd:
+		return []string{"systemctl", "is-active", name}
+	case InitOpenRC:
+		return []string{"rc-service", name, "status"}
+	case InitRunit:
+		return []string{"sv", "status", name}
+	case InitS6:
+		return []string{"s6-svstat", filepath.Join(s6ServiceDir, name)}
+	default:
+		return []string{"service", name, "status"}
+	}
+}
+
+// ServiceRestartCommand returns the command that restarts a service under the
+// detected init system
+func ServiceRestartCommand(name string) []string {
+	switch InitSystem() {
+	case InitSystemd:
+		return []string{"systemctl", "restart", name}
+	case InitOpenRC:
+		return []string{"rc-service", name, "restart"}
+	case InitRunit:
+		return []string{"sv", "restart", name}
+	case InitS6:
+		return []string{"s6-svc", "-r", filepath.Join(s6ServiceDir, name)}
+	default:
+		return []string{"service", name, "restart"}
+	}
+}
+
+// parseServiceStatus turns status command output into a service status
+// ("running", "stopped", or systemd's own state). ok reports whether the
+// command exited successfully.
+func parseServiceStatus(initSystem, output string, ok bool) string {
+	output = strings.TrimSpace(output)
+
+	switch initSystem {
+	case InitSystemd:
+		// is-active exits non-zero for inactive and unknown units alike
+		if !ok {
+			return ""
+		}
+		if output == "active" {
+			return "running"
+		}
+		return output
+	case InitRunit:
+		// run: nginx: (pid 123) 45s / down: nginx: 3s, normally up
+		if strings.HasPrefix(output, "run:") {
+			return "running"
+		}
+		if strings.HasPrefix(output, "down:") {
+			return "stopped"
+		}
+		return ""
+	case InitS6:
+		// up (pid 123) 45 seconds / down (exitcode 0) 3 seconds
+		if strings.HasPrefix(output, "up ") {
+			return "running"
+		}
+		if strings.HasPrefix(output, "down ") {
+			return "stopped"
+		}
+		return ""
+	default:
+		if ok {
+			return "running"
+		}
+		return ""
+	}
+}
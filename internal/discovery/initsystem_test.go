@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeInitSystem makes InitSystem report the given init system for the test
+func fakeInitSystem(t *testing.T, initSystem string) {
+	t.Helper()
+
+	orig := detectInitSystem
+	detectInitSystem = func() string { return initSystem }
+	initSystemOnce = sync.Once{}
+	t.Cleanup(func() {
+		detectInitSystem = orig
+		initSystemOnce = sync.Once{}
+	})
+}
+
+func TestServiceCommands_FollowInitSystem(t *testing.T) {
+	tests := []struct {
+		initSystem string
+		status     []string
+		restart    []string
+	}{
+		{InitSystemd, []string{"systemctl", "is-active", "nginx"}, []string{"systemctl", "restart", "nginx"}},
+		{InitOpenRC, []string{"rc-service", "nginx", "status"}, []string{"rc-service", "nginx", "restart"}},
+		{InitRunit, []string{"sv", "status", "nginx"}, []string{"sv", "restart", "nginx"}},
+		{InitS6, []string{"s6-svstat", "/run/service/nginx"}, []string{"s6-svc", "-r", "/run/service/nginx"}},
+		{InitSysV, []string{"service", "nginx", "status"}, []string{"service", "nginx", "restart"}},
+		{InitUnknown, []string{"service", "nginx", "status"}, []string{"service", "nginx", "restart"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.initSystem, func(t *testing.T) {
+			fakeInitSystem(t, tt.initSystem)
+
+			if got := ServiceStatusCommand("nginx"); !reflect.DeepEqual(got, tt.status) {
+				t.Errorf("status command = %v, want %v", got, tt.status)
+			}
+			if got := ServiceRestartCommand("nginx"); !reflect.DeepEqual(got, tt.restart) {
+				t.Errorf("restart command = %v, want %v", got, tt.restart)
+			}
+		})
+	}
+}
+
+func TestInitSystem_DetectedOnce(t *testing.T) {
+	calls := 0
+	fakeInitSystem(t, InitOpenRC)
+	detectInitSystem = func() string { calls++; return InitOpenRC }
+
+	InitSystem()
+	if got := InitSystem(); got != InitOpenRC {
+		t.Errorf("expected %s, got %s", InitOpenRC, got)
+	}
+	if calls != 1 {
+		t.Errorf("expected detection to run once, ran %d times", calls)
+	}
+}
+
+func TestDetectInitSystemAt(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  string
+	}{
+		{"systemd wins over init.d", []string{"run/systemd/system", "etc/init.d"}, InitSystemd},
+		{"openrc", []string{"run/openrc", "etc/init.d"}, InitOpenRC},
+		{"runit", []string{"etc/runit/runsvdir"}, InitRunit},
+		{"s6", []string{"run/s6-rc"}, InitS6},
+		{"sysvinit", []string{"etc/init.d"}, InitSysV},
+		{"unknown", nil, InitUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, path := range tt.paths {
+				if err := os.MkdirAll(filepath.Join(root, path), 0755); err != nil {
+					t.Fatalf("failed to create %s: %v", path, err)
+				}
+			}
+
+			if got := detectInitSystemAt(root); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseServiceStatus(t *testing.T) {
+	tests := []struct {
+		initSystem string
+		output     string
+		ok         bool
+		want       string
+	}{
+		{InitSystemd, "active\n", true, "running"},
+		{InitSystemd, "inactive\n", false, ""},
+		{InitOpenRC, " * status: started\n", true, "running"},
+		{InitOpenRC, " * status: stopped\n", false, ""},
+		{InitRunit, "run: nginx: (pid 123) 45s\n", true, "running"},
+		{InitRunit, "down: nginx: 3s, normally up\n", true, "stopped"},
+		{InitRunit, "fail: nginx: unable to change to service directory\n", false, ""},
+		{InitS6, "up (pid 123) 45 seconds\n", true, "running"},
+		{InitS6, "down (exitcode 0) 3 seconds\n", true, "stopped"},
+	}
+
+	for _, tt := range tests {
+		if got := parseServiceStatus(tt.initSystem, tt.output, tt.ok); got != tt.want {
+			t.Errorf("parseServiceStatus(%s, %q, %t) = %q, want %q", tt.initSystem, tt.output, tt.ok, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package discovery
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// logCatalog holds where each framework writes its logs by default and the
+// patterns that pick out its errors. Level names are compared against the
+// severity of frameworks with a log parser (laravel, rails, django).
+var logCatalog = map[string]messages.AppLogConfig{
+	"laravel": {
+		LogPaths:      []string{"storage/logs/*.log"},
+		ErrorPatterns: []string{"ERROR", "CRITICAL", "ALERT", "EMERGENCY"},
+	},
+	"rails": {
+		LogPaths:      []string{"log/production.log"},
+		ErrorPatterns: []string{"ERROR", "FATAL"},
+	},
+	"django": {
+		LogPaths:      []string{"logs/*.log"},
+		ErrorPatterns: []string{"ERROR", "CRITICAL", "Traceback (most recent call last)"},
+	},
+	"nextjs": {
+		LogPaths:      []string{"logs/*.log"},
+		ErrorPatterns: []string{"Error:", "Unhandled Rejection", "uncaughtException"},
+	},
+	"nuxt": {
+		LogPaths:      []string{"logs/*.log"},
+		ErrorPatterns: []string{"[nuxt] [request error]", "Error:", "Unhandled Rejection", "uncaughtException"},
+	},
+}
+
+// DefaultLogConfig returns the default log paths (relative to the app) and
+// error patterns for a framework, or nil if it has none. The cloud can use
+// them as a starting point for an app's monitoring config.
+func DefaultLogConfig(framework string) *messages.AppLogConfig {
+	defaults, ok := logCatalog[strings.ToLower(framework)]
+	if !ok {
+		return nil
+	}
+	return &messages.AppLogConfig{
+		LogPaths:      slices.Clone(defaults.LogPaths),
+		ErrorPatterns: slices.Clone(defaults.ErrorPatterns),
+	}
+}
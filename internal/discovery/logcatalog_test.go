@@ -0,0 +1,61 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func TestDefaultLogConfig(t *testing.T) {
+	tests := []struct {
+		framework string
+		expected  *messages.AppLogConfig
+	}{
+		{"laravel", &messages.AppLogConfig{
+			LogPaths:      []string{"storage/logs/*.log"},
+			ErrorPatterns: []string{"ERROR", "CRITICAL", "ALERT", "EMERGENCY"},
+		}},
+		{"Laravel", &messages.AppLogConfig{
+			LogPaths:      []string{"storage/logs/*.log"},
+			ErrorPatterns: []string{"ERROR", "CRITICAL", "ALERT", "EMERGENCY"},
+		}},
+		{"rails", &messages.AppLogConfig{
+			LogPaths:      []string{"log/production.log"},
+			ErrorPatterns: []string{"ERROR", "FATAL"},
+		}},
+		{"django", &messages.AppLogConfig{
+			LogPaths:      []string{"logs/*.log"},
+			ErrorPatterns: []string{"ERROR", "CRITICAL", "Traceback (most recent call last)"},
+		}},
+		{"nextjs", &messages.AppLogConfig{
+			LogPaths:      []string{"logs/*.log"},
+			ErrorPatterns: []string{"Error:", "Unhandled Rejection", "uncaughtException"},
+		}},
+		{"nuxt", &messages.AppLogConfig{
+			LogPaths:      []string{"logs/*.log"},
+			ErrorPatterns: []string{"[nuxt] [request error]", "Error:", "Unhandled Rejection", "uncaughtException"},
+		}},
+		{"go", nil},
+		{"node", nil},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.framework, func(t *testing.T) {
+			if got := DefaultLogConfig(tt.framework); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("DefaultLogConfig(%q) = %+v, expected %+v", tt.framework, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDefaultLogConfig_ReturnsCopy(t *testing.T) {
+	defaults := DefaultLogConfig("laravel")
+	defaults.LogPaths[0] = "changed.log"
+	defaults.ErrorPatterns = append(defaults.ErrorPatterns[:1], "changed")
+
+	if again := DefaultLogConfig("laravel"); again.LogPaths[0] != "storage/logs/*.log" || again.ErrorPatterns[1] != "CRITICAL" {
+		t.Errorf("expected the catalog unchanged by callers, got %+v", again)
+	}
+}
@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// maxPackageJSONSize bounds the package.json files read, so a huge one can't
+// slow down discovery
+const maxPackageJSONSize = 1024 * 1024
+
+// packageJSON holds the package.json fields discovery reports
+type packageJSON struct {
+	Name            string            `json:"name"`
+	Version         string            `json:"version"`
+	Scripts         map[string]string `json:"scripts"`
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+// nodeFrameworkDeps are the dependencies that identify a node framework, most
+// specific first (nestjs apps depend on express too)
+var nodeFrameworkDeps = []struct {
+	dependency string
+	framework  string
+}{
+	{"next", "nextjs"},
+	{"nuxt", "nuxt"},
+	{"@nestjs/core", "nestjs"},
+	{"express", "express"},
+}
+
+// nodeConfigFiles identify a node framework by its config file
+var nodeConfigFiles = []struct {
+	file      string
+	framework string
+}{
+	{"next.config.js", "nextjs"},
+	{"next.config.mjs", "nextjs"},
+	{"next.config.ts", "nextjs"},
+	{"nuxt.config.js", "nuxt"},
+	{"nuxt.config.ts", "nuxt"},
+}
+
+// readPackageJSON parses a package.json file, or returns nil if it is
+// missing, too large or malformed
+func readPackageJSON(path string) *packageJSON {
+	data, err := readLimited(path, maxPackageJSONSize)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Skipping %s: %v", path, err)
+		}
+		return nil
+	}
+
+	var pkg packageJSON
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		log.Printf("Invalid package.json at %s: %v", path, err)
+		return nil
+	}
+	return &pkg
+}
+
+// readLimited reads a file, failing if it is larger than limit
+func readLimited(path string, limit int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// dependency returns the version range a package declares for a dependency,
+// and whether it declares it at all
+func (p *packageJSON) dependency(name string) (string, bool) {
+	if version, ok := p.Dependencies[name]; ok {
+		return version, true
+	}
+	version, ok := p.DevDependencies[name]
+	return version, ok
+}
+
+// detectNodeFramework identifies the framework of a node app by its config
+// file, else by its dependencies, falling back to "node"
+func detectNodeFramework(appPath string, pkg *packageJSON) string {
+	for _, candidate := range nodeConfigFiles {
+		if _, err := os.Stat(filepath.Join(appPath, candidate.file)); err == nil {
+			return candidate.framework
+		}
+	}
+	if pkg != nil {
+		for _, candidate := range nodeFrameworkDeps {
+			if _, ok := pkg.dependency(candidate.dependency); ok {
+				return candidate.framework
+			}
+		}
+	}
+	return "node"
+}
+
+// isNodeFramework reports whether a framework is one of the node ones
+func isNodeFramework(framework string) bool {
+	if framework == "node" {
+		return true
+	}
+	for _, candidate := range nodeFrameworkDeps {
+		if candidate.framework == framework {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPackageJSON adds a node app's package name, version and scripts, and
+// the version of its framework, to app
+func applyPackageJSON(app *messages.AppInfo, pkg *packageJSON) {
+	app.PackageName = pkg.Name
+	app.PackageVersion = pkg.Version
+	if len(pkg.Scripts) > 0 {
+		app.Scripts = pkg.Scripts
+	}
+
+	for _, candidate := range nodeFrameworkDeps {
+		if candidate.framework == app.Framework {
+			app.FrameworkVersion = frameworkVersion(app.Path, candidate.dependency, pkg)
+			return
+		}
+	}
+}
+
+// frameworkVersion returns the installed version of a framework package (from
+// node_modules), else the version range package.json declares for it
+func frameworkVersion(appPath, dependency string, pkg *packageJSON) string {
+	installed := readPackageJSON(filepath.Join(appPath, "node_modules", dependency, "package.json"))
+	if installed != nil && installed.Version != "" {
+		return installed.Version
+	}
+	version, _ := pkg.dependency(dependency)
+	return version
+}
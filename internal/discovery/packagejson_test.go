@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeApp_PackageJSON(t *testing.T) {
+	tests := []struct {
+		name             string
+		files            map[string]string
+		framework        string
+		packageName      string
+		packageVersion   string
+		scripts          map[string]string
+		frameworkVersion string
+	}{
+		{
+			name: "nextjs by dependency, installed version",
+			files: map[string]string{
+				"package.json": `{
+  "name": "storefront",
+  "version": "2.3.0",
+  "private": true,
+  "scripts": {"dev": "next dev", "build": "next build", "start": "next start"},
+  "dependencies": {"next": "^14.1.0", "react": "^18.2.0"}
+}`,
+				"node_modules/next/package.json": `{"name": "next", "version": "14.1.4"}`,
+			},
+			framework:        "nextjs",
+			packageName:      "storefront",
+			packageVersion:   "2.3.0",
+			scripts:          map[string]string{"dev": "next dev", "build": "next build", "start": "next start"},
+			frameworkVersion: "14.1.4",
+		},
+		{
+			name: "nuxt by dev dependency, declared version",
+			files: map[string]string{
+				"package.json": `{"name": "docs", "scripts": {"build": "nuxt build"}, "devDependencies": {"nuxt": "^3.10.0"}}`,
+			},
+			framework:        "nuxt",
+			packageName:      "docs",
+			scripts:          map[string]string{"build": "nuxt build"},
+			frameworkVersion: "^3.10.0",
+		},
+		{
+			name: "nestjs over its express dependency",
+			files: map[string]string{
+				"package.json":                           `{"name": "api", "version": "1.0.0", "dependencies": {"@nestjs/core": "^10.3.0", "@nestjs/platform-express": "^10.3.0", "express": "^4.18.2"}}`,
+				"node_modules/@nestjs/core/package.json": `{"version": "10.3.7"}`,
+			},
+			framework:        "nestjs",
+			packageName:      "api",
+			packageVersion:   "1.0.0",
+			frameworkVersion: "10.3.7",
+		},
+		{
+			name: "express",
+			files: map[string]string{
+				"package.json": `{"name": "webhooks", "scripts": {"start": "node index.js"}, "dependencies": {"express": "4.19.2"}}`,
+			},
+			framework:        "express",
+			packageName:      "webhooks",
+			scripts:          map[string]string{"start": "node index.js"},
+			frameworkVersion: "4.19.2",
+		},
+		{
+			name: "config file wins over dependencies",
+			files: map[string]string{
+				"package.json":   `{"name": "site", "dependencies": {"express": "^4.18.2"}}`,
+				"nuxt.config.ts": "export default {}",
+			},
+			framework:   "nuxt",
+			packageName: "site",
+		},
+		{
+			name: "plain node",
+			files: map[string]string{
+				"package.json": `{"name": "worker", "scripts": {"start": "node worker.js"}, "dependencies": {"bullmq": "^5.0.0"}}`,
+			},
+			framework:   "node",
+			packageName: "worker",
+			scripts:     map[string]string{"start": "node worker.js"},
+		},
+		{
+			name: "malformed package.json",
+			files: map[string]string{
+				"package.json": `{"name": "broken", "dependencies": {`,
+			},
+			framework: "node",
+		},
+		{
+			name: "huge package.json",
+			files: map[string]string{
+				"package.json": `{"name": "huge", "dependencies": {"next": "14.0.0"}, "description": "` +
+					strings.Repeat("x", maxPackageJSONSize) + `"}`,
+			},
+			framework: "node",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appDir := t.TempDir()
+			writeConfigs(t, appDir, tt.files)
+
+			app := analyzeApp(appDir)
+			if app == nil {
+				t.Fatal("expected the app to be recognized")
+			}
+			if app.Framework != tt.framework {
+				t.Errorf("expected framework %q, got %q", tt.framework, app.Framework)
+			}
+			if app.PackageName != tt.packageName || app.PackageVersion != tt.packageVersion {
+				t.Errorf("expected package %q %q, got %q %q", tt.packageName, tt.packageVersion, app.PackageName, app.PackageVersion)
+			}
+			if !reflect.DeepEqual(app.Scripts, tt.scripts) {
+				t.Errorf("expected scripts %v, got %v", tt.scripts, app.Scripts)
+			}
+			if app.FrameworkVersion != tt.frameworkVersion {
+				t.Errorf("expected framework version %q, got %q", tt.frameworkVersion, app.FrameworkVersion)
+			}
+		})
+	}
+}
+
+func TestAnalyzeApp_PackageJSONWithAntidoteConfig(t *testing.T) {
+	appDir := t.TempDir()
+	writeConfigs(t, appDir, map[string]string{
+		"antidote.yml": "version: 1\napp:\n  name: shop\n  framework: nextjs\n",
+		"package.json": `{"name": "shop", "scripts": {"build": "next build"}, "dependencies": {"next": "^14.2.0"}}`,
+	})
+
+	app := analyzeApp(appDir)
+	if app == nil || app.Config == nil {
+		t.Fatalf("expected a configured app, got %+v", app)
+	}
+	if app.PackageName != "shop" || app.Scripts["build"] != "next build" || app.FrameworkVersion != "^14.2.0" {
+		t.Errorf("expected package.json details for a configured node app, got %+v", app)
+	}
+}
+
+func TestAnalyzeApp_PackageJSONIgnoredForOtherFrameworks(t *testing.T) {
+	// Laravel apps ship a package.json for their frontend assets
+	appDir := t.TempDir()
+	writeConfigs(t, appDir, map[string]string{
+		"artisan":      "#!/usr/bin/env php",
+		"package.json": `{"private": true, "scripts": {"dev": "vite"}, "devDependencies": {"vite": "^5.0.0"}}`,
+	})
+
+	app := analyzeApp(appDir)
+	if app == nil || app.Framework != "laravel" {
+		t.Fatalf("expected a laravel app, got %+v", app)
+	}
+	if app.Scripts != nil || app.PackageName != "" {
+		t.Errorf("expected no package.json details for a laravel app, got %+v", app)
+	}
+}
+
+func TestReadPackageJSON_Missing(t *testing.T) {
+	if pkg := readPackageJSON(filepath.Join(t.TempDir(), "package.json")); pkg != nil {
+		t.Errorf("expected nil for a missing file, got %+v", pkg)
+	}
+
+	// Unreadable as a file
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "package.json"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if pkg := readPackageJSON(filepath.Join(dir, "package.json")); pkg != nil {
+		t.Errorf("expected nil for a directory, got %+v", pkg)
+	}
+}
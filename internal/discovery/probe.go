@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"os"
+	"os/exec"
+)
+
+// probeUser is the user discovery probes (version checks, docker ps) run as
+// when the agent runs as root. Probes only read information, so they don't
+// need the agent's privileges; commands from the cloud are unaffected.
+var probeUser = "nobody"
+
+// probeEnvVars are the only agent environment variables probes inherit. The
+// rest (PHPRC, RUBYOPT, the agent token, ...) could change a probe's output or
+// leak into it.
+var probeEnvVars = []string{"PATH", "SystemRoot", "WINDIR"}
+
+// probeCommand creates a discovery probe, running with reduced privileges where
+// possible and a minimal environment. Extra groups (e.g. "docker") are granted
+// to the probe if they exist.
+func probeCommand(groups []string, name string, args ...string) *exec.Cmd {
+	cmd := exec.Command(name, args...)
+	cmd.Env = probeEnv()
+	reducePrivileges(cmd, groups)
+	return cmd
+}
+
+// probeEnv returns the environment probes run with: the allowed agent
+// variables plus the C locale, so version strings parse the same everywhere
+func probeEnv() []string {
+	env := []string{"LC_ALL=C"}
+	for _, name := range probeEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
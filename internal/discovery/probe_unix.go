@@ -0,0 +1,68 @@
+//go:build !windows
+
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+var probeWarnOnce sync.Once
+
+// reducePrivileges makes a probe run as probeUser when the agent runs as root.
+// If the user can't be resolved the probe runs unchanged.
+func reducePrivileges(cmd *exec.Cmd, groups []string) {
+	if os.Geteuid() != 0 || probeUser == "" {
+		return
+	}
+
+	cred, err := probeCredential(probeUser, groups)
+	if err != nil {
+		probeWarnOnce.Do(func() {
+			log.Printf("Warning: discovery probes run with full privileges: %v", err)
+		})
+		return
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: cred}
+	// The agent's working directory may not be readable by the probe user
+	cmd.Dir = "/"
+}
+
+// probeCredential resolves a user and optional extra groups to a credential.
+// Groups that don't exist on this host are skipped.
+func probeCredential(username string, groups []string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %w", username, err)
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q for %s", u.Uid, username)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid %q for %s", u.Gid, username)
+	}
+
+	// Always set the group list so root's supplementary groups are dropped
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}
+	for _, name := range groups {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			continue
+		}
+		if id, err := strconv.ParseUint(g.Gid, 10, 32); err == nil {
+			cred.Groups = append(cred.Groups, uint32(id))
+		}
+	}
+
+	return cred, nil
+}
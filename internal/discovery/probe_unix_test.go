@@ -0,0 +1,162 @@
+//go:build !windows
+
+package discovery
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProbeCommand_RunsAsProbeUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges requires running as root")
+	}
+	nobody, err := user.Lookup(probeUser)
+	if err != nil {
+		t.Skipf("probe user %s not available: %v", probeUser, err)
+	}
+
+	out, err := probeCommand(nil, "id", "-u").Output()
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if uid := strings.TrimSpace(string(out)); uid != nobody.Uid {
+		t.Errorf("expected probe to run as uid %s, got %s", nobody.Uid, uid)
+	}
+
+	out, err = probeCommand(nil, "id", "-G").Output()
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	for _, gid := range strings.Fields(string(out)) {
+		if gid == "0" {
+			t.Errorf("probe kept root group membership: %s", strings.TrimSpace(string(out)))
+		}
+	}
+}
+
+func TestProbeCommand_UnchangedWithoutRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("only applies when not running as root")
+	}
+
+	cmd := probeCommand(nil, "id", "-u")
+	if cmd.SysProcAttr != nil {
+		t.Error("expected probe to run unchanged without root")
+	}
+}
+
+func TestProbeCredential_SkipsMissingGroups(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("current user not available: %v", err)
+	}
+
+	cred, err := probeCredential(current.Username, []string{"antidote-no-such-group"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cred.Groups) != 0 {
+		t.Errorf("expected no extra groups, got %v", cred.Groups)
+	}
+
+	if _, err := probeCredential("antidote-no-such-user", nil); err == nil {
+		t.Error("expected error for unknown user")
+	}
+}
+
+func TestProbeCommand_MinimalEnvironment(t *testing.T) {
+	t.Setenv("PHPRC", "/tmp/antidote-test-php.ini")
+	t.Setenv("ANTIDOTE_TOKEN", "ant_secret")
+
+	out, err := probeCommand(nil, "env").Output()
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	env := string(out)
+	for _, leaked := range []string{"PHPRC=", "ANTIDOTE_TOKEN=", "ant_secret"} {
+		if strings.Contains(env, leaked) {
+			t.Errorf("expected probe not to see %s, got:\n%s", leaked, env)
+		}
+	}
+	if !strings.Contains(env, "PATH=") || !strings.Contains(env, "LC_ALL=C") {
+		t.Errorf("expected PATH and LC_ALL in probe environment, got:\n%s", env)
+	}
+}
+
+// writeFakeBinary writes an executable script printing output
+func writeFakeBinary(t *testing.T, path, output string) {
+	t.Helper()
+
+	script := "#!/bin/sh\necho '" + output + "'\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscoverLanguages_ReportsResolvedPathAndShadowed(t *testing.T) {
+	original := probeUser
+	probeUser = "" // the probe user can't read the test's temp dirs
+	t.Cleanup(func() { probeUser = original })
+
+	realDir, firstDir, secondDir := t.TempDir(), t.TempDir(), t.TempDir()
+
+	// First on PATH: a symlink, as with /etc/alternatives
+	writeFakeBinary(t, filepath.Join(realDir, "php8.2"), "PHP 8.2.10 (cli)")
+	if err := os.Symlink(filepath.Join(realDir, "php8.2"), filepath.Join(firstDir, "php")); err != nil {
+		t.Fatal(err)
+	}
+	// Later on PATH, hidden by the first
+	writeFakeBinary(t, filepath.Join(secondDir, "php"), "PHP 7.4.33 (cli)")
+
+	pathEntries := []string{firstDir, "relative/bin", secondDir}
+	t.Setenv("PATH", strings.Join(pathEntries, string(os.PathListSeparator)))
+
+	languages := discoverLanguages()
+	if len(languages) != 1 {
+		t.Fatalf("expected only php to be found, got %+v", languages)
+	}
+
+	php := languages[0]
+	if php.Name != "php" || php.Version != "8.2.10" {
+		t.Errorf("expected php 8.2.10 from the first binary on PATH, got %s %s", php.Name, php.Version)
+	}
+	if want := filepath.Join(firstDir, "php"); php.Path != want {
+		t.Errorf("expected path %s, got %s", want, php.Path)
+	}
+	wantResolved, _ := filepath.EvalSymlinks(filepath.Join(realDir, "php8.2"))
+	if php.ResolvedPath != wantResolved {
+		t.Errorf("expected resolved path %s, got %s", wantResolved, php.ResolvedPath)
+	}
+	if want := filepath.Join(secondDir, "php"); len(php.Shadowed) != 1 || php.Shadowed[0] != want {
+		t.Errorf("expected shadowed [%s], got %v", want, php.Shadowed)
+	}
+
+	if got := searchPath(); strings.Join(got, ",") != strings.Join(pathEntries, ",") {
+		t.Errorf("expected search path %v, got %v", pathEntries, got)
+	}
+}
+
+func TestLocateBinary_SameFileNotShadowed(t *testing.T) {
+	binDir, linkParent := t.TempDir(), t.TempDir()
+	writeFakeBinary(t, filepath.Join(binDir, "node"), "v20.1.0")
+
+	// A symlinked directory reaches the same binary (like /bin -> /usr/bin)
+	linkDir := filepath.Join(linkParent, "bin")
+	if err := os.Symlink(binDir, linkDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+linkDir)
+
+	info, ok := locateBinary("node")
+	if !ok {
+		t.Fatal("expected node to be found")
+	}
+	if len(info.Shadowed) != 0 {
+		t.Errorf("expected no shadowed binaries, got %v", info.Shadowed)
+	}
+}
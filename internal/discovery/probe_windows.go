@@ -0,0 +1,10 @@
+//go:build windows
+
+package discovery
+
+import (
+	"os/exec"
+)
+
+// reducePrivileges is a no-op on Windows
+func reducePrivileges(cmd *exec.Cmd, groups []string) {}
@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+var (
+	environment   string
+	environmentMu sync.Mutex
+)
+
+// SetEnvironment sets the environment (e.g. "production") whose profile in
+// antidote.yml overrides the base config; empty applies no profile
+func SetEnvironment(env string) {
+	environmentMu.Lock()
+	defer environmentMu.Unlock()
+	environment = strings.TrimSpace(env)
+}
+
+func getEnvironment() string {
+	environmentMu.Lock()
+	defer environmentMu.Unlock()
+	return environment
+}
+
+// applyProfile overrides config with the profile matching env (compared
+// case-insensitively); fields the profile doesn't set keep the base value and
+// actions are merged by name
+func applyProfile(config *messages.AppConfig, env string) {
+	if env == "" {
+		return
+	}
+
+	for name, profile := range config.Profiles {
+		if !strings.EqualFold(name, env) {
+			continue
+		}
+
+		if profile.TrustLevel != "" {
+			config.TrustLevel = profile.TrustLevel
+		}
+		if len(profile.Actions) > 0 {
+			actions := make(map[string]messages.AppConfigAction, len(config.Actions)+len(profile.Actions))
+			for actionName, action := range config.Actions {
+				actions[actionName] = action
+			}
+			for actionName, action := range profile.Actions {
+				actions[actionName] = action
+			}
+			config.Actions = actions
+		}
+		if profile.ApprovalRequired != nil {
+			config.ApprovalRequired = profile.ApprovalRequired
+		}
+		if profile.Deny != nil {
+			config.Deny = profile.Deny
+		}
+		if profile.DenyOptOut != nil {
+			config.DenyOptOut = profile.DenyOptOut
+		}
+		if profile.AllowList != nil {
+			config.AllowList = *profile.AllowList
+		}
+		if profile.Allow != nil {
+			config.Allow = profile.Allow
+		}
+		if profile.Redact != nil {
+			config.Redact = profile.Redact
+		}
+		if profile.Logs != nil {
+			config.Logs = profile.Logs
+		}
+		if profile.Health != nil {
+			config.Health = profile.Health
+		}
+		if profile.LoginShell != nil {
+			config.LoginShell = *profile.LoginShell
+		}
+		if profile.WorkingDirs != nil {
+			config.WorkingDirs = profile.WorkingDirs
+		}
+		if profile.MaxTimeout != nil {
+			config.MaxTimeout = *profile.MaxTimeout
+		}
+
+		config.Profile = name
+		return
+	}
+}
@@ -0,0 +1,267 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/security"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigIssue is a single problem found in an antidote.yml file
+type ConfigIssue struct {
+	Line    int // 1-based line in the file, 0 if unknown
+	Field   string
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", i.Line, i.Field, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.Field, i.Message)
+}
+
+// ConfigReport is the result of validating an antidote.yml file
+type ConfigReport struct {
+	Path   string
+	Config *messages.AppConfig
+	Issues []ConfigIssue
+}
+
+// Valid returns true if the file parsed and no issues were found
+func (r *ConfigReport) Valid() bool {
+	return r.Config != nil && len(r.Issues) == 0
+}
+
+// ValidateConfigFile checks an antidote.yml file without deploying it: it parses
+// the file the same way discovery does, checks required fields, and compiles all
+// deny/redact/approval patterns the way the security validator does
+func ValidateConfigFile(path string) (*ConfigReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return validateConfigData(path, data), nil
+}
+
+// validateConfigData validates antidote.yml content
+func validateConfigData(path string, data []byte) *ConfigReport {
+	report := &ConfigReport{Path: path}
+
+	config, err := parseAntidoteConfig(data)
+	if err != nil {
+		report.Issues = append(report.Issues, ConfigIssue{
+			Field:   "file",
+			Message: err.Error(),
+		})
+		return report
+	}
+	report.Config = config
+
+	// Line numbers come from the YAML node tree (parsing already succeeded above)
+	var root yaml.Node
+	yaml.Unmarshal(data, &root)
+	lines := patternLines(&root)
+
+	actionNames := make([]string, 0, len(config.Actions))
+	for name := range config.Actions {
+		actionNames = append(actionNames, name)
+	}
+	sort.Strings(actionNames)
+
+	for _, name := range actionNames {
+		if strings.TrimSpace(config.Actions[name].Command) == "" {
+			report.Issues = append(report.Issues, ConfigIssue{
+				Field:   "actions." + name,
+				Message: "command is empty",
+			})
+		}
+	}
+
+	report.Issues = append(report.Issues, validateRules("", config.Deny, config.Allow, config.Redact, config.WorkingDirs, config.ApprovalRequired, lines)...)
+	if issue := validateMaxTimeout("max_timeout", config.MaxTimeout); issue != nil {
+		report.Issues = append(report.Issues, *issue)
+	}
+
+	profileNames := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		profileNames = append(profileNames, name)
+	}
+	sort.Strings(profileNames)
+
+	for _, name := range profileNames {
+		profile := config.Profiles[name]
+		prefix := "profiles." + name + "."
+
+		actionNames := make([]string, 0, len(profile.Actions))
+		for actionName := range profile.Actions {
+			actionNames = append(actionNames, actionName)
+		}
+		sort.Strings(actionNames)
+
+		for _, actionName := range actionNames {
+			if strings.TrimSpace(profile.Actions[actionName].Command) == "" {
+				report.Issues = append(report.Issues, ConfigIssue{
+					Field:   prefix + "actions." + actionName,
+					Message: "command is empty",
+				})
+			}
+		}
+
+		report.Issues = append(report.Issues, validateRules(prefix, profile.Deny, profile.Allow, profile.Redact, profile.WorkingDirs, profile.ApprovalRequired, lines)...)
+		if profile.MaxTimeout != nil {
+			if issue := validateMaxTimeout(prefix+"max_timeout", *profile.MaxTimeout); issue != nil {
+				report.Issues = append(report.Issues, *issue)
+			}
+		}
+	}
+
+	return report
+}
+
+// validateMaxTimeout checks an app's timeout cap is within the agent's own
+func validateMaxTimeout(field string, maxTimeout int) *ConfigIssue {
+	if maxTimeout < 0 || maxTimeout > security.MaxTimeout {
+		return &ConfigIssue{
+			Field:   field,
+			Message: fmt.Sprintf("must be between 0 (no cap) and %d seconds", security.MaxTimeout),
+		}
+	}
+	return nil
+}
+
+// validateRules compiles deny/allow/redact/approval patterns and checks working
+// dirs, prefixing field names with prefix (e.g. "profiles.production.")
+func validateRules(prefix string, deny, allow, redact, workingDirs []string, approvals []messages.AppConfigApproval, lines map[string]int) []ConfigIssue {
+	var issues []ConfigIssue
+
+	for i, pattern := range deny {
+		field := fmt.Sprintf("%sdeny[%d]", prefix, i)
+		if _, err := security.CompilePattern(pattern); err != nil {
+			issues = append(issues, ConfigIssue{
+				Line:    lines[field],
+				Field:   field,
+				Message: fmt.Sprintf("invalid regex %q (would be matched as literal text): %v", pattern, err),
+			})
+		}
+	}
+
+	for i, pattern := range allow {
+		field := fmt.Sprintf("%sallow[%d]", prefix, i)
+		if _, err := security.CompileAllowPattern(pattern); err != nil {
+			issues = append(issues, ConfigIssue{
+				Line:    lines[field],
+				Field:   field,
+				Message: fmt.Sprintf("invalid regex %q (would be ignored): %v", pattern, err),
+			})
+		}
+	}
+
+	for i, pattern := range redact {
+		field := fmt.Sprintf("%sredact[%d]", prefix, i)
+		if _, err := security.CompilePattern(pattern); err != nil {
+			issues = append(issues, ConfigIssue{
+				Line:    lines[field],
+				Field:   field,
+				Message: fmt.Sprintf("invalid regex %q (would be matched as literal text): %v", pattern, err),
+			})
+		}
+	}
+
+	for i, dir := range workingDirs {
+		field := fmt.Sprintf("%sworking_dirs[%d]", prefix, i)
+		if filepath.IsAbs(dir) || containsDotDot(dir) {
+			issues = append(issues, ConfigIssue{
+				Line:    lines[field],
+				Field:   field,
+				Message: fmt.Sprintf("%q must be a path relative to the app root (use \".\" for the root)", dir),
+			})
+		}
+	}
+
+	for i, approval := range approvals {
+		field := fmt.Sprintf("%sapproval_required[%d].pattern", prefix, i)
+		if _, err := security.CompilePattern(approval.Pattern); err != nil {
+			issues = append(issues, ConfigIssue{
+				Line:    lines[field],
+				Field:   field,
+				Message: fmt.Sprintf("invalid regex %q (would be matched as literal text): %v", approval.Pattern, err),
+			})
+		}
+	}
+
+	return issues
+}
+
+// containsDotDot reports whether a relative path has a ".." component
+func containsDotDot(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// patternLines maps deny/allow/redact/approval pattern fields to their line in the file
+func patternLines(root *yaml.Node) map[string]int {
+	lines := make(map[string]int)
+
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return lines
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return lines
+	}
+
+	collectPatternLines(doc, "", lines)
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		if key.Value != "profiles" || value.Kind != yaml.MappingNode {
+			continue
+		}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			if value.Content[j+1].Kind == yaml.MappingNode {
+				collectPatternLines(value.Content[j+1], "profiles."+value.Content[j].Value+".", lines)
+			}
+		}
+	}
+
+	return lines
+}
+
+// collectPatternLines records the lines of the pattern fields in one mapping
+// (the top level or a profile)
+func collectPatternLines(node *yaml.Node, prefix string, lines map[string]int) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if value.Kind != yaml.SequenceNode {
+			continue
+		}
+
+		switch key.Value {
+		case "deny", "allow", "redact", "working_dirs":
+			for j, item := range value.Content {
+				lines[fmt.Sprintf("%s%s[%d]", prefix, key.Value, j)] = item.Line
+			}
+		case "approval_required":
+			for j, item := range value.Content {
+				if item.Kind != yaml.MappingNode {
+					continue
+				}
+				for k := 0; k+1 < len(item.Content); k += 2 {
+					if item.Content[k].Value == "pattern" {
+						lines[fmt.Sprintf("%sapproval_required[%d].pattern", prefix, j)] = item.Content[k+1].Line
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "antidote.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestValidateConfigFile_Valid(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+trust_level: balanced
+actions:
+  clear_cache:
+    command: php artisan cache:clear
+    label: Clear Cache
+approval_required:
+  - pattern: "migrate.*--force"
+    reason: Production migration
+deny:
+  - "DROP\\s+DATABASE"
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !report.Valid() {
+		t.Errorf("expected valid config, got issues: %v", report.Issues)
+	}
+	if report.Config == nil || report.Config.App.Name != "myapp" {
+		t.Errorf("expected parsed config for myapp, got %+v", report.Config)
+	}
+}
+
+func TestValidateConfigFile_BadRegex(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+approval_required:
+  - pattern: "deploy(("
+    reason: Broken
+deny:
+  - "rm -rf"
+  - "[unclosed"
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.Valid() {
+		t.Fatal("expected invalid config")
+	}
+	if len(report.Issues) != 2 {
+		t.Fatalf("expected 2 issues, got %d: %v", len(report.Issues), report.Issues)
+	}
+
+	issues := map[string]ConfigIssue{}
+	for _, issue := range report.Issues {
+		issues[issue.Field] = issue
+	}
+
+	deny, ok := issues["deny[1]"]
+	if !ok {
+		t.Fatalf("expected issue for deny[1], got %v", report.Issues)
+	}
+	if deny.Line != 10 {
+		t.Errorf("expected deny[1] on line 10, got %d", deny.Line)
+	}
+	if !strings.Contains(deny.String(), "line 10") {
+		t.Errorf("expected line hint in %q", deny.String())
+	}
+
+	approval, ok := issues["approval_required[0].pattern"]
+	if !ok {
+		t.Fatalf("expected issue for approval pattern, got %v", report.Issues)
+	}
+	if approval.Line != 6 {
+		t.Errorf("expected approval pattern on line 6, got %d", approval.Line)
+	}
+}
+
+func TestValidateConfigFile_BadRedactPattern(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+redact:
+  - "STRIPE_[A-Z_]+=(\\S+)"
+  - "(unclosed"
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Issues) != 1 {
+		t.Fatalf("expected 1 issue, got %d: %v", len(report.Issues), report.Issues)
+	}
+	if report.Issues[0].Field != "redact[1]" || report.Issues[0].Line != 7 {
+		t.Errorf("expected redact[1] on line 7, got %v", report.Issues[0])
+	}
+}
+
+func TestValidateConfigFile_BadAllowPattern(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+allow:
+  - "^php artisan queue:(restart|work)$"
+  - "deploy)|(rm"
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Issues) != 1 {
+		t.Fatalf("expected 1 issue, got %d: %v", len(report.Issues), report.Issues)
+	}
+	if report.Issues[0].Field != "allow[1]" || report.Issues[0].Line != 7 {
+		t.Errorf("expected allow[1] on line 7, got %v", report.Issues[0])
+	}
+}
+
+func TestValidateConfigFile_BadWorkingDirs(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+working_dirs:
+  - "."
+  - "/var/www/other"
+  - "../shared"
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Issues) != 2 {
+		t.Fatalf("expected 2 issues, got %d: %v", len(report.Issues), report.Issues)
+	}
+	if report.Issues[0].Field != "working_dirs[1]" || report.Issues[0].Line != 7 {
+		t.Errorf("expected working_dirs[1] on line 7, got %v", report.Issues[0])
+	}
+	if report.Issues[1].Field != "working_dirs[2]" {
+		t.Errorf("expected working_dirs[2], got %v", report.Issues[1])
+	}
+}
+
+func TestValidateConfigFile_BadMaxTimeout(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+max_timeout: 7200
+profiles:
+  staging:
+    max_timeout: 120
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Issues) != 1 || report.Issues[0].Field != "max_timeout" {
+		t.Fatalf("expected a single max_timeout issue, got %v", report.Issues)
+	}
+}
+
+func TestValidateConfigFile_MissingFields(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+actions:
+  broken:
+    label: No command
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.Valid() {
+		t.Fatal("expected invalid config")
+	}
+	if !strings.Contains(report.Issues[0].Message, "missing name or framework") {
+		t.Errorf("unexpected issue: %v", report.Issues[0])
+	}
+}
+
+func TestValidateConfigFile_EmptyActionCommand(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: rails
+actions:
+  broken:
+    label: No command
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Issues) != 1 || report.Issues[0].Field != "actions.broken" {
+		t.Errorf("expected empty command issue, got %v", report.Issues)
+	}
+}
+
+func TestValidateConfigFile_NotFound(t *testing.T) {
+	if _, err := ValidateConfigFile("/nonexistent/antidote.yml"); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestValidateConfigFile_BadProfilePattern(t *testing.T) {
+	path := writeConfig(t, `version: 1
+app:
+  name: myapp
+  framework: laravel
+profiles:
+  production:
+    deny:
+      - "rm -rf"
+      - "(unclosed"
+`)
+
+	report, err := ValidateConfigFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(report.Issues) != 1 {
+		t.Fatalf("expected 1 issue, got %v", report.Issues)
+	}
+	issue := report.Issues[0]
+	if issue.Field != "profiles.production.deny[1]" || issue.Line != 9 {
+		t.Errorf("expected issue at line 9 for profiles.production.deny[1], got %v", issue)
+	}
+}
@@ -0,0 +1,400 @@
+package discovery
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// Web server main config files, parsed when present (replaced in tests)
+var (
+	nginxConfigs  = []string{"/etc/nginx/nginx.conf", "/usr/local/etc/nginx/nginx.conf"}
+	apacheConfigs = []string{"/etc/apache2/apache2.conf", "/etc/httpd/conf/httpd.conf"}
+)
+
+// Bounds on following includes, against include loops and huge config trees
+const (
+	maxIncludeDepth = 8
+	maxConfigFiles  = 1000
+)
+
+// discoverVirtualHosts parses the nginx and apache configs on the host into
+// their sites, tying each to the discovered app its root is in. Missing or
+// unreadable configs are skipped.
+func discoverVirtualHosts(apps []messages.AppInfo) []messages.VirtualHostInfo {
+	var hosts []messages.VirtualHostInfo
+	for _, path := range nginxConfigs {
+		hosts = append(hosts, parseNginxConfig(path)...)
+	}
+	for _, path := range apacheConfigs {
+		hosts = append(hosts, parseApacheConfig(path)...)
+	}
+
+	for i := range hosts {
+		hosts[i].AppPath = appForRoot(hosts[i].Root, apps)
+	}
+	return hosts
+}
+
+// appForRoot returns the path of the app a document root is in (the most
+// specific one), or "" if it isn't in any
+func appForRoot(root string, apps []messages.AppInfo) string {
+	if root == "" {
+		return ""
+	}
+	root = filepath.Clean(root)
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		resolvedRoot = root
+	}
+
+	appPath := ""
+	for _, app := range apps {
+		resolvedApp, err := filepath.EvalSymlinks(app.Path)
+		if err != nil {
+			resolvedApp = app.Path
+		}
+		if (withinDir(root, filepath.Clean(app.Path)) || withinDir(resolvedRoot, resolvedApp)) && len(app.Path) > len(appPath) {
+			appPath = app.Path
+		}
+	}
+	return appPath
+}
+
+// configReader reads a web server's config files, following includes
+type configReader struct {
+	prefix string // directory relative include paths resolve against
+	seen   map[string]bool
+}
+
+func newConfigReader(mainConfig string) *configReader {
+	return &configReader{prefix: filepath.Dir(mainConfig), seen: make(map[string]bool)}
+}
+
+// read returns a config file's contents, or false if it is missing,
+// unreadable, already read or past the limits
+func (r *configReader) read(path string, depth int) (string, bool) {
+	if depth > maxIncludeDepth || len(r.seen) >= maxConfigFiles || r.seen[path] {
+		return "", false
+	}
+	r.seen[path] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Skipping web server config %s: %v", path, err)
+		}
+		return "", false
+	}
+	return string(data), true
+}
+
+// includePaths expands an include pattern to the files it names, sorted.
+// Directories stand for the files in them (apache).
+func (r *configReader) includePaths(pattern string) []string {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(r.prefix, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if !info.IsDir() {
+			paths = append(paths, match)
+			continue
+		}
+		entries, err := os.ReadDir(match)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				paths = append(paths, filepath.Join(match, entry.Name()))
+			}
+		}
+	}
+	return paths
+}
+
+// nginxToken is a word or one of ";", "{" and "}" in an nginx config
+type nginxToken struct {
+	text string
+	file string
+}
+
+// parseNginxConfig returns the server blocks of an nginx config and the
+// files it includes
+func parseNginxConfig(path string) []messages.VirtualHostInfo {
+	reader := newConfigReader(path)
+	return parseNginxTokens(reader.nginxTokens(path, 0))
+}
+
+// nginxTokens tokenizes an nginx config file with its includes expanded in
+// place
+func (r *configReader) nginxTokens(path string, depth int) []nginxToken {
+	data, ok := r.read(path, depth)
+	if !ok {
+		return nil
+	}
+
+	words := tokenizeNginx(data)
+	var tokens []nginxToken
+	statementStart := true
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if statementStart && word == "include" && i+2 < len(words) && words[i+2] == ";" {
+			for _, included := range r.includePaths(words[i+1]) {
+				tokens = append(tokens, r.nginxTokens(included, depth+1)...)
+			}
+			i += 2
+			continue
+		}
+		tokens = append(tokens, nginxToken{text: word, file: path})
+		statementStart = word == ";" || word == "{" || word == "}"
+	}
+	return tokens
+}
+
+// tokenizeNginx splits nginx config text into words and ";", "{" and "}",
+// dropping comments and quotes
+func tokenizeNginx(data string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	var quote byte
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && i+1 < len(data) {
+				i++
+				word.WriteByte(data[i])
+			} else if c == quote {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && word.Len() == 0:
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == ';' || c == '{' || c == '}':
+			flush()
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			flush()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// parseNginxTokens collects the http server blocks from a tokenized config
+func parseNginxTokens(tokens []nginxToken) []messages.VirtualHostInfo {
+	var hosts []messages.VirtualHostInfo
+	var blocks []string // names of the enclosing blocks
+	var statement []nginxToken
+
+	var current *messages.VirtualHostInfo
+	serverDepth := 0   // len(blocks) inside the current server block
+	locationRoot := "" // first root set in one of its locations
+
+	for _, tok := range tokens {
+		switch tok.text {
+		case "{":
+			name := ""
+			if len(statement) > 0 {
+				name = statement[0].text
+			}
+			if name == "server" && current == nil && !slices.Contains(blocks, "stream") && !slices.Contains(blocks, "mail") {
+				current = &messages.VirtualHostInfo{Server: messages.WebServerNginx, ConfigFile: statement[0].file}
+				serverDepth = len(blocks) + 1
+			}
+			blocks = append(blocks, name)
+			statement = nil
+
+		case "}":
+			if current != nil && len(blocks) == serverDepth {
+				if current.Root == "" {
+					current.Root = locationRoot
+				}
+				hosts = append(hosts, *current)
+				current, locationRoot = nil, ""
+			}
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			statement = nil
+
+		case ";":
+			if current != nil && len(statement) > 1 {
+				args := make([]string, 0, len(statement)-1)
+				for _, word := range statement[1:] {
+					args = append(args, word.text)
+				}
+				inServer := len(blocks) == serverDepth
+
+				switch statement[0].text {
+				case "server_name":
+					for _, name := range args {
+						if name != "_" && name != "" && !slices.Contains(current.ServerNames, name) {
+							current.ServerNames = append(current.ServerNames, name)
+						}
+					}
+				case "listen":
+					if inServer {
+						current.Listen = append(current.Listen, args[0])
+					}
+				case "root":
+					if inServer {
+						current.Root = args[0]
+					} else if locationRoot == "" {
+						locationRoot = args[0]
+					}
+				case "proxy_pass":
+					if !slices.Contains(current.ProxyPass, args[0]) {
+						current.ProxyPass = append(current.ProxyPass, args[0])
+					}
+				}
+			}
+			statement = nil
+
+		default:
+			statement = append(statement, tok)
+		}
+	}
+	return hosts
+}
+
+// parseApacheConfig returns the VirtualHost sections of an apache config and
+// the files it includes
+func parseApacheConfig(path string) []messages.VirtualHostInfo {
+	reader := newConfigReader(path)
+	var hosts []messages.VirtualHostInfo
+	reader.apacheFile(path, 0, &hosts)
+	return hosts
+}
+
+// apacheFile adds the VirtualHost sections of an apache config file and its
+// includes to hosts
+func (r *configReader) apacheFile(path string, depth int, hosts *[]messages.VirtualHostInfo) {
+	data, ok := r.read(path, depth)
+	if !ok {
+		return
+	}
+
+	var current *messages.VirtualHostInfo
+	for _, line := range apacheLines(data) {
+		fields := apacheFields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		directive, args := strings.ToLower(fields[0]), fields[1:]
+
+		switch {
+		case directive == "<virtualhost":
+			current = &messages.VirtualHostInfo{Server: messages.WebServerApache, ConfigFile: path}
+			for _, addr := range args {
+				if addr = strings.TrimSuffix(addr, ">"); addr != "" {
+					current.Listen = append(current.Listen, addr)
+				}
+			}
+		case directive == "</virtualhost>":
+			if current != nil {
+				*hosts = append(*hosts, *current)
+				current = nil
+			}
+		case len(args) == 0:
+		case directive == "serverroot":
+			r.prefix = args[0]
+		case directive == "include" || directive == "includeoptional":
+			for _, included := range r.includePaths(args[0]) {
+				r.apacheFile(included, depth+1, hosts)
+			}
+		case current == nil:
+		case directive == "servername" || directive == "serveralias":
+			for _, name := range args {
+				if !slices.Contains(current.ServerNames, name) {
+					current.ServerNames = append(current.ServerNames, name)
+				}
+			}
+		case directive == "documentroot":
+			current.Root = args[0]
+		case directive == "proxypass" || directive == "proxypassmatch":
+			for _, arg := range args {
+				if strings.Contains(arg, "://") && !slices.Contains(current.ProxyPass, arg) {
+					current.ProxyPass = append(current.ProxyPass, arg)
+				}
+			}
+		}
+	}
+}
+
+// apacheLines returns the directive lines of an apache config, with
+// continuation lines joined and comments dropped
+func apacheLines(data string) []string {
+	var lines []string
+	var pending strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if cont, ok := strings.CutSuffix(line, "\\"); ok {
+			pending.WriteString(cont + " ")
+			continue
+		}
+		pending.WriteString(line)
+		joined := strings.TrimSpace(pending.String())
+		pending.Reset()
+		if joined != "" && !strings.HasPrefix(joined, "#") {
+			lines = append(lines, joined)
+		}
+	}
+	return lines
+}
+
+// apacheFields splits an apache directive line into fields, keeping quoted
+// values whole
+func apacheFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	inQuotes, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			inQuotes, quoted = !inQuotes, true
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if field.Len() > 0 || quoted {
+				fields = append(fields, field.String())
+				field.Reset()
+				quoted = false
+			}
+		default:
+			field.WriteByte(c)
+		}
+	}
+	if field.Len() > 0 || quoted {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
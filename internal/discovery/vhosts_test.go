@@ -0,0 +1,229 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// writeConfigs writes config files under dir, creating their directories
+func writeConfigs(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseNginxConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigs(t, dir, map[string]string{
+		"nginx.conf": `
+user www-data;
+events { worker_connections 768; }
+
+http {
+    include mime.types;   # missing, skipped
+    include sites-enabled/*;
+
+    server {
+        listen 80 default_server;
+        server_name _;
+        return 444;
+    }
+}
+
+stream {
+    server {
+        listen 3307;
+        proxy_pass db.internal:3306;
+    }
+}
+`,
+		"sites-enabled/shop.example.com": `
+# FORGE CONFIG (DO NOT REMOVE!)
+server {
+    listen 443 ssl http2;
+    server_name shop.example.com www.shop.example.com;
+    root "/home/forge/shop.example.com/current/public";
+
+    location / {
+        try_files $uri $uri/ /index.php?$query_string;
+    }
+}
+`,
+		"sites-enabled/api.example.com": `
+upstream node_api {
+    server 127.0.0.1:3000;
+}
+
+server {
+    listen 80;
+    server_name api.example.com;
+
+    location / {
+        proxy_pass http://node_api;
+    }
+    location /static/ {
+        root /var/www/api/public;
+    }
+}
+`,
+	})
+
+	hosts := parseNginxConfig(filepath.Join(dir, "nginx.conf"))
+
+	expected := []messages.VirtualHostInfo{
+		{
+			Server:      messages.WebServerNginx,
+			ConfigFile:  filepath.Join(dir, "sites-enabled/api.example.com"),
+			ServerNames: []string{"api.example.com"},
+			Listen:      []string{"80"},
+			Root:        "/var/www/api/public",
+			ProxyPass:   []string{"http://node_api"},
+		},
+		{
+			Server:      messages.WebServerNginx,
+			ConfigFile:  filepath.Join(dir, "sites-enabled/shop.example.com"),
+			ServerNames: []string{"shop.example.com", "www.shop.example.com"},
+			Listen:      []string{"443"},
+			Root:        "/home/forge/shop.example.com/current/public",
+		},
+		{
+			Server:     messages.WebServerNginx,
+			ConfigFile: filepath.Join(dir, "nginx.conf"),
+			Listen:     []string{"80"},
+		},
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected hosts\n%+v\ngot\n%+v", expected, hosts)
+	}
+}
+
+func TestParseNginxConfig_IncludeLoop(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigs(t, dir, map[string]string{
+		"nginx.conf": "http { include conf.d/*.conf; }",
+		"conf.d/a.conf": `include conf.d/b.conf;
+server { server_name a.example.com; }`,
+		"conf.d/b.conf": `include conf.d/a.conf;
+server { server_name b.example.com; }`,
+	})
+
+	hosts := parseNginxConfig(filepath.Join(dir, "nginx.conf"))
+	if len(hosts) != 2 {
+		t.Errorf("expected each site once despite the include loop, got %+v", hosts)
+	}
+}
+
+func TestParseApacheConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigs(t, dir, map[string]string{
+		"apache2.conf": `
+ServerRoot "` + dir + `"
+Timeout 300
+IncludeOptional mods-enabled/*.load
+IncludeOptional sites-enabled/*.conf
+Include ports.conf
+`,
+		"ports.conf": "Listen 80\n",
+		"sites-enabled/000-default.conf": `
+<VirtualHost *:80>
+	ServerName blog.example.com
+	ServerAlias www.blog.example.com \
+		blog.example.org
+	DocumentRoot "/var/www/blog/public"
+	# ServerAlias commented.example.com
+	<Directory /var/www/blog/public>
+		AllowOverride All
+	</Directory>
+</VirtualHost>
+`,
+		"sites-enabled/app.conf": `
+<VirtualHost 10.0.0.5:443 [::1]:443>
+	ServerName app.example.com
+	ProxyPass "/" "http://127.0.0.1:8000/"
+	ProxyPassReverse / http://127.0.0.1:8000/
+	ProxyPass /assets !
+</VirtualHost>
+`,
+	})
+
+	hosts := parseApacheConfig(filepath.Join(dir, "apache2.conf"))
+
+	expected := []messages.VirtualHostInfo{
+		{
+			Server:      messages.WebServerApache,
+			ConfigFile:  filepath.Join(dir, "sites-enabled/000-default.conf"),
+			ServerNames: []string{"blog.example.com", "www.blog.example.com", "blog.example.org"},
+			Listen:      []string{"*:80"},
+			Root:        "/var/www/blog/public",
+		},
+		{
+			Server:      messages.WebServerApache,
+			ConfigFile:  filepath.Join(dir, "sites-enabled/app.conf"),
+			ServerNames: []string{"app.example.com"},
+			Listen:      []string{"10.0.0.5:443", "[::1]:443"},
+			ProxyPass:   []string{"http://127.0.0.1:8000/"},
+		},
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected hosts\n%+v\ngot\n%+v", expected, hosts)
+	}
+}
+
+func TestDiscoverVirtualHosts(t *testing.T) {
+	dir := t.TempDir()
+	release := filepath.Join(dir, "forge", "shop", "releases", "1")
+	if err := os.MkdirAll(filepath.Join(release, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, "forge", "shop", "current")
+	if err := os.Symlink(release, current); err != nil {
+		t.Fatal(err)
+	}
+
+	writeConfigs(t, dir, map[string]string{
+		"nginx/nginx.conf": `http {
+    server { server_name shop.example.com; root ` + filepath.Join(current, "public") + `; }
+    server { server_name release.example.com; root ` + filepath.Join(release, "public") + `; }
+    server { server_name other.example.com; root /srv/other; }
+}`,
+	})
+
+	origNginx, origApache := nginxConfigs, apacheConfigs
+	defer func() { nginxConfigs, apacheConfigs = origNginx, origApache }()
+	nginxConfigs = []string{filepath.Join(dir, "nginx/nginx.conf")}
+	apacheConfigs = []string{filepath.Join(dir, "apache2/apache2.conf")} // missing
+
+	apps := []messages.AppInfo{{Path: filepath.Join(dir, "forge")}, {Path: current}}
+	hosts := discoverVirtualHosts(apps)
+
+	if len(hosts) != 3 {
+		t.Fatalf("expected 3 hosts, got %+v", hosts)
+	}
+	for i, expected := range []string{current, current, ""} {
+		if hosts[i].AppPath != expected {
+			t.Errorf("%v: expected app %q, got %q", hosts[i].ServerNames, expected, hosts[i].AppPath)
+		}
+	}
+}
+
+func TestDiscoverVirtualHosts_NoConfigs(t *testing.T) {
+	origNginx, origApache := nginxConfigs, apacheConfigs
+	defer func() { nginxConfigs, apacheConfigs = origNginx, origApache }()
+	nginxConfigs = []string{filepath.Join(t.TempDir(), "nginx.conf")}
+	apacheConfigs = nil
+
+	if hosts := discoverVirtualHosts(nil); hosts != nil {
+		t.Errorf("expected no hosts without configs, got %+v", hosts)
+	}
+}
@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// MaxCoalesceBytes is the most output batched into one coalesced message
+const MaxCoalesceBytes = 64 * 1024
+
+// outputCoalescer batches a stream's complete lines into one message per
+// window, so chatty commands send fewer messages. The message keeps the first
+// line's timestamp and lists every line's timestamp in LineTimestamps, in the
+// order of the lines in its data. Partial lines and base64 output are not
+// batched: they send the pending batch first and then go out as-is, so output
+// order is kept.
+type outputCoalescer struct {
+	handler OutputHandler
+	window  time.Duration
+
+	pending *messages.OutputMessage
+	stamps  []string
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+// newOutputCoalescer creates a coalescer sending batches to handler
+func newOutputCoalescer(handler OutputHandler, window time.Duration) *outputCoalescer {
+	return &outputCoalescer{handler: handler, window: window}
+}
+
+// send adds a message to the pending batch, or sends it as-is after the batch
+// when it can't be batched
+func (c *outputCoalescer) send(msg *messages.OutputMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.Encoding != "" || !strings.HasSuffix(msg.Data, "\n") {
+		c.flushLocked()
+		c.handler(msg)
+		return
+	}
+
+	if c.pending != nil && len(c.pending.Data)+len(msg.Data) > MaxCoalesceBytes {
+		c.flushLocked()
+	}
+
+	if c.pending == nil {
+		c.pending = msg
+		c.stamps = []string{msg.Timestamp}
+		c.timer = time.AfterFunc(c.window, c.flush)
+		return
+	}
+
+	c.pending.Data += msg.Data
+	c.stamps = append(c.stamps, msg.Timestamp)
+}
+
+// flush sends the pending batch
+func (c *outputCoalescer) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flushLocked()
+}
+
+// flushLocked sends the pending batch (caller must hold lock)
+func (c *outputCoalescer) flushLocked() {
+	if c.pending == nil {
+		return
+	}
+	c.timer.Stop()
+
+	msg := c.pending
+	if len(c.stamps) > 1 {
+		msg.LineTimestamps = c.stamps
+	}
+	c.pending, c.stamps, c.timer = nil, nil, nil
+	c.handler(msg)
+}
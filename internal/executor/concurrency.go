@@ -0,0 +1,130 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/codebasehealth/antidote-agent/internal/security"
+)
+
+// DefaultMaxConcurrent is how many commands run at once unless configured
+const DefaultMaxConcurrent = 10
+
+// DefaultMaxQueued is how many commands may wait for a slot before further
+// ones are rejected
+const DefaultMaxQueued = 50
+
+// SetMaxConcurrent sets the locally configured limit on commands running at
+// once (0 = unlimited). A limit from the server takes precedence.
+func (e *Executor) SetMaxConcurrent(n int) {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	e.localMax = n
+	e.slotCond.Broadcast()
+}
+
+// SetServerMaxConcurrent applies a limit on concurrent commands sent by the
+// server, overriding the local limit (0 reverts to the local limit). Lowering
+// the limit doesn't stop running commands; queued ones wait until enough of
+// them finish.
+func (e *Executor) SetServerMaxConcurrent(n int) {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	e.serverMax = n
+	e.slotCond.Broadcast()
+}
+
+// MaxConcurrent returns the limit on concurrent commands in effect (0 =
+// unlimited)
+func (e *Executor) MaxConcurrent() int {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+	return e.maxConcurrent()
+}
+
+// maxConcurrent returns the effective limit (caller must hold slotMu)
+func (e *Executor) maxConcurrent() int {
+	if e.serverMax > 0 {
+		return e.serverMax
+	}
+	return e.localMax
+}
+
+// SetMaxQueued sets how many commands may wait for a slot while the limit is
+// reached; further commands are rejected with TOO_MANY_RUNNING (negative =
+// no cap). Commands already queued keep waiting.
+func (e *Executor) SetMaxQueued(n int) {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+	e.maxQueued = n
+}
+
+// enqueue admits a command to wait for a slot, rejecting it when the queue is
+// full. Every admitted command must then call acquireSlot.
+func (e *Executor) enqueue() error {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+
+	limit := e.maxConcurrent()
+	if limit > 0 && e.maxQueued >= 0 && e.active+e.queued >= limit+e.maxQueued {
+		return &security.ValidationError{
+			Code:    "TOO_MANY_RUNNING",
+			Message: fmt.Sprintf("%d commands running and %d queued (limit %d, queue %d)", e.active, e.queued, limit, e.maxQueued),
+		}
+	}
+
+	e.queued++
+	return nil
+}
+
+// acquireSlot waits until the command may start under the concurrency limit,
+// returning false if ctx is cancelled first
+func (e *Executor) acquireSlot(ctx context.Context, id string) bool {
+	// Wake the wait below when the command is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		e.slotMu.Lock()
+		defer e.slotMu.Unlock()
+		e.slotCond.Broadcast()
+	})
+	defer stop()
+
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+	defer func() { e.queued-- }()
+
+	logged := false
+	for limit := e.maxConcurrent(); limit > 0 && e.active >= limit; limit = e.maxConcurrent() {
+		if ctx.Err() != nil {
+			return false
+		}
+		if !logged {
+			log.Printf("Command %s queued: %d commands running (limit %d)", id, e.active, limit)
+			logged = true
+		}
+		e.slotCond.Wait()
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	e.active++
+	return true
+}
+
+// releaseSlot frees a slot taken by acquireSlot
+func (e *Executor) releaseSlot() {
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+
+	e.active--
+	e.slotCond.Broadcast()
+}
@@ -0,0 +1,71 @@
+package executor
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/security"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DefaultMinFreeDiskMB is the free space (in MB) disk-heavy commands need on
+// their working directory's filesystem
+const DefaultMinFreeDiskMB = 1024
+
+// freeDiskSpace returns the free bytes on the filesystem holding path
+// (replaced in tests)
+var freeDiskSpace = func(path string) (uint64, error) {
+	usage, err := disk.Usage(path)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Free, nil
+}
+
+// SetMinFreeDisk sets the free space (in bytes) disk-heavy commands need on
+// their working directory's filesystem (<= 0 disables the check)
+func (e *Executor) SetMinFreeDisk(bytes int64) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.minFreeDisk = bytes
+}
+
+// checkDiskSpace rejects a disk-heavy command (marked by the server or by its
+// app's action) when its filesystem is low on space. If the free space can't
+// be read the command runs.
+func (e *Executor) checkDiskSpace(cmdMsg *messages.CommandMessage) error {
+	e.runningMu.Lock()
+	minFree := e.minFreeDisk
+	e.runningMu.Unlock()
+	if minFree <= 0 {
+		return nil
+	}
+
+	diskHeavy := cmdMsg.DiskHeavy
+	if !diskHeavy && e.validator != nil {
+		diskHeavy = e.validator.DiskHeavyAction(cmdMsg.WorkingDir, commandText(cmdMsg))
+	}
+	if !diskHeavy {
+		return nil
+	}
+
+	dir := cmdMsg.WorkingDir
+	if dir == "" {
+		dir = "."
+	}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		log.Printf("Failed to check free disk space for command %s: %v", cmdMsg.ID, err)
+		return nil
+	}
+	if free >= uint64(minFree) {
+		return nil
+	}
+
+	return &security.ValidationError{
+		Code: "LOW_DISK_SPACE",
+		Message: fmt.Sprintf("only %d MB free on the filesystem of %s; disk-heavy commands need at least %d MB",
+			free/(1024*1024), dir, minFree/(1024*1024)),
+	}
+}
@@ -1,22 +1,39 @@
 package executor
 
 import (
-	"bufio"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/codebasehealth/antidote-agent/internal/crash"
 	"github.com/codebasehealth/antidote-agent/internal/messages"
 	"github.com/codebasehealth/antidote-agent/internal/security"
 )
 
 const DefaultTimeout = 5 * time.Minute
 
+// DefaultInterruptGrace is how long an interrupted command has to exit after
+// SIGINT before its process group is killed
+const DefaultInterruptGrace = 10 * time.Second
+
+// MaxHistory is how many finished commands are kept in the command history
+const MaxHistory = 50
+
+// DefaultHistoryMaxAge is how long a finished command stays in the history
+const DefaultHistoryMaxAge = 24 * time.Hour
+
 // OutputHandler is called when command output is produced
 type OutputHandler func(msg *messages.OutputMessage)
 
@@ -26,47 +43,192 @@ type CompleteHandler func(msg *messages.CompleteMessage)
 // RejectedHandler is called when a command is rejected by security validation
 type RejectedHandler func(msg *messages.RejectedMessage)
 
+// IdleHandler is called when the last running command finishes
+type IdleHandler func()
+
 // Executor manages command execution
 type Executor struct {
 	outputHandler   OutputHandler
 	completeHandler CompleteHandler
 	rejectedHandler RejectedHandler
+	idleHandler     IdleHandler
 	validator       *security.Validator
-
-	running   map[string]context.CancelFunc
+	binaryThreshold int
+	maxOutputBytes  int64
+	interruptGrace  time.Duration
+	outputCoalesce  time.Duration // 0 = each line sent on its own
+	notFoundHints   bool
+	minFreeDisk     int64 // bytes disk-heavy commands need free, <= 0 = unchecked
+
+	running   map[string]*runningCommand
+	lastCmdAt time.Time       // when the last command was accepted
+	history   []CommandRecord // finished commands, oldest first
+	maxAge    time.Duration   // history retention, 0 = until pushed out by newer commands
 	runningMu sync.Mutex
+
+	// Concurrency limit: commands beyond it wait for a slot, up to maxQueued
+	active    int // commands holding a slot
+	queued    int // commands admitted but not yet holding a slot
+	maxQueued int // < 0 = no cap
+	localMax  int // from agent config (0 = unlimited)
+	serverMax int // from the server, overrides localMax when set
+	slotMu    sync.Mutex
+	slotCond  *sync.Cond
+}
+
+// runningCommand tracks an in-flight command
+type runningCommand struct {
+	command    string
+	cancel     context.CancelFunc
+	started    time.Time
+	redactor   *security.Redactor
+	loginShell bool
+
+	binaryThreshold int
+	outputCoalesce  time.Duration
+	notFoundHint    bool // explain "command not found" failures
+	outputLimit     *outputLimit
+
+	// Set when the command runs an exclusive action: app path + action name
+	exclusiveKey  string
+	exclusiveName string
+
+	// Output streams, registered once the process has started
+	streams   []*outputStream
+	streamsMu sync.Mutex
+
+	// The started process, and whether the command was interrupted
+	process     *exec.Cmd
+	interrupted bool
+	processMu   sync.Mutex
+}
+
+// setProcess records the command's started process so it can be interrupted
+func (rc *runningCommand) setProcess(cmd *exec.Cmd) {
+	rc.processMu.Lock()
+	defer rc.processMu.Unlock()
+	rc.process = cmd
+}
+
+// wasInterrupted reports whether Interrupt was called for the command
+func (rc *runningCommand) wasInterrupted() bool {
+	rc.processMu.Lock()
+	defer rc.processMu.Unlock()
+	return rc.interrupted
+}
+
+// addStream registers an output stream so it can be flushed on request
+func (rc *runningCommand) addStream(stream *outputStream) {
+	rc.streamsMu.Lock()
+	defer rc.streamsMu.Unlock()
+	rc.streams = append(rc.streams, stream)
+}
+
+// flush emits any buffered partial output on all streams
+func (rc *runningCommand) flush() {
+	rc.streamsMu.Lock()
+	streams := rc.streams
+	rc.streamsMu.Unlock()
+
+	for _, stream := range streams {
+		stream.Flush()
+	}
 }
 
 // New creates a new executor
 func New(outputHandler OutputHandler, completeHandler CompleteHandler, rejectedHandler RejectedHandler, validator *security.Validator) *Executor {
-	return &Executor{
+	e := &Executor{
 		outputHandler:   outputHandler,
 		completeHandler: completeHandler,
 		rejectedHandler: rejectedHandler,
 		validator:       validator,
-		running:         make(map[string]context.CancelFunc),
+		binaryThreshold: DefaultBinaryThreshold,
+		maxOutputBytes:  DefaultMaxOutputBytes,
+		maxAge:          DefaultHistoryMaxAge,
+		interruptGrace:  DefaultInterruptGrace,
+		notFoundHints:   true,
+		minFreeDisk:     DefaultMinFreeDiskMB * 1024 * 1024,
+		running:         make(map[string]*runningCommand),
+		localMax:        DefaultMaxConcurrent,
+		maxQueued:       DefaultMaxQueued,
+	}
+	e.slotCond = sync.NewCond(&e.slotMu)
+	return e
+}
+
+// SetBinaryThreshold sets how many invalid UTF-8 bytes an output stream may
+// contain before it switches to base64 (negative disables base64)
+func (e *Executor) SetBinaryThreshold(threshold int) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.binaryThreshold = threshold
+}
+
+// SetMaxOutputBytes sets how much output a command may send across stdout and
+// stderr before the rest is dropped (<= 0 for no limit). A command's own
+// max_output_bytes overrides it.
+func (e *Executor) SetMaxOutputBytes(max int64) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.maxOutputBytes = max
+}
+
+// SetOutputCoalesce sets how long a stream's output lines are batched into one
+// message, each line's timestamp kept in line_timestamps (<= 0, the default,
+// sends every line on its own)
+func (e *Executor) SetOutputCoalesce(window time.Duration) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.outputCoalesce = window
+}
+
+// SetNotFoundHints sets whether the complete message of a command that failed
+// because a binary wasn't on PATH carries a diagnostic suggesting fixes (on by
+// default)
+func (e *Executor) SetNotFoundHints(enabled bool) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.notFoundHints = enabled
+}
+
+// SetInterruptGrace sets how long an interrupted command has to exit after
+// SIGINT before it is killed (<= 0 restores the default)
+func (e *Executor) SetInterruptGrace(grace time.Duration) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+
+	if grace <= 0 {
+		grace = DefaultInterruptGrace
 	}
+	e.interruptGrace = grace
 }
 
 // Execute runs a command from the cloud
 func (e *Executor) Execute(cmdMsg *messages.CommandMessage) error {
+	// A working dir relative to an app is resolved before it is validated
+	if cmdMsg.App != "" {
+		if e.validator == nil {
+			return e.reject(cmdMsg, &security.ValidationError{
+				Code:    "UNKNOWN_APP",
+				Message: fmt.Sprintf("no discovered app matches %s", cmdMsg.App),
+			})
+		}
+		dir, err := e.validator.ResolveAppDir(cmdMsg.App, cmdMsg.WorkingDir)
+		if err != nil {
+			return e.reject(cmdMsg, err)
+		}
+		cmdMsg.WorkingDir = dir
+	}
+
 	// Security validation
 	if e.validator != nil {
 		if err := e.validator.ValidateCommand(cmdMsg); err != nil {
-			log.Printf("Command %s rejected: %v", cmdMsg.ID, err)
-
-			// Send rejection message back to cloud
-			if e.rejectedHandler != nil {
-				code := "VALIDATION_ERROR"
-				if vErr, ok := err.(*security.ValidationError); ok {
-					code = vErr.Code
-				}
-				e.rejectedHandler(messages.NewRejectedMessage(cmdMsg.ID, code, err.Error()))
-			}
-
-			return err
+			return e.reject(cmdMsg, err)
 		}
 	}
+	if err := e.checkDiskSpace(cmdMsg); err != nil {
+		return e.reject(cmdMsg, err)
+	}
 
 	// Determine timeout
 	timeout := DefaultTimeout
@@ -74,11 +236,59 @@ func (e *Executor) Execute(cmdMsg *messages.CommandMessage) error {
 		timeout = time.Duration(cmdMsg.Timeout) * time.Second
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	// The timeout starts once the command gets a slot; cancel also covers the
+	// wait for one
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// Track running command
+	rc := &runningCommand{
+		command: commandText(cmdMsg),
+		cancel:  cancel,
+		started: time.Now(),
+	}
+	if e.validator != nil {
+		rc.redactor = e.validator.Redactor(cmdMsg.WorkingDir)
+		if cmdMsg.WorkingDir != "" {
+			if config := e.validator.GetAppConfig(cmdMsg.WorkingDir); config != nil {
+				rc.loginShell = config.LoginShell
+
+				// The default timeout must not exceed the app's cap either
+				if appMax := time.Duration(config.MaxTimeout) * time.Second; appMax > 0 && timeout > appMax {
+					timeout = appMax
+				}
+			}
+		}
+	}
+	if e.validator != nil {
+		if appPath, action := e.validator.ExclusiveAction(cmdMsg.WorkingDir, commandText(cmdMsg)); action != "" {
+			rc.exclusiveKey = appPath + "\x00" + action
+			rc.exclusiveName = action
+		}
+	}
+
 	e.runningMu.Lock()
-	e.running[cmdMsg.ID] = cancel
+	if other := e.runningExclusive(rc.exclusiveKey); other != "" {
+		e.runningMu.Unlock()
+		cancel()
+		return e.reject(cmdMsg, &security.ValidationError{
+			Code:    "ALREADY_RUNNING",
+			Message: fmt.Sprintf("exclusive action %s is already running for this app (command %s)", rc.exclusiveName, other),
+		})
+	}
+	if err := e.enqueue(); err != nil {
+		e.runningMu.Unlock()
+		cancel()
+		return e.reject(cmdMsg, err)
+	}
+	rc.binaryThreshold = e.binaryThreshold
+	rc.outputCoalesce = e.outputCoalesce
+	rc.notFoundHint = e.notFoundHints
+	rc.outputLimit = &outputLimit{max: e.maxOutputBytes}
+	if cmdMsg.MaxOutputBytes > 0 {
+		rc.outputLimit.max = cmdMsg.MaxOutputBytes
+	}
+	e.running[cmdMsg.ID] = rc
+	e.lastCmdAt = rc.started
 	e.runningMu.Unlock()
 
 	// Run in goroutine
@@ -87,15 +297,76 @@ func (e *Executor) Execute(cmdMsg *messages.CommandMessage) error {
 			cancel()
 			e.runningMu.Lock()
 			delete(e.running, cmdMsg.ID)
+			idle := len(e.running) == 0
+			idleHandler := e.idleHandler
 			e.runningMu.Unlock()
+
+			if idle && idleHandler != nil {
+				idleHandler()
+			}
 		}()
+		defer e.recoverCommand(cmdMsg, rc.started)
 
-		e.executeCommand(ctx, cmdMsg)
+		if !e.acquireSlot(ctx, cmdMsg.ID) {
+			log.Printf("Command %s cancelled before it started", cmdMsg.ID)
+			complete := newCompleteMessage(cmdMsg, 1)
+			if rc.wasInterrupted() {
+				complete.Reason = messages.ReasonInterrupted
+			}
+			e.sendComplete(complete, rc.started)
+			return
+		}
+		defer e.releaseSlot()
+
+		runCtx, runCancel := context.WithTimeout(ctx, timeout)
+		defer runCancel()
+
+		e.executeCommand(runCtx, cmdMsg, rc)
 	}()
 
 	return nil
 }
 
+// recoverCommand reports a panic while running a command as a failed
+// command instead of taking the agent down. It must be deferred directly.
+func (e *Executor) recoverCommand(cmdMsg *messages.CommandMessage, started time.Time) {
+	if r := recover(); r != nil {
+		crash.Report(fmt.Sprintf("command %s", cmdMsg.ID), r)
+		defer crash.Recover(fmt.Sprintf("completion of command %s", cmdMsg.ID))
+		e.sendComplete(newCompleteMessage(cmdMsg, 1), started)
+	}
+}
+
+// reject reports a command that won't run to the cloud
+func (e *Executor) reject(cmdMsg *messages.CommandMessage, err error) error {
+	log.Printf("Command %s rejected: %v", cmdMsg.ID, err)
+
+	// Send rejection message back to cloud
+	if e.rejectedHandler != nil {
+		code := "VALIDATION_ERROR"
+		if vErr, ok := err.(*security.ValidationError); ok {
+			code = vErr.Code
+		}
+		e.rejectedHandler(messages.NewRejectedMessage(cmdMsg.ID, code, err.Error()))
+	}
+
+	return err
+}
+
+// runningExclusive returns the ID of a running command holding an exclusive
+// action key, or "" (caller must hold runningMu)
+func (e *Executor) runningExclusive(key string) string {
+	if key == "" {
+		return ""
+	}
+	for id, rc := range e.running {
+		if rc.exclusiveKey == key {
+			return id
+		}
+	}
+	return ""
+}
+
 // UpdateValidator updates the security validator with new app configs
 func (e *Executor) UpdateValidator(apps []messages.AppInfo) {
 	if e.validator != nil {
@@ -104,27 +375,236 @@ func (e *Executor) UpdateValidator(apps []messages.AppInfo) {
 	}
 }
 
+// SetIdleHandler sets the handler called whenever the executor becomes idle
+func (e *Executor) SetIdleHandler(handler IdleHandler) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	e.idleHandler = handler
+}
+
+// RunningCount returns the number of commands currently running
+func (e *Executor) RunningCount() int {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+	return len(e.running)
+}
+
+// Workload summarizes the executor's current load
+type Workload struct {
+	Running       int       // commands holding a concurrency slot
+	Queued        int       // commands waiting for one
+	LastCommandAt time.Time // when the last command was accepted, zero if none
+}
+
+// Workload returns how many commands are running and queued
+func (e *Executor) Workload() Workload {
+	e.runningMu.Lock()
+	lastCmdAt := e.lastCmdAt
+	e.runningMu.Unlock()
+
+	e.slotMu.Lock()
+	defer e.slotMu.Unlock()
+	return Workload{Running: e.active, Queued: e.queued, LastCommandAt: lastCmdAt}
+}
+
+// RunningCommand describes an in-flight command
+type RunningCommand struct {
+	ID        string    `json:"id"`
+	Command   string    `json:"command"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// Running returns the commands currently running, oldest first, with secrets
+// redacted from their command text as in the history
+func (e *Executor) Running() []RunningCommand {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+
+	running := make([]RunningCommand, 0, len(e.running))
+	for id, rc := range e.running {
+		running = append(running, RunningCommand{
+			ID:        id,
+			Command:   rc.redactor.Redact(rc.command),
+			StartedAt: rc.started,
+		})
+	}
+
+	sort.Slice(running, func(i, j int) bool {
+		return running[i].StartedAt.Before(running[j].StartedAt)
+	})
+	return running
+}
+
+// CommandRecord describes a finished command
+type CommandRecord struct {
+	ID         string    `json:"id"`
+	Command    string    `json:"command"` // redacted like the command's output
+	ExitCode   int       `json:"exit_code"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+
+	PeakMemoryBytes int64 `json:"peak_memory_bytes,omitempty"`
+
+	finishedAt time.Time
+}
+
+// SetHistoryMaxAge sets how long finished commands are kept in the history
+// (<= 0 keeps them until newer commands push them out)
+func (e *Executor) SetHistoryMaxAge(maxAge time.Duration) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	e.maxAge = maxAge
+	e.pruneHistory(time.Now())
+}
+
+// History returns the most recently finished commands, newest first
+func (e *Executor) History() []CommandRecord {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+
+	e.pruneHistory(time.Now())
+
+	history := make([]CommandRecord, len(e.history))
+	for i, record := range e.history {
+		history[len(e.history)-1-i] = record
+	}
+	return history
+}
+
+// recordHistory adds a finished command to the history
+func (e *Executor) recordHistory(msg *messages.CompleteMessage) {
+	e.runningMu.Lock()
+	defer e.runningMu.Unlock()
+
+	rc, ok := e.running[msg.ID]
+	if !ok {
+		return
+	}
+
+	e.history = append(e.history, CommandRecord{
+		ID:         msg.ID,
+		Command:    rc.redactor.Redact(rc.command),
+		ExitCode:   msg.ExitCode,
+		StartedAt:  rc.started,
+		DurationMs: msg.DurationMs,
+
+		PeakMemoryBytes: msg.PeakMemoryBytes,
+
+		finishedAt: time.Now(),
+	})
+	if len(e.history) > MaxHistory {
+		e.history = e.history[len(e.history)-MaxHistory:]
+	}
+	e.pruneHistory(time.Now())
+}
+
+// pruneHistory drops records that finished longer than the history max age
+// before now (caller must hold runningMu)
+func (e *Executor) pruneHistory(now time.Time) {
+	if e.maxAge <= 0 {
+		return
+	}
+
+	// Records are kept in the order they finished
+	cutoff := now.Add(-e.maxAge)
+	expired := 0
+	for expired < len(e.history) && e.history[expired].finishedAt.Before(cutoff) {
+		expired++
+	}
+	if expired > 0 {
+		e.history = append([]CommandRecord(nil), e.history[expired:]...)
+	}
+}
+
 // Cancel cancels a running command
 func (e *Executor) Cancel(id string) bool {
 	e.runningMu.Lock()
-	cancel, ok := e.running[id]
+	rc, ok := e.running[id]
 	e.runningMu.Unlock()
 
-	if ok && cancel != nil {
-		cancel()
+	if ok && rc.cancel != nil {
+		rc.cancel()
 		return true
 	}
 	return false
 }
 
+// Interrupt stops a running command gracefully: its process group gets SIGINT
+// (e.g. so a test suite can report partial results) and is killed like Cancel
+// if it is still running after the interrupt grace period. The command
+// completes with reason "interrupted".
+func (e *Executor) Interrupt(id string) bool {
+	e.runningMu.Lock()
+	rc, ok := e.running[id]
+	grace := e.interruptGrace
+	e.runningMu.Unlock()
+
+	if !ok || rc.cancel == nil {
+		return false
+	}
+
+	rc.processMu.Lock()
+	rc.interrupted = true
+	cmd := rc.process
+	rc.processMu.Unlock()
+
+	// Not started yet, or the platform can't signal it: stop it right away
+	if cmd == nil || interruptProcessGroup(cmd) != nil {
+		rc.cancel()
+		return true
+	}
+
+	log.Printf("Command %s interrupted, killing it in %v if still running", id, grace)
+	time.AfterFunc(grace, rc.cancel)
+	return true
+}
+
+// FlushOutput immediately emits any buffered partial output of a running
+// command (e.g. a progress line without a trailing newline)
+func (e *Executor) FlushOutput(id string) bool {
+	e.runningMu.Lock()
+	rc, ok := e.running[id]
+	e.runningMu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	rc.flush()
+	return true
+}
+
 // executeCommand runs the actual shell command
-func (e *Executor) executeCommand(ctx context.Context, cmdMsg *messages.CommandMessage) {
+func (e *Executor) executeCommand(ctx context.Context, cmdMsg *messages.CommandMessage, rc *runningCommand) {
 	startTime := time.Now()
 
-	log.Printf("Executing command %s: %s", cmdMsg.ID, cmdMsg.Command)
+	log.Printf("Executing command %s: %s", cmdMsg.ID, commandText(cmdMsg))
+
+	// Scripts run from a private temp file, removed once the script exits
+	var scriptPath string
+	if cmdMsg.Script != "" {
+		path, cleanup, err := writeScript(cmdMsg.Script)
+		if err != nil {
+			log.Printf("Failed to write script: %v", err)
+			e.sendComplete(newCompleteMessage(cmdMsg, 1), startTime)
+			return
+		}
+		defer cleanup()
+		scriptPath = path
+	}
 
 	// Create command
-	cmd := exec.CommandContext(ctx, "sh", "-c", cmdMsg.Command)
+	cmd, pipefail := buildCommand(ctx, cmdMsg, rc.loginShell, scriptPath)
+
+	// Run in its own process group and kill the whole group on cancel/timeout
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
 
 	// Set working directory
 	if cmdMsg.WorkingDir != "" {
@@ -141,36 +621,101 @@ func (e *Executor) executeCommand(ctx context.Context, cmdMsg *messages.CommandM
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		log.Printf("Failed to create stdout pipe: %v", err)
-		e.sendComplete(cmdMsg.ID, 1, startTime)
+		e.sendComplete(newCompleteMessage(cmdMsg, 1), startTime)
 		return
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		log.Printf("Failed to create stderr pipe: %v", err)
-		e.sendComplete(cmdMsg.ID, 1, startTime)
+		e.sendComplete(newCompleteMessage(cmdMsg, 1), startTime)
 		return
 	}
 
+	// Stdin is streamed in once the command starts; without it the command
+	// reads from the null device
+	var stdin io.WriteCloser
+	if cmdMsg.Stdin != "" {
+		stdin, err = cmd.StdinPipe()
+		if err != nil {
+			log.Printf("Failed to create stdin pipe: %v", err)
+			e.sendComplete(newCompleteMessage(cmdMsg, 1), startTime)
+			return
+		}
+	}
+
+	// Pipeline stage exit codes are reported on an extra descriptor
+	var pipeStatus *os.File
+	if pipefail {
+		var statusWriter *os.File
+		pipeStatus, statusWriter, err = os.Pipe()
+		if err != nil {
+			log.Printf("Failed to create pipe status pipe: %v", err)
+			e.sendComplete(newCompleteMessage(cmdMsg, 1), startTime)
+			return
+		}
+		defer pipeStatus.Close()
+		cmd.ExtraFiles = []*os.File{statusWriter}
+	}
+
 	// Start command
-	if err := cmd.Start(); err != nil {
+	err = cmd.Start()
+	if pipefail {
+		// The child has its own copy; close ours so reads end when it exits
+		cmd.ExtraFiles[0].Close()
+	}
+	if err != nil {
 		log.Printf("Failed to start command: %v", err)
-		e.sendComplete(cmdMsg.ID, 1, startTime)
+		e.sendComplete(newCompleteMessage(cmdMsg, 1), startTime)
 		return
 	}
+	rc.setProcess(cmd)
+
+	if stdin != nil {
+		go func() {
+			defer stdin.Close()
+			if _, err := io.Copy(stdin, stdinReader(cmdMsg)); err != nil && !errors.Is(err, syscall.EPIPE) && !errors.Is(err, os.ErrClosed) {
+				log.Printf("Failed to write stdin of command %s: %v", cmdMsg.ID, err)
+			}
+		}()
+	}
+
+	var statusData []byte
+	statusDone := make(chan struct{})
+	if pipefail {
+		go func() {
+			defer close(statusDone)
+			statusData, _ = io.ReadAll(io.LimitReader(pipeStatus, 4096))
+		}()
+	} else {
+		close(statusDone)
+	}
 
 	// Stream output
+	outputHandler := e.outputHandlerFor(cmdMsg)
+	stdoutStream := newOutputStream(cmdMsg.ID, "stdout", outputHandler, rc.redactor, rc.binaryThreshold, rc.outputLimit)
+	stderrStream := newOutputStream(cmdMsg.ID, "stderr", outputHandler, rc.redactor, rc.binaryThreshold, rc.outputLimit)
+	stdoutStream.coalesce(rc.outputCoalesce)
+	stderrStream.coalesce(rc.outputCoalesce)
+	rc.addStream(stdoutStream)
+	rc.addStream(stderrStream)
+
+	// The start of stderr names a command the shell couldn't find
+	stderrHead := &headBuffer{max: notFoundCaptureBytes}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
-		e.streamOutput(cmdMsg.ID, "stdout", stdout)
+		defer crash.Recover(fmt.Sprintf("stdout of command %s", cmdMsg.ID))
+		e.streamOutput(stdoutStream, stdout)
 	}()
 
 	go func() {
 		defer wg.Done()
-		e.streamOutput(cmdMsg.ID, "stderr", stderr)
+		defer crash.Recover(fmt.Sprintf("stderr of command %s", cmdMsg.ID))
+		e.streamOutput(stderrStream, io.TeeReader(stderr, stderrHead))
 	}()
 
 	// Wait for output streaming to complete
@@ -191,30 +736,153 @@ func (e *Executor) executeCommand(ctx context.Context, cmdMsg *messages.CommandM
 		}
 	}
 
-	e.sendComplete(cmdMsg.ID, exitCode, startTime)
+	complete := newCompleteMessage(cmdMsg, exitCode)
+	complete.PeakMemoryBytes = peakMemory(cmd.ProcessState)
+	complete.OutputTruncated = rc.outputLimit.wasTruncated()
+	if rc.wasInterrupted() {
+		complete.Reason = messages.ReasonInterrupted
+	} else if rc.notFoundHint && exitCode != 0 {
+		complete.Diagnostic = rc.redactor.Redact(notFoundDiagnostic(exitCode, stderrHead.String(), rc.loginShell))
+	}
+	if pipefail {
+		<-statusDone
+		complete.PipeStatus = parsePipeStatus(string(statusData))
+		complete.FailedStage = failedStage(complete.PipeStatus)
+	}
+
+	e.sendComplete(complete, startTime)
+}
+
+// stdinReader returns a command's stdin, decoding base64 as it is read so a
+// large input isn't decoded into memory up front
+func stdinReader(cmdMsg *messages.CommandMessage) io.Reader {
+	reader := io.Reader(strings.NewReader(cmdMsg.Stdin))
+	if cmdMsg.StdinEncoding == messages.EncodingBase64 {
+		reader = base64.NewDecoder(base64.StdEncoding, reader)
+	}
+	return reader
 }
 
-// streamOutput reads from a reader and sends output messages
-func (e *Executor) streamOutput(id, stream string, reader io.Reader) {
-	scanner := bufio.NewScanner(reader)
-	// Increase buffer size for long lines
-	buf := make([]byte, 64*1024)
-	scanner.Buffer(buf, 1024*1024)
+// newCompleteMessage creates a command's complete message, carrying its group
+// and step
+func newCompleteMessage(cmdMsg *messages.CommandMessage, exitCode int) *messages.CompleteMessage {
+	msg := messages.NewCompleteMessage(cmdMsg.ID, exitCode, 0)
+	msg.GroupID = cmdMsg.GroupID
+	msg.Step = cmdMsg.Step
+	return msg
+}
+
+// outputHandlerFor returns the output handler for a command, tagging output
+// with the command's group and step
+func (e *Executor) outputHandlerFor(cmdMsg *messages.CommandMessage) OutputHandler {
+	if e.outputHandler == nil || (cmdMsg.GroupID == "" && cmdMsg.Step == 0) {
+		return e.outputHandler
+	}
+
+	return func(msg *messages.OutputMessage) {
+		msg.GroupID = cmdMsg.GroupID
+		msg.Step = cmdMsg.Step
+		e.outputHandler(msg)
+	}
+}
+
+// buildCommand creates the shell command, wrapped for a lower scheduling
+// priority when requested. Apps that need their
+// profile environment (rbenv, nvm, PATH from .bashrc) run via a bash login shell.
+// Pipefail commands run via bash and report whether pipeline stage exit codes
+// will be written to pipeStatusFD. Scripts run from scriptPath; pipefail
+// applies to their pipelines but stage exit codes are not reported.
+func buildCommand(ctx context.Context, cmdMsg *messages.CommandMessage, loginShell bool, scriptPath string) (*exec.Cmd, bool) {
+	if scriptPath != "" {
+		return buildScriptCommand(ctx, cmdMsg, loginShell, scriptPath), false
+	}
+
+	args := []string{"sh", "-c", cmdMsg.Command}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if e.outputHandler != nil {
-			e.outputHandler(messages.NewOutputMessage(id, stream, line+"\n"))
+	_, bashErr := exec.LookPath("bash")
+	pipefail := cmdMsg.Pipefail && bashErr == nil && runtime.GOOS != "windows"
+	if cmdMsg.Pipefail && !pipefail {
+		log.Printf("Pipefail requested for command %s but bash is not available, using sh", cmdMsg.ID)
+	}
+
+	script := cmdMsg.Command
+	if pipefail {
+		script = pipefailScript(cmdMsg.Command)
+		args = []string{"bash", "-c", script}
+	}
+
+	if loginShell {
+		if bashErr == nil {
+			args = []string{"bash", "-lc", script}
+		} else {
+			log.Printf("Login shell requested for command %s but bash is not available, using sh", cmdMsg.ID)
+		}
+	}
+
+	args = withPriority(args, cmdMsg.Priority)
+	return exec.CommandContext(ctx, args[0], args[1:]...), pipefail
+}
+
+// buildScriptCommand creates the shell command running a script file
+func buildScriptCommand(ctx context.Context, cmdMsg *messages.CommandMessage, loginShell bool, scriptPath string) *exec.Cmd {
+	args := []string{"sh", scriptPath}
+
+	if cmdMsg.Pipefail || loginShell {
+		if _, err := exec.LookPath("bash"); err == nil {
+			args = []string{"bash"}
+			if loginShell {
+				args = append(args, "-l")
+			}
+			if cmdMsg.Pipefail {
+				args = append(args, "-o", "pipefail")
+			}
+			args = append(args, scriptPath)
+		} else {
+			log.Printf("Bash requested for script %s but is not available, using sh", cmdMsg.ID)
 		}
 	}
+
+	args = withPriority(args, cmdMsg.Priority)
+	return exec.CommandContext(ctx, args[0], args[1:]...)
 }
 
-// sendComplete sends a command complete message
-func (e *Executor) sendComplete(id string, exitCode int, startTime time.Time) {
-	durationMs := time.Since(startTime).Milliseconds()
-	log.Printf("Command %s completed with exit code %d (duration: %dms)", id, exitCode, durationMs)
+// withPriority wraps args with nice (and ionice on Linux) when a lower
+// scheduling priority is requested
+func withPriority(args []string, priority int) []string {
+	if priority > 0 {
+		args = append([]string{"nice", "-n", strconv.Itoa(priority)}, args...)
+
+		// Map niceness 1-19 onto best-effort IO priority 0-7 (7 = lowest)
+		if runtime.GOOS == "linux" {
+			if _, err := exec.LookPath("ionice"); err == nil {
+				ioLevel := priority * 7 / 19
+				args = append([]string{"ionice", "-c", "2", "-n", strconv.Itoa(ioLevel)}, args...)
+			}
+		}
+	}
+
+	return args
+}
+
+// streamOutput reads from a reader into an output stream until EOF
+func (e *Executor) streamOutput(stream *outputStream, reader io.Reader) {
+	if _, err := io.Copy(stream, reader); err != nil {
+		log.Printf("Error reading %s of command %s: %v", stream.stream, stream.id, err)
+	}
+	stream.Close()
+}
+
+// sendComplete sets the command's duration and sends its complete message
+func (e *Executor) sendComplete(msg *messages.CompleteMessage, startTime time.Time) {
+	msg.DurationMs = messages.ElapsedMs(startTime, time.Now())
+	log.Printf("Command %s completed with exit code %d (duration: %dms)", msg.ID, msg.ExitCode, msg.DurationMs)
+	if msg.FailedStage > 0 {
+		log.Printf("Command %s pipeline stage %d failed (stage exit codes %v)", msg.ID, msg.FailedStage, msg.PipeStatus)
+	}
+
+	e.recordHistory(msg)
 
 	if e.completeHandler != nil {
-		e.completeHandler(messages.NewCompleteMessage(id, exitCode, durationMs))
+		e.completeHandler(msg)
 	}
 }
@@ -1,6 +1,13 @@
 package executor
 
 import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -500,6 +507,162 @@ func TestExecutor_OutputStreaming_BothStreams(t *testing.T) {
 	}
 }
 
+func TestExecutor_OutputStreaming_PartialLineHeldUntilEOF(t *testing.T) {
+	var outputs []string
+	var outputMu sync.Mutex
+	done := make(chan struct{})
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputMu.Lock()
+			outputs = append(outputs, msg.Data)
+			outputMu.Unlock()
+		},
+		func(msg *messages.CompleteMessage) {
+			close(done)
+		},
+		nil,
+		nil,
+	)
+
+	exec.Execute(&messages.CommandMessage{
+		ID:      "test-partial-eof",
+		Command: "printf 'done\\nno newline'",
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	expected := []string{"done\n", "no newline\n"}
+	if strings.Join(outputs, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, outputs)
+	}
+}
+
+func TestExecutor_OutputStreaming_RedactsSecrets(t *testing.T) {
+	appDir := t.TempDir()
+
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{
+		{
+			Path: appDir,
+			Config: &messages.AppConfig{
+				Redact: []string{`sk_live_[A-Za-z0-9]+`},
+			},
+		},
+	})
+
+	var outputs []string
+	var outputMu sync.Mutex
+	done := make(chan struct{})
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputMu.Lock()
+			outputs = append(outputs, msg.Data)
+			outputMu.Unlock()
+		},
+		func(msg *messages.CompleteMessage) {
+			close(done)
+		},
+		nil,
+		validator,
+	)
+
+	exec.Execute(&messages.CommandMessage{
+		ID:         "test-redact",
+		Command:    "echo 'DB_PASSWORD=hunter2'; echo 'charging with sk_live_abc123'; echo 'done'",
+		WorkingDir: appDir,
+	})
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	expected := []string{"DB_PASSWORD=***\n", "charging with ***\n", "done\n"}
+	if strings.Join(outputs, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, outputs)
+	}
+}
+
+// =============================================================================
+// FLUSH OUTPUT TESTS
+// =============================================================================
+
+func TestExecutor_FlushOutput(t *testing.T) {
+	outputs := make(chan string, 10)
+	done := make(chan struct{})
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputs <- msg.Data
+		},
+		func(msg *messages.CompleteMessage) {
+			close(done)
+		},
+		nil,
+		nil,
+	)
+
+	exec.Execute(&messages.CommandMessage{
+		ID:      "test-flush",
+		Command: "printf 'progress 50%%'; sleep 2",
+	})
+
+	// Partial line is buffered until flushed
+	select {
+	case data := <-outputs:
+		t.Fatalf("expected partial line to be buffered, got %q", data)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	if !exec.FlushOutput("test-flush") {
+		t.Fatal("expected flush of running command to return true")
+	}
+
+	select {
+	case data := <-outputs:
+		if data != "progress 50%" {
+			t.Errorf("expected flushed partial line, got %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for flushed output")
+	}
+
+	exec.Cancel("test-flush")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// Nothing left to emit after the flush
+	select {
+	case data := <-outputs:
+		t.Errorf("unexpected output after flush: %q", data)
+	default:
+	}
+}
+
+func TestExecutor_FlushOutput_NonExistent(t *testing.T) {
+	exec := New(nil, nil, nil, nil)
+
+	if exec.FlushOutput("non-existent") {
+		t.Error("expected flush of non-existent command to return false")
+	}
+}
+
 // =============================================================================
 // WORKING DIRECTORY TESTS
 // =============================================================================
@@ -545,6 +708,94 @@ func TestExecutor_WorkingDirectory(t *testing.T) {
 	}
 }
 
+func TestExecutor_AppMaxTimeoutCapsDefault(t *testing.T) {
+	app := t.TempDir()
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{
+		{Path: app, Config: &messages.AppConfig{MaxTimeout: 1}},
+	})
+
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(nil, func(msg *messages.CompleteMessage) { done <- msg }, nil, validator)
+
+	// No timeout requested: the 5 minute default is capped to the app's 1s
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-app-cap", Command: "sleep 10", WorkingDir: app}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-done:
+		if msg.ExitCode == 0 {
+			t.Error("expected non-zero exit code for timed out command")
+		}
+		if msg.DurationMs >= 5000 {
+			t.Errorf("expected the command stopped after about 1s, ran %dms", msg.DurationMs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not stopped at the app's timeout cap")
+	}
+}
+
+func TestExecutor_WorkingDirRelativeToApp(t *testing.T) {
+	root := t.TempDir()
+	app := filepath.Join(root, "shop")
+	if err := os.MkdirAll(filepath.Join(app, "public"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	restricted := filepath.Join(root, "locked")
+	if err := os.MkdirAll(filepath.Join(restricted, "storage"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{
+		{Path: app},
+		{Path: restricted, Config: &messages.AppConfig{WorkingDirs: []string{"."}}},
+	})
+
+	var output strings.Builder
+	var outputMu sync.Mutex
+	done := make(chan struct{})
+	rejected := make(chan *messages.RejectedMessage, 1)
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputMu.Lock()
+			defer outputMu.Unlock()
+			output.WriteString(msg.Data)
+		},
+		func(msg *messages.CompleteMessage) { close(done) },
+		func(msg *messages.RejectedMessage) { rejected <- msg },
+		validator,
+	)
+
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-app-dir", Command: "pwd", App: "shop", WorkingDir: "public"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	outputMu.Lock()
+	got := strings.TrimSpace(output.String())
+	outputMu.Unlock()
+	want, _ := filepath.EvalSymlinks(filepath.Join(app, "public"))
+	if resolved, _ := filepath.EvalSymlinks(got); resolved != want {
+		t.Errorf("expected command to run in %s, got %q", want, got)
+	}
+
+	// The resolved dir is still validated against the app's rules
+	err := exec.Execute(&messages.CommandMessage{ID: "test-app-locked", Command: "pwd", App: "locked", WorkingDir: "storage"})
+	if vErr, ok := err.(*security.ValidationError); !ok || vErr.Code != "WORKING_DIR_NOT_ALLOWED" {
+		t.Errorf("expected WORKING_DIR_NOT_ALLOWED, got %v", err)
+	}
+	if msg := <-rejected; msg.ID != "test-app-locked" {
+		t.Errorf("expected rejection for test-app-locked, got %+v", msg)
+	}
+}
+
 // =============================================================================
 // ENVIRONMENT VARIABLE TESTS
 // =============================================================================
@@ -726,3 +977,1188 @@ func TestExecutor_DurationTracking(t *testing.T) {
 		t.Errorf("expected duration >= 100ms, got %d", completeMsg.DurationMs)
 	}
 }
+
+// =============================================================================
+// PRIORITY TESTS
+// =============================================================================
+
+func TestExecutor_Priority_Niceness(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("niceness check requires Linux")
+	}
+
+	// Niceness is relative to the test process's own niceness
+	baseOut, err := osexec.Command("nice").Output()
+	if err != nil {
+		t.Skipf("nice not available: %v", err)
+	}
+	base, _ := strconv.Atoi(strings.TrimSpace(string(baseOut)))
+
+	expected := base + 10
+	if expected > 19 {
+		expected = 19
+	}
+
+	var output strings.Builder
+	var outputMu sync.Mutex
+	done := make(chan struct{})
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputMu.Lock()
+			output.WriteString(msg.Data)
+			outputMu.Unlock()
+		},
+		func(msg *messages.CompleteMessage) {
+			close(done)
+		},
+		nil,
+		security.NewValidator(),
+	)
+
+	err = exec.Execute(&messages.CommandMessage{
+		ID:       "test-priority",
+		Command:  "nice",
+		Priority: 10,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	got, err := strconv.Atoi(strings.TrimSpace(output.String()))
+	if err != nil {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+	if got != expected {
+		t.Errorf("expected niceness %d, got %d", expected, got)
+	}
+}
+
+func TestExecutor_Priority_OutOfRange(t *testing.T) {
+	var rejectedMsg *messages.RejectedMessage
+
+	exec := New(
+		nil,
+		nil,
+		func(msg *messages.RejectedMessage) {
+			rejectedMsg = msg
+		},
+		security.NewValidator(),
+	)
+
+	err := exec.Execute(&messages.CommandMessage{
+		ID:       "test-priority-boost",
+		Command:  "echo hi",
+		Priority: -5,
+	})
+	if err == nil {
+		t.Fatal("expected negative priority to be rejected")
+	}
+	if rejectedMsg == nil || rejectedMsg.Code != "INVALID_PRIORITY" {
+		t.Errorf("expected INVALID_PRIORITY rejection, got %+v", rejectedMsg)
+	}
+}
+
+// =============================================================================
+// LOGIN SHELL TESTS
+// =============================================================================
+
+func TestExecutor_LoginShell_LoadsProfile(t *testing.T) {
+	if _, err := osexec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+
+	home := t.TempDir()
+	profile := "export ANTIDOTE_PROFILE_VAR=from_profile\n"
+	if err := os.WriteFile(filepath.Join(home, ".bash_profile"), []byte(profile), 0644); err != nil {
+		t.Fatalf("failed to write profile: %v", err)
+	}
+	t.Setenv("HOME", home)
+
+	loginApp := t.TempDir()
+	plainApp := t.TempDir()
+
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{
+		{Path: loginApp, Config: &messages.AppConfig{LoginShell: true}},
+		{Path: plainApp, Config: &messages.AppConfig{}},
+	})
+
+	tests := []struct {
+		name       string
+		workingDir string
+		expected   string
+	}{
+		{"login shell app", loginApp, "[from_profile]\n"},
+		{"plain app", plainApp, "[]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outputs []string
+			var outputMu sync.Mutex
+			done := make(chan struct{})
+
+			exec := New(
+				func(msg *messages.OutputMessage) {
+					outputMu.Lock()
+					outputs = append(outputs, msg.Data)
+					outputMu.Unlock()
+				},
+				func(msg *messages.CompleteMessage) {
+					close(done)
+				},
+				nil,
+				validator,
+			)
+
+			exec.Execute(&messages.CommandMessage{
+				ID:         "test-login-shell",
+				Command:    `echo "[$ANTIDOTE_PROFILE_VAR]"`,
+				WorkingDir: tt.workingDir,
+			})
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+
+			outputMu.Lock()
+			defer outputMu.Unlock()
+
+			if output := strings.Join(outputs, ""); output != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, output)
+			}
+		})
+	}
+}
+
+// =============================================================================
+// COMMAND NOT FOUND TESTS
+// =============================================================================
+
+func TestExecutor_CommandNotFound_Diagnostic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix shells only")
+	}
+	if _, err := osexec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+	t.Setenv("HOME", t.TempDir())
+
+	loginApp := t.TempDir()
+	plainApp := t.TempDir()
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{
+		{Path: loginApp, Config: &messages.AppConfig{LoginShell: true}},
+		{Path: plainApp, Config: &messages.AppConfig{}},
+	})
+
+	tests := []struct {
+		name       string
+		workingDir string
+		hints      bool
+		expected   string // in the diagnostic, "" for none
+	}{
+		{"plain app", plainApp, true, "login_shell: true"},
+		{"login shell app", loginApp, true, "even in the app's login shell"},
+		{"hints disabled", plainApp, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan *messages.CompleteMessage, 1)
+			exec := New(nil, func(msg *messages.CompleteMessage) { done <- msg }, nil, validator)
+			exec.SetNotFoundHints(tt.hints)
+
+			exec.Execute(&messages.CommandMessage{
+				ID:         "test-not-found",
+				Command:    "antidote-no-such-binary --version",
+				WorkingDir: tt.workingDir,
+			})
+
+			var msg *messages.CompleteMessage
+			select {
+			case msg = <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timeout")
+			}
+
+			if msg.ExitCode != ExitCommandNotFound {
+				t.Errorf("expected exit code %d, got %d", ExitCommandNotFound, msg.ExitCode)
+			}
+			if tt.expected == "" {
+				if msg.Diagnostic != "" {
+					t.Errorf("expected no diagnostic, got %q", msg.Diagnostic)
+				}
+				return
+			}
+			if !strings.Contains(msg.Diagnostic, `"antidote-no-such-binary"`) || !strings.Contains(msg.Diagnostic, tt.expected) {
+				t.Errorf("expected a diagnostic naming the binary and containing %q, got %q", tt.expected, msg.Diagnostic)
+			}
+		})
+	}
+}
+
+func TestExecutor_CommandNotFound_OtherFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix shells only")
+	}
+
+	msg := runComplete(t, &messages.CommandMessage{ID: "test-exit-1", Command: "echo 'not found' >&2; exit 1"})
+	if msg.ExitCode != 1 || msg.Diagnostic != "" {
+		t.Errorf("expected exit 1 without a diagnostic, got %+v", msg)
+	}
+}
+
+func TestMissingCommand(t *testing.T) {
+	tests := []struct {
+		stderr string
+		name   string
+		found  bool
+	}{
+		{"bash: composer: command not found\n", "composer", true},
+		{"bash: line 1: composer: command not found\n", "composer", true},
+		{"sh: 1: composer: not found\n", "composer", true},
+		{"zsh: command not found: composer\n", "composer", true},
+		{"PHP Warning: something\nsh: 3: php: not found\n", "php", true},
+		{"Error: file not found\n", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		name, found := missingCommand(tt.stderr)
+		if name != tt.name || found != tt.found {
+			t.Errorf("missingCommand(%q) = %q, %v; expected %q, %v", tt.stderr, name, found, tt.name, tt.found)
+		}
+	}
+}
+
+// =============================================================================
+// PIPEFAIL TESTS
+// =============================================================================
+
+// runComplete runs a command and returns its complete message
+func runComplete(t *testing.T, cmdMsg *messages.CommandMessage) *messages.CompleteMessage {
+	t.Helper()
+
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(nil, func(msg *messages.CompleteMessage) { done <- msg }, nil, nil)
+
+	if err := exec.Execute(cmdMsg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-done:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return nil
+	}
+}
+
+func TestExecutor_Pipefail_ReportsFailingStage(t *testing.T) {
+	if _, err := osexec.LookPath("bash"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("bash not available")
+	}
+
+	// Without pipefail only the last stage counts
+	plain := runComplete(t, &messages.CommandMessage{ID: "test-plain", Command: "false | cat"})
+	if plain.ExitCode != 0 || plain.PipeStatus != nil {
+		t.Errorf("expected plain pipeline to succeed without stage status, got %+v", plain)
+	}
+
+	msg := runComplete(t, &messages.CommandMessage{ID: "test-pipefail", Command: "echo data | (exit 3) | cat", Pipefail: true})
+	if msg.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", msg.ExitCode)
+	}
+	if len(msg.PipeStatus) != 3 || msg.PipeStatus[1] != 3 {
+		t.Errorf("expected stage exit codes [0 3 0], got %v", msg.PipeStatus)
+	}
+	if msg.FailedStage != 2 {
+		t.Errorf("expected failed stage 2, got %d", msg.FailedStage)
+	}
+}
+
+func TestExecutor_Pipefail_Success(t *testing.T) {
+	if _, err := osexec.LookPath("bash"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("bash not available")
+	}
+
+	msg := runComplete(t, &messages.CommandMessage{ID: "test-pipefail-ok", Command: "echo data | cat", Pipefail: true})
+	if msg.ExitCode != 0 || msg.FailedStage != 0 {
+		t.Errorf("expected success, got %+v", msg)
+	}
+	if len(msg.PipeStatus) != 2 {
+		t.Errorf("expected 2 stage exit codes, got %v", msg.PipeStatus)
+	}
+}
+
+func TestExecutor_Pipefail_BackgroundChildDoesNotBlock(t *testing.T) {
+	if _, err := osexec.LookPath("bash"); err != nil || runtime.GOOS == "windows" {
+		t.Skip("bash not available")
+	}
+
+	start := time.Now()
+	msg := runComplete(t, &messages.CommandMessage{ID: "test-pipefail-bg", Command: "sleep 3 >/dev/null 2>&1 & true | true", Pipefail: true})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected completion without waiting for background child, took %v", elapsed)
+	}
+	if len(msg.PipeStatus) != 2 {
+		t.Errorf("expected 2 stage exit codes, got %v", msg.PipeStatus)
+	}
+}
+
+// =============================================================================
+// SCRIPT TESTS
+// =============================================================================
+
+func TestExecutor_Script_RunsAndCleansUp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("scripts run via sh")
+	}
+
+	dir := t.TempDir()
+	original := scriptDir
+	scriptDir = func() string { return dir }
+	t.Cleanup(func() { scriptDir = original })
+
+	var outputs []string
+	var outputMu sync.Mutex
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputMu.Lock()
+			outputs = append(outputs, msg.Data)
+			outputMu.Unlock()
+		},
+		func(msg *messages.CompleteMessage) { done <- msg },
+		nil,
+		security.NewValidator(),
+	)
+
+	script := "#!/bin/sh\nset -e\nname=world\necho \"hello $name\"\n\n# comment\necho second line\n"
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-script", Script: script}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-done:
+		if msg.ExitCode != 0 {
+			t.Errorf("expected exit code 0, got %d", msg.ExitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	outputMu.Lock()
+	output := strings.Join(outputs, "")
+	outputMu.Unlock()
+	if output != "hello world\nsecond line\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected script temp dir to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestExecutor_Script_DangerousLineRejected(t *testing.T) {
+	var rejected *messages.RejectedMessage
+	completed := make(chan struct{}, 1)
+	exec := New(
+		nil,
+		func(msg *messages.CompleteMessage) { completed <- struct{}{} },
+		func(msg *messages.RejectedMessage) { rejected = msg },
+		security.NewValidator(),
+	)
+
+	err := exec.Execute(&messages.CommandMessage{ID: "test-script-deny", Script: "echo cleaning up\nrm -rf /\n"})
+	if err == nil {
+		t.Fatal("expected script with a dangerous line to be rejected")
+	}
+	if rejected == nil || rejected.Code != "COMMAND_DENIED" {
+		t.Errorf("expected COMMAND_DENIED rejection, got %+v", rejected)
+	}
+
+	select {
+	case <-completed:
+		t.Error("expected rejected script not to run")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// =============================================================================
+// STDIN TESTS
+// =============================================================================
+
+// runOutput runs a command and returns its stdout and complete message
+func runOutput(t *testing.T, cmdMsg *messages.CommandMessage) (string, *messages.CompleteMessage) {
+	t.Helper()
+
+	var stdout strings.Builder
+	var outputMu sync.Mutex
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			outputMu.Lock()
+			defer outputMu.Unlock()
+			if msg.Stream == "stdout" {
+				stdout.WriteString(msg.Data)
+			}
+		},
+		func(msg *messages.CompleteMessage) { done <- msg },
+		nil,
+		nil,
+	)
+
+	if err := exec.Execute(cmdMsg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-done:
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		return stdout.String(), msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+		return "", nil
+	}
+}
+
+func TestExecutor_Stdin(t *testing.T) {
+	output, msg := runOutput(t, &messages.CommandMessage{ID: "test-stdin", Command: "cat", Stdin: "line one\nline two\n"})
+	if msg.ExitCode != 0 || output != "line one\nline two\n" {
+		t.Errorf("expected stdin echoed back, got %q (exit %d)", output, msg.ExitCode)
+	}
+}
+
+func TestExecutor_StdinBase64(t *testing.T) {
+	output, _ := runOutput(t, &messages.CommandMessage{
+		ID:            "test-stdin-b64",
+		Command:       "cat",
+		Stdin:         base64.StdEncoding.EncodeToString([]byte("decoded input\n")),
+		StdinEncoding: messages.EncodingBase64,
+	})
+	if output != "decoded input\n" {
+		t.Errorf("expected decoded stdin, got %q", output)
+	}
+}
+
+func TestExecutor_StdinLargerThanPipeBuffer(t *testing.T) {
+	// Larger than a pipe buffer, so it only completes if stdin is streamed
+	// while the output is read
+	input := strings.Repeat("0123456789abcdef\n", 64*1024)
+	output, msg := runOutput(t, &messages.CommandMessage{ID: "test-stdin-large", Command: "cat", Stdin: input})
+	if msg.ExitCode != 0 || len(output) != len(input) {
+		t.Errorf("expected %d bytes echoed, got %d (exit %d)", len(input), len(output), msg.ExitCode)
+	}
+}
+
+func TestExecutor_StdinIgnoredByCommand(t *testing.T) {
+	// A command that exits without reading its stdin must not hang
+	_, msg := runOutput(t, &messages.CommandMessage{ID: "test-stdin-unread", Command: "true", Stdin: strings.Repeat("x", 1<<20)})
+	if msg.ExitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", msg.ExitCode)
+	}
+}
+
+func TestExecutor_NoStdinReadsEmpty(t *testing.T) {
+	output, _ := runOutput(t, &messages.CommandMessage{ID: "test-no-stdin", Command: "cat; echo end"})
+	if output != "end\n" {
+		t.Errorf("expected empty stdin, got %q", output)
+	}
+}
+
+// =============================================================================
+// OUTPUT LIMIT TESTS
+// =============================================================================
+
+func TestExecutor_MaxOutputBytes_Truncates(t *testing.T) {
+	output, msg := runOutput(t, &messages.CommandMessage{
+		ID:             "test-output-limit",
+		Command:        "yes | head -c 1000000; echo done",
+		MaxOutputBytes: 1000,
+	})
+
+	if !msg.OutputTruncated {
+		t.Error("expected complete message to report truncated output")
+	}
+	if msg.ExitCode != 0 {
+		t.Errorf("expected command to run to completion, got exit code %d", msg.ExitCode)
+	}
+	if !strings.HasPrefix(output, strings.Repeat("y\n", 500)) {
+		t.Errorf("expected the first 1000 bytes of output, got %q", output[:min(len(output), 40)])
+	}
+	notice := strings.TrimPrefix(output, strings.Repeat("y\n", 500))
+	if !strings.HasPrefix(notice, "[output truncated") || strings.Contains(notice, "done") {
+		t.Errorf("expected only a truncation notice after the limit, got %q", notice)
+	}
+}
+
+func TestExecutor_MaxOutputBytes_NotTruncated(t *testing.T) {
+	_, msg := runOutput(t, &messages.CommandMessage{ID: "test-output-small", Command: "echo hello"})
+	if msg.OutputTruncated {
+		t.Error("expected small output not to be truncated")
+	}
+}
+
+func TestExecutor_SetMaxOutputBytes(t *testing.T) {
+	var output strings.Builder
+	var outputMu sync.Mutex
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(func(msg *messages.OutputMessage) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		output.WriteString(msg.Data)
+	}, func(msg *messages.CompleteMessage) { done <- msg }, nil, nil)
+	exec.SetMaxOutputBytes(4)
+
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-agent-limit", Command: "echo ab; echo cd >&2; echo ef"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-done:
+		if !msg.OutputTruncated {
+			t.Error("expected output over the executor limit to be truncated")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if strings.Contains(output.String(), "ef") {
+		t.Errorf("expected output after the limit to be dropped, got %q", output.String())
+	}
+}
+
+func TestExecutor_SetOutputCoalesce(t *testing.T) {
+	var msgs []*messages.OutputMessage
+	var outputMu sync.Mutex
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(func(msg *messages.OutputMessage) {
+		outputMu.Lock()
+		defer outputMu.Unlock()
+		msgs = append(msgs, msg)
+	}, func(msg *messages.CompleteMessage) { done <- msg }, nil, nil)
+	exec.SetOutputCoalesce(time.Hour)
+
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-coalesce", Command: "echo one; echo two; echo three"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	// The batch is sent when the stream ends, before the command completes
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 coalesced message, got %d", len(msgs))
+	}
+	if msgs[0].Data != "one\ntwo\nthree\n" {
+		t.Errorf("expected all lines in one message, got %q", msgs[0].Data)
+	}
+	if len(msgs[0].LineTimestamps) != 3 {
+		t.Errorf("expected a timestamp per line, got %v", msgs[0].LineTimestamps)
+	}
+}
+
+// =============================================================================
+// HISTORY TESTS
+// =============================================================================
+
+func TestExecutor_History(t *testing.T) {
+	done := make(chan struct{}, 1)
+	exec := New(nil, func(msg *messages.CompleteMessage) { done <- struct{}{} }, nil, security.NewValidator())
+
+	commands := []string{"echo first", "exit 2", "echo API_KEY=sk-secret123"}
+	for i, command := range commands {
+		if err := exec.Execute(&messages.CommandMessage{ID: fmt.Sprintf("test-history-%d", i), Command: command}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	history := exec.History()
+	if len(history) != 3 {
+		t.Fatalf("expected 3 history records, got %d", len(history))
+	}
+
+	// Newest first
+	if history[0].ID != "test-history-2" || history[2].ID != "test-history-0" {
+		t.Errorf("expected newest record first, got %s ... %s", history[0].ID, history[2].ID)
+	}
+	if history[1].ExitCode != 2 {
+		t.Errorf("expected exit code 2, got %d", history[1].ExitCode)
+	}
+	if strings.Contains(history[0].Command, "sk-secret123") {
+		t.Errorf("expected secret to be redacted from history, got %q", history[0].Command)
+	}
+	if history[0].StartedAt.IsZero() {
+		t.Error("expected start time to be recorded")
+	}
+}
+
+func TestExecutor_Running_Redacted(t *testing.T) {
+	done := make(chan struct{}, 1)
+	exec := New(nil, func(msg *messages.CompleteMessage) { done <- struct{}{} }, nil, security.NewValidator())
+
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-running-secret", Command: "sleep 0.5; echo API_KEY=sk-secret123"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}()
+
+	running := exec.Running()
+	if len(running) != 1 || running[0].ID != "test-running-secret" {
+		t.Fatalf("expected the command to be running, got %+v", running)
+	}
+	if strings.Contains(running[0].Command, "sk-secret123") {
+		t.Errorf("expected secret to be redacted from running commands, got %q", running[0].Command)
+	}
+}
+
+func TestExecutor_History_Bounded(t *testing.T) {
+	exec := New(nil, nil, nil, nil)
+
+	for i := 0; i < MaxHistory+5; i++ {
+		id := fmt.Sprintf("cmd-%d", i)
+		exec.running[id] = &runningCommand{command: "true"}
+		exec.recordHistory(&messages.CompleteMessage{ID: id})
+	}
+
+	history := exec.History()
+	if len(history) != MaxHistory {
+		t.Fatalf("expected %d history records, got %d", MaxHistory, len(history))
+	}
+	if history[0].ID != fmt.Sprintf("cmd-%d", MaxHistory+4) {
+		t.Errorf("expected newest record first, got %s", history[0].ID)
+	}
+}
+
+func TestExecutor_History_MaxAge(t *testing.T) {
+	exec := New(nil, nil, nil, nil)
+	exec.SetHistoryMaxAge(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("cmd-%d", i)
+		exec.running[id] = &runningCommand{command: "true"}
+		exec.recordHistory(&messages.CompleteMessage{ID: id})
+	}
+
+	// The two oldest finished longer ago than the max age
+	exec.runningMu.Lock()
+	exec.history[0].finishedAt = time.Now().Add(-3 * time.Hour)
+	exec.history[1].finishedAt = time.Now().Add(-2 * time.Hour)
+	exec.runningMu.Unlock()
+
+	history := exec.History()
+	if len(history) != 1 || history[0].ID != "cmd-2" {
+		t.Fatalf("expected only the recent record to remain, got %+v", history)
+	}
+
+	// Without a max age, records stay until pushed out by count
+	exec.SetHistoryMaxAge(0)
+	exec.runningMu.Lock()
+	exec.history[0].finishedAt = time.Now().Add(-48 * time.Hour)
+	exec.runningMu.Unlock()
+	if len(exec.History()) != 1 {
+		t.Error("expected an old record to be kept with no max age")
+	}
+}
+
+func TestExecutor_History_ConcurrentPruning(t *testing.T) {
+	exec := New(nil, nil, nil, nil)
+	exec.SetHistoryMaxAge(time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				id := fmt.Sprintf("cmd-%d-%d", i, j)
+				exec.runningMu.Lock()
+				exec.running[id] = &runningCommand{command: "true"}
+				exec.runningMu.Unlock()
+				exec.recordHistory(&messages.CompleteMessage{ID: id})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for _, record := range exec.History() {
+					if record.ID == "" {
+						t.Error("expected only complete records")
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(5 * time.Millisecond)
+	if history := exec.History(); len(history) != 0 {
+		t.Errorf("expected every record to age out, got %d", len(history))
+	}
+}
+
+// =============================================================================
+// COMMAND GROUP TESTS
+// =============================================================================
+
+func TestExecutor_GroupAndStepPropagate(t *testing.T) {
+	var mu sync.Mutex
+	var outputs []*messages.OutputMessage
+	done := make(chan *messages.CompleteMessage, 1)
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			outputs = append(outputs, msg)
+		},
+		func(msg *messages.CompleteMessage) { done <- msg },
+		nil,
+		nil,
+	)
+
+	cmd := &messages.CommandMessage{
+		ID:      "test-group",
+		Command: "echo migrating; echo warning >&2",
+		GroupID: "deploy_42",
+		Step:    3,
+	}
+	if err := exec.Execute(cmd); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var complete *messages.CompleteMessage
+	select {
+	case complete = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	if complete.GroupID != "deploy_42" || complete.Step != 3 {
+		t.Errorf("expected group deploy_42 step 3 on complete, got %q step %d", complete.GroupID, complete.Step)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(outputs) < 2 {
+		t.Fatalf("expected stdout and stderr output, got %d messages", len(outputs))
+	}
+	for _, out := range outputs {
+		if out.GroupID != "deploy_42" || out.Step != 3 {
+			t.Errorf("expected group deploy_42 step 3 on %s output, got %q step %d", out.Stream, out.GroupID, out.Step)
+		}
+	}
+}
+
+func TestExecutor_GroupAndStepOnFailedStart(t *testing.T) {
+	msg := runComplete(t, &messages.CommandMessage{
+		ID:         "test-group-fail",
+		Command:    "true",
+		WorkingDir: "/nonexistent/antidote-test-dir",
+		GroupID:    "deploy_42",
+		Step:       1,
+	})
+
+	if msg.ExitCode == 0 {
+		t.Errorf("expected failure for missing working directory")
+	}
+	if msg.GroupID != "deploy_42" || msg.Step != 1 {
+		t.Errorf("expected group deploy_42 step 1, got %q step %d", msg.GroupID, msg.Step)
+	}
+}
+
+// =============================================================================
+// EXCLUSIVE ACTION TESTS
+// =============================================================================
+
+func TestExecutor_ExclusiveActionRejectedWhileRunning(t *testing.T) {
+	shop, blog := t.TempDir(), t.TempDir()
+	deploy := messages.AppConfigAction{Command: "sleep 0.5", Label: "Deploy", Exclusive: true}
+
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{
+		{Path: shop, Config: &messages.AppConfig{Actions: map[string]messages.AppConfigAction{"deploy": deploy}}},
+		{Path: blog, Config: &messages.AppConfig{Actions: map[string]messages.AppConfigAction{"deploy": deploy}}},
+	})
+
+	done := make(chan string, 3)
+	rejected := make(chan *messages.RejectedMessage, 1)
+	exec := New(
+		nil,
+		func(msg *messages.CompleteMessage) { done <- msg.ID },
+		func(msg *messages.RejectedMessage) { rejected <- msg },
+		validator,
+	)
+
+	run := func(id, dir string) error {
+		return exec.Execute(&messages.CommandMessage{ID: id, Command: "sleep 0.5", WorkingDir: dir})
+	}
+
+	if err := run("deploy-1", shop); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A second deploy of the same app is rejected while the first runs
+	if err := run("deploy-2", shop); err == nil {
+		t.Fatal("expected second exclusive run to be rejected")
+	}
+	select {
+	case msg := <-rejected:
+		if msg.ID != "deploy-2" || msg.Code != "ALREADY_RUNNING" {
+			t.Errorf("expected ALREADY_RUNNING for deploy-2, got %s %s", msg.ID, msg.Code)
+		}
+		if !strings.Contains(msg.Message, "deploy-1") {
+			t.Errorf("expected message to name the running command, got %q", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for rejection")
+	}
+
+	// The same action for another app isn't blocked
+	if err := run("deploy-3", blog); err != nil {
+		t.Errorf("expected deploy for another app to run, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for deploys to finish")
+		}
+	}
+
+	// Once the first run finishes the action can run again
+	if err := run("deploy-4", shop); err != nil {
+		t.Errorf("expected deploy to run after the first finished, got %v", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for deploy-4")
+	}
+}
+
+// =============================================================================
+// CONCURRENCY LIMIT TESTS
+// =============================================================================
+
+// waitForActive polls until n commands hold a concurrency slot
+func waitForActive(t *testing.T, exec *Executor, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		exec.slotMu.Lock()
+		active := exec.active
+		exec.slotMu.Unlock()
+		if active == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d active commands", n)
+}
+
+func TestExecutor_MaxQueued_RejectsBeyondQueue(t *testing.T) {
+	rejected := make(chan *messages.RejectedMessage, 5)
+	done := make(chan string, 5)
+	exec := New(nil, func(msg *messages.CompleteMessage) { done <- msg.ID }, func(msg *messages.RejectedMessage) { rejected <- msg }, nil)
+	exec.SetMaxConcurrent(2)
+	exec.SetMaxQueued(1)
+
+	// Two run, one waits, the rest are turned away
+	for i := 0; i < 5; i++ {
+		exec.Execute(&messages.CommandMessage{ID: fmt.Sprintf("test-queue-%d", i), Command: "sleep 10"})
+	}
+	waitForActive(t, exec, 2)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-rejected:
+			if msg.Code != "TOO_MANY_RUNNING" {
+				t.Errorf("expected TOO_MANY_RUNNING, got %s", msg.Code)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected commands beyond the queue to be rejected")
+		}
+	}
+	if n := len(rejected); n != 0 {
+		t.Errorf("expected exactly 2 rejections, got %d more", n)
+	}
+
+	// Cancelling the queued command frees its place in the queue
+	exec.Cancel("test-queue-2")
+	select {
+	case id := <-done:
+		if id != "test-queue-2" {
+			t.Fatalf("expected the queued command to complete, got %s", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the cancelled command")
+	}
+
+	if err := exec.Execute(&messages.CommandMessage{ID: "test-queue-5", Command: "sleep 10"}); err != nil {
+		t.Errorf("expected a command to be queued again, got %v", err)
+	}
+
+	// Finished and cancelled commands release their slots
+	for _, id := range []string{"test-queue-0", "test-queue-1", "test-queue-5"} {
+		exec.Cancel(id)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for cancelled commands")
+		}
+	}
+	waitForActive(t, exec, 0)
+
+	exec.slotMu.Lock()
+	defer exec.slotMu.Unlock()
+	if exec.queued != 0 {
+		t.Errorf("expected an empty queue, got %d", exec.queued)
+	}
+}
+
+func TestExecutor_ServerMaxConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	done := make(chan struct{}, 3)
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch strings.TrimSpace(msg.Data) {
+			case "start":
+				running++
+				if running > peak {
+					peak = running
+				}
+			case "end":
+				running--
+			}
+		},
+		func(msg *messages.CompleteMessage) { done <- struct{}{} },
+		nil,
+		nil,
+	)
+	exec.SetMaxConcurrent(3)
+	exec.SetServerMaxConcurrent(1)
+
+	if got := exec.MaxConcurrent(); got != 1 {
+		t.Fatalf("expected the server limit to override the local one, got %d", got)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := exec.Execute(&messages.CommandMessage{
+			ID:      fmt.Sprintf("test-limit-%d", i),
+			Command: "echo start; sleep 0.1; echo end",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for commands")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Errorf("expected at most 1 command running at once, saw %d", peak)
+	}
+}
+
+func TestExecutor_ServerMaxConcurrentRaisedLive(t *testing.T) {
+	started := make(chan struct{}, 1)
+	done := make(chan string, 2)
+	exec := New(
+		func(msg *messages.OutputMessage) { started <- struct{}{} },
+		func(msg *messages.CompleteMessage) { done <- msg.ID },
+		nil,
+		nil,
+	)
+	exec.SetServerMaxConcurrent(1)
+
+	exec.Execute(&messages.CommandMessage{ID: "test-slow", Command: "echo started; sleep 2"})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the slow command to start")
+	}
+	exec.Execute(&messages.CommandMessage{ID: "test-queued", Command: "true"})
+
+	// Queued behind the slow command until the limit is lifted
+	select {
+	case id := <-done:
+		t.Fatalf("expected no command to finish yet, %s did", id)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	exec.SetServerMaxConcurrent(0)
+
+	select {
+	case id := <-done:
+		if id != "test-queued" {
+			t.Errorf("expected the queued command to finish first, got %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the queued command to start once the limit was lifted")
+	}
+}
+
+func TestExecutor_CancelWhileQueued(t *testing.T) {
+	done := make(chan *messages.CompleteMessage, 2)
+	exec := New(nil, func(msg *messages.CompleteMessage) { done <- msg }, nil, nil)
+	exec.SetMaxConcurrent(1)
+
+	exec.Execute(&messages.CommandMessage{ID: "test-holder", Command: "sleep 1"})
+	waitForActive(t, exec, 1)
+	exec.Execute(&messages.CommandMessage{ID: "test-waiting", Command: "echo never"})
+
+	if !exec.Cancel("test-waiting") {
+		t.Fatal("expected the queued command to be cancellable")
+	}
+
+	select {
+	case msg := <-done:
+		if msg.ID != "test-waiting" || msg.ExitCode == 0 {
+			t.Errorf("expected test-waiting to complete unsuccessfully, got %s exit %d", msg.ID, msg.ExitCode)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("expected the cancelled command to complete without waiting for a slot")
+	}
+}
+
+func TestExecutor_DurationNeverNegative(t *testing.T) {
+	var complete *messages.CompleteMessage
+	exec := New(nil, func(msg *messages.CompleteMessage) { complete = msg }, nil, nil)
+
+	// A start time without a monotonic reading, an hour "after" now, as if
+	// the clock stepped back while the command ran
+	start := time.Now().Add(time.Hour).Round(0)
+	exec.sendComplete(messages.NewCompleteMessage("test-clock", 0, 0), start)
+
+	if complete == nil || complete.DurationMs != 0 {
+		t.Errorf("expected a zero duration, got %+v", complete)
+	}
+}
+
+// =============================================================================
+// DISK SPACE TESTS
+// =============================================================================
+
+func TestExecutor_DiskHeavyRejectedOnLowSpace(t *testing.T) {
+	origFree := freeDiskSpace
+	defer func() { freeDiskSpace = origFree }()
+	var free uint64 = 100 * 1024 * 1024
+	var checkedDir string
+	freeDiskSpace = func(path string) (uint64, error) {
+		checkedDir = path
+		return free, nil
+	}
+
+	app := t.TempDir()
+	validator := security.NewValidator()
+	validator.UpdateApps([]messages.AppInfo{{Path: app, Config: &messages.AppConfig{
+		Actions: map[string]messages.AppConfigAction{
+			"build": {Command: "echo building", Label: "Build", DiskHeavy: true},
+		},
+	}}})
+
+	var rejected []*messages.RejectedMessage
+	var rejectedMu sync.Mutex
+	exec := New(nil, nil, func(msg *messages.RejectedMessage) {
+		rejectedMu.Lock()
+		defer rejectedMu.Unlock()
+		rejected = append(rejected, msg)
+	}, validator)
+
+	tests := []struct {
+		name     string
+		cmdMsg   *messages.CommandMessage
+		rejected bool
+	}{
+		{"tagged by the server", &messages.CommandMessage{ID: "tagged", Command: "echo artifacts", WorkingDir: app, DiskHeavy: true}, true},
+		{"disk-heavy action", &messages.CommandMessage{ID: "action", Command: "echo building", WorkingDir: app}, true},
+		{"disk-heavy action with arguments", &messages.CommandMessage{ID: "action-args", Command: "echo  building --verbose", WorkingDir: app}, true},
+		{"disk-heavy action in a script", &messages.CommandMessage{ID: "action-script", Script: "set -e\necho building\n", WorkingDir: app}, true},
+		{"other command", &messages.CommandMessage{ID: "other", Command: "echo hi", WorkingDir: app}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := exec.Execute(tt.cmdMsg)
+			if !tt.rejected {
+				if err != nil {
+					t.Errorf("expected the command to run, got %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("expected the command to be rejected")
+			}
+			rejectedMu.Lock()
+			defer rejectedMu.Unlock()
+			last := rejected[len(rejected)-1]
+			if last.ID != tt.cmdMsg.ID || last.Code != "LOW_DISK_SPACE" {
+				t.Errorf("expected LOW_DISK_SPACE for %s, got %s %s", tt.cmdMsg.ID, last.ID, last.Code)
+			}
+			if !strings.Contains(last.Message, "100 MB free") || checkedDir != app {
+				t.Errorf("expected the free space of %s in the message, got %q (checked %s)", app, last.Message, checkedDir)
+			}
+		})
+	}
+
+	// Enough space, or the check turned off, lets disk-heavy commands run
+	free = 2 * DefaultMinFreeDiskMB * 1024 * 1024
+	if err := exec.Execute(&messages.CommandMessage{ID: "enough", Command: "echo building", WorkingDir: app}); err != nil {
+		t.Errorf("expected the command to run with enough space, got %v", err)
+	}
+	free = 0
+	exec.SetMinFreeDisk(0)
+	if err := exec.Execute(&messages.CommandMessage{ID: "unchecked", Command: "echo building", WorkingDir: app}); err != nil {
+		t.Errorf("expected the command to run with the check off, got %v", err)
+	}
+}
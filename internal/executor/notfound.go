@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// ExitCommandNotFound is the exit code shells use when a command isn't found
+const ExitCommandNotFound = 127
+
+// notFoundCaptureBytes is how much of a command's stderr is kept to name the
+// command that wasn't found
+const notFoundCaptureBytes = 4096
+
+// notFoundPatterns match shells' "command not found" errors, capturing the
+// command:
+//
+//	bash: composer: command not found
+//	bash: line 1: composer: command not found
+//	sh: 1: composer: not found
+//	zsh: command not found: composer
+var notFoundPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?m)^[^:\n]+:(?: line \d+:)? ([^:\n]+): command not found$`),
+	regexp.MustCompile(`(?m)^[^:\n]+: \d+: ([^:\n]+): not found$`),
+	regexp.MustCompile(`(?m)command not found: (\S+)$`),
+}
+
+// headBuffer keeps the first bytes written to it and discards the rest
+type headBuffer struct {
+	max  int
+	data []byte
+	mu   sync.Mutex
+}
+
+func (b *headBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (b *headBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
+
+// missingCommand returns the command a shell reported as not found in
+// stderr, and whether it reported one
+func missingCommand(stderr string) (string, bool) {
+	for _, pattern := range notFoundPatterns {
+		if m := pattern.FindStringSubmatch(stderr); m != nil {
+			return m[1], true
+		}
+	}
+	return "", false
+}
+
+// notFoundDiagnostic explains a command that failed because a binary wasn't
+// on PATH, or returns "" if that isn't why it failed. Commands not run via a
+// login shell miss the PATH set up by the user's profile (rbenv, nvm, asdf),
+// which the app's login_shell setting fixes.
+func notFoundDiagnostic(exitCode int, stderr string, loginShell bool) string {
+	name, found := missingCommand(stderr)
+	if !found && exitCode != ExitCommandNotFound {
+		return ""
+	}
+
+	what := "A command"
+	if found {
+		what = fmt.Sprintf("Command %q", name)
+	}
+	if loginShell {
+		return fmt.Sprintf("%s was not found on PATH, even in the app's login shell. "+
+			"Check that the login profile (~/.bash_profile or ~/.profile) of the user the agent runs as "+
+			"adds its directory to PATH, or use the binary's full path.", what)
+	}
+	return fmt.Sprintf("%s was not found on PATH. Commands run with the agent's PATH, not a login shell's, "+
+		"so binaries set up by a shell profile (rbenv, nvm, asdf, ~/.bashrc) are missing: "+
+		"set login_shell: true in the app's antidote.yml, or use the binary's full path.", what)
+}
@@ -0,0 +1,294 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/security"
+)
+
+// MaxLineLength is the longest line buffered before it is emitted as-is
+const MaxLineLength = 1024 * 1024
+
+// redactContext is how much of a line already sent in parts is kept, so the
+// rest of the line is redacted in its context and a secret split across
+// messages is still masked
+const redactContext = 4096
+
+// DefaultBinaryThreshold is how many invalid UTF-8 bytes a stream may contain
+// before it switches to base64. A negative threshold disables base64.
+const DefaultBinaryThreshold = 8
+
+// DefaultMaxOutputBytes is how much output a command may send, across stdout
+// and stderr, before the rest is dropped
+const DefaultMaxOutputBytes = 4 * 1024 * 1024
+
+// outputLimit is a command's output budget, shared by its streams
+type outputLimit struct {
+	max       int64 // <= 0 for no limit
+	used      int64
+	truncated bool
+	mu        sync.Mutex
+}
+
+// take reserves up to n bytes of the budget, returning how many may be sent
+// and whether this call used up the budget
+func (l *outputLimit) take(n int) (allowed int, exceeded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max <= 0 {
+		return n, false
+	}
+	if l.truncated {
+		return 0, false
+	}
+
+	if remaining := l.max - l.used; int64(n) > remaining {
+		l.used = l.max
+		l.truncated = true
+		return int(remaining), true
+	}
+
+	l.used += int64(n)
+	return n, false
+}
+
+// wasTruncated reports whether output was dropped
+func (l *outputLimit) wasTruncated() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.truncated
+}
+
+// outputStream turns raw command output into output messages. Complete lines
+// are emitted as soon as they arrive, with a CRLF line ending sent as a plain
+// newline; a trailing partial line (e.g. a progress
+// indicator without a newline) is held until it is completed, flushed on
+// request, or the stream ends. Sensitive values are masked before sending; when
+// a line is sent in parts, each part is redacted in the context of the part
+// before it.
+//
+// Output is sent as text while it is (mostly) valid UTF-8; stray invalid bytes
+// are replaced with U+FFFD. Once more than binaryThreshold invalid bytes have
+// been seen, the stream switches to base64 for the rest of the command so
+// binary output arrives intact.
+//
+// Once the command's output limit is reached, a truncation notice is sent and
+// the rest of the output is read but dropped.
+//
+// With coalescing on, complete lines are batched before they are sent (see
+// outputCoalescer); Flush and Close send the batch right away.
+type outputStream struct {
+	id        string
+	stream    string // stdout or stderr
+	handler   OutputHandler
+	redactor  *security.Redactor
+	coalescer *outputCoalescer // nil when lines are sent one by one
+
+	binaryThreshold int
+	invalidBytes    int
+	binary          bool
+
+	limit *outputLimit
+
+	partial  []byte
+	lineSent []byte // end of the current line already sent in parts (raw)
+	mu       sync.Mutex
+}
+
+// newOutputStream creates an output stream for a command
+func newOutputStream(id, stream string, handler OutputHandler, redactor *security.Redactor, binaryThreshold int, limit *outputLimit) *outputStream {
+	return &outputStream{
+		id:              id,
+		stream:          stream,
+		handler:         handler,
+		redactor:        redactor,
+		binaryThreshold: binaryThreshold,
+		limit:           limit,
+	}
+}
+
+// coalesce batches the stream's lines for up to window before they are sent
+// (call before the first Write)
+func (s *outputStream) coalesce(window time.Duration) {
+	if s.handler == nil || window <= 0 {
+		return
+	}
+	s.coalescer = newOutputCoalescer(s.handler, window)
+	s.handler = s.coalescer.send
+}
+
+// Write buffers output and emits every complete line
+func (s *outputStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.partial = append(s.partial, p...)
+
+	for {
+		idx := bytes.IndexByte(s.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		s.emit(string(dropCR(s.partial[:idx])) + "\n")
+		s.partial = s.partial[idx+1:]
+	}
+
+	// Don't let a single unterminated line grow without bound
+	if len(s.partial) >= MaxLineLength {
+		s.emitPartial()
+	}
+
+	return len(p), nil
+}
+
+// Flush emits the buffered partial line as-is; the rest of the line will
+// follow in a later message once it arrives
+func (s *outputStream) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.emitPartial()
+	s.flushCoalesced()
+}
+
+// Close emits a final unterminated line, newline-terminated like every other line
+func (s *outputStream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.partial) > 0 {
+		s.emit(string(dropCR(s.partial)) + "\n")
+		s.partial = nil
+	}
+	s.flushCoalesced()
+}
+
+// flushCoalesced sends the lines batched for coalescing (caller must hold lock)
+func (s *outputStream) flushCoalesced() {
+	if s.coalescer != nil {
+		s.coalescer.flush()
+	}
+}
+
+// emitPartial emits the buffered partial line, holding back a trailing
+// incomplete UTF-8 sequence so a character isn't split across messages, and a
+// trailing carriage return in case it starts a CRLF line ending (caller must
+// hold lock)
+func (s *outputStream) emitPartial() {
+	complete, rest := splitIncompleteRune(s.partial)
+	if len(rest) == 0 && len(complete) > 0 && complete[len(complete)-1] == '\r' {
+		complete, rest = complete[:len(complete)-1], complete[len(complete)-1:]
+	}
+	if len(complete) > 0 {
+		s.emit(string(complete))
+	}
+	s.partial = append([]byte(nil), rest...)
+}
+
+// emit sends a chunk of output (caller must hold lock)
+func (s *outputStream) emit(data string) {
+	if s.handler == nil {
+		return
+	}
+
+	data = s.redact(data)
+
+	if s.limit != nil {
+		allowed, exceeded := s.limit.take(len(data))
+		if exceeded {
+			defer s.handler(messages.NewOutputMessage(s.id, s.stream,
+				fmt.Sprintf("[output truncated: command exceeded %d bytes of output]\n", s.limit.max)))
+		}
+		if allowed < len(data) {
+			data = data[:allowed]
+			if !s.binary {
+				complete, _ := splitIncompleteRune([]byte(data))
+				data = string(complete)
+			}
+		}
+		if data == "" {
+			return
+		}
+	}
+
+	if !s.binary {
+		if invalid := countInvalidUTF8(data); invalid > 0 {
+			s.invalidBytes += invalid
+			s.binary = s.binaryThreshold >= 0 && s.invalidBytes > s.binaryThreshold
+		}
+	}
+
+	if s.binary {
+		msg := messages.NewOutputMessage(s.id, s.stream, base64.StdEncoding.EncodeToString([]byte(data)))
+		msg.Encoding = messages.EncodingBase64
+		s.handler(msg)
+		return
+	}
+
+	s.handler(messages.NewOutputMessage(s.id, s.stream, strings.ToValidUTF8(data, "�")))
+}
+
+// redact masks sensitive values in a chunk of output, in the context of the
+// part of its line already sent, and tracks that part (caller must hold lock)
+func (s *outputStream) redact(data string) string {
+	var redacted string
+	if len(s.lineSent) == 0 {
+		redacted = s.redactor.Redact(data)
+	} else {
+		redacted = s.redactor.RedactFrom(string(s.lineSent)+data, len(s.lineSent))
+	}
+
+	if strings.HasSuffix(data, "\n") {
+		s.lineSent = nil
+	} else {
+		s.lineSent = append(s.lineSent, data...)
+		if len(s.lineSent) > redactContext {
+			s.lineSent = append([]byte(nil), s.lineSent[len(s.lineSent)-redactContext:]...)
+		}
+	}
+	return redacted
+}
+
+// dropCR drops a trailing carriage return from a line
+func dropCR(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+// countInvalidUTF8 returns the number of bytes in s that aren't valid UTF-8
+func countInvalidUTF8(s string) int {
+	invalid := 0
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		}
+		i += size
+	}
+	return invalid
+}
+
+// splitIncompleteRune splits off a trailing, not yet complete UTF-8 sequence
+func splitIncompleteRune(b []byte) (complete, rest []byte) {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		start := len(b) - i
+		if !utf8.RuneStart(b[start]) {
+			continue
+		}
+		if !utf8.FullRune(b[start:]) {
+			return b[:start], b[start:]
+		}
+		break
+	}
+	return b, nil
+}
@@ -0,0 +1,342 @@
+package executor
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/security"
+)
+
+// collectStream creates an output stream that records emitted messages
+func collectStream(threshold int) (*outputStream, *[]*messages.OutputMessage) {
+	var msgs []*messages.OutputMessage
+	stream := newOutputStream("cmd", "stdout", func(msg *messages.OutputMessage) {
+		msgs = append(msgs, msg)
+	}, nil, threshold, nil)
+	return stream, &msgs
+}
+
+func TestOutputStream_BinaryThresholdBoundary(t *testing.T) {
+	line := "abc\xff\xfe\xfddef\n" // 3 invalid bytes
+
+	tests := []struct {
+		name         string
+		threshold    int
+		wantEncoding string
+	}{
+		{"below threshold", 4, ""},
+		{"at threshold", 3, ""},
+		{"above threshold", 2, messages.EncodingBase64},
+		{"zero tolerates nothing", 0, messages.EncodingBase64},
+		{"negative disables base64", -1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, msgs := collectStream(tt.threshold)
+			stream.Write([]byte(line))
+
+			if len(*msgs) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(*msgs))
+			}
+			msg := (*msgs)[0]
+
+			if msg.Encoding != tt.wantEncoding {
+				t.Errorf("expected encoding %q, got %q", tt.wantEncoding, msg.Encoding)
+			}
+
+			if msg.Encoding == messages.EncodingBase64 {
+				decoded, err := base64.StdEncoding.DecodeString(msg.Data)
+				if err != nil {
+					t.Fatalf("invalid base64: %v", err)
+				}
+				if string(decoded) != line {
+					t.Errorf("expected original bytes %q, got %q", line, decoded)
+				}
+			} else if msg.Data != "abc�def\n" {
+				t.Errorf("expected invalid bytes replaced in text, got %q", msg.Data)
+			}
+		})
+	}
+}
+
+func TestOutputStream_BinaryThresholdCumulative(t *testing.T) {
+	stream, msgs := collectStream(2)
+
+	stream.Write([]byte("one stray \xff byte\n"))
+	stream.Write([]byte("plain text\n"))
+	stream.Write([]byte("another \xff\n")) // 2 invalid in total: still text
+	stream.Write([]byte("third \xff\n"))   // 3 > 2: switch
+	stream.Write([]byte("text again\n"))   // stays base64 once switched
+
+	expected := []string{"", "", "", messages.EncodingBase64, messages.EncodingBase64}
+	if len(*msgs) != len(expected) {
+		t.Fatalf("expected %d messages, got %d", len(expected), len(*msgs))
+	}
+	for i, msg := range *msgs {
+		if msg.Encoding != expected[i] {
+			t.Errorf("message %d: expected encoding %q, got %q", i, expected[i], msg.Encoding)
+		}
+	}
+}
+
+func TestOutputStream_BinaryDecisionPerStream(t *testing.T) {
+	stdout, stdoutMsgs := collectStream(0)
+	stderr, stderrMsgs := collectStream(0)
+
+	stdout.Write([]byte("\x00\x01\xff\n"))
+	stderr.Write([]byte("warning: plain text\n"))
+
+	if (*stdoutMsgs)[0].Encoding != messages.EncodingBase64 {
+		t.Error("expected binary stdout to switch to base64")
+	}
+	if (*stderrMsgs)[0].Encoding != "" {
+		t.Error("expected text stderr to stay text")
+	}
+}
+
+func TestOutputStream_FlushKeepsMultiByteCharacters(t *testing.T) {
+	stream, msgs := collectStream(0)
+
+	// "é" is 0xc3 0xa9; flush arrives between the two bytes
+	stream.Write([]byte("caf\xc3"))
+	stream.Flush()
+	stream.Write([]byte("\xa9\n"))
+
+	if len(*msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(*msgs))
+	}
+	if (*msgs)[0].Data != "caf" || (*msgs)[1].Data != "é\n" {
+		t.Errorf("expected character kept whole, got %q and %q", (*msgs)[0].Data, (*msgs)[1].Data)
+	}
+	for _, msg := range *msgs {
+		if msg.Encoding != "" {
+			t.Error("split character must not count as invalid UTF-8")
+		}
+	}
+}
+
+func TestOutputStream_LimitSharedAcrossStreams(t *testing.T) {
+	var msgs []*messages.OutputMessage
+	handler := func(msg *messages.OutputMessage) { msgs = append(msgs, msg) }
+	limit := &outputLimit{max: 10}
+	stdout := newOutputStream("cmd", "stdout", handler, nil, 0, limit)
+	stderr := newOutputStream("cmd", "stderr", handler, nil, 0, limit)
+
+	stdout.Write([]byte("123456\n"))
+	stderr.Write([]byte("abcdef\n"))
+	stdout.Write([]byte("dropped\n"))
+
+	if len(msgs) != 3 {
+		t.Fatalf("expected output, cut output and notice, got %d messages", len(msgs))
+	}
+	if msgs[0].Data != "123456\n" || msgs[1].Data != "abc" {
+		t.Errorf("expected output cut at 10 bytes, got %q and %q", msgs[0].Data, msgs[1].Data)
+	}
+	if msgs[2].Stream != "stderr" || !strings.Contains(msgs[2].Data, "output truncated") {
+		t.Errorf("expected truncation notice on stderr, got %+v", msgs[2])
+	}
+	if !limit.wasTruncated() {
+		t.Error("expected limit to report truncation")
+	}
+}
+
+func TestOutputStream_OneMessagePerLine(t *testing.T) {
+	stream, msgs := collectStream(0)
+
+	// Lines arriving in one read are not batched: each carries its own timestamp
+	stream.Write([]byte("one\ntwo\nthr"))
+	stream.Write([]byte("ee\n"))
+
+	if len(*msgs) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(*msgs))
+	}
+	for i, want := range []string{"one\n", "two\n", "three\n"} {
+		if (*msgs)[i].Data != want {
+			t.Errorf("message %d: expected %q, got %q", i, want, (*msgs)[i].Data)
+		}
+		if (*msgs)[i].Timestamp == "" {
+			t.Errorf("message %d: expected a timestamp", i)
+		}
+	}
+}
+
+func TestOutputCoalescer_KeepsLineTimestamps(t *testing.T) {
+	var msgs []*messages.OutputMessage
+	c := newOutputCoalescer(func(msg *messages.OutputMessage) {
+		msgs = append(msgs, msg)
+	}, time.Hour)
+
+	stamps := []string{"2024-01-01T00:00:01Z", "2024-01-01T00:00:02Z", "2024-01-01T00:00:04Z"}
+	for i, line := range []string{"one\n", "two\n", "three\n"} {
+		msg := messages.NewOutputMessage("cmd", "stdout", line)
+		msg.Timestamp = stamps[i]
+		c.send(msg)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expected lines to be held until the window ends, got %d messages", len(msgs))
+	}
+	c.flush()
+
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 coalesced message, got %d", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.Data != "one\ntwo\nthree\n" {
+		t.Errorf("expected lines in order, got %q", msg.Data)
+	}
+	if msg.Timestamp != stamps[0] {
+		t.Errorf("expected the first line's timestamp, got %q", msg.Timestamp)
+	}
+	if strings.Join(msg.LineTimestamps, ",") != strings.Join(stamps, ",") {
+		t.Errorf("expected per-line timestamps %v, got %v", stamps, msg.LineTimestamps)
+	}
+}
+
+func TestOutputCoalescer_SingleLine(t *testing.T) {
+	var msgs []*messages.OutputMessage
+	c := newOutputCoalescer(func(msg *messages.OutputMessage) {
+		msgs = append(msgs, msg)
+	}, time.Hour)
+
+	c.send(messages.NewOutputMessage("cmd", "stdout", "one\n"))
+	c.flush()
+	c.flush()
+
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	if msgs[0].LineTimestamps != nil {
+		t.Errorf("expected no line timestamps for a single line, got %v", msgs[0].LineTimestamps)
+	}
+}
+
+func TestOutputCoalescer_FlushesAfterWindow(t *testing.T) {
+	sent := make(chan *messages.OutputMessage, 1)
+	c := newOutputCoalescer(func(msg *messages.OutputMessage) { sent <- msg }, 10*time.Millisecond)
+
+	c.send(messages.NewOutputMessage("cmd", "stdout", "one\n"))
+	c.send(messages.NewOutputMessage("cmd", "stdout", "two\n"))
+
+	select {
+	case msg := <-sent:
+		if msg.Data != "one\ntwo\n" || len(msg.LineTimestamps) != 2 {
+			t.Errorf("expected both lines with their timestamps, got %q %v", msg.Data, msg.LineTimestamps)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the batch to be sent once the window ended")
+	}
+}
+
+func TestOutputStream_CoalesceKeepsOrder(t *testing.T) {
+	stream, msgs := collectStream(-1)
+	stream.coalesce(time.Hour)
+
+	// A partial line and base64 output send the batch before them
+	stream.Write([]byte("one\ntwo\nthr"))
+	stream.Flush()
+	stream.Write([]byte("ee\nfour\n"))
+	stream.binary = true
+	stream.Write([]byte("bin\n"))
+	stream.Write([]byte("five"))
+	stream.Close()
+
+	want := []string{"one\ntwo\n", "thr", "ee\nfour\n", "bin\n", "five\n"}
+	if len(*msgs) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(*msgs))
+	}
+	for i := range want {
+		data := (*msgs)[i].Data
+		if (*msgs)[i].Encoding == messages.EncodingBase64 {
+			decoded, _ := base64.StdEncoding.DecodeString(data)
+			data = string(decoded)
+		}
+		if data != want[i] {
+			t.Errorf("message %d: expected %q, got %q", i, want[i], data)
+		}
+	}
+	if len((*msgs)[0].LineTimestamps) != 2 {
+		t.Errorf("expected 2 line timestamps on the batch, got %v", (*msgs)[0].LineTimestamps)
+	}
+}
+
+func TestOutputStream_StripsCRLF(t *testing.T) {
+	stream, msgs := collectStream(0)
+
+	// CRLF endings become newlines, also when split by a flush or at the end;
+	// a carriage return inside a line (a progress bar) is kept
+	stream.Write([]byte("one\r\n10%\r20%\r\ntwo\r"))
+	stream.Flush()
+	stream.Write([]byte("\nthree\r"))
+	stream.Close()
+
+	want := []string{"one\n", "10%\r20%\n", "two", "\n", "three\n"}
+	if len(*msgs) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(*msgs))
+	}
+	for i := range want {
+		if (*msgs)[i].Data != want[i] {
+			t.Errorf("message %d: expected %q, got %q", i, want[i], (*msgs)[i].Data)
+		}
+	}
+}
+
+func TestOutputStream_RedactsAcrossParts(t *testing.T) {
+	redactor := security.NewRedactor(security.DefaultRedactPatterns)
+
+	tests := []struct {
+		name   string
+		writes func(s *outputStream)
+	}{
+		{"value split by a flush", func(s *outputStream) {
+			s.Write([]byte("export API_TOKEN=ant_ab"))
+			s.Flush()
+			s.Write([]byte("c123\n"))
+		}},
+		{"key split by a flush", func(s *outputStream) {
+			s.Write([]byte("export API_TO"))
+			s.Flush()
+			s.Write([]byte("KEN=ant_abc123\n"))
+		}},
+		{"value split at the line length limit", func(s *outputStream) {
+			s.Write([]byte(strings.Repeat("x", MaxLineLength-10) + " API_TOKEN=ant_ab"))
+			s.Write([]byte("c123\n"))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var data []string
+			stream := newOutputStream("cmd", "stdout", func(msg *messages.OutputMessage) {
+				data = append(data, msg.Data)
+			}, redactor, DefaultBinaryThreshold, nil)
+			tt.writes(stream)
+			stream.Close()
+
+			if len(data) != 2 {
+				t.Fatalf("expected the line in 2 parts, got %d", len(data))
+			}
+			joined := strings.Join(data, "")
+			for _, secret := range []string{"ant_ab", "c123"} {
+				if strings.Contains(joined, secret) {
+					t.Errorf("expected %q redacted, got %q", secret, joined[len(joined)-40:])
+				}
+			}
+		})
+	}
+
+	// A later line is redacted on its own again
+	var data []string
+	stream := newOutputStream("cmd", "stdout", func(msg *messages.OutputMessage) {
+		data = append(data, msg.Data)
+	}, redactor, DefaultBinaryThreshold, nil)
+	stream.Write([]byte("API_TOKEN=ant_"))
+	stream.Flush()
+	stream.Write([]byte("abc\nabc\n"))
+	if len(data) != 3 || data[1] != "***\n" || data[2] != "abc\n" {
+		t.Errorf("expected only the split value masked, got %q", data)
+	}
+}
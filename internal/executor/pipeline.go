@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"strconv"
+	"strings"
+)
+
+// pipeStatusFD is the file descriptor the pipefail wrapper reports stage exit
+// codes on (the first of cmd.ExtraFiles)
+const pipeStatusFD = 3
+
+// pipefailScript wraps a command so it runs under `set -o pipefail` and the
+// exit code of every stage of its last pipeline is written to pipeStatusFD.
+// The command itself runs with that descriptor closed so background children
+// can't hold it open. If the command exits early the stages aren't reported.
+func pipefailScript(command string) string {
+	fd := strconv.Itoa(pipeStatusFD)
+	return "set -o pipefail\n" +
+		"{ " + command + "\n" +
+		"__antidote_pipestatus=\"${PIPESTATUS[*]}\" __antidote_status=$?\n" +
+		"} " + fd + ">&-\n" +
+		"printf '%s\\n' \"$__antidote_pipestatus\" >&" + fd + "\n" +
+		"exit $__antidote_status\n"
+}
+
+// parsePipeStatus parses the stage exit codes reported by the wrapper
+func parsePipeStatus(data string) []int {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	status := make([]int, 0, len(fields))
+	for _, field := range fields {
+		code, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		status = append(status, code)
+	}
+	return status
+}
+
+// failedStage returns the 1-based index of the first pipeline stage that
+// exited non-zero, or 0 if every stage succeeded
+func failedStage(status []int) int {
+	for i, code := range status {
+		if code != 0 {
+			return i + 1
+		}
+	}
+	return 0
+}
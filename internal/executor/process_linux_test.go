@@ -0,0 +1,134 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func TestExecutor_ReportsPeakMemory(t *testing.T) {
+	const allocated = 32 * 1024 * 1024
+
+	done := make(chan *messages.CompleteMessage, 1)
+	exec := New(nil, func(msg *messages.CompleteMessage) {
+		done <- msg
+	}, nil, nil)
+
+	// Hold 32MB in a shell variable
+	err := exec.Execute(&messages.CommandMessage{
+		ID:      "test-peak-memory",
+		Command: "x=$(head -c 33554432 /dev/zero | tr '\\0' a); echo ${#x}",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case msg := <-done:
+		if msg.ExitCode != 0 {
+			t.Fatalf("expected exit code 0, got %d", msg.ExitCode)
+		}
+		if msg.PeakMemoryBytes < allocated || msg.PeakMemoryBytes > 64*allocated {
+			t.Errorf("expected peak memory of at least %d bytes, got %d", allocated, msg.PeakMemoryBytes)
+		}
+		if history := exec.History(); len(history) != 1 || history[0].PeakMemoryBytes != msg.PeakMemoryBytes {
+			t.Errorf("expected peak memory in history, got %+v", history)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for completion")
+	}
+}
+
+// procState reads a process's state and parent pid from /proc/<pid>/stat,
+// returning ok false once the process is gone (reaped)
+func procState(pid int) (state string, ppid int, ok bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", 0, false
+	}
+
+	// The command name may contain spaces, so fields start after its ')'
+	stat := string(data)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 2 {
+		return "", 0, false
+	}
+	ppid, _ = strconv.Atoi(fields[1])
+	return fields[0], ppid, true
+}
+
+func TestExecutor_NoZombiesAfterBackgroundChild(t *testing.T) {
+	var mu sync.Mutex
+	var output string
+	done := make(chan struct{})
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			output += msg.Data
+		},
+		func(msg *messages.CompleteMessage) {
+			close(done)
+		},
+		nil,
+		nil,
+	)
+
+	// Prints the shell's pid and its background child's
+	err := exec.Execute(&messages.CommandMessage{
+		ID:      "test-background-child",
+		Command: "sleep 0.2 & echo $$ $!",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	mu.Lock()
+	var shellPid, childPid int
+	_, scanErr := fmt.Sscan(output, &shellPid, &childPid)
+	mu.Unlock()
+	if scanErr != nil {
+		t.Fatalf("expected the shell and child pids, got %q: %v", output, scanErr)
+	}
+
+	// The shell is the executor's own child and must be reaped by now
+	if state, _, ok := procState(shellPid); ok && state == "Z" {
+		t.Errorf("shell %d left as a zombie", shellPid)
+	}
+
+	// Once the background child has exited it must not linger as a zombie of
+	// the agent (when orphaned, reaping it is up to its new parent, init or a
+	// subreaper)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		state, ppid, ok := procState(childPid)
+		if !ok {
+			return
+		}
+		if state == "Z" {
+			if ppid == os.Getpid() {
+				t.Errorf("background child %d left as a zombie of the agent", childPid)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("background child %d still running (state %s)", childPid, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
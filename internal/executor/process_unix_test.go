@@ -0,0 +1,154 @@
+//go:build !windows
+
+package executor
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func TestExecutor_TimeoutKillsProcessGroup(t *testing.T) {
+	var completeMsg *messages.CompleteMessage
+	done := make(chan struct{})
+
+	exec := New(
+		nil,
+		func(msg *messages.CompleteMessage) {
+			completeMsg = msg
+			close(done)
+		},
+		nil,
+		nil,
+	)
+
+	start := time.Now()
+
+	// The background sleep holds the output pipes open; it must die with the group
+	err := exec.Execute(&messages.CommandMessage{
+		ID:      "test-group-timeout",
+		Command: "sleep 30 & sleep 30",
+		Timeout: 1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for process group to be killed")
+	}
+
+	if completeMsg.ExitCode == 0 {
+		t.Error("expected non-zero exit code for timed out command")
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected command to stop near its 1s timeout, took %v", elapsed)
+	}
+}
+
+// startInterruptible runs a command and waits for it to print "ready", so
+// that its signal handling is set up before it is interrupted
+func startInterruptible(t *testing.T, exec *Executor, id, command string, ready <-chan struct{}) {
+	t.Helper()
+
+	if err := exec.Execute(&messages.CommandMessage{ID: id, Command: command}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for command to start")
+	}
+}
+
+func TestExecutor_InterruptLetsCommandExitGracefully(t *testing.T) {
+	var mu sync.Mutex
+	var output string
+	ready := make(chan struct{})
+	done := make(chan *messages.CompleteMessage, 1)
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			mu.Lock()
+			defer mu.Unlock()
+			output += msg.Data
+			if strings.Contains(msg.Data, "ready") {
+				close(ready)
+			}
+		},
+		func(msg *messages.CompleteMessage) { done <- msg },
+		nil,
+		nil,
+	)
+
+	startInterruptible(t, exec, "test-interrupt",
+		"trap 'kill $!; echo partial results; exit 130' INT; sleep 10 & echo ready; wait", ready)
+
+	start := time.Now()
+	if !exec.Interrupt("test-interrupt") {
+		t.Fatal("expected Interrupt to find the running command")
+	}
+
+	select {
+	case msg := <-done:
+		if msg.ExitCode != 130 {
+			t.Errorf("expected the trap's exit code 130, got %d", msg.ExitCode)
+		}
+		if msg.Reason != messages.ReasonInterrupted {
+			t.Errorf("expected reason %q, got %q", messages.ReasonInterrupted, msg.Reason)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("expected a graceful exit well before the grace period, took %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !strings.Contains(output, "partial results") {
+		t.Errorf("expected output from the SIGINT handler, got %q", output)
+	}
+}
+
+func TestExecutor_InterruptEscalatesAfterGrace(t *testing.T) {
+	ready := make(chan struct{})
+	done := make(chan *messages.CompleteMessage, 1)
+
+	exec := New(
+		func(msg *messages.OutputMessage) {
+			if strings.Contains(msg.Data, "ready") {
+				close(ready)
+			}
+		},
+		func(msg *messages.CompleteMessage) { done <- msg },
+		nil,
+		nil,
+	)
+	exec.SetInterruptGrace(200 * time.Millisecond)
+
+	// Ignores SIGINT, so only the kill after the grace period stops it
+	startInterruptible(t, exec, "test-interrupt-ignored", "trap '' INT; echo ready; sleep 10", ready)
+
+	if !exec.Interrupt("test-interrupt-ignored") {
+		t.Fatal("expected Interrupt to find the running command")
+	}
+
+	select {
+	case msg := <-done:
+		if msg.ExitCode == 0 {
+			t.Error("expected a non-zero exit code after the kill")
+		}
+		if msg.Reason != messages.ReasonInterrupted {
+			t.Errorf("expected reason %q, got %q", messages.ReasonInterrupted, msg.Reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout: command was not killed after the grace period")
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills only the command process on Windows
+func killProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+// interruptProcessGroup is not supported on Windows; interrupted commands are
+// killed right away
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return errors.New("interrupting commands is not supported on Windows")
+}
+
+// peakMemory is not reported on Windows
+func peakMemory(state *os.ProcessState) int64 {
+	return 0
+}
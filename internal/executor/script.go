@@ -0,0 +1,37 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// scriptDir is where script temp dirs are created (overridden in tests)
+var scriptDir = os.TempDir
+
+// writeScript writes a command's script to a file in a fresh private (0700)
+// temp dir, returning the file and a func that removes the dir
+func writeScript(script string) (string, func(), error) {
+	dir, err := os.MkdirTemp(scriptDir(), "antidote-script-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	path := filepath.Join(dir, "script.sh")
+	if err := os.WriteFile(path, []byte(script), 0600); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return path, cleanup, nil
+}
+
+// commandText returns what a command runs: its command, or its script
+func commandText(cmdMsg *messages.CommandMessage) string {
+	if cmdMsg.Script != "" {
+		return cmdMsg.Script
+	}
+	return cmdMsg.Command
+}
@@ -0,0 +1,136 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // checks run every interval
+	BreakerOpen     = "open"      // checks are skipped until the next probe
+	BreakerHalfOpen = "half_open" // a single probe check is allowed through
+)
+
+// Default circuit breaker settings
+const (
+	DefaultFailureThreshold = 5
+	DefaultProbeInterval    = 5 * time.Minute
+	MaxProbeInterval        = time.Hour
+)
+
+// CircuitBreaker stops running a repeatedly-failing health check action.
+// After threshold consecutive failures the circuit opens and the check is only
+// probed occasionally (backing off exponentially up to MaxProbeInterval); a
+// successful probe closes the circuit again.
+type CircuitBreaker struct {
+	threshold     int
+	probeInterval time.Duration
+
+	state          string
+	failures       int
+	currentBackoff time.Duration
+	nextProbe      time.Time
+	now            func() time.Time
+	mu             sync.Mutex
+}
+
+// NewCircuitBreaker creates a circuit breaker
+func NewCircuitBreaker(threshold int, probeInterval time.Duration) *CircuitBreaker {
+	if threshold <= 0 {
+		threshold = DefaultFailureThreshold
+	}
+	if probeInterval <= 0 {
+		probeInterval = DefaultProbeInterval
+	}
+
+	return &CircuitBreaker{
+		threshold:     threshold,
+		probeInterval: probeInterval,
+		state:         BreakerClosed,
+		now:           time.Now,
+	}
+}
+
+// Allow reports whether the check should run now
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Before(b.nextProbe) {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// Only one probe at a time
+		return false
+	default:
+		return true
+	}
+}
+
+// RecordSuccess records a successful check, closing the circuit
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.currentBackoff = 0
+}
+
+// RecordFailure records a failed check, opening the circuit once the
+// threshold is reached (or immediately if a probe fails)
+func (b *CircuitBreaker) RecordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+
+	switch {
+	case b.state == BreakerHalfOpen:
+		// Failed probe - back off further
+		b.currentBackoff *= 2
+		if b.currentBackoff > MaxProbeInterval {
+			b.currentBackoff = MaxProbeInterval
+		}
+		b.open()
+	case b.state == BreakerClosed && b.failures >= b.threshold:
+		b.currentBackoff = b.probeInterval
+		b.open()
+	}
+}
+
+// open opens the circuit until the next probe (caller must hold lock)
+func (b *CircuitBreaker) open() {
+	b.state = BreakerOpen
+	b.nextProbe = b.now().Add(b.currentBackoff)
+}
+
+// State returns the current breaker state
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Failures returns the number of consecutive failures
+func (b *CircuitBreaker) Failures() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures
+}
+
+// NextProbe returns when the next probe is allowed (zero if the circuit is closed)
+func (b *CircuitBreaker) NextProbe() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerClosed {
+		return time.Time{}
+	}
+	return b.nextProbe
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock is a controllable time source for breaker tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestBreaker(threshold int, probe time.Duration) (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := NewCircuitBreaker(threshold, probe)
+	b.now = clock.now
+	return b, clock
+}
+
+func TestCircuitBreaker_OpensAfterThreshold(t *testing.T) {
+	b, _ := newTestBreaker(3, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if !b.Allow() {
+			t.Fatalf("expected check %d to be allowed", i+1)
+		}
+		b.RecordFailure()
+	}
+
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected closed before threshold, got %s", b.State())
+	}
+
+	b.Allow()
+	b.RecordFailure()
+
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected open after 3 failures, got %s", b.State())
+	}
+	if b.Allow() {
+		t.Error("expected checks to be skipped while open")
+	}
+}
+
+func TestCircuitBreaker_ProbeSuccessCloses(t *testing.T) {
+	b, clock := newTestBreaker(2, time.Minute)
+
+	b.RecordFailure()
+	b.RecordFailure()
+
+	clock.advance(30 * time.Second)
+	if b.Allow() {
+		t.Fatal("expected no probe before the probe interval")
+	}
+
+	clock.advance(31 * time.Second)
+	if !b.Allow() {
+		t.Fatal("expected a probe after the probe interval")
+	}
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half_open during probe, got %s", b.State())
+	}
+	if b.Allow() {
+		t.Error("expected only one concurrent probe")
+	}
+
+	b.RecordSuccess()
+
+	if b.State() != BreakerClosed {
+		t.Errorf("expected closed after successful probe, got %s", b.State())
+	}
+	if b.Failures() != 0 {
+		t.Errorf("expected failures reset, got %d", b.Failures())
+	}
+	if !b.Allow() {
+		t.Error("expected checks to run again once closed")
+	}
+}
+
+func TestCircuitBreaker_ProbeFailureBacksOff(t *testing.T) {
+	b, clock := newTestBreaker(1, time.Minute)
+
+	b.RecordFailure()
+	first := b.NextProbe()
+
+	clock.advance(time.Minute)
+	if !b.Allow() {
+		t.Fatal("expected probe to be allowed")
+	}
+	b.RecordFailure()
+
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected open after failed probe, got %s", b.State())
+	}
+
+	// Backoff doubles after a failed probe
+	if got := b.NextProbe().Sub(clock.now()); got != 2*time.Minute {
+		t.Errorf("expected 2m backoff, got %v", got)
+	}
+	if !b.NextProbe().After(first) {
+		t.Error("expected next probe to move later")
+	}
+}
+
+func TestCircuitBreaker_BackoffCapped(t *testing.T) {
+	b, clock := newTestBreaker(1, 40*time.Minute)
+
+	b.RecordFailure()
+	for i := 0; i < 5; i++ {
+		clock.advance(MaxProbeInterval)
+		b.Allow()
+		b.RecordFailure()
+	}
+
+	if got := b.NextProbe().Sub(clock.now()); got != MaxProbeInterval {
+		t.Errorf("expected backoff capped at %v, got %v", MaxProbeInterval, got)
+	}
+}
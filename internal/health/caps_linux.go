@@ -0,0 +1,126 @@
+//go:build linux
+
+package health
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// capabilityNames maps Linux capability bit numbers to names (see capabilities(7))
+var capabilityNames = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// processCapabilities reads the agent's capability sets from /proc/self/status
+func processCapabilities() (*messages.Capabilities, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	caps := &messages.Capabilities{}
+	found := 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		var target *[]string
+		switch key {
+		case "CapEff":
+			target = &caps.Effective
+		case "CapPrm":
+			target = &caps.Permitted
+		case "CapBnd":
+			target = &caps.Bounding
+		default:
+			continue
+		}
+
+		names, err := parseCapabilityMask(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*target = names
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if found == 0 {
+		return nil, fmt.Errorf("no capability sets in /proc/self/status")
+	}
+	return caps, nil
+}
+
+// parseCapabilityMask converts a hex capability mask to capability names
+func parseCapabilityMask(mask string) ([]string, error) {
+	bits, err := strconv.ParseUint(mask, 16, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for bit := 0; bit < 64; bit++ {
+		if bits&(1<<uint(bit)) == 0 {
+			continue
+		}
+		if bit < len(capabilityNames) {
+			names = append(names, capabilityNames[bit])
+		} else {
+			names = append(names, fmt.Sprintf("cap_%d", bit))
+		}
+	}
+	return names, nil
+}
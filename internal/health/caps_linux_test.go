@@ -0,0 +1,69 @@
+//go:build linux
+
+package health
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseCapabilityMask(t *testing.T) {
+	tests := []struct {
+		mask     string
+		expected []string
+	}{
+		{"0000000000000000", []string{}},
+		{"0000000000003000", []string{"cap_net_admin", "cap_net_raw"}},
+		{"0000000000000401", []string{"cap_chown", "cap_net_bind_service"}},
+		{"0000080000000000", []string{"cap_43"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mask, func(t *testing.T) {
+			names, err := parseCapabilityMask(tt.mask)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+
+	if _, err := parseCapabilityMask("not-hex"); err == nil {
+		t.Error("expected error for invalid mask")
+	}
+}
+
+func TestCurrentUser_Linux(t *testing.T) {
+	info := CurrentUser()
+
+	if info.UID != os.Geteuid() || info.GID != os.Getegid() {
+		t.Errorf("expected uid/gid %d/%d, got %d/%d", os.Geteuid(), os.Getegid(), info.UID, info.GID)
+	}
+	if info.Root != (os.Geteuid() == 0) {
+		t.Errorf("expected root=%t", os.Geteuid() == 0)
+	}
+	if info.Username == "" {
+		t.Error("expected username to be resolved")
+	}
+
+	if info.Capabilities == nil {
+		t.Fatal("expected capabilities on Linux")
+	}
+	if info.Capabilities.Effective == nil || info.Capabilities.Permitted == nil || info.Capabilities.Bounding == nil {
+		t.Errorf("expected all capability sets to be populated, got %+v", info.Capabilities)
+	}
+
+	// Effective capabilities are always a subset of the bounding set for a non-setuid agent
+	bounding := make(map[string]bool)
+	for _, name := range info.Capabilities.Bounding {
+		bounding[name] = true
+	}
+	for _, name := range info.Capabilities.Effective {
+		if !bounding[name] {
+			t.Errorf("effective capability %s not in bounding set", name)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package health
+
+import (
+	"errors"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// processCapabilities is only supported on Linux
+func processCapabilities() (*messages.Capabilities, error) {
+	return nil, errors.New("capabilities not supported on this platform")
+}
@@ -3,6 +3,8 @@ package health
 import (
 	"context"
 	"log"
+	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,6 +15,18 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
+// DegradedLoadPerCore is the 1-minute load per CPU core at which a server is
+// reported as degraded (runnable tasks queueing well beyond available cores)
+const DegradedLoadPerCore = 2.0
+
+// Metric collectors (replaced in tests)
+var (
+	cpuPercent    = func() ([]float64, error) { return cpu.Percent(time.Second, false) }
+	virtualMemory = mem.VirtualMemory
+	diskUsage     = func() (*disk.UsageStat, error) { return disk.Usage("/") }
+	loadAvg       = load.Avg
+)
+
 // SendFunc is a function that sends a message
 type SendFunc func(msg interface{}) error
 
@@ -21,13 +35,31 @@ type Monitor struct {
 	send   SendFunc
 	doneCh chan struct{}
 	wg     sync.WaitGroup
+
+	last   *messages.HealthMessage // most recently collected metrics
+	lastMu sync.RWMutex
+
+	unavailable string // metrics that failed last time, to log only changes
+
+	// Per metric, a breaker that stops collecting it while it keeps failing
+	breakers     map[string]*CircuitBreaker
+	openCircuits string // metrics with an open circuit last time, to log only changes
 }
 
+// Metric names, as used for circuit breakers and in log messages
+var metricNames = []string{"cpu", "memory", "disk", "load"}
+
 // NewMonitor creates a new health monitor
 func NewMonitor(send SendFunc) *Monitor {
+	breakers := make(map[string]*CircuitBreaker, len(metricNames))
+	for _, name := range metricNames {
+		breakers[name] = NewCircuitBreaker(DefaultFailureThreshold, DefaultProbeInterval)
+	}
+
 	return &Monitor{
-		send:   send,
-		doneCh: make(chan struct{}),
+		send:     send,
+		doneCh:   make(chan struct{}),
+		breakers: breakers,
 	}
 }
 
@@ -66,36 +98,169 @@ func (m *Monitor) Stop() {
 	m.wg.Wait()
 }
 
+// LoadPerCore normalizes a load average by the number of CPU cores
+func LoadPerCore(load float64, cores int) float64 {
+	if cores <= 0 {
+		cores = 1
+	}
+	return load / float64(cores)
+}
+
+// Status decides the health status from the normalized load
+func Status(loadPerCore float64) string {
+	if loadPerCore >= DegradedLoadPerCore {
+		return messages.HealthStatusDegraded
+	}
+	return messages.HealthStatusOK
+}
+
+// Last returns the most recently collected metrics (nil before the first report)
+func (m *Monitor) Last() *messages.HealthMessage {
+	m.lastMu.RLock()
+	defer m.lastMu.RUnlock()
+	return m.last
+}
+
 // reportHealth collects and sends system metrics
 func (m *Monitor) reportHealth() {
-	var cpuPercent float64
+	msg := m.collectMetrics()
+	m.logUnavailable(msg)
+	m.logOpenCircuits(msg)
+
+	m.lastMu.Lock()
+	m.last = msg
+	m.lastMu.Unlock()
+
+	if err := m.send(msg); err != nil {
+		log.Printf("Failed to send health message: %v", err)
+	}
+}
+
+// collectMetrics gathers system metrics, flagging the ones that couldn't be
+// collected. A metric whose circuit is open is skipped (and flagged) until its
+// next probe.
+func (m *Monitor) collectMetrics() *messages.HealthMessage {
+	var cpuPct float64
 	var memUsed, memTotal, diskUsed, diskTotal uint64
-	var loadAvg float64
+	var load1 float64
 
 	// CPU percent (1 second sample)
-	if cpuPct, err := cpu.Percent(time.Second, false); err == nil && len(cpuPct) > 0 {
-		cpuPercent = cpuPct[0]
-	}
+	cpuOK := m.collect("cpu", func() bool {
+		pct, err := cpuPercent()
+		if err != nil || len(pct) == 0 {
+			return false
+		}
+		cpuPct = pct[0]
+		return true
+	})
 
 	// Memory
-	if memInfo, err := mem.VirtualMemory(); err == nil {
-		memUsed = memInfo.Used
-		memTotal = memInfo.Total
-	}
+	memOK := m.collect("memory", func() bool {
+		memInfo, err := virtualMemory()
+		if err != nil {
+			return false
+		}
+		memUsed, memTotal = memInfo.Used, memInfo.Total
+		return true
+	})
 
 	// Disk (root partition)
-	if diskInfo, err := disk.Usage("/"); err == nil {
-		diskUsed = diskInfo.Used
-		diskTotal = diskInfo.Total
-	}
+	diskOK := m.collect("disk", func() bool {
+		diskInfo, err := diskUsage()
+		if err != nil {
+			return false
+		}
+		diskUsed, diskTotal = diskInfo.Used, diskInfo.Total
+		return true
+	})
 
 	// Load average
-	if loadInfo, err := load.Avg(); err == nil {
-		loadAvg = loadInfo.Load1
+	loadOK := m.collect("load", func() bool {
+		loadInfo, err := loadAvg()
+		if err != nil {
+			return false
+		}
+		load1 = loadInfo.Load1
+		return true
+	})
+
+	msg := messages.NewHealthMessage(cpuPct, memUsed, memTotal, diskUsed, diskTotal, load1)
+	msg.LoadPerCore = LoadPerCore(load1, runtime.NumCPU())
+	msg.Status = Status(msg.LoadPerCore)
+	msg.CPUAvailable = cpuOK
+	msg.MemoryAvailable = memOK
+	msg.DiskAvailable = diskOK
+	msg.LoadAvailable = loadOK
+	for _, name := range metricNames {
+		if m.breakers[name].State() != BreakerClosed {
+			msg.OpenCircuits = append(msg.OpenCircuits, name)
+		}
+	}
+	return msg
+}
+
+// collect runs a metric collector through the metric's circuit breaker,
+// reporting whether the metric was collected
+func (m *Monitor) collect(name string, collector func() bool) bool {
+	breaker := m.breakers[name]
+	if !breaker.Allow() {
+		return false
 	}
 
-	msg := messages.NewHealthMessage(cpuPercent, memUsed, memTotal, diskUsed, diskTotal, loadAvg)
-	if err := m.send(msg); err != nil {
-		log.Printf("Failed to send health message: %v", err)
+	if !collector() {
+		breaker.RecordFailure()
+		return false
+	}
+	breaker.RecordSuccess()
+	return true
+}
+
+// unavailableMetrics lists the metrics a health message couldn't collect
+func unavailableMetrics(msg *messages.HealthMessage) []string {
+	var names []string
+	if !msg.CPUAvailable {
+		names = append(names, "cpu")
+	}
+	if !msg.MemoryAvailable {
+		names = append(names, "memory")
+	}
+	if !msg.DiskAvailable {
+		names = append(names, "disk")
+	}
+	if !msg.LoadAvailable {
+		names = append(names, "load")
+	}
+	return names
+}
+
+// logUnavailable logs which metrics can't be collected when that changes,
+// rather than on every report
+func (m *Monitor) logUnavailable(msg *messages.HealthMessage) {
+	unavailable := strings.Join(unavailableMetrics(msg), ", ")
+	if unavailable == m.unavailable {
+		return
+	}
+
+	if unavailable == "" {
+		log.Printf("Health metrics available again")
+	} else {
+		log.Printf("Health metrics unavailable on this platform: %s (reported as zero)", unavailable)
+	}
+	m.unavailable = unavailable
+}
+
+// logOpenCircuits logs which metrics have stopped being collected when that
+// changes
+func (m *Monitor) logOpenCircuits(msg *messages.HealthMessage) {
+	open := strings.Join(msg.OpenCircuits, ", ")
+	if open == m.openCircuits {
+		return
+	}
+
+	if open == "" {
+		log.Printf("Health metric circuits closed, collecting all metrics again")
+	} else {
+		log.Printf("Health metric circuits open after repeated failures: %s (probing occasionally)", open)
 	}
+	m.openCircuits = open
 }
@@ -0,0 +1,162 @@
+package health
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+func TestLoadPerCore(t *testing.T) {
+	tests := []struct {
+		name     string
+		load     float64
+		cores    int
+		expected float64
+	}{
+		{"load 4 on 8 cores", 4, 8, 0.5},
+		{"load 4 on 2 cores", 4, 2, 2},
+		{"idle", 0, 4, 0},
+		{"unknown core count", 3, 0, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LoadPerCore(tt.load, tt.cores); math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("LoadPerCore(%v, %d) = %v, expected %v", tt.load, tt.cores, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStatus_UsesNormalizedLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		load     float64
+		cores    int
+		expected string
+	}{
+		// Same raw load, different verdicts depending on core count
+		{"load 4 on 8 cores is fine", 4, 8, messages.HealthStatusOK},
+		{"load 4 on 2 cores is degraded", 4, 2, messages.HealthStatusDegraded},
+		{"just below threshold", 7.9, 4, messages.HealthStatusOK},
+		{"high raw load on big server", 40, 64, messages.HealthStatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Status(LoadPerCore(tt.load, tt.cores)); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// failCollectors makes the CPU and disk collectors fail and the others succeed
+func failCollectors(t *testing.T) {
+	t.Helper()
+
+	origCPU, origMem, origDisk, origLoad := cpuPercent, virtualMemory, diskUsage, loadAvg
+	t.Cleanup(func() {
+		cpuPercent, virtualMemory, diskUsage, loadAvg = origCPU, origMem, origDisk, origLoad
+	})
+
+	cpuPercent = func() ([]float64, error) { return nil, errors.New("not implemented yet") }
+	virtualMemory = func() (*mem.VirtualMemoryStat, error) {
+		return &mem.VirtualMemoryStat{Total: 8 << 30, Used: 2 << 30}, nil
+	}
+	diskUsage = func() (*disk.UsageStat, error) { return nil, errors.New("statfs /: permission denied") }
+	loadAvg = func() (*load.AvgStat, error) { return &load.AvgStat{Load1: 0}, nil }
+}
+
+func TestCollectMetrics_FlagsUnavailable(t *testing.T) {
+	failCollectors(t)
+
+	var sent []*messages.HealthMessage
+	m := NewMonitor(func(msg interface{}) error {
+		sent = append(sent, msg.(*messages.HealthMessage))
+		return nil
+	})
+	m.reportHealth()
+
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 health message, got %d", len(sent))
+	}
+	msg := sent[0]
+
+	if msg.CPUAvailable || msg.DiskAvailable {
+		t.Errorf("expected cpu and disk to be flagged unavailable, got %+v", msg)
+	}
+	if !msg.MemoryAvailable || msg.MemoryTotal != 8<<30 {
+		t.Errorf("expected memory to be available, got %+v", msg)
+	}
+
+	// A real zero load is still available
+	if !msg.LoadAvailable || msg.LoadAvg != 0 {
+		t.Errorf("expected load 0 to be available, got %+v", msg)
+	}
+
+	if m.unavailable != "cpu, disk" {
+		t.Errorf("expected unavailable metrics to be tracked, got %q", m.unavailable)
+	}
+}
+
+func TestMonitor_OpensCircuitForFailingMetric(t *testing.T) {
+	failCollectors(t)
+
+	cpuCalls := 0
+	cpuFails := true
+	cpuPercent = func() ([]float64, error) {
+		cpuCalls++
+		if cpuFails {
+			return nil, errors.New("not implemented yet")
+		}
+		return []float64{12.5}, nil
+	}
+
+	var sent []*messages.HealthMessage
+	m := NewMonitor(func(msg interface{}) error {
+		sent = append(sent, msg.(*messages.HealthMessage))
+		return nil
+	})
+	clock := &fakeClock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	for _, b := range m.breakers {
+		b.now = clock.now
+	}
+
+	for i := 0; i < DefaultFailureThreshold; i++ {
+		m.reportHealth()
+	}
+	if got := sent[len(sent)-1].OpenCircuits; len(got) != 2 || got[0] != "cpu" || got[1] != "disk" {
+		t.Fatalf("expected the cpu and disk circuits to open, got %v", got)
+	}
+
+	// Open: the collector is skipped and the metric flagged unavailable
+	m.reportHealth()
+	if cpuCalls != DefaultFailureThreshold {
+		t.Errorf("expected the cpu collector to be skipped, called %d times", cpuCalls)
+	}
+	if msg := sent[len(sent)-1]; msg.CPUAvailable || !msg.MemoryAvailable {
+		t.Errorf("expected only the failing metrics to be unavailable, got %+v", msg)
+	}
+	if m.openCircuits != "cpu, disk" {
+		t.Errorf("expected open circuits to be tracked, got %q", m.openCircuits)
+	}
+
+	// A successful probe closes the circuit
+	cpuFails = false
+	clock.advance(DefaultProbeInterval)
+	m.reportHealth()
+	msg := sent[len(sent)-1]
+	if !msg.CPUAvailable || msg.CPUPercent != 12.5 {
+		t.Errorf("expected the probe to collect cpu, got %+v", msg)
+	}
+	if len(msg.OpenCircuits) != 1 || msg.OpenCircuits[0] != "disk" {
+		t.Errorf("expected only the disk circuit to stay open, got %v", msg.OpenCircuits)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build linux
+
+package health
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefunctCounts scans /proc for zombie (defunct) processes. It returns the
+// total on the system and how many are direct children of the agent, which
+// would indicate the agent is failing to reap finished commands.
+func DefunctCounts() (total int, ownChildren int, err error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	selfPid := os.Getpid()
+
+	for _, entry := range entries {
+		if _, convErr := strconv.Atoi(entry.Name()); convErr != nil {
+			continue
+		}
+
+		data, readErr := os.ReadFile(filepath.Join("/proc", entry.Name(), "stat"))
+		if readErr != nil {
+			// Process exited while scanning
+			continue
+		}
+
+		state, ppid, ok := parseProcStat(string(data))
+		if !ok || state != "Z" {
+			continue
+		}
+
+		total++
+		if ppid == selfPid {
+			ownChildren++
+		}
+	}
+
+	return total, ownChildren, nil
+}
+
+// parseProcStat extracts the state and parent PID from /proc/<pid>/stat.
+// The command name (field 2) may contain spaces and parens, so fields are
+// located after the last ')'.
+func parseProcStat(stat string) (state string, ppid int, ok bool) {
+	end := strings.LastIndex(stat, ")")
+	if end < 0 {
+		return "", 0, false
+	}
+
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 2 {
+		return "", 0, false
+	}
+
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return "", 0, false
+	}
+
+	return fields[0], ppid, true
+}
@@ -0,0 +1,45 @@
+//go:build linux
+
+package health
+
+import (
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		name      string
+		stat      string
+		wantState string
+		wantPPid  int
+		wantOK    bool
+	}{
+		{"running", "1234 (sleep) S 1 1234 1234 0 -1", "S", 1, true},
+		{"zombie", "4321 (sh) Z 999 4321 4321 0 -1", "Z", 999, true},
+		{"name with spaces and parens", "77 (my (odd) cmd) Z 42 77 77 0 -1", "Z", 42, true},
+		{"truncated", "77 (sh", "", 0, false},
+		{"missing fields", "77 (sh) Z", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, ppid, ok := parseProcStat(tt.stat)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, expected %v", ok, tt.wantOK)
+			}
+			if state != tt.wantState || ppid != tt.wantPPid {
+				t.Errorf("got (%q, %d), expected (%q, %d)", state, ppid, tt.wantState, tt.wantPPid)
+			}
+		})
+	}
+}
+
+func TestDefunctCounts(t *testing.T) {
+	total, own, err := DefunctCounts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if own > total {
+		t.Errorf("own children (%d) cannot exceed total (%d)", own, total)
+	}
+}
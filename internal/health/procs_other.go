@@ -0,0 +1,10 @@
+//go:build !linux
+
+package health
+
+import "errors"
+
+// DefunctCounts is only supported on Linux
+func DefunctCounts() (total int, ownChildren int, err error) {
+	return 0, 0, errors.New("defunct process counting not supported on this platform")
+}
@@ -0,0 +1,33 @@
+package health
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// CurrentUser reports the effective user the agent runs as, including its
+// Linux capabilities, so over-privileged agents can be flagged
+func CurrentUser() *messages.AgentUser {
+	info := &messages.AgentUser{
+		UID: os.Geteuid(),
+		GID: os.Getegid(),
+	}
+	info.Root = info.UID == 0
+
+	if info.UID >= 0 {
+		if u, err := user.LookupId(strconv.Itoa(info.UID)); err == nil {
+			info.Username = u.Username
+		}
+	} else if u, err := user.Current(); err == nil {
+		info.Username = u.Username
+	}
+
+	if caps, err := processCapabilities(); err == nil {
+		info.Capabilities = caps
+	}
+
+	return info
+}
@@ -21,8 +21,50 @@ type Config struct {
 	// ErrorPatterns are strings to match for error detection
 	ErrorPatterns []string
 
+	// ScopedPatterns maps a log source (file name or glob) to patterns that
+	// replace ErrorPatterns for lines from that source
+	ScopedPatterns map[string][]string
+
 	// ContextLines is the number of lines to capture before/after an error
 	ContextLines int
+
+	// ContextBefore and ContextAfter are the lines captured before and after
+	// an error; they default to ContextLines
+	ContextBefore int
+	ContextAfter  int
+
+	// GrowthFactor enables log growth anomaly detection: a file growing faster
+	// than this multiple of its baseline rate is reported (0 = disabled)
+	GrowthFactor float64
+
+	// GrowthMinRate is the growth rate in bytes/sec below which growth is
+	// never reported (0 = DefaultGrowthMinRate)
+	GrowthMinRate int64
+
+	// MultilineContinuation is a regex for lines grouped into the error line
+	// before them, so a stack trace is one match ("" = each line separately,
+	// "default" = DefaultMultilineContinuation)
+	MultilineContinuation string
+
+	// MinLevel drops matches below this level ("" = keep all)
+	MinLevel string
+
+	// PatternLevels gives the matches of error patterns a level, keyed by
+	// pattern as given in ErrorPatterns or ScopedPatterns
+	PatternLevels map[string]string
+
+	// BackfillBytes is how much of the end of each log file is read when
+	// monitoring starts, so recent errors are matched too (0 = only new
+	// lines, capped at MaxBackfillBytes)
+	BackfillBytes int64
+
+	// LogStreams maps a log path (as given in LogPaths) to the output stream
+	// it holds, StreamStdout or StreamStderr
+	LogStreams map[string]string
+
+	// MatchStreams limits matching to lines from these streams; lines from
+	// other streams are only context (nil = all lines can match)
+	MatchStreams []string
 }
 
 // NewConfigFromMessage creates a Config from a MonitoringAppConfig
@@ -30,16 +72,68 @@ type Config struct {
 func NewConfigFromMessage(msg messages.MonitoringAppConfig) *Config {
 	contextLines := msg.ContextLines
 	if contextLines <= 0 {
-		contextLines = 20
+		contextLines = DefaultContextLines
+	}
+
+	contextBefore, contextAfter := msg.ContextBefore, msg.ContextAfter
+	if contextBefore <= 0 {
+		contextBefore = contextLines
+	}
+	if contextAfter <= 0 {
+		contextAfter = contextLines
 	}
 
 	return &Config{
-		RepoFullName:  msg.RepoFullName,
-		Framework:     msg.Framework,
-		LogPaths:      msg.LogPaths,
-		ErrorPatterns: msg.ErrorPatterns,
-		ContextLines:  contextLines,
+		RepoFullName:   msg.RepoFullName,
+		Framework:      msg.Framework,
+		LogPaths:       msg.LogPaths,
+		ErrorPatterns:  msg.ErrorPatterns,
+		ScopedPatterns: scopedPatternMap(msg.ScopedPatterns),
+		ContextLines:   contextLines,
+		ContextBefore:  contextBefore,
+		ContextAfter:   contextAfter,
+		GrowthFactor:   msg.GrowthFactor,
+		GrowthMinRate:  msg.GrowthMinRate,
+
+		MultilineContinuation: msg.MultilineContinuation,
+		MinLevel:              msg.MinLevel,
+		PatternLevels:         msg.PatternLevels,
+		BackfillBytes:         msg.BackfillBytes,
+		LogStreams:            msg.LogStreams,
+		MatchStreams:          msg.MatchStreams,
+	}
+}
+
+// scopedPatternMap groups scoped patterns by source, skipping unscoped ones
+func scopedPatternMap(scopedPatterns []messages.ScopedErrorPatterns) map[string][]string {
+	if len(scopedPatterns) == 0 {
+		return nil
 	}
+
+	scoped := make(map[string][]string, len(scopedPatterns))
+	for _, sp := range scopedPatterns {
+		if sp.Source == "" {
+			continue
+		}
+		scoped[sp.Source] = append(scoped[sp.Source], sp.Patterns...)
+	}
+	return scoped
+}
+
+// contextBefore returns the lines to capture before an error
+func (c *Config) contextBefore() int {
+	if c.ContextBefore > 0 {
+		return c.ContextBefore
+	}
+	return c.ContextLines
+}
+
+// contextAfter returns the lines to capture after an error
+func (c *Config) contextAfter() int {
+	if c.ContextAfter > 0 {
+		return c.ContextAfter
+	}
+	return c.ContextLines
 }
 
 // ConfigStore stores monitoring configurations and maps them to discovered apps
@@ -96,3 +190,14 @@ func (s *ConfigStore) GetConfigured() []*Config {
 	}
 	return result
 }
+
+// GetUnmatched returns configs with no discovered app on this server
+func (s *ConfigStore) GetUnmatched() []*Config {
+	var result []*Config
+	for _, cfg := range s.configs {
+		if cfg.AppPath == "" {
+			result = append(result, cfg)
+		}
+	}
+	return result
+}
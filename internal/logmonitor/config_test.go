@@ -179,3 +179,40 @@ func TestConfigStoreUpdateClearsOld(t *testing.T) {
 		t.Errorf("expected 1 config after update, got %d", len(store.GetAll()))
 	}
 }
+
+func TestNewConfigFromMessageScopedPatterns(t *testing.T) {
+	msg := messages.MonitoringAppConfig{
+		RepoFullName:  "owner/repo",
+		ErrorPatterns: []string{"ERROR"},
+		ScopedPatterns: []messages.ScopedErrorPatterns{
+			{Source: "laravel.log", Patterns: []string{"production.ERROR"}},
+			{Source: "", Patterns: []string{"ignored"}},
+		},
+	}
+
+	config := NewConfigFromMessage(msg)
+
+	if len(config.ScopedPatterns) != 1 {
+		t.Fatalf("expected 1 scoped source, got %d", len(config.ScopedPatterns))
+	}
+	if got := config.ScopedPatterns["laravel.log"]; len(got) != 1 || got[0] != "production.ERROR" {
+		t.Errorf("unexpected scoped patterns: %v", got)
+	}
+}
+
+func TestNewConfigFromMessageAsymmetricContext(t *testing.T) {
+	msg := messages.MonitoringAppConfig{
+		RepoFullName:  "owner/repo",
+		ContextLines:  5,
+		ContextAfter:  30,
+	}
+
+	config := NewConfigFromMessage(msg)
+
+	if config.ContextBefore != 5 {
+		t.Errorf("expected context before to default to 5, got %d", config.ContextBefore)
+	}
+	if config.ContextAfter != 30 {
+		t.Errorf("expected context after 30, got %d", config.ContextAfter)
+	}
+}
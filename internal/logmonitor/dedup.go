@@ -3,7 +3,9 @@ package logmonitor
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -14,8 +16,26 @@ const (
 	DefaultRateWindow   = 5 * time.Minute  // Time window for rate limiting
 	DefaultMaxPerWindow = 5                 // Max events per signature per window
 	DefaultCleanupInterval = 10 * time.Minute
+	MaxSampleLineLength = 500 // Longest sample line kept per signature
 )
 
+// Signature scopes: what, besides the normalized error text, identifies an
+// error signature
+const (
+	ScopeMessage = "message" // same text from any app or log file is one signature
+	ScopeApp     = "app"     // same text is tracked separately per app
+	ScopeSource  = "source"  // same text is tracked separately per log file
+)
+
+// ValidateSignatureScope checks that scope is a known signature scope
+func ValidateSignatureScope(scope string) error {
+	switch scope {
+	case ScopeMessage, ScopeApp, ScopeSource:
+		return nil
+	}
+	return fmt.Errorf("unknown signature scope %q (expected %s, %s or %s)", scope, ScopeMessage, ScopeApp, ScopeSource)
+}
+
 // DedupEntry tracks a single error signature
 type DedupEntry struct {
 	SignatureHash   string
@@ -24,6 +44,11 @@ type DedupEntry struct {
 	OccurrenceCount int
 	WindowStart     time.Time
 	WindowCount     int
+	SampleLine      string // First line seen with this signature
+	Level           string // level of the matches with this signature
+	AppPath         string // app and log source the signature was first seen in
+	Source          string
+	Suppressed      int // occurrences rate-limited since the last DrainSuppressed
 }
 
 // Deduplicator prevents duplicate error events from flooding the system
@@ -31,6 +56,7 @@ type Deduplicator struct {
 	entries     map[string]*DedupEntry
 	rateWindow  time.Duration
 	maxPerWindow int
+	scope        string
 
 	mu       sync.Mutex
 	stopCh   chan struct{}
@@ -43,6 +69,7 @@ func NewDeduplicator() *Deduplicator {
 		entries:      make(map[string]*DedupEntry),
 		rateWindow:   DefaultRateWindow,
 		maxPerWindow: DefaultMaxPerWindow,
+		scope:        ScopeMessage,
 		stopCh:       make(chan struct{}),
 	}
 }
@@ -62,12 +89,37 @@ func (d *Deduplicator) Stop() {
 // ShouldEmit checks if an error should be emitted (returns true) or suppressed
 // It also updates internal state and returns the dedup info
 func (d *Deduplicator) ShouldEmit(errorLine string) (emit bool, entry *DedupEntry) {
-	hash := d.computeSignature(errorLine)
+	return d.ShouldEmitSignature(errorLine, errorLine)
+}
+
+// ShouldEmitSignature is ShouldEmit with the signature computed from
+// signatureLine (e.g. a parsed message) and errorLine kept as the sample
+func (d *Deduplicator) ShouldEmitSignature(signatureLine, errorLine string) (emit bool, entry *DedupEntry) {
+	return d.ShouldEmitFrom("", "", signatureLine, errorLine)
+}
+
+// ShouldEmitFrom is ShouldEmitSignature for an error from the given app and
+// log source, which become part of the signature per the signature scope
+func (d *Deduplicator) ShouldEmitFrom(appPath, source, signatureLine, errorLine string) (emit bool, entry *DedupEntry) {
+	return d.ShouldEmitLevel(appPath, source, "", signatureLine, errorLine)
+}
+
+// ShouldEmitLevel is ShouldEmitFrom for a match of the given level. The same
+// text at different levels is tracked as separate signatures, so warnings
+// never rate-limit errors.
+func (d *Deduplicator) ShouldEmitLevel(appPath, source, level, signatureLine, errorLine string) (emit bool, entry *DedupEntry) {
 	now := time.Now()
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	key := d.scopeKey(appPath, source)
+	if level != "" && level != DefaultLevel {
+		// Error-level matches keep the signatures they had before levels
+		key += "\x00" + level
+	}
+	hash := d.computeSignature(key, signatureLine)
+
 	existing, found := d.entries[hash]
 	if !found {
 		// New error signature
@@ -78,6 +130,10 @@ func (d *Deduplicator) ShouldEmit(errorLine string) (emit bool, entry *DedupEntr
 			OccurrenceCount: 1,
 			WindowStart:     now,
 			WindowCount:     1,
+			SampleLine:      truncate(errorLine, MaxSampleLineLength),
+			Level:           level,
+			AppPath:         appPath,
+			Source:          source,
 		}
 		d.entries[hash] = entry
 		return true, entry
@@ -102,12 +158,13 @@ func (d *Deduplicator) ShouldEmit(errorLine string) (emit bool, entry *DedupEntr
 	}
 
 	// Rate limited
+	existing.Suppressed++
 	return false, existing
 }
 
 // GetEntry returns the dedup entry for an error (without modifying state)
 func (d *Deduplicator) GetEntry(errorLine string) *DedupEntry {
-	hash := d.computeSignature(errorLine)
+	hash := d.computeSignature("", errorLine)
 
 	d.mu.Lock()
 	defer d.mu.Unlock()
@@ -115,10 +172,81 @@ func (d *Deduplicator) GetEntry(errorLine string) *DedupEntry {
 	return d.entries[hash]
 }
 
+// Entries returns copies of the current entries, most frequent first
+func (d *Deduplicator) Entries() []DedupEntry {
+	d.mu.Lock()
+	entries := make([]DedupEntry, 0, len(d.entries))
+	for _, entry := range d.entries {
+		entries = append(entries, *entry)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].OccurrenceCount != entries[j].OccurrenceCount {
+			return entries[i].OccurrenceCount > entries[j].OccurrenceCount
+		}
+		return entries[i].LastSeen.After(entries[j].LastSeen)
+	})
+	return entries
+}
+
+// DrainSuppressed returns copies of the entries with occurrences suppressed
+// by rate limiting since the last call, most suppressed first, and resets
+// their suppressed counts
+func (d *Deduplicator) DrainSuppressed() []DedupEntry {
+	d.mu.Lock()
+	var entries []DedupEntry
+	for _, entry := range d.entries {
+		if entry.Suppressed > 0 {
+			entries = append(entries, *entry)
+			entry.Suppressed = 0
+		}
+	}
+	d.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Suppressed != entries[j].Suppressed {
+			return entries[i].Suppressed > entries[j].Suppressed
+		}
+		return entries[i].SignatureHash < entries[j].SignatureHash
+	})
+	return entries
+}
+
+// restoreSuppressed adds drained suppressed counts back, for a summary that
+// could not be sent
+func (d *Deduplicator) restoreSuppressed(drained []DedupEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, drainedEntry := range drained {
+		if entry, ok := d.entries[drainedEntry.SignatureHash]; ok {
+			entry.Suppressed += drainedEntry.Suppressed
+		}
+	}
+}
+
+// scopeKey returns the part of the signature input identifying where an
+// error came from, per the signature scope. Caller must hold d.mu.
+func (d *Deduplicator) scopeKey(appPath, source string) string {
+	switch d.scope {
+	case ScopeApp:
+		return appPath
+	case ScopeSource:
+		return appPath + "\x00" + source
+	default:
+		return ""
+	}
+}
+
 // computeSignature generates a hash for error deduplication
-// Normalizes timestamps, IDs, and other variable parts
-func (d *Deduplicator) computeSignature(errorLine string) string {
+// Normalizes timestamps, IDs, and other variable parts; a non-empty scope
+// key keeps the same text from different sources apart
+func (d *Deduplicator) computeSignature(scopeKey, errorLine string) string {
 	normalized := d.normalizeError(errorLine)
+	if scopeKey != "" {
+		normalized = scopeKey + "\x00" + normalized
+	}
 	hash := sha256.Sum256([]byte(normalized))
 	return hex.EncodeToString(hash[:8]) // Use first 8 bytes (16 hex chars)
 }
@@ -214,6 +342,20 @@ func (d *Deduplicator) SetMaxPerWindow(max int) {
 	d.maxPerWindow = max
 }
 
+// SetSignatureScope sets what identifies an error signature besides its text
+// (ScopeMessage, ScopeApp or ScopeSource). Existing entries are kept, so
+// it should be set before errors are seen.
+func (d *Deduplicator) SetSignatureScope(scope string) error {
+	if err := ValidateSignatureScope(scope); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scope = scope
+	return nil
+}
+
 // Stats returns deduplication statistics
 func (d *Deduplicator) Stats() (uniqueErrors int, totalOccurrences int) {
 	d.mu.Lock()
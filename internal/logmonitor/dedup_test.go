@@ -161,3 +161,126 @@ func TestDeduplicatorWindowReset(t *testing.T) {
 		t.Errorf("expected occurrence count 4, got %d", entry.OccurrenceCount)
 	}
 }
+
+func TestDeduplicatorSignatureScope(t *testing.T) {
+	errorLine := "ERROR: database connection failed"
+
+	tests := []struct {
+		scope    string
+		sameApp  bool // same text from two log files of one app
+		otherApp bool // same text from the same log file name in another app
+	}{
+		{ScopeMessage, true, true},
+		{ScopeApp, true, false},
+		{ScopeSource, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			dedup := NewDeduplicator()
+			if err := dedup.SetSignatureScope(tt.scope); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, first := dedup.ShouldEmitFrom("/var/www/app", "laravel.log", errorLine, errorLine)
+			_, sameApp := dedup.ShouldEmitFrom("/var/www/app", "worker.log", errorLine, errorLine)
+			_, otherApp := dedup.ShouldEmitFrom("/var/www/other", "laravel.log", errorLine, errorLine)
+
+			if got := first.SignatureHash == sameApp.SignatureHash; got != tt.sameApp {
+				t.Errorf("same app, other source: shared signature = %v, expected %v", got, tt.sameApp)
+			}
+			if got := first.SignatureHash == otherApp.SignatureHash; got != tt.otherApp {
+				t.Errorf("other app: shared signature = %v, expected %v", got, tt.otherApp)
+			}
+		})
+	}
+}
+
+func TestDeduplicatorSourceScopeTracksSourcesSeparately(t *testing.T) {
+	dedup := NewDeduplicator()
+	dedup.SetSignatureScope(ScopeSource)
+	errorLine := "ERROR: database connection failed"
+
+	dedup.ShouldEmitFrom("/var/www/app", "laravel.log", errorLine, errorLine)
+	dedup.ShouldEmitFrom("/var/www/app", "laravel.log", errorLine, errorLine)
+	_, entry := dedup.ShouldEmitFrom("/var/www/app", "worker.log", errorLine, errorLine)
+
+	if entry.OccurrenceCount != 1 {
+		t.Errorf("expected the second source to start its own count, got %d", entry.OccurrenceCount)
+	}
+	if unique, total := dedup.Stats(); unique != 2 || total != 3 {
+		t.Errorf("expected 2 signatures and 3 occurrences, got %d and %d", unique, total)
+	}
+}
+
+func TestDeduplicatorSetSignatureScopeInvalid(t *testing.T) {
+	dedup := NewDeduplicator()
+	if err := dedup.SetSignatureScope("file"); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+	if dedup.scope != ScopeMessage {
+		t.Errorf("expected scope to stay %q, got %q", ScopeMessage, dedup.scope)
+	}
+}
+
+func TestDeduplicatorTracksLevelsSeparately(t *testing.T) {
+	dedup := NewDeduplicator()
+	dedup.SetMaxPerWindow(1)
+	errorLine := "queue worker restarted"
+
+	_, warning := dedup.ShouldEmitLevel("/var/www/app", "app.log", LevelWarning, errorLine, errorLine)
+	emit, critical := dedup.ShouldEmitLevel("/var/www/app", "app.log", LevelCritical, errorLine, errorLine)
+
+	if !emit || critical.OccurrenceCount != 1 {
+		t.Errorf("expected the critical match not to be limited by the warning, got %+v", critical)
+	}
+	if warning.SignatureHash == critical.SignatureHash || warning.Level != LevelWarning || critical.Level != LevelCritical {
+		t.Errorf("expected separate signatures per level, got %+v and %+v", warning, critical)
+	}
+
+	// Error-level signatures are unchanged by levels
+	_, plain := dedup.ShouldEmitFrom("", "", "ERROR: boom", "ERROR: boom")
+	_, leveled := NewDeduplicator().ShouldEmitLevel("", "", LevelError, "ERROR: boom", "ERROR: boom")
+	if plain.SignatureHash != leveled.SignatureHash {
+		t.Errorf("expected error-level signatures to keep their hash, got %s and %s", plain.SignatureHash, leveled.SignatureHash)
+	}
+}
+
+func TestDeduplicatorDrainSuppressed(t *testing.T) {
+	dedup := NewDeduplicator()
+	dedup.SetMaxPerWindow(2)
+
+	for i := 0; i < 6; i++ {
+		dedup.ShouldEmitFrom("/var/www/app", "laravel.log", "ERROR: queue timeout", "ERROR: queue timeout")
+	}
+	for i := 0; i < 3; i++ {
+		dedup.ShouldEmitFrom("/var/www/app", "worker.log", "ERROR: cache miss storm", "ERROR: cache miss storm")
+	}
+	dedup.ShouldEmit("ERROR: only once")
+
+	drained := dedup.DrainSuppressed()
+	if len(drained) != 2 {
+		t.Fatalf("expected 2 suppressed signatures, got %+v", drained)
+	}
+	if drained[0].Suppressed != 4 || drained[0].OccurrenceCount != 6 || drained[0].Source != "laravel.log" || drained[0].AppPath != "/var/www/app" {
+		t.Errorf("expected 4 suppressed queue timeouts from laravel.log first, got %+v", drained[0])
+	}
+	if drained[1].Suppressed != 1 || drained[1].Source != "worker.log" {
+		t.Errorf("expected 1 suppressed cache miss storm from worker.log, got %+v", drained[1])
+	}
+
+	// Drained counts start over
+	if again := dedup.DrainSuppressed(); len(again) != 0 {
+		t.Errorf("expected nothing suppressed since the last drain, got %+v", again)
+	}
+	dedup.ShouldEmitFrom("/var/www/app", "laravel.log", "ERROR: queue timeout", "ERROR: queue timeout")
+	if again := dedup.DrainSuppressed(); len(again) != 1 || again[0].Suppressed != 1 {
+		t.Errorf("expected 1 new suppressed occurrence, got %+v", again)
+	}
+
+	// Counts from a summary that failed to send are kept for the next one
+	dedup.restoreSuppressed(drained)
+	if restored := dedup.DrainSuppressed(); len(restored) != 2 || restored[0].Suppressed != 4 {
+		t.Errorf("expected restored counts, got %+v", restored)
+	}
+}
@@ -0,0 +1,104 @@
+package logmonitor
+
+import (
+	"sync"
+	"time"
+)
+
+// Growth anomaly detection defaults
+const (
+	DefaultGrowthWindow        = 10 * time.Second // bytes are summed per window to get a rate
+	DefaultGrowthWarmupWindows = 6                // windows observed before alerting
+	DefaultGrowthMinRate       = 10 * 1024        // bytes/sec below which growth is never anomalous
+	growthBaselineWeight       = 0.2              // weight of the newest window in the rolling baseline
+)
+
+// GrowthHandler is called when a file grows abnormally fast
+type GrowthHandler func(rate, baseline float64)
+
+// GrowthDetector flags abnormal growth of a log file (an error storm or an
+// attack) before any line is matched. It keeps a rolling baseline of the
+// file's growth rate and reports a window whose rate exceeds factor times the
+// baseline. Anomalous windows don't feed the baseline, so a sustained storm
+// isn't learned as normal, and an anomaly is reported once until the rate
+// drops back.
+type GrowthDetector struct {
+	factor  float64
+	minRate float64
+	window  time.Duration
+	warmup  int
+	handler GrowthHandler
+
+	windowStart time.Time
+	windowBytes int64
+	windows     int
+	baseline    float64
+	alerting    bool
+
+	mu sync.Mutex
+}
+
+// NewGrowthDetector creates a detector alerting when the growth rate exceeds
+// factor times the baseline and at least minRate bytes/sec
+func NewGrowthDetector(factor float64, minRate int64, handler GrowthHandler) *GrowthDetector {
+	if minRate <= 0 {
+		minRate = DefaultGrowthMinRate
+	}
+
+	return &GrowthDetector{
+		factor:  factor,
+		minRate: float64(minRate),
+		window:  DefaultGrowthWindow,
+		warmup:  DefaultGrowthWarmupWindows,
+		handler: handler,
+	}
+}
+
+// Observe records bytes appended to the file at the given time
+func (d *GrowthDetector) Observe(bytes int64, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.windowStart.IsZero() {
+		d.windowStart = now
+	}
+	d.windowBytes += bytes
+
+	elapsed := now.Sub(d.windowStart)
+	if elapsed < d.window {
+		return
+	}
+
+	rate := float64(d.windowBytes) / elapsed.Seconds()
+	d.windowStart = now
+	d.windowBytes = 0
+	d.evaluate(rate)
+}
+
+// Baseline returns the current baseline growth rate in bytes/sec
+func (d *GrowthDetector) Baseline() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.baseline
+}
+
+// evaluate checks a completed window's rate against the baseline (caller must hold lock)
+func (d *GrowthDetector) evaluate(rate float64) {
+	if d.windows >= d.warmup && rate >= d.minRate && rate > d.factor*d.baseline {
+		if !d.alerting {
+			d.alerting = true
+			if d.handler != nil {
+				d.handler(rate, d.baseline)
+			}
+		}
+		return
+	}
+	d.alerting = false
+
+	if d.windows == 0 {
+		d.baseline = rate
+	} else {
+		d.baseline += growthBaselineWeight * (rate - d.baseline)
+	}
+	d.windows++
+}
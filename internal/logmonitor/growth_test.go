@@ -0,0 +1,123 @@
+package logmonitor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// feedWindows observes bytesPerSec for the given number of windows
+func feedWindows(d *GrowthDetector, now *time.Time, windows int, bytesPerSec int64) {
+	for i := 0; i < windows; i++ {
+		for s := 0; s < int(DefaultGrowthWindow/time.Second); s++ {
+			*now = now.Add(time.Second)
+			d.Observe(bytesPerSec, *now)
+		}
+	}
+}
+
+func TestGrowthDetector_BurstTriggersAnomaly(t *testing.T) {
+	var rates []float64
+	d := NewGrowthDetector(10, 1024, func(rate, baseline float64) {
+		rates = append(rates, rate)
+	})
+
+	now := time.Unix(1700000000, 0)
+	d.Observe(0, now)
+
+	// Steady baseline of ~2KB/sec
+	feedWindows(d, &now, DefaultGrowthWarmupWindows+2, 2048)
+	if len(rates) != 0 {
+		t.Fatalf("expected no anomaly at baseline, got %v", rates)
+	}
+
+	// 100x burst
+	feedWindows(d, &now, 3, 204800)
+	if len(rates) != 1 {
+		t.Fatalf("expected 1 anomaly for a sustained burst, got %d", len(rates))
+	}
+	if rates[0] < 100000 {
+		t.Errorf("expected burst rate to be reported, got %.0f", rates[0])
+	}
+	if baseline := d.Baseline(); baseline > 4096 {
+		t.Errorf("expected burst not to raise the baseline, got %.0f", baseline)
+	}
+
+	// Back to normal, then a second burst is reported again
+	feedWindows(d, &now, 2, 2048)
+	feedWindows(d, &now, 1, 204800)
+	if len(rates) != 2 {
+		t.Errorf("expected a new anomaly after recovering, got %d", len(rates))
+	}
+}
+
+func TestGrowthDetector_NoAnomalyDuringWarmup(t *testing.T) {
+	triggered := false
+	d := NewGrowthDetector(10, 1024, func(rate, baseline float64) { triggered = true })
+
+	now := time.Unix(1700000000, 0)
+	d.Observe(0, now)
+	feedWindows(d, &now, 1, 100)
+	feedWindows(d, &now, DefaultGrowthWarmupWindows-2, 500000)
+
+	if triggered {
+		t.Error("expected no anomaly before the baseline is established")
+	}
+}
+
+func TestGrowthDetector_IgnoresSmallRates(t *testing.T) {
+	triggered := false
+	d := NewGrowthDetector(10, 10240, func(rate, baseline float64) { triggered = true })
+
+	now := time.Unix(1700000000, 0)
+	d.Observe(0, now)
+	feedWindows(d, &now, DefaultGrowthWarmupWindows, 10)
+	feedWindows(d, &now, 1, 5000) // 500x, but below the minimum rate
+
+	if triggered {
+		t.Error("expected growth below the minimum rate to be ignored")
+	}
+}
+
+func TestTailer_ReportsGrowthToDetector(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "laravel.log")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("failed to create log: %v", err)
+	}
+
+	var observed int64
+	d := NewGrowthDetector(10, 1024, nil)
+	d.window = time.Hour // only count bytes
+
+	tailer := NewTailer(path, nil)
+	tailer.SetGrowthDetector(d)
+	if err := tailer.Start(); err != nil {
+		t.Fatalf("failed to start tailer: %v", err)
+	}
+	defer tailer.Stop()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	data := strings.Repeat("ERROR: storm\n", 100)
+	f.WriteString(data)
+	f.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d.mu.Lock()
+		observed = d.windowBytes
+		d.mu.Unlock()
+		if observed == int64(len(data)) {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if observed != int64(len(data)) {
+		t.Errorf("expected %d bytes observed, got %d", len(data), observed)
+	}
+}
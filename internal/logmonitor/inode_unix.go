@@ -0,0 +1,16 @@
+//go:build !windows
+
+package logmonitor
+
+import (
+	"os"
+	"syscall"
+)
+
+// getInode gets the inode of a file (for rotation detection)
+func getInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return modTimeInode(info)
+}
@@ -0,0 +1,86 @@
+//go:build !windows
+
+package logmonitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGetInode_SameModTime(t *testing.T) {
+	dir := t.TempDir()
+	mtime := time.Date(2026, 1, 13, 17, 52, 46, 0, time.UTC)
+
+	var inodes []uint64
+	for _, name := range []string{"a.log", "b.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("line\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inodes = append(inodes, getInode(info))
+	}
+
+	if inodes[0] == inodes[1] {
+		t.Errorf("expected files with the same mtime to have different inodes, both got %d", inodes[0])
+	}
+}
+
+func TestTailer_DetectsRotationWithSameModTime(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "laravel.log")
+	mtime := time.Date(2026, 1, 13, 17, 52, 46, 0, time.UTC)
+	if err := os.WriteFile(path, []byte("old line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	tailer := NewTailer(path, func(source, line string) {
+		lines = append(lines, line)
+	})
+	if err := tailer.openFile(); err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer tailer.file.Close()
+	oldInode := tailer.inode
+
+	// logrotate's default: rename the log, then create a new one
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	tailer.checkRotation()
+	if tailer.inode == oldInode {
+		t.Fatal("expected rotation to be detected for a new file with the same mtime")
+	}
+
+	// Lines written to the new file are read from it
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("ERROR: after rotation\n")
+	f.Close()
+
+	tailer.readLines()
+	if len(lines) != 1 || lines[0] != "ERROR: after rotation" {
+		t.Errorf("expected the new file's line, got %v", lines)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package logmonitor
+
+import "os"
+
+// getInode gets the inode of a file (for rotation detection). Windows has no
+// inode in os.FileInfo, so the modification time stands in for it.
+func getInode(info os.FileInfo) uint64 {
+	return modTimeInode(info)
+}
@@ -0,0 +1,100 @@
+package logmonitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Severity levels of matched errors, lowest first (syslog/PSR-3 names)
+const (
+	LevelDebug     = "debug"
+	LevelInfo      = "info"
+	LevelNotice    = "notice"
+	LevelWarning   = "warning"
+	LevelError     = "error"
+	LevelCritical  = "critical"
+	LevelAlert     = "alert"
+	LevelEmergency = "emergency"
+)
+
+// DefaultLevel is the level of a match that neither its pattern nor the
+// framework parser gave one
+const DefaultLevel = LevelError
+
+// levelRanks orders the levels
+var levelRanks = map[string]int{
+	LevelDebug:     1,
+	LevelInfo:      2,
+	LevelNotice:    3,
+	LevelWarning:   4,
+	LevelError:     5,
+	LevelCritical:  6,
+	LevelAlert:     7,
+	LevelEmergency: 8,
+}
+
+// levelAliases maps other names frameworks use for a level
+var levelAliases = map[string]string{
+	"warn":  LevelWarning,
+	"err":   LevelError,
+	"crit":  LevelCritical,
+	"fatal": LevelCritical,
+	"emerg": LevelEmergency,
+}
+
+// NormalizeLevel returns the level named by name (any case, aliases like
+// "warn" or "fatal" included), or false if it isn't one
+func NormalizeLevel(name string) (string, bool) {
+	level := strings.ToLower(name)
+	if alias, ok := levelAliases[level]; ok {
+		level = alias
+	}
+	if _, ok := levelRanks[level]; !ok {
+		return "", false
+	}
+	return level, true
+}
+
+// ParseMinLevel parses a minimum level setting ("" for none)
+func ParseMinLevel(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil
+	}
+	level, ok := NormalizeLevel(name)
+	if !ok {
+		return "", fmt.Errorf("unknown level %q (expected debug, info, notice, warning, error, critical, alert or emergency)", name)
+	}
+	return level, nil
+}
+
+// levelBelow reports whether level ranks below min ("" min allows all)
+func levelBelow(level, min string) bool {
+	return min != "" && levelRanks[level] < levelRanks[min]
+}
+
+// ParsePatternLevels normalizes the levels given to error patterns' matches,
+// keyed by pattern as configured. Patterns with an unknown level are left out
+// and reported in the error.
+func ParsePatternLevels(levels map[string]string) (map[string]string, error) {
+	if len(levels) == 0 {
+		return nil, nil
+	}
+
+	parsed := make(map[string]string, len(levels))
+	var unknown []string
+	for pattern, name := range levels {
+		level, ok := NormalizeLevel(strings.TrimSpace(name))
+		if !ok {
+			unknown = append(unknown, fmt.Sprintf("%q: %q", pattern, name))
+			continue
+		}
+		parsed[pattern] = level
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return parsed, fmt.Errorf("unknown level for %s", strings.Join(unknown, ", "))
+	}
+	return parsed, nil
+}
@@ -0,0 +1,64 @@
+package logmonitor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level string
+		ok    bool
+	}{
+		{"error", LevelError, true},
+		{"WARNING", LevelWarning, true},
+		{"warn", LevelWarning, true},
+		{"FATAL", LevelCritical, true},
+		{"emerg", LevelEmergency, true},
+		{"severe", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		level, ok := NormalizeLevel(tt.name)
+		if level != tt.level || ok != tt.ok {
+			t.Errorf("NormalizeLevel(%q) = %q, %v, expected %q, %v", tt.name, level, ok, tt.level, tt.ok)
+		}
+	}
+}
+
+func TestParseMinLevel(t *testing.T) {
+	if level, err := ParseMinLevel(" Warn "); err != nil || level != LevelWarning {
+		t.Errorf("expected warning, got %q (%v)", level, err)
+	}
+	if level, err := ParseMinLevel(""); err != nil || level != "" {
+		t.Errorf("expected no minimum, got %q (%v)", level, err)
+	}
+	if _, err := ParseMinLevel("loud"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestParsePatternLevels(t *testing.T) {
+	levels, err := ParsePatternLevels(map[string]string{"FATAL": "fatal", "re:^WARN": " Warning "})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(levels) != 2 || levels["FATAL"] != LevelCritical || levels["re:^WARN"] != LevelWarning {
+		t.Errorf("unexpected levels %v", levels)
+	}
+
+	// Unknown levels are reported, the rest still apply
+	levels, err = ParsePatternLevels(map[string]string{"FATAL": "critical", "timeout": "loud"})
+	if err == nil || !strings.Contains(err.Error(), "loud") {
+		t.Errorf("expected an error naming the unknown level, got %v", err)
+	}
+	if len(levels) != 1 || levels["FATAL"] != LevelCritical {
+		t.Errorf("expected the known level to be kept, got %v", levels)
+	}
+
+	if levels, err := ParsePatternLevels(nil); levels != nil || err != nil {
+		t.Errorf("expected no levels, got %v (%v)", levels, err)
+	}
+}
@@ -1,26 +1,138 @@
 package logmonitor
 
 import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// Output streams of command output fed to a matcher. Lines from log files
+// have no stream.
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
 )
 
 // Match represents a matched error with context
 type Match struct {
 	Source        string
-	ErrorLine     string
+	Stream        string   // stream of the error line ("" for log files)
+	ErrorLine     string   // with any continuation lines appended, newline-separated
+	Block         []string // continuation lines grouped with the error line
 	ContextBefore []string
 	ContextAfter  []string
+
+	// Set when the app's framework parser understood the error line
+	Severity string
+	Message  string
+
+	// Level is the match's severity level: the matching pattern's, else the
+	// parsed severity's, else DefaultLevel
+	Level string
+}
+
+// signatureLine returns the text used for deduplication: the parsed severity
+// and message when available, so per-occurrence context doesn't split
+// signatures. A grouped block is hashed whole.
+func (m Match) signatureLine() string {
+	if m.Message == "" {
+		return m.ErrorLine
+	}
+	if len(m.Block) > 0 {
+		return m.Severity + ": " + m.Message + "\n" + strings.Join(m.Block, "\n")
+	}
+	return m.Severity + ": " + m.Message
 }
 
 // MatchHandler is called when an error is matched with full context
 type MatchHandler func(match Match)
 
+// RegexPrefix marks an error pattern as a regular expression, e.g.
+// "re:^\[\d+\] production\.ERROR". Other patterns match as
+// case-insensitive substrings.
+const RegexPrefix = "re:"
+
+// errorPattern is an error pattern prepared for matching
+type errorPattern struct {
+	text  string         // as configured
+	lower string         // lower-cased text for substring matching
+	re    *regexp.Regexp // set for regex patterns
+}
+
+// compilePatterns prepares error patterns once, so lines aren't matched
+// against freshly compiled regexes. Invalid regexes are logged and skipped.
+func compilePatterns(patterns []string) []errorPattern {
+	compiled := make([]errorPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if expr, ok := strings.CutPrefix(pattern, RegexPrefix); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				log.Printf("Warning: skipping invalid error pattern %q: %v", pattern, err)
+				continue
+			}
+			compiled = append(compiled, errorPattern{text: pattern, re: re})
+			continue
+		}
+		compiled = append(compiled, errorPattern{text: pattern, lower: strings.ToLower(pattern)})
+	}
+	return compiled
+}
+
+// compileScopedPatterns prepares per-source error patterns
+func compileScopedPatterns(scoped map[string][]string) map[string][]errorPattern {
+	if scoped == nil {
+		return nil
+	}
+
+	compiled := make(map[string][]errorPattern, len(scoped))
+	for source, patterns := range scoped {
+		compiled[source] = compilePatterns(patterns)
+	}
+	return compiled
+}
+
+// DefaultMultilineContinuation matches the continuation lines of common stack
+// traces: indented lines, numbered frames (#0 ...), "at ..." frames and the
+// headers around them. A MultilineContinuation of "default" selects it.
+const DefaultMultilineContinuation = `^(\s|#\d+ |at |Stack trace:|\[stacktrace\]|Caused by:|Next |\.\.\. \d+ more|"\}$)`
+
+// MaxBlockLines caps the continuation lines grouped into one match; later
+// ones are captured as context after it
+const MaxBlockLines = 200
+
+// CompileContinuation compiles a multiline continuation pattern ("" disables
+// block mode, "default" selects DefaultMultilineContinuation)
+func CompileContinuation(expr string) (*regexp.Regexp, error) {
+	switch expr {
+	case "":
+		return nil, nil
+	case "default":
+		expr = DefaultMultilineContinuation
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiline continuation %q: %w", expr, err)
+	}
+	return re, nil
+}
+
 // Matcher matches lines against error patterns and captures context
 type Matcher struct {
-	patterns     []string
-	contextLines int
-	handler      MatchHandler
+	patterns       []errorPattern
+	scopedPatterns map[string][]errorPattern // source (name or glob) -> patterns
+	contextBefore  int                       // lines kept before a match (ring buffer size)
+	contextAfter   int                       // lines captured after a match
+	parser         Parser                    // framework log parser (nil = substring matching only)
+	matchStreams   map[string]bool           // streams whose lines can match (nil = all)
+	continuation   *regexp.Regexp            // lines grouped into the current error (nil = no block mode)
+	minLevel       string                    // matches below this level are dropped ("" = none)
+	patternLevels  map[string]string         // pattern -> level given to its matches (unlisted = inferred)
+	handler        MatchHandler
 
 	// Ring buffer for context before
 	buffer      []string
@@ -31,44 +143,77 @@ type Matcher struct {
 	capturing         bool
 	captureMatch      Match
 	captureAfterCount int
+	inBlock           bool // continuation lines are still being grouped
 
 	mu sync.Mutex
 }
 
-// NewMatcher creates a new pattern matcher
+// DefaultContextLines is used when no context line count is configured
+const DefaultContextLines = 20
+
+// NewMatcher creates a new pattern matcher capturing the same number of
+// context lines before and after a match
 func NewMatcher(patterns []string, contextLines int, handler MatchHandler) *Matcher {
-	if contextLines <= 0 {
-		contextLines = 20
+	return NewMatcherWithContext(patterns, contextLines, contextLines, handler)
+}
+
+// NewMatcherWithContext creates a new pattern matcher with separate context
+// line counts before and after a match (e.g. few before, many after for
+// stack traces)
+func NewMatcherWithContext(patterns []string, before, after int, handler MatchHandler) *Matcher {
+	if before <= 0 {
+		before = DefaultContextLines
+	}
+	if after <= 0 {
+		after = DefaultContextLines
 	}
 
 	return &Matcher{
-		patterns:     patterns,
-		contextLines: contextLines,
-		handler:      handler,
-		buffer:       make([]string, contextLines),
-		bufferPos:    0,
-		bufferCount:  0,
+		patterns:      compilePatterns(patterns),
+		contextBefore: before,
+		contextAfter:  after,
+		handler:       handler,
+		buffer:        make([]string, before),
+		bufferPos:     0,
+		bufferCount:   0,
 	}
 }
 
 // ProcessLine processes a single line from a log file
 func (m *Matcher) ProcessLine(source, line string) {
+	m.ProcessStreamLine(source, "", line)
+}
+
+// ProcessStreamLine processes a line from one stream of combined output.
+// Lines from streams excluded by SetMatchStreams only provide context.
+func (m *Matcher) ProcessStreamLine(source, stream, line string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	// Continuation lines extend the current error instead of starting a new
+	// one or counting as context
+	if m.inBlock && source == m.captureMatch.Source && stream == m.captureMatch.Stream &&
+		len(m.captureMatch.Block) < MaxBlockLines && m.continuation.MatchString(line) {
+		m.captureMatch.Block = append(m.captureMatch.Block, line)
+		m.bufferLine(line)
+		return
+	}
+	m.inBlock = false
+
 	// If we're capturing context after an error
 	if m.capturing {
 		m.captureMatch.ContextAfter = append(m.captureMatch.ContextAfter, line)
 		m.captureAfterCount++
 
-		if m.captureAfterCount >= m.contextLines {
+		if m.captureAfterCount >= m.contextAfter {
 			// Done capturing, emit the match
 			m.emitMatch()
 		}
 	}
 
 	// Check if this line matches any error pattern
-	if m.matchesPattern(line) {
+	parsed, isParsed := m.parse(line)
+	if level, ok := m.matchLevel(source, stream, line, parsed, isParsed); ok {
 		// If we were capturing context for a previous match, emit it first
 		if m.capturing {
 			m.emitMatch()
@@ -77,18 +222,29 @@ func (m *Matcher) ProcessLine(source, line string) {
 		// Start a new match
 		m.captureMatch = Match{
 			Source:        source,
+			Stream:        stream,
 			ErrorLine:     line,
 			ContextBefore: m.getContextBefore(),
-			ContextAfter:  make([]string, 0, m.contextLines),
+			ContextAfter:  make([]string, 0, m.contextAfter),
+			Level:         level,
+		}
+		if isParsed {
+			m.captureMatch.Severity = parsed.Severity
+			m.captureMatch.Message = parsed.Message
 		}
 		m.capturing = true
 		m.captureAfterCount = 0
+		m.inBlock = m.continuation != nil
 	}
 
-	// Add line to context buffer (ring buffer)
+	m.bufferLine(line)
+}
+
+// bufferLine adds a line to the context before buffer (ring buffer)
+func (m *Matcher) bufferLine(line string) {
 	m.buffer[m.bufferPos] = line
-	m.bufferPos = (m.bufferPos + 1) % m.contextLines
-	if m.bufferCount < m.contextLines {
+	m.bufferPos = (m.bufferPos + 1) % m.contextBefore
+	if m.bufferCount < m.contextBefore {
 		m.bufferCount++
 	}
 }
@@ -103,17 +259,126 @@ func (m *Matcher) Flush() {
 	}
 }
 
-// matchesPattern checks if a line matches any error pattern
-func (m *Matcher) matchesPattern(line string) bool {
+// Capturing reports whether a match is waiting for its context after lines
+func (m *Matcher) Capturing() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.capturing
+}
+
+// SetMatchStreams limits matching to lines from the given streams (e.g. only
+// stderr), with lines from other streams kept as context. No streams means
+// every line can match. Lines without a stream always can.
+func (m *Matcher) SetMatchStreams(streams []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(streams) == 0 {
+		m.matchStreams = nil
+		return
+	}
+
+	m.matchStreams = make(map[string]bool, len(streams))
+	for _, stream := range streams {
+		m.matchStreams[stream] = true
+	}
+}
+
+// streamCanMatch reports whether lines from a stream are checked against the
+// patterns
+func (m *Matcher) streamCanMatch(stream string) bool {
+	return stream == "" || m.matchStreams == nil || m.matchStreams[stream]
+}
+
+// parse runs the framework parser on a line, if one is set
+func (m *Matcher) parse(line string) (ParsedLine, bool) {
+	if m.parser == nil {
+		return ParsedLine{}, false
+	}
+	return m.parser(line)
+}
+
+// matchLevel checks whether a line starts a match and returns its level.
+// Lines from streams that can't match, and matches below the minimum level,
+// don't.
+func (m *Matcher) matchLevel(source, stream, line string, parsed ParsedLine, isParsed bool) (string, bool) {
+	if !m.streamCanMatch(stream) {
+		return "", false
+	}
+
+	level, ok := m.matchesPattern(source, line, parsed, isParsed)
+	if !ok {
+		return "", false
+	}
+	if level == "" && isParsed {
+		level, _ = NormalizeLevel(parsed.Severity)
+	}
+	if level == "" {
+		level = DefaultLevel
+	}
+
+	if levelBelow(level, m.minLevel) {
+		return "", false
+	}
+	return level, true
+}
+
+// matchesPattern checks if a line matches any error pattern for its source,
+// returning the highest level given by a matching pattern ("" if none gives
+// one). Regex patterns are matched against the whole line. Otherwise parsed
+// lines are matched field by field (falling back to the whole line for
+// patterns other than level names), and other lines (and all lines when there
+// is no parser) by case-insensitive substring.
+func (m *Matcher) matchesPattern(source, line string, parsed ParsedLine, isParsed bool) (string, bool) {
 	lineLower := strings.ToLower(line)
 
-	for _, pattern := range m.patterns {
-		// Case-insensitive substring match
-		if strings.Contains(lineLower, strings.ToLower(pattern)) {
-			return true
+	level, matched := "", false
+	for _, pattern := range m.patternsFor(source) {
+		var ok bool
+		switch {
+		case pattern.re != nil:
+			ok = pattern.re.MatchString(line)
+		case isParsed:
+			ok = matchesParsed(parsed, lineLower, pattern.text)
+		default:
+			// Case-insensitive substring match
+			ok = strings.Contains(lineLower, pattern.lower)
+		}
+		if !ok {
+			continue
+		}
+
+		matched = true
+		if patternLevel := m.patternLevels[pattern.text]; levelRanks[patternLevel] > levelRanks[level] {
+			level = patternLevel
 		}
 	}
-	return false
+	return level, matched
+}
+
+// patternsFor returns the patterns scoped to a source, falling back to the
+// global patterns when no scoped set matches
+func (m *Matcher) patternsFor(source string) []errorPattern {
+	if len(m.scopedPatterns) == 0 {
+		return m.patterns
+	}
+
+	var scoped []errorPattern
+	matched := false
+	for scope, patterns := range m.scopedPatterns {
+		if scope == source {
+			scoped = append(scoped, patterns...)
+			matched = true
+		} else if ok, _ := filepath.Match(scope, source); ok {
+			scoped = append(scoped, patterns...)
+			matched = true
+		}
+	}
+
+	if !matched {
+		return m.patterns
+	}
+	return scoped
 }
 
 // getContextBefore returns the context lines before the current position
@@ -125,15 +390,15 @@ func (m *Matcher) getContextBefore() []string {
 	}
 
 	// Read from ring buffer in order
-	if m.bufferCount < m.contextLines {
+	if m.bufferCount < m.contextBefore {
 		// Buffer not full yet, start from 0
 		for i := 0; i < m.bufferCount; i++ {
 			result = append(result, m.buffer[i])
 		}
 	} else {
 		// Buffer full, read from bufferPos to end, then start to bufferPos
-		for i := 0; i < m.contextLines; i++ {
-			idx := (m.bufferPos + i) % m.contextLines
+		for i := 0; i < m.contextBefore; i++ {
+			idx := (m.bufferPos + i) % m.contextBefore
 			result = append(result, m.buffer[idx])
 		}
 	}
@@ -143,34 +408,134 @@ func (m *Matcher) getContextBefore() []string {
 
 // emitMatch emits the current match and resets state
 func (m *Matcher) emitMatch() {
+	if len(m.captureMatch.Block) > 0 {
+		m.captureMatch.ErrorLine += "\n" + strings.Join(m.captureMatch.Block, "\n")
+	}
 	if m.handler != nil {
 		m.handler(m.captureMatch)
 	}
 	m.capturing = false
 	m.captureAfterCount = 0
+	m.inBlock = false
 }
 
 // UpdatePatterns updates the error patterns
 func (m *Matcher) UpdatePatterns(patterns []string) {
+	compiled := compilePatterns(patterns)
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.patterns = patterns
+	m.patterns = compiled
 }
 
-// UpdateContextLines updates the context line count
+// ValidatePatterns checks error patterns before they are applied, returning
+// a problem for each one that can't be used. An empty pattern would match
+// every line.
+func ValidatePatterns(patterns []string, scoped []messages.ScopedErrorPatterns) []messages.PatternIssue {
+	var issues []messages.PatternIssue
+
+	check := func(source, pattern string) {
+		expr, isRegex := strings.CutPrefix(pattern, RegexPrefix)
+		if strings.TrimSpace(expr) == "" {
+			issues = append(issues, messages.PatternIssue{Source: source, Pattern: pattern, Message: "pattern is empty"})
+			return
+		}
+		if isRegex {
+			if _, err := regexp.Compile(expr); err != nil {
+				issues = append(issues, messages.PatternIssue{Source: source, Pattern: pattern, Message: fmt.Sprintf("invalid regex: %v", err)})
+			}
+		}
+	}
+
+	for _, pattern := range patterns {
+		check("", pattern)
+	}
+	for _, sp := range scoped {
+		if sp.Source == "" {
+			issues = append(issues, messages.PatternIssue{Message: "scoped patterns have no source"})
+			continue
+		}
+		if _, err := filepath.Match(sp.Source, ""); err != nil {
+			issues = append(issues, messages.PatternIssue{Source: sp.Source, Message: fmt.Sprintf("invalid source glob: %v", err)})
+		}
+		for _, pattern := range sp.Patterns {
+			check(sp.Source, pattern)
+		}
+	}
+
+	return issues
+}
+
+// SetParser sets the framework log parser (nil disables parsing)
+func (m *Matcher) SetParser(parser Parser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.parser = parser
+}
+
+// SetContinuation enables block mode: lines matching the continuation
+// pattern right after an error line (e.g. stack frames) are grouped into that
+// error and hashed with it, instead of each frame being context or a match of
+// its own. nil disables it.
+func (m *Matcher) SetContinuation(continuation *regexp.Regexp) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.continuation = continuation
+	if continuation == nil {
+		m.inBlock = false
+	}
+}
+
+// SetMinLevel drops matches below a level ("" keeps all); their lines are
+// only context
+func (m *Matcher) SetMinLevel(level string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.minLevel = level
+}
+
+// SetPatternLevels gives the matches of error patterns a level, keyed by
+// pattern as configured (global or scoped), e.g. {"FATAL": "critical"}.
+// Levels must be normalized; matches of other patterns get the parsed
+// severity, else DefaultLevel.
+func (m *Matcher) SetPatternLevels(levels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.patternLevels = levels
+}
+
+// UpdateScopedPatterns updates the per-source error patterns
+func (m *Matcher) UpdateScopedPatterns(scoped map[string][]string) {
+	compiled := compileScopedPatterns(scoped)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scopedPatterns = compiled
+}
+
+// UpdateContextLines updates the context line count before and after a match
 func (m *Matcher) UpdateContextLines(count int) {
+	m.UpdateContext(count, count)
+}
+
+// UpdateContext updates the context line counts before and after a match
+func (m *Matcher) UpdateContext(before, after int) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if count <= 0 {
-		count = 20
+	if before <= 0 {
+		before = DefaultContextLines
+	}
+	if after <= 0 {
+		after = DefaultContextLines
 	}
 
 	// Resize buffer if needed
-	if count != m.contextLines {
-		m.buffer = make([]string, count)
+	if before != m.contextBefore {
+		m.buffer = make([]string, before)
 		m.bufferPos = 0
 		m.bufferCount = 0
-		m.contextLines = count
+		m.contextBefore = before
 	}
+	m.contextAfter = after
 }
@@ -1,6 +1,9 @@
 package logmonitor
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -140,3 +143,382 @@ func TestMatcherContextBuffer(t *testing.T) {
 		t.Errorf("expected 5 context before lines, got %d", len(matches[0].ContextBefore))
 	}
 }
+
+func TestMatcherAsymmetricContext(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcherWithContext([]string{"ERROR"}, 2, 10, func(m Match) {
+		matches = append(matches, m)
+	})
+
+	for i := 0; i < 5; i++ {
+		matcher.ProcessLine("test.log", fmt.Sprintf("before %d", i))
+	}
+	matcher.ProcessLine("test.log", "ERROR: Uncaught exception")
+	for i := 0; i < 15; i++ {
+		matcher.ProcessLine("test.log", fmt.Sprintf("#%d stack frame", i))
+	}
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(matches))
+	}
+
+	before := matches[0].ContextBefore
+	if len(before) != 2 || before[0] != "before 3" || before[1] != "before 4" {
+		t.Errorf("expected the 2 lines before the error, got %v", before)
+	}
+
+	after := matches[0].ContextAfter
+	if len(after) != 10 || after[0] != "#0 stack frame" || after[9] != "#9 stack frame" {
+		t.Errorf("expected the 10 lines after the error, got %v", after)
+	}
+}
+
+func TestMatcherScopedPatterns(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"ERROR"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.UpdateScopedPatterns(map[string][]string{
+		"laravel.log": {"production.CRITICAL"},
+	})
+
+	// Scoped pattern only applies to its own source
+	matcher.ProcessLine("worker.log", "production.CRITICAL: queue stalled")
+	matcher.ProcessLine("laravel.log", "production.CRITICAL: database down")
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(matches))
+	}
+	if matches[0].Source != "laravel.log" {
+		t.Errorf("expected match from laravel.log, got %s", matches[0].Source)
+	}
+
+	// Global patterns are replaced for the scoped source...
+	matches = nil
+	matcher.ProcessLine("laravel.log", "ERROR: not matched here")
+	matcher.Flush()
+	if len(matches) != 0 {
+		t.Errorf("expected global pattern to be replaced for scoped source, got %d matches", len(matches))
+	}
+
+	// ...but still apply to sources without a scoped set
+	matcher.ProcessLine("worker.log", "ERROR: job failed")
+	matcher.Flush()
+	if len(matches) != 1 {
+		t.Errorf("expected global pattern to match unscoped source, got %d matches", len(matches))
+	}
+}
+
+func TestMatcherScopedPatternsGlob(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"ERROR"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.UpdateScopedPatterns(map[string][]string{
+		"worker-*.log": {"FAILED"},
+	})
+
+	matcher.ProcessLine("worker-1.log", "Job FAILED")
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected glob-scoped pattern to match, got %d matches", len(matches))
+	}
+}
+
+func TestMatcherMatchStreams(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcherWithContext([]string{"ERROR"}, 5, 5, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetMatchStreams([]string{StreamStderr})
+
+	matcher.ProcessStreamLine("deploy", StreamStdout, "Running migrations")
+	matcher.ProcessStreamLine("deploy", StreamStdout, "0 ERRORS found in schema check")
+	matcher.ProcessStreamLine("deploy", StreamStderr, "ERROR: migration 42 failed")
+	matcher.ProcessStreamLine("deploy", StreamStdout, "Rolling back")
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected only the stderr line to match, got %d matches", len(matches))
+	}
+
+	m := matches[0]
+	if m.ErrorLine != "ERROR: migration 42 failed" || m.Stream != StreamStderr {
+		t.Errorf("unexpected match: %+v", m)
+	}
+
+	// stdout lines still provide context
+	if len(m.ContextBefore) != 2 || m.ContextBefore[1] != "0 ERRORS found in schema check" {
+		t.Errorf("expected stdout lines as context before, got %v", m.ContextBefore)
+	}
+	if len(m.ContextAfter) != 1 || m.ContextAfter[0] != "Rolling back" {
+		t.Errorf("expected stdout line as context after, got %v", m.ContextAfter)
+	}
+
+	// Without a stream restriction both streams match
+	matches = nil
+	matcher.SetMatchStreams(nil)
+	matcher.ProcessStreamLine("deploy", StreamStdout, "ERROR: on stdout")
+	matcher.Flush()
+	if len(matches) != 1 {
+		t.Errorf("expected stdout line to match without restriction, got %d matches", len(matches))
+	}
+}
+
+func TestMatcherAnchoredRegexPattern(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{`re:^\[\d{4}-\d{2}-\d{2} [\d:]+\] production\.ERROR`}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+
+	matcher.ProcessLine("laravel.log", "[2026-03-01 12:00:00] production.INFO: retrying after error")
+	matcher.ProcessLine("laravel.log", "user said: [2026-03-01 12:00:00] production.ERROR in a quote")
+	matcher.ProcessLine("laravel.log", "[2026-03-01 12:00:01] production.ERROR: SQLSTATE[HY000]")
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(matches))
+	}
+	if matches[0].ErrorLine != "[2026-03-01 12:00:01] production.ERROR: SQLSTATE[HY000]" {
+		t.Errorf("unexpected error line: %s", matches[0].ErrorLine)
+	}
+}
+
+func TestMatcherMixedSubstringAndRegexPatterns(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"fatal", `re:\bpanic:`, `re:(unclosed`}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+
+	lines := []string{
+		"FATAL: out of memory",           // substring, case-insensitive
+		"goroutine 1 [running]",          // no match
+		"panic: nil map",                 // regex
+		"PANIC: regex is case-sensitive", // no match
+		"unclosed paren",                 // invalid regex was skipped
+	}
+	for _, line := range lines {
+		matcher.ProcessLine("app.log", line)
+	}
+	matcher.Flush()
+
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d: %+v", len(matches), matches)
+	}
+	if matches[0].ErrorLine != "FATAL: out of memory" || matches[1].ErrorLine != "panic: nil map" {
+		t.Errorf("unexpected matches %q, %q", matches[0].ErrorLine, matches[1].ErrorLine)
+	}
+
+	// Patterns updated later are compiled the same way
+	matcher.UpdatePatterns([]string{`re:^goroutine \d+`})
+	matches = nil
+	matcher.ProcessLine("app.log", "goroutine 7 [chan receive]")
+	matcher.Flush()
+	if len(matches) != 1 {
+		t.Errorf("expected the updated regex to match, got %d matches", len(matches))
+	}
+}
+
+func TestValidatePatterns_Regex(t *testing.T) {
+	issues := ValidatePatterns([]string{`re:^ERROR`, `re:(unclosed`, "re:"}, nil)
+	if len(issues) != 2 {
+		t.Fatalf("expected 2 issues, got %+v", issues)
+	}
+	if issues[0].Pattern != `re:(unclosed` || issues[1].Message != "pattern is empty" {
+		t.Errorf("unexpected issues %+v", issues)
+	}
+}
+
+// phpStackTrace is an uncaught PHP exception as written to the error log
+var phpStackTrace = []string{
+	"PHP Fatal error:  Uncaught RuntimeException: Connection refused in /var/www/app/src/Db.php:42",
+	"Stack trace:",
+	"#0 /var/www/app/src/Repository.php(17): Db->connect()",
+	"#1 /var/www/app/src/Controller.php(30): Repository->find(7)",
+	"#2 /var/www/app/public/index.php(12): Controller->show()",
+	"#3 {main}",
+	"  thrown in /var/www/app/src/Db.php on line 42",
+}
+
+func TestMatcherMultilineBlock(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"error", "Exception"}, 2, func(m Match) {
+		matches = append(matches, m)
+	})
+	continuation, err := CompileContinuation("default")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	matcher.SetContinuation(continuation)
+
+	matcher.ProcessLine("php_errors.log", "request started")
+	for _, line := range phpStackTrace {
+		matcher.ProcessLine("php_errors.log", line)
+	}
+	matcher.ProcessLine("php_errors.log", "request finished")
+	matcher.ProcessLine("php_errors.log", "next request")
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected the stack trace to be one match, got %d: %+v", len(matches), matches)
+	}
+	m := matches[0]
+	if m.ErrorLine != strings.Join(phpStackTrace, "\n") {
+		t.Errorf("expected the whole block as the error line, got %q", m.ErrorLine)
+	}
+	if len(m.Block) != len(phpStackTrace)-1 {
+		t.Errorf("expected %d continuation lines, got %d", len(phpStackTrace)-1, len(m.Block))
+	}
+	if len(m.ContextBefore) != 1 || m.ContextBefore[0] != "request started" {
+		t.Errorf("unexpected context before %v", m.ContextBefore)
+	}
+	if len(m.ContextAfter) != 2 || m.ContextAfter[0] != "request finished" {
+		t.Errorf("expected the lines after the block as context, got %v", m.ContextAfter)
+	}
+	if m.signatureLine() != m.ErrorLine {
+		t.Error("expected the whole block to be the dedup signature")
+	}
+}
+
+func TestMatcherMultilineBlock_Disabled(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"Exception", "Repository"}, 2, func(m Match) {
+		matches = append(matches, m)
+	})
+
+	for _, line := range phpStackTrace {
+		matcher.ProcessLine("php_errors.log", line)
+	}
+	matcher.Flush()
+
+	// Without block mode a frame matching a pattern is a match of its own
+	if len(matches) != 3 {
+		t.Fatalf("expected 3 separate matches, got %d", len(matches))
+	}
+	if matches[0].ErrorLine != phpStackTrace[0] || len(matches[0].Block) != 0 {
+		t.Errorf("expected a single-line match, got %+v", matches[0])
+	}
+}
+
+func TestMatcherMultilineBlock_OtherSource(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"Exception"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetContinuation(regexp.MustCompile(`^#\d+ `))
+
+	matcher.ProcessLine("a.log", "RuntimeException: boom")
+	matcher.ProcessLine("b.log", "#0 unrelated frame")
+	matcher.Flush()
+
+	if len(matches) != 1 || len(matches[0].Block) != 0 {
+		t.Fatalf("expected no grouping across sources, got %+v", matches)
+	}
+	if len(matches[0].ContextAfter) != 1 {
+		t.Errorf("expected the other source's line as context, got %v", matches[0].ContextAfter)
+	}
+}
+
+func TestCompileContinuation(t *testing.T) {
+	if re, err := CompileContinuation(""); re != nil || err != nil {
+		t.Errorf("expected no continuation for an empty pattern, got %v, %v", re, err)
+	}
+	if _, err := CompileContinuation("(unclosed"); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+}
+
+func TestMatcherLevelInference(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"FATAL", "WARN", "timeout", "re:database.*"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetPatternLevels(map[string]string{"FATAL": LevelCritical, "WARN": LevelWarning, "re:database.*": LevelError})
+
+	matcher.ProcessLine("app.log", "FATAL: out of memory")
+	matcher.ProcessLine("app.log", "WARN: slow query")
+	matcher.ProcessLine("app.log", "upstream timeout")
+	matcher.ProcessLine("app.log", "WARN: database unavailable") // warning and error patterns match
+	matcher.Flush()
+
+	expected := []string{LevelCritical, LevelWarning, DefaultLevel, LevelError}
+	if len(matches) != len(expected) {
+		t.Fatalf("expected %d matches, got %d", len(expected), len(matches))
+	}
+	for i, level := range expected {
+		if matches[i].Level != level {
+			t.Errorf("match %d (%q): expected level %q, got %q", i, matches[i].ErrorLine, level, matches[i].Level)
+		}
+	}
+}
+
+func TestMatcherLevelFromParsedSeverity(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"failed"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetParser(ParseLaravelLine)
+
+	matcher.ProcessLine("laravel.log", "[2026-01-13 17:52:46] production.WARNING: login failed")
+	matcher.Flush()
+
+	if len(matches) != 1 || matches[0].Level != LevelWarning {
+		t.Fatalf("expected a warning-level match, got %+v", matches)
+	}
+}
+
+func TestMatcherMinLevel(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"FATAL", "ERROR", "WARN"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetPatternLevels(map[string]string{"FATAL": LevelCritical, "WARN": LevelWarning})
+	matcher.SetMinLevel(LevelError)
+
+	matcher.ProcessLine("app.log", "WARN: disk 80% full")
+	matcher.ProcessLine("app.log", "ERROR: request failed")
+	matcher.ProcessLine("app.log", "WARN: retrying")
+	matcher.ProcessLine("app.log", "FATAL: worker died")
+	matcher.Flush()
+
+	if len(matches) != 2 {
+		t.Fatalf("expected warnings to be dropped, got %d matches: %+v", len(matches), matches)
+	}
+	if matches[0].Level != LevelError || matches[1].Level != LevelCritical {
+		t.Errorf("unexpected levels %q, %q", matches[0].Level, matches[1].Level)
+	}
+	// A dropped warning is only context
+	if len(matches[0].ContextAfter) != 1 || matches[0].ContextAfter[0] != "WARN: retrying" {
+		t.Errorf("expected the dropped warning as context, got %v", matches[0].ContextAfter)
+	}
+}
+
+func TestMatcherColonLevelPatternIsText(t *testing.T) {
+	// A pattern ending in a level name is matched as written, not split into
+	// a pattern and a level
+	var matches []Match
+	matcher := NewMatcher([]string{"level:error", "re:status=5\\d\\d:critical"}, 1, func(m Match) {
+		matches = append(matches, m)
+	})
+
+	matcher.ProcessLine("app.log", "level=info msg=ok")
+	matcher.ProcessLine("app.log", "ts=1 level:error msg=failed")
+	matcher.ProcessLine("app.log", "status=503 upstream")
+	matcher.ProcessLine("app.log", "status=503:critical upstream")
+	matcher.Flush()
+
+	if len(matches) != 2 {
+		t.Fatalf("expected the two literal matches, got %d: %+v", len(matches), matches)
+	}
+	if matches[0].ErrorLine != "ts=1 level:error msg=failed" || matches[1].ErrorLine != "status=503:critical upstream" {
+		t.Errorf("unexpected matches %q, %q", matches[0].ErrorLine, matches[1].ErrorLine)
+	}
+	for _, match := range matches {
+		if match.Level != DefaultLevel {
+			t.Errorf("expected %q to get the default level, got %q", match.ErrorLine, match.Level)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package logmonitor
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/codebasehealth/antidote-agent/internal/messages"
@@ -12,6 +17,20 @@ import (
 // SendFunc is a function that sends a message to the cloud
 type SendFunc func(msg interface{}) error
 
+// Dropped event reporting
+const (
+	DefaultStatusInterval = time.Minute // how often drops are reported to the cloud
+	DropLogInterval       = time.Minute // at most one drop log line per interval
+)
+
+// DefaultSummaryInterval is how often the occurrences of rate-limited errors
+// are summarized to the cloud
+const DefaultSummaryInterval = time.Minute
+
+// DefaultShutdownGrace is how long Stop keeps tailing while a match is still
+// capturing its context after lines
+const DefaultShutdownGrace = 2 * time.Second
+
 // AppDiscovery provides app discovery info for matching configs to paths
 type AppDiscovery interface {
 	GetApps() []messages.AppInfo
@@ -27,6 +46,22 @@ type Monitor struct {
 	// Per-app monitors
 	appMonitors map[string]*AppMonitor // keyed by app path
 
+	// Log paths tailed so far; config reloads restart their tailers, which
+	// must not backfill them again
+	tailedPaths map[string]bool
+
+	// Error events that could not be sent
+	droppedTotal   atomic.Uint64
+	droppedUnsent  atomic.Uint64 // not yet reported in a status message
+	droppedLogged  atomic.Uint64 // not yet mentioned in the log
+	lastDropLog    time.Time
+	dropLogMu      sync.Mutex
+	statusInterval time.Duration
+
+	summaryInterval time.Duration
+
+	shutdownGrace time.Duration
+
 	mu     sync.Mutex
 	stopCh chan struct{}
 	wg     sync.WaitGroup
@@ -42,35 +77,254 @@ type AppMonitor struct {
 // NewMonitor creates a new log monitor
 func NewMonitor(send SendFunc, discovery AppDiscovery) *Monitor {
 	return &Monitor{
-		send:        send,
-		discovery:   discovery,
-		configStore: NewConfigStore(),
-		dedup:       NewDeduplicator(),
-		appMonitors: make(map[string]*AppMonitor),
-		stopCh:      make(chan struct{}),
+		send:            send,
+		discovery:       discovery,
+		configStore:     NewConfigStore(),
+		dedup:           NewDeduplicator(),
+		appMonitors:     make(map[string]*AppMonitor),
+		tailedPaths:     make(map[string]bool),
+		stopCh:          make(chan struct{}),
+		statusInterval:  DefaultStatusInterval,
+		summaryInterval: DefaultSummaryInterval,
+		shutdownGrace:   DefaultShutdownGrace,
 	}
 }
 
+// SetShutdownGrace sets how long Stop waits for in-progress matches to
+// capture their context (0 stops immediately; pending matches are still
+// flushed)
+func (m *Monitor) SetShutdownGrace(grace time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shutdownGrace = grace
+}
+
+// SetSignatureScope sets whether identical errors from different apps or log
+// files share a dedup signature (see ScopeMessage, ScopeApp, ScopeSource)
+func (m *Monitor) SetSignatureScope(scope string) error {
+	return m.dedup.SetSignatureScope(scope)
+}
+
 // Start starts the monitor
 func (m *Monitor) Start() {
 	m.dedup.Start()
+
+	m.wg.Add(1)
+	go m.statusLoop()
+}
+
+// LogFiles returns the paths of all log files currently being tailed
+func (m *Monitor) LogFiles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var paths []string
+	for _, appMon := range m.appMonitors {
+		for _, tailer := range appMon.tailers {
+			paths = append(paths, tailer.path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// MaxDedupDumpEntries bounds the number of entries in a dedup dump
+const MaxDedupDumpEntries = 100
+
+// DedupDump returns the most frequent error signatures seen, at most
+// MaxDedupDumpEntries, along with the total number tracked
+func (m *Monitor) DedupDump() *messages.DedupEntriesMessage {
+	entries := m.dedup.Entries()
+	total := len(entries)
+	if len(entries) > MaxDedupDumpEntries {
+		entries = entries[:MaxDedupDumpEntries]
+	}
+
+	dump := make([]messages.DedupEntry, 0, len(entries))
+	for _, entry := range entries {
+		dump = append(dump, messages.DedupEntry{
+			SignatureHash:   entry.SignatureHash,
+			FirstSeen:       entry.FirstSeen.UTC().Format(time.RFC3339),
+			LastSeen:        entry.LastSeen.UTC().Format(time.RFC3339),
+			OccurrenceCount: entry.OccurrenceCount,
+			SampleLine:      entry.SampleLine,
+			Level:           entry.Level,
+		})
+	}
+	return messages.NewDedupEntriesMessage(dump, total)
+}
+
+// DroppedEvents returns the number of error events that could not be sent
+func (m *Monitor) DroppedEvents() uint64 {
+	return m.droppedTotal.Load()
+}
+
+// statusLoop periodically reports dropped error events and the errors rate
+// limiting suppressed
+func (m *Monitor) statusLoop() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.statusInterval)
+	defer ticker.Stop()
+	summaryTicker := time.NewTicker(m.summaryInterval)
+	defer summaryTicker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.reportDropped()
+		case <-summaryTicker.C:
+			m.reportSuppressed()
+		}
+	}
+}
+
+// reportSuppressed sends a summary of the error occurrences rate limiting
+// suppressed since the last one, if there were any
+func (m *Monitor) reportSuppressed() {
+	drained := m.dedup.DrainSuppressed()
+	if len(drained) == 0 {
+		return
+	}
+
+	signatures := make([]messages.ErrorSummaryEntry, 0, len(drained))
+	for _, entry := range drained {
+		signatures = append(signatures, messages.ErrorSummaryEntry{
+			SignatureHash:   entry.SignatureHash,
+			AppPath:         entry.AppPath,
+			RepoFullName:    m.repoFullName(entry.AppPath),
+			Source:          entry.Source,
+			SuppressedCount: entry.Suppressed,
+			OccurrenceCount: entry.OccurrenceCount,
+			FirstSeen:       entry.FirstSeen.UTC().Format(time.RFC3339),
+			LastSeen:        entry.LastSeen.UTC().Format(time.RFC3339),
+			SampleLine:      entry.SampleLine,
+			Level:           entry.Level,
+		})
+	}
+
+	if err := m.send(messages.NewErrorSummaryMessage(signatures)); err != nil {
+		// Keep the counts for the next summary
+		m.dedup.restoreSuppressed(drained)
+		log.Printf("Failed to send error summary: %v", err)
+		return
+	}
+	log.Printf("Sent error summary for %d rate-limited signatures", len(signatures))
+}
+
+// repoFullName returns the repo of the monitored app at appPath, "" if it
+// isn't monitored
+func (m *Monitor) repoFullName(appPath string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if appMon, ok := m.appMonitors[appPath]; ok {
+		return appMon.config.RepoFullName
+	}
+	return ""
+}
+
+// reportDropped sends a status message if events were dropped since the last one
+func (m *Monitor) reportDropped() {
+	if m.droppedUnsent.Load() == 0 {
+		return
+	}
+	m.sendStatus(nil, nil)
 }
 
-// Stop stops all monitoring
+// sendStatus sends a status message with the events dropped since the last one
+// and optionally the state of each log path and the repos with no app
+func (m *Monitor) sendStatus(logPaths []messages.LogPathStatus, unmatched []string) {
+	dropped := m.droppedUnsent.Swap(0)
+
+	msg := messages.NewMonitoringStatusMessage(dropped, m.droppedTotal.Load())
+	msg.LogPaths = logPaths
+	msg.UnmatchedApps = unmatched
+	if err := m.send(msg); err != nil {
+		// Keep the count for the next report
+		m.droppedUnsent.Add(dropped)
+		if logPaths != nil {
+			log.Printf("Failed to send monitoring status: %v", err)
+		}
+	}
+}
+
+// recordDropped counts an error event that could not be sent, logging at
+// most once per DropLogInterval
+func (m *Monitor) recordDropped(err error) {
+	m.droppedTotal.Add(1)
+	m.droppedUnsent.Add(1)
+	m.droppedLogged.Add(1)
+
+	m.dropLogMu.Lock()
+	defer m.dropLogMu.Unlock()
+
+	if time.Since(m.lastDropLog) < DropLogInterval {
+		return
+	}
+	m.lastDropLog = time.Now()
+
+	log.Printf("Dropped %d error events (last error: %v, total dropped: %d)",
+		m.droppedLogged.Swap(0), err, m.droppedTotal.Load())
+}
+
+// Stop stops all monitoring. Tailers keep running for up to the shutdown
+// grace period while a match is capturing context, then pending matches are
+// flushed so a half-captured error is still sent.
 func (m *Monitor) Stop() {
 	close(m.stopCh)
 
+	m.waitForCaptures()
+
 	m.mu.Lock()
+	m.stopAppMonitors()
+	m.mu.Unlock()
+
+	m.dedup.Stop()
+	m.wg.Wait()
+}
+
+// waitForCaptures waits up to the shutdown grace period for matches that are
+// still capturing context after lines
+func (m *Monitor) waitForCaptures() {
+	m.mu.Lock()
+	deadline := time.Now().Add(m.shutdownGrace)
+	m.mu.Unlock()
+
+	for time.Now().Before(deadline) && m.capturing() {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// capturing reports whether any matcher has a match in progress
+func (m *Monitor) capturing() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, appMon := range m.appMonitors {
+		for _, matcher := range appMon.matchers {
+			if matcher.Capturing() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stopAppMonitors stops all tailers and flushes their matchers (caller must
+// hold m.mu)
+func (m *Monitor) stopAppMonitors() {
 	for _, appMon := range m.appMonitors {
 		for _, tailer := range appMon.tailers {
 			tailer.Stop()
 		}
+		for _, matcher := range appMon.matchers {
+			matcher.Flush()
+		}
 	}
 	m.appMonitors = make(map[string]*AppMonitor)
-	m.mu.Unlock()
-
-	m.dedup.Stop()
-	m.wg.Wait()
 }
 
 // UpdateConfig updates the monitoring configuration from the cloud
@@ -86,20 +340,73 @@ func (m *Monitor) UpdateConfig(msg *messages.MonitoringConfigMessage) {
 	// Match configs to discovered apps
 	m.matchConfigsToApps()
 
-	// Restart monitoring with new config
-	m.restartMonitoring()
+	// Restart monitoring with new config and report which paths are tailed
+	// and which repos aren't deployed here (retried after each discovery)
+	statuses := m.restartMonitoring()
+	unmatched := m.unmatchedRepos()
+	for _, repoFullName := range unmatched {
+		log.Printf("No discovered app for %s, will retry after discovery", repoFullName)
+	}
+	m.sendStatus(statuses, unmatched)
+}
+
+// UpdatePatterns validates and applies new error patterns for a monitored
+// app. Its matchers are updated in place, so tailers keep their position and
+// in-progress matches keep their context.
+func (m *Monitor) UpdatePatterns(msg *messages.UpdatePatternsMessage) *messages.PatternsUpdatedMessage {
+	result := messages.NewPatternsUpdatedMessage(msg.RepoFullName)
+
+	if result.Issues = ValidatePatterns(msg.ErrorPatterns, msg.ScopedPatterns); len(result.Issues) > 0 {
+		result.Error = fmt.Sprintf("%d invalid patterns, nothing applied", len(result.Issues))
+		return result
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	config := m.configStore.GetByRepoFullName(msg.RepoFullName)
+	if config == nil || m.appMonitors[config.AppPath] == nil {
+		result.Error = fmt.Sprintf("%s is not being monitored", msg.RepoFullName)
+		return result
+	}
+
+	// Kept in the config so a later restart uses them too
+	config.ErrorPatterns = msg.ErrorPatterns
+	config.ScopedPatterns = scopedPatternMap(msg.ScopedPatterns)
+	for _, matcher := range m.appMonitors[config.AppPath].matchers {
+		matcher.UpdatePatterns(config.ErrorPatterns)
+		matcher.UpdateScopedPatterns(config.ScopedPatterns)
+	}
+
+	log.Printf("Updated error patterns for %s (%d patterns, %d scoped sources)", msg.RepoFullName, len(config.ErrorPatterns), len(config.ScopedPatterns))
+	result.Applied = true
+	return result
 }
 
 // matchConfigsToApps matches repo configs to discovered app paths
 func (m *Monitor) matchConfigsToApps() {
+	for repoFullName, appPath := range m.discoveredRepos() {
+		// Find config for this repo
+		config := m.configStore.GetByRepoFullName(repoFullName)
+		if config != nil {
+			config.AppPath = appPath
+			log.Printf("Matched repo %s to path %s", repoFullName, appPath)
+		}
+	}
+}
+
+// discoveredRepos maps the repo of each discovered app with a git remote to
+// its path
+func (m *Monitor) discoveredRepos() map[string]string {
 	if m.discovery == nil {
 		log.Printf("No discovery provider - cannot match configs to apps")
-		return
+		return nil
 	}
 
 	apps := m.discovery.GetApps()
 	log.Printf("Matching configs to %d discovered apps", len(apps))
 
+	repos := make(map[string]string)
 	for _, app := range apps {
 		if app.GitRemote == "" {
 			continue
@@ -110,34 +417,71 @@ func (m *Monitor) matchConfigsToApps() {
 		if repoFullName == "" {
 			continue
 		}
+		repos[repoFullName] = app.Path
+	}
+	return repos
+}
 
-		// Find config for this repo
-		config := m.configStore.GetByRepoFullName(repoFullName)
-		if config != nil {
-			config.AppPath = app.Path
-			log.Printf("Matched repo %s to path %s", repoFullName, app.Path)
-		}
+// unmatchedRepos returns the repos of configs with no app on this server,
+// sorted
+func (m *Monitor) unmatchedRepos() []string {
+	var repos []string
+	for _, config := range m.configStore.GetUnmatched() {
+		repos = append(repos, config.RepoFullName)
 	}
+	sort.Strings(repos)
+	return repos
 }
 
-// restartMonitoring stops current monitors and starts new ones based on config
-func (m *Monitor) restartMonitoring() {
-	// Stop existing monitors
-	for _, appMon := range m.appMonitors {
-		for _, tailer := range appMon.tailers {
-			tailer.Stop()
+// AppsUpdated retries matching the configs that had no app on this server
+// against the latest discovery, starting monitoring for any app deployed
+// since and reporting it in a status message. Apps already monitored are left
+// as they are.
+func (m *Monitor) AppsUpdated() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	unmatched := m.configStore.GetUnmatched()
+	if len(unmatched) == 0 {
+		return
+	}
+
+	repos := m.discoveredRepos()
+	statuses := []messages.LogPathStatus{}
+	for _, config := range unmatched {
+		appPath, ok := repos[config.RepoFullName]
+		if !ok {
+			continue
 		}
+		config.AppPath = appPath
+		log.Printf("Matched repo %s to path %s after discovery", config.RepoFullName, appPath)
+		statuses = append(statuses, m.startAppMonitor(config)...)
 	}
-	m.appMonitors = make(map[string]*AppMonitor)
+
+	if len(statuses) > 0 {
+		m.sendStatus(statuses, m.unmatchedRepos())
+	}
+}
+
+// restartMonitoring stops current monitors and starts new ones based on
+// config, returning the status of each log path
+func (m *Monitor) restartMonitoring() []messages.LogPathStatus {
+	// Stop existing monitors
+	m.stopAppMonitors()
 
 	// Start monitors for configured apps
+	statuses := []messages.LogPathStatus{}
 	for _, config := range m.configStore.GetConfigured() {
-		m.startAppMonitor(config)
+		statuses = append(statuses, m.startAppMonitor(config)...)
 	}
+	return statuses
 }
 
-// startAppMonitor starts monitoring for a single app
-func (m *Monitor) startAppMonitor(config *Config) {
+// startAppMonitor starts monitoring for a single app, returning the status of
+// each log path. Paths that can't be opened yet are still polled for.
+func (m *Monitor) startAppMonitor(config *Config) []messages.LogPathStatus {
+	var statuses []messages.LogPathStatus
+
 	appMon := &AppMonitor{
 		config:   config,
 		tailers:  make([]*Tailer, 0),
@@ -147,14 +491,33 @@ func (m *Monitor) startAppMonitor(config *Config) {
 	log.Printf("Starting log monitor for %s at %s", config.RepoFullName, config.AppPath)
 
 	// Create a matcher for this app
-	matcher := NewMatcher(config.ErrorPatterns, config.ContextLines, func(match Match) {
+	matcher := NewMatcherWithContext(config.ErrorPatterns, config.contextBefore(), config.contextAfter(), func(match Match) {
 		m.handleMatch(config, match)
 	})
+	matcher.UpdateScopedPatterns(config.ScopedPatterns)
+	matcher.SetParser(ParserFor(config.Framework))
+	if minLevel, err := ParseMinLevel(config.MinLevel); err != nil {
+		log.Printf("Warning: invalid min_level for %s: %v", config.RepoFullName, err)
+	} else {
+		matcher.SetMinLevel(minLevel)
+	}
+	patternLevels, err := ParsePatternLevels(config.PatternLevels)
+	if err != nil {
+		log.Printf("Warning: invalid pattern_levels for %s: %v", config.RepoFullName, err)
+	}
+	matcher.SetPatternLevels(patternLevels)
+	if continuation, err := CompileContinuation(config.MultilineContinuation); err != nil {
+		log.Printf("Warning: %v; grouping disabled for %s", err, config.RepoFullName)
+	} else {
+		matcher.SetContinuation(continuation)
+	}
+	matcher.SetMatchStreams(config.MatchStreams)
 	appMon.matchers = append(appMon.matchers, matcher)
 
 	// Create tailers for each log path
 	for _, logPath := range config.LogPaths {
 		fullPath := filepath.Join(config.AppPath, logPath)
+		stream := config.LogStreams[logPath]
 
 		// Handle glob patterns
 		matches, err := filepath.Glob(fullPath)
@@ -165,26 +528,52 @@ func (m *Monitor) startAppMonitor(config *Config) {
 
 		for _, path := range matches {
 			tailer := NewTailer(path, func(source, line string) {
-				matcher.ProcessLine(source, line)
+				matcher.ProcessStreamLine(source, stream, line)
 			})
+			if config.GrowthFactor > 0 {
+				source := filepath.Base(path)
+				tailer.SetGrowthDetector(NewGrowthDetector(config.GrowthFactor, config.GrowthMinRate, func(rate, baseline float64) {
+					m.handleGrowthAnomaly(config, source, rate, baseline)
+				}))
+			}
+			if !m.tailedPaths[path] {
+				tailer.SetBackfill(config.BackfillBytes)
+				m.tailedPaths[path] = true
+			}
 
+			status := messages.LogPathStatus{AppPath: config.AppPath, Path: path, Status: messages.LogPathStarted}
 			if err := tailer.Start(); err != nil {
-				log.Printf("Failed to start tailer for %s: %v", path, err)
-				continue
+				status.Status = logPathStatus(err)
+				status.Error = err.Error()
+			} else {
+				log.Printf("  Tailing: %s", path)
 			}
 
 			appMon.tailers = append(appMon.tailers, tailer)
-			log.Printf("  Tailing: %s", path)
+			statuses = append(statuses, status)
 		}
 	}
 
 	m.appMonitors[config.AppPath] = appMon
+	return statuses
+}
+
+// logPathStatus classifies the error from opening a log file
+func logPathStatus(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return messages.LogPathNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return messages.LogPathFailedPermission
+	default:
+		return messages.LogPathFailed
+	}
 }
 
 // handleMatch handles a matched error
 func (m *Monitor) handleMatch(config *Config, match Match) {
 	// Check deduplication
-	shouldEmit, entry := m.dedup.ShouldEmit(match.ErrorLine)
+	shouldEmit, entry := m.dedup.ShouldEmitLevel(config.AppPath, match.Source, match.Level, match.signatureLine(), match.ErrorLine)
 	if !shouldEmit {
 		log.Printf("Suppressed duplicate error (count: %d): %s",
 			entry.OccurrenceCount, truncate(match.ErrorLine, 80))
@@ -203,16 +592,30 @@ func (m *Monitor) handleMatch(config *Config, match Match) {
 		entry.FirstSeen.UTC().Format(time.RFC3339),
 		entry.SignatureHash,
 	)
+	msg.Severity = match.Severity
+	msg.Message = match.Message
+	msg.Level = match.Level
+	msg.Stream = match.Stream
 
 	// Send to cloud
 	if err := m.send(msg); err != nil {
-		log.Printf("Failed to send error event: %v", err)
+		m.recordDropped(err)
 		return
 	}
 
 	log.Printf("Sent error event: %s (count: %d)", truncate(match.ErrorLine, 60), entry.OccurrenceCount)
 }
 
+// handleGrowthAnomaly reports a log file growing abnormally fast
+func (m *Monitor) handleGrowthAnomaly(config *Config, source string, rate, baseline float64) {
+	log.Printf("Log growth anomaly in %s: %.0f bytes/sec (baseline %.0f bytes/sec)", source, rate, baseline)
+
+	msg := messages.NewLogGrowthAnomalyMessage(config.AppPath, config.RepoFullName, source, rate, baseline)
+	if err := m.send(msg); err != nil {
+		log.Printf("Failed to send log growth anomaly: %v", err)
+	}
+}
+
 // extractRepoFullName extracts "owner/repo" from a git remote URL
 func extractRepoFullName(gitRemote string) string {
 	// Handle SSH format: git@github.com:owner/repo.git
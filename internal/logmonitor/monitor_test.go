@@ -0,0 +1,635 @@
+package logmonitor
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// flakySender fails error event sends while failing is set and records
+// status messages
+type flakySender struct {
+	mu        sync.Mutex
+	failing   bool
+	statuses  []*messages.MonitoringStatusMessage
+	events    []*messages.ErrorEventMessage
+	summaries []*messages.ErrorSummaryMessage
+}
+
+func (s *flakySender) send(msg interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m := msg.(type) {
+	case *messages.ErrorEventMessage:
+		if s.failing {
+			return errors.New("send buffer full")
+		}
+		s.events = append(s.events, m)
+	case *messages.MonitoringStatusMessage:
+		s.statuses = append(s.statuses, m)
+	case *messages.ErrorSummaryMessage:
+		s.summaries = append(s.summaries, m)
+	}
+	return nil
+}
+
+func (s *flakySender) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *flakySender) errorEvents() []*messages.ErrorEventMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*messages.ErrorEventMessage{}, s.events...)
+}
+
+func (s *flakySender) summaryMessages() []*messages.ErrorSummaryMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*messages.ErrorSummaryMessage{}, s.summaries...)
+}
+
+func (s *flakySender) statusMessages() []*messages.MonitoringStatusMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*messages.MonitoringStatusMessage{}, s.statuses...)
+}
+
+func TestMonitorDroppedEventsCounted(t *testing.T) {
+	sender := &flakySender{failing: true}
+	m := NewMonitor(sender.send, nil)
+	config := &Config{AppPath: "/var/www/app", RepoFullName: "acme/app"}
+
+	for i := 0; i < 3; i++ {
+		m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: fmt.Sprintf("ERROR: failure kind %c", 'a'+i)})
+	}
+
+	if dropped := m.DroppedEvents(); dropped != 3 {
+		t.Errorf("expected 3 dropped events, got %d", dropped)
+	}
+
+	// Successful sends don't count as dropped
+	sender.setFailing(false)
+	m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: "ERROR: something else"})
+
+	if dropped := m.DroppedEvents(); dropped != 3 {
+		t.Errorf("expected dropped count to stay at 3, got %d", dropped)
+	}
+}
+
+func TestMonitorReportsDroppedEvents(t *testing.T) {
+	sender := &flakySender{failing: true}
+	m := NewMonitor(sender.send, nil)
+	m.statusInterval = 20 * time.Millisecond
+	m.Start()
+	defer m.Stop()
+
+	config := &Config{AppPath: "/var/www/app", RepoFullName: "acme/app"}
+	m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: "ERROR: first"})
+	m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: "ERROR: second"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(sender.statusMessages()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	statuses := sender.statusMessages()
+	if len(statuses) == 0 {
+		t.Fatal("expected a monitoring status message")
+	}
+	if statuses[0].DroppedEvents != 2 || statuses[0].DroppedTotal != 2 {
+		t.Errorf("expected 2 dropped events reported, got %+v", statuses[0])
+	}
+
+	// No further status messages without new drops
+	time.Sleep(100 * time.Millisecond)
+	if n := len(sender.statusMessages()); n != 1 {
+		t.Errorf("expected a single status message, got %d", n)
+	}
+}
+
+func TestMonitorDedupDump(t *testing.T) {
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, nil)
+	config := &Config{AppPath: "/var/www/app", RepoFullName: "acme/app"}
+
+	lines := []string{
+		"[2026-01-13 17:52:46] production.ERROR: SQLSTATE[HY000] Connection refused",
+		"[2026-01-13 17:53:10] production.ERROR: SQLSTATE[HY000] Connection refused",
+		"[2026-01-13 17:54:02] production.ERROR: Undefined index: user",
+		"[2026-01-13 17:55:31] production.ERROR: SQLSTATE[HY000] Connection refused",
+	}
+	for _, line := range lines {
+		m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: line})
+	}
+
+	dump := m.DedupDump()
+	if dump.Type != messages.TypeDedupEntries {
+		t.Errorf("expected type %s, got %s", messages.TypeDedupEntries, dump.Type)
+	}
+	if dump.Total != 2 || len(dump.Entries) != 2 || dump.Truncated {
+		t.Fatalf("expected 2 entries, got %+v", dump)
+	}
+
+	// Most frequent first
+	first, second := dump.Entries[0], dump.Entries[1]
+	if first.OccurrenceCount != 3 || second.OccurrenceCount != 1 {
+		t.Errorf("expected counts 3 and 1, got %d and %d", first.OccurrenceCount, second.OccurrenceCount)
+	}
+	if first.SampleLine != lines[0] {
+		t.Errorf("expected first line as sample, got %q", first.SampleLine)
+	}
+	if first.SignatureHash == "" || first.FirstSeen == "" || first.LastSeen == "" {
+		t.Errorf("expected signature and timestamps, got %+v", first)
+	}
+}
+
+func TestMonitorDedupDumpBounded(t *testing.T) {
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, nil)
+	config := &Config{AppPath: "/var/www/app"}
+
+	long := strings.Repeat("x", MaxSampleLineLength*2)
+	for i := 0; i < MaxDedupDumpEntries+10; i++ {
+		m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: fmt.Sprintf("ERROR: kind %c%c %s", 'a'+i/26, 'a'+i%26, long)})
+	}
+
+	dump := m.DedupDump()
+	if len(dump.Entries) != MaxDedupDumpEntries {
+		t.Errorf("expected %d entries, got %d", MaxDedupDumpEntries, len(dump.Entries))
+	}
+	if dump.Total != MaxDedupDumpEntries+10 || !dump.Truncated {
+		t.Errorf("expected truncated dump of %d entries, got total %d truncated %t", MaxDedupDumpEntries+10, dump.Total, dump.Truncated)
+	}
+	if n := len(dump.Entries[0].SampleLine); n > MaxSampleLineLength {
+		t.Errorf("expected sample line of at most %d bytes, got %d", MaxSampleLineLength, n)
+	}
+}
+
+func TestMonitorStopFlushesPendingMatch(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, nil)
+	m.SetShutdownGrace(100 * time.Millisecond)
+	m.Start()
+
+	config := &Config{AppPath: dir, LogPaths: []string{"app.log"}, ErrorPatterns: []string{"ERROR"}, ContextAfter: 10}
+	m.mu.Lock()
+	m.startAppMonitor(config)
+	m.mu.Unlock()
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("ERROR: worker crashed\n#0 stack frame\n")
+
+	// Wait for the match to start capturing (needs 10 lines after, gets 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && !m.capturing() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !m.capturing() {
+		t.Fatal("expected a match in progress")
+	}
+
+	// Written just before shutdown, read by the final pass
+	f.WriteString("#1 stack frame\n")
+
+	m.Stop()
+
+	events := sender.errorEvents()
+	if len(events) != 1 {
+		t.Fatalf("expected the pending match to be sent on stop, got %d events", len(events))
+	}
+	if events[0].ErrorLine != "ERROR: worker crashed" {
+		t.Errorf("unexpected error line %q", events[0].ErrorLine)
+	}
+	if len(events[0].ContextAfter) != 2 || events[0].ContextAfter[1] != "#1 stack frame" {
+		t.Errorf("expected both stack frames as context, got %v", events[0].ContextAfter)
+	}
+}
+
+// staticDiscovery returns a fixed list of apps
+type staticDiscovery []messages.AppInfo
+
+func (d staticDiscovery) GetApps() []messages.AppInfo {
+	return d
+}
+
+func TestMonitorReportsLogPathStatus(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"laravel.log", "secret.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Root can read any file, so deny secret.log at open
+	secretPath := filepath.Join(dir, "secret.log")
+	original := openLogFile
+	openLogFile = func(name string) (*os.File, error) {
+		if name == secretPath {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		return original(name)
+	}
+	defer func() { openLogFile = original }()
+
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, staticDiscovery{{Path: dir, GitRemote: "git@github.com:acme/app.git"}})
+	m.SetShutdownGrace(0)
+	m.Start()
+	defer m.Stop()
+
+	m.UpdateConfig(&messages.MonitoringConfigMessage{
+		Type: messages.TypeMonitoringConfig,
+		Apps: []messages.MonitoringAppConfig{{
+			RepoFullName:  "acme/app",
+			LogPaths:      []string{"laravel.log", "secret.log", "missing.log"},
+			ErrorPatterns: []string{"ERROR"},
+		}},
+	})
+
+	statuses := sender.statusMessages()
+	if len(statuses) != 1 {
+		t.Fatalf("expected a status message after applying the config, got %d", len(statuses))
+	}
+
+	got := make(map[string]messages.LogPathStatus)
+	for _, status := range statuses[0].LogPaths {
+		got[filepath.Base(status.Path)] = status
+	}
+
+	expected := map[string]string{
+		"laravel.log": messages.LogPathStarted,
+		"secret.log":  messages.LogPathFailedPermission,
+		"missing.log": messages.LogPathNotFound,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d log path statuses, got %+v", len(expected), statuses[0].LogPaths)
+	}
+	for name, status := range expected {
+		if got[name].Status != status {
+			t.Errorf("expected %s to be %s, got %+v", name, status, got[name])
+		}
+		if got[name].AppPath != dir {
+			t.Errorf("expected app path %s for %s, got %s", dir, name, got[name].AppPath)
+		}
+	}
+	if got["secret.log"].Error == "" {
+		t.Error("expected an error message for the denied path")
+	}
+}
+
+func TestMonitorUpdatePatternsLive(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, staticDiscovery{{Path: dir, GitRemote: "git@github.com:acme/app.git"}})
+	m.SetShutdownGrace(0)
+	m.Start()
+	defer m.Stop()
+
+	m.UpdateConfig(&messages.MonitoringConfigMessage{
+		Type: messages.TypeMonitoringConfig,
+		Apps: []messages.MonitoringAppConfig{{
+			RepoFullName:  "acme/app",
+			LogPaths:      []string{"app.log"},
+			ErrorPatterns: []string{"ERROR"},
+			ContextAfter:  1,
+		}},
+	})
+	m.mu.Lock()
+	tailer := m.appMonitors[dir].tailers[0]
+	m.mu.Unlock()
+
+	result := m.UpdatePatterns(&messages.UpdatePatternsMessage{
+		Type:          messages.TypeUpdatePatterns,
+		RepoFullName:  "acme/app",
+		ErrorPatterns: []string{"FATAL"},
+	})
+	if !result.Applied || result.Error != "" {
+		t.Fatalf("expected the patterns to be applied, got %+v", result)
+	}
+
+	m.mu.Lock()
+	sameTailer := m.appMonitors[dir].tailers[0] == tailer
+	m.mu.Unlock()
+	if !sameTailer {
+		t.Error("expected the tailer to keep running")
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.WriteString("ERROR: no longer an error\nFATAL: out of memory\ncontext\n")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(sender.errorEvents()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := sender.errorEvents()
+	if len(events) != 1 || events[0].ErrorLine != "FATAL: out of memory" {
+		t.Fatalf("expected only the FATAL line to match, got %+v", events)
+	}
+}
+
+func TestMonitorUpdatePatternsRejected(t *testing.T) {
+	m := NewMonitor((&flakySender{}).send, nil)
+
+	result := m.UpdatePatterns(&messages.UpdatePatternsMessage{
+		RepoFullName:   "acme/app",
+		ErrorPatterns:  []string{"ERROR", " "},
+		ScopedPatterns: []messages.ScopedErrorPatterns{{Source: "[worker.log", Patterns: []string{"panic"}}},
+	})
+	if result.Applied || len(result.Issues) != 2 {
+		t.Fatalf("expected 2 issues and nothing applied, got %+v", result)
+	}
+	if result.Issues[1].Source != "[worker.log" {
+		t.Errorf("expected the bad glob to be reported, got %+v", result.Issues[1])
+	}
+
+	result = m.UpdatePatterns(&messages.UpdatePatternsMessage{RepoFullName: "acme/unknown", ErrorPatterns: []string{"ERROR"}})
+	if result.Applied || !strings.Contains(result.Error, "not being monitored") {
+		t.Errorf("expected an unmonitored app to be refused, got %+v", result)
+	}
+}
+
+func TestMonitorGroupsStackTraces(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "php_errors.log")
+	if err := os.WriteFile(logPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, staticDiscovery{{Path: dir, GitRemote: "git@github.com:acme/app.git"}})
+	m.SetShutdownGrace(time.Second)
+	m.Start()
+
+	m.UpdateConfig(&messages.MonitoringConfigMessage{
+		Type: messages.TypeMonitoringConfig,
+		Apps: []messages.MonitoringAppConfig{{
+			RepoFullName:          "acme/app",
+			LogPaths:              []string{"php_errors.log"},
+			ErrorPatterns:         []string{"Fatal error", "Exception"},
+			ContextAfter:          1,
+			MultilineContinuation: "default",
+		}},
+	})
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The same exception twice: one event each, sharing a signature
+	trace := strings.Join(phpStackTrace, "\n") + "\n"
+	f.WriteString(trace + "request finished\n" + trace + "request finished\n")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(sender.errorEvents()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.Stop()
+
+	events := sender.errorEvents()
+	if len(events) != 2 {
+		t.Fatalf("expected one error event per stack trace, got %d", len(events))
+	}
+	if events[0].ErrorLine != strings.Join(phpStackTrace, "\n") {
+		t.Errorf("expected the whole stack trace as the error line, got %q", events[0].ErrorLine)
+	}
+	if events[1].SignatureHash != events[0].SignatureHash || events[1].OccurrenceCount != 2 {
+		t.Errorf("expected the second trace to repeat the first's signature, got %+v", events[1])
+	}
+}
+
+func TestMonitorBackfillsOnFirstStart(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "laravel.log")
+	old := "[2026-01-13 17:52:46] production.INFO: request started\n" +
+		"[2026-01-13 17:52:47] production.ERROR: Connection refused\n" +
+		"[2026-01-13 17:52:48] production.INFO: request finished\n"
+	if err := os.WriteFile(logPath, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, staticDiscovery{{Path: dir, GitRemote: "git@github.com:acme/app.git"}})
+	m.Start()
+	defer m.Stop()
+
+	config := &messages.MonitoringConfigMessage{
+		Type: messages.TypeMonitoringConfig,
+		Apps: []messages.MonitoringAppConfig{{
+			RepoFullName:  "acme/app",
+			LogPaths:      []string{"laravel.log"},
+			ErrorPatterns: []string{"ERROR"},
+			ContextAfter:  1,
+			BackfillBytes: 4096,
+		}},
+	}
+	m.UpdateConfig(config)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(sender.errorEvents()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	events := sender.errorEvents()
+	if len(events) != 1 || !strings.Contains(events[0].ErrorLine, "Connection refused") {
+		t.Fatalf("expected the error logged before start to be reported, got %+v", events)
+	}
+
+	// A config reload restarts the tailer without reading the old lines again
+	m.UpdateConfig(config)
+	time.Sleep(300 * time.Millisecond)
+	if got := len(sender.errorEvents()); got != 1 {
+		t.Errorf("expected no backfill on config reload, got %d events", got)
+	}
+}
+
+func TestMonitorSummarizesSuppressedErrors(t *testing.T) {
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, nil)
+	m.summaryInterval = 20 * time.Millisecond
+
+	config := &Config{AppPath: "/var/www/app", RepoFullName: "acme/app"}
+	m.appMonitors[config.AppPath] = &AppMonitor{config: config}
+	for i := 0; i < DefaultMaxPerWindow+40; i++ {
+		m.handleMatch(config, Match{Source: "laravel.log", ErrorLine: "ERROR: Redis connection refused", Level: LevelError})
+	}
+	m.Start()
+	defer m.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && len(sender.summaryMessages()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	summaries := sender.summaryMessages()
+	if len(summaries) == 0 {
+		t.Fatal("expected an error summary message")
+	}
+	if len(sender.errorEvents()) != DefaultMaxPerWindow {
+		t.Errorf("expected %d error events before rate limiting, got %d", DefaultMaxPerWindow, len(sender.errorEvents()))
+	}
+	if len(summaries[0].Signatures) != 1 {
+		t.Fatalf("expected one summarized signature, got %+v", summaries[0].Signatures)
+	}
+	summary := summaries[0].Signatures[0]
+	if summary.SuppressedCount != 40 || summary.OccurrenceCount != DefaultMaxPerWindow+40 {
+		t.Errorf("expected 40 suppressed of %d occurrences, got %+v", DefaultMaxPerWindow+40, summary)
+	}
+	if summary.SignatureHash != sender.errorEvents()[0].SignatureHash || summary.RepoFullName != "acme/app" || summary.Source != "laravel.log" {
+		t.Errorf("expected the summary to identify the signature's events, got %+v", summary)
+	}
+
+	// Nothing more is summarized without new suppressed occurrences
+	time.Sleep(100 * time.Millisecond)
+	if n := len(sender.summaryMessages()); n != 1 {
+		t.Errorf("expected a single summary message, got %d", n)
+	}
+}
+
+func TestMonitorReportsUnmatchedConfigs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "laravel.log"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// acme/api isn't deployed yet
+	discovery := &staticDiscovery{{Path: t.TempDir(), GitRemote: "git@github.com:acme/app.git"}}
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, discovery)
+	m.SetShutdownGrace(0)
+	m.Start()
+	defer m.Stop()
+
+	m.UpdateConfig(&messages.MonitoringConfigMessage{
+		Type: messages.TypeMonitoringConfig,
+		Apps: []messages.MonitoringAppConfig{
+			{RepoFullName: "acme/app", LogPaths: []string{"app.log"}, ErrorPatterns: []string{"ERROR"}},
+			{RepoFullName: "acme/api", LogPaths: []string{"laravel.log"}, ErrorPatterns: []string{"ERROR"}},
+		},
+	})
+
+	statuses := sender.statusMessages()
+	if len(statuses) != 1 {
+		t.Fatalf("expected a status message after applying the config, got %d", len(statuses))
+	}
+	if unmatched := statuses[0].UnmatchedApps; len(unmatched) != 1 || unmatched[0] != "acme/api" {
+		t.Errorf("expected acme/api to be reported unmatched, got %v", unmatched)
+	}
+
+	// A discovery without it changes nothing
+	m.AppsUpdated()
+	if got := len(sender.statusMessages()); got != 1 {
+		t.Fatalf("expected no status while still unmatched, got %d", got)
+	}
+
+	// Deployed later
+	*discovery = append(*discovery, messages.AppInfo{Path: dir, GitRemote: "https://github.com/acme/api.git"})
+	m.AppsUpdated()
+
+	statuses = sender.statusMessages()
+	if len(statuses) != 2 {
+		t.Fatalf("expected a status once the app is discovered, got %d", len(statuses))
+	}
+	if len(statuses[1].UnmatchedApps) != 0 {
+		t.Errorf("expected nothing left unmatched, got %v", statuses[1].UnmatchedApps)
+	}
+	if paths := statuses[1].LogPaths; len(paths) != 1 || paths[0].AppPath != dir || paths[0].Status != messages.LogPathStarted {
+		t.Errorf("expected the new app's log to be started, got %+v", paths)
+	}
+	if files := m.LogFiles(); len(files) != 2 {
+		t.Errorf("expected both apps to be monitored, got %v", files)
+	}
+}
+
+func TestMonitorMatchesOnlyChosenStreams(t *testing.T) {
+	dir := t.TempDir()
+	outPath := filepath.Join(dir, "worker.out.log")
+	errPath := filepath.Join(dir, "worker.err.log")
+	for _, path := range []string{outPath, errPath} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sender := &flakySender{}
+	m := NewMonitor(sender.send, staticDiscovery{{Path: dir, GitRemote: "git@github.com:acme/app.git"}})
+	m.SetShutdownGrace(time.Second)
+	m.Start()
+
+	m.UpdateConfig(&messages.MonitoringConfigMessage{
+		Type: messages.TypeMonitoringConfig,
+		Apps: []messages.MonitoringAppConfig{{
+			RepoFullName:  "acme/app",
+			LogPaths:      []string{"worker.out.log", "worker.err.log"},
+			ErrorPatterns: []string{"error"},
+			ContextBefore: 5,
+			ContextAfter:  1,
+			LogStreams:    map[string]string{"worker.out.log": StreamStdout, "worker.err.log": StreamStderr},
+			MatchStreams:  []string{StreamStderr},
+		}},
+	})
+
+	appendLine := func(path, line string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.WriteString(line + "\n")
+	}
+
+	// The stdout line only provides context for the stderr one
+	appendLine(outPath, "retrying after error")
+	time.Sleep(300 * time.Millisecond)
+	appendLine(errPath, "fatal error: out of memory\nworker exited")
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(sender.errorEvents()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.Stop()
+
+	events := sender.errorEvents()
+	if len(events) != 1 {
+		t.Fatalf("expected only the stderr line to match, got %d events: %+v", len(events), events)
+	}
+	if events[0].ErrorLine != "fatal error: out of memory" || events[0].Stream != StreamStderr {
+		t.Errorf("expected the stderr error, got %+v", events[0])
+	}
+	if len(events[0].ContextBefore) != 1 || events[0].ContextBefore[0] != "retrying after error" {
+		t.Errorf("expected the stdout line as context, got %v", events[0].ContextBefore)
+	}
+}
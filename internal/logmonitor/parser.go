@@ -0,0 +1,139 @@
+package logmonitor
+
+import (
+	"regexp"
+	"strings"
+)
+
+// ParsedLine is a log line split into the fields a framework writes
+type ParsedLine struct {
+	Severity string // upper-case level, e.g. "ERROR"
+	Message  string // the message without timestamp, channel, or context
+}
+
+// Parser extracts the severity and message from a framework's log line,
+// returning false for lines not in the framework's format (e.g. stack
+// trace lines)
+type Parser func(line string) (ParsedLine, bool)
+
+// ParserFor returns the log parser for a framework, or nil if there is none
+func ParserFor(framework string) Parser {
+	switch strings.ToLower(framework) {
+	case "laravel":
+		return ParseLaravelLine
+	case "rails":
+		return ParseRailsLine
+	case "django":
+		return ParseDjangoLine
+	default:
+		return nil
+	}
+}
+
+var (
+	// [2026-01-13 17:52:46] production.ERROR: message {"exception":"..."} []
+	laravelLine = regexp.MustCompile(`^\[[^\]]+\]\s+[\w-]+\.([A-Za-z]+):\s?(.*)$`)
+
+	// E, [2026-01-13T17:52:46.123456 #12345] ERROR -- : message
+	railsLine = regexp.MustCompile(`^[A-Z], \[[^\]]+\]\s+([A-Za-z]+) -- [^:]*: ?(.*)$`)
+
+	// Leading tags added by Rails tagged logging: [request-id] [subdomain]
+	railsTags = regexp.MustCompile(`^(\[[^\]]*\]\s*)+`)
+
+	// [13/Jan/2026 17:52:46] ERROR [django.request:241] message
+	djangoBracketLine = regexp.MustCompile(`^\[[^\]]+\]\s+([A-Z]+)\s+\[[^\]]+\]\s+(.*)$`)
+
+	// ERROR 2026-01-13 17:52:46,123 module message
+	djangoVerboseLine = regexp.MustCompile(`^([A-Z]+)\s+\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:,\d+)?\s+\S+\s+(.*)$`)
+)
+
+// ParseLaravelLine parses a Monolog line as written by Laravel, dropping the
+// JSON context and extra arrays that follow the message
+func ParseLaravelLine(line string) (ParsedLine, bool) {
+	m := laravelLine.FindStringSubmatch(line)
+	if m == nil {
+		return ParsedLine{}, false
+	}
+
+	return ParsedLine{
+		Severity: strings.ToUpper(m[1]),
+		Message:  stripMonologContext(m[2]),
+	}, true
+}
+
+// ParseRailsLine parses a line from Ruby's default Logger formatter, dropping
+// tagged logging tags
+func ParseRailsLine(line string) (ParsedLine, bool) {
+	m := railsLine.FindStringSubmatch(line)
+	if m == nil {
+		return ParsedLine{}, false
+	}
+
+	return ParsedLine{
+		Severity: strings.ToUpper(m[1]),
+		Message:  strings.TrimSpace(railsTags.ReplaceAllString(m[2], "")),
+	}, true
+}
+
+// ParseDjangoLine parses Django's runserver format and the "verbose" format
+// from the Django logging docs
+func ParseDjangoLine(line string) (ParsedLine, bool) {
+	m := djangoBracketLine.FindStringSubmatch(line)
+	if m == nil {
+		m = djangoVerboseLine.FindStringSubmatch(line)
+	}
+	if m == nil {
+		return ParsedLine{}, false
+	}
+
+	return ParsedLine{
+		Severity: m[1],
+		Message:  strings.TrimSpace(m[2]),
+	}, true
+}
+
+// stripMonologContext removes the trailing context and extra fields Monolog
+// appends to a message: ` {"key":"value"} []`, ` [] []`, etc.
+func stripMonologContext(message string) string {
+	message = strings.TrimSpace(message)
+
+	for i := 0; i < 2; i++ {
+		switch {
+		case strings.HasSuffix(message, " []"):
+			message = strings.TrimSuffix(message, " []")
+		case strings.HasSuffix(message, "}"):
+			idx := strings.LastIndex(message, ` {"`)
+			if idx < 0 {
+				return message
+			}
+			message = message[:idx]
+		default:
+			return message
+		}
+		message = strings.TrimSpace(message)
+	}
+
+	return message
+}
+
+// severityLevels are the level names frameworks write; a pattern equal to one
+// of them is compared against a parsed line's severity rather than its text
+var severityLevels = map[string]bool{
+	"DEBUG": true, "INFO": true, "NOTICE": true, "WARN": true, "WARNING": true,
+	"ERROR": true, "CRITICAL": true, "ALERT": true, "EMERGENCY": true, "FATAL": true,
+}
+
+// matchesParsed checks a parsed line against a pattern: level patterns must
+// equal the severity, other patterns must appear in the message or, failing
+// that, anywhere in the raw line (so patterns on the channel or context, like
+// "production.ERROR" or "PDOException", keep matching). lineLower is the raw
+// line in lower case.
+func matchesParsed(parsed ParsedLine, lineLower, pattern string) bool {
+	upper := strings.ToUpper(pattern)
+	if severityLevels[upper] {
+		return parsed.Severity == upper
+	}
+	patternLower := strings.ToLower(pattern)
+	return strings.Contains(strings.ToLower(parsed.Message), patternLower) ||
+		strings.Contains(lineLower, patternLower)
+}
@@ -0,0 +1,165 @@
+package logmonitor
+
+import "testing"
+
+func TestParseLaravelLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		severity string
+		message  string
+	}{
+		{
+			line:     `[2026-01-13 17:52:46] production.ERROR: SQLSTATE[HY000] [2002] Connection refused {"userId":42,"exception":"[object] (PDOException(code: 2002): Connection refused at /var/www/app/vendor/laravel/framework/src/Illuminate/Database/Connectors/Connector.php:70)"} []`,
+			severity: "ERROR",
+			message:  "SQLSTATE[HY000] [2002] Connection refused",
+		},
+		{
+			line:     `[2026-01-13 17:52:46] local.CRITICAL: Queue worker crashed [] []`,
+			severity: "CRITICAL",
+			message:  "Queue worker crashed",
+		},
+		{
+			line:     `[2026-01-13 17:52:46] production.INFO: User {id} logged in`,
+			severity: "INFO",
+			message:  "User {id} logged in",
+		},
+	}
+
+	for _, tt := range tests {
+		parsed, ok := ParseLaravelLine(tt.line)
+		if !ok {
+			t.Errorf("expected %q to parse", tt.line)
+			continue
+		}
+		if parsed.Severity != tt.severity {
+			t.Errorf("expected severity %q, got %q", tt.severity, parsed.Severity)
+		}
+		if parsed.Message != tt.message {
+			t.Errorf("expected message %q, got %q", tt.message, parsed.Message)
+		}
+	}
+
+	// Stack trace lines aren't Laravel log entries
+	if _, ok := ParseLaravelLine("#0 /var/www/app/vendor/laravel/framework/src/Illuminate/Database/Connection.php(368): PDO->prepare()"); ok {
+		t.Error("expected stack trace line not to parse")
+	}
+}
+
+func TestParseRailsLine(t *testing.T) {
+	tests := []struct {
+		line     string
+		severity string
+		message  string
+	}{
+		{
+			line:     `E, [2026-01-13T17:52:46.123456 #12345] ERROR -- : [8f3c2a1e-5b7d-4c9a-a1e2-3f4b5c6d7e8f] ActiveRecord::RecordNotFound (Couldn't find User with 'id'=42):`,
+			severity: "ERROR",
+			message:  "ActiveRecord::RecordNotFound (Couldn't find User with 'id'=42):",
+		},
+		{
+			line:     `F, [2026-01-13T17:52:46.123456 #12345] FATAL -- : NoMethodError (undefined method 'name' for nil):`,
+			severity: "FATAL",
+			message:  "NoMethodError (undefined method 'name' for nil):",
+		},
+		{
+			line:     `I, [2026-01-13T17:52:46.123456 #12345]  INFO -- : Completed 200 OK in 12ms`,
+			severity: "INFO",
+			message:  "Completed 200 OK in 12ms",
+		},
+	}
+
+	for _, tt := range tests {
+		parsed, ok := ParseRailsLine(tt.line)
+		if !ok {
+			t.Errorf("expected %q to parse", tt.line)
+			continue
+		}
+		if parsed.Severity != tt.severity {
+			t.Errorf("expected severity %q, got %q", tt.severity, parsed.Severity)
+		}
+		if parsed.Message != tt.message {
+			t.Errorf("expected message %q, got %q", tt.message, parsed.Message)
+		}
+	}
+
+	if _, ok := ParseRailsLine("app/controllers/users_controller.rb:12:in `show'"); ok {
+		t.Error("expected backtrace line not to parse")
+	}
+}
+
+func TestParseDjangoLine(t *testing.T) {
+	lines := []string{
+		`[13/Jan/2026 17:52:46] ERROR [django.request:241] Internal Server Error: /api/users/`,
+		`ERROR 2026-01-13 17:52:46,123 log Internal Server Error: /api/users/`,
+	}
+
+	for _, line := range lines {
+		parsed, ok := ParseDjangoLine(line)
+		if !ok {
+			t.Errorf("expected %q to parse", line)
+			continue
+		}
+		if parsed.Severity != "ERROR" || parsed.Message != "Internal Server Error: /api/users/" {
+			t.Errorf("unexpected parse of %q: %+v", line, parsed)
+		}
+	}
+}
+
+func TestParserFor(t *testing.T) {
+	for _, framework := range []string{"laravel", "rails", "django", "Laravel"} {
+		if ParserFor(framework) == nil {
+			t.Errorf("expected a parser for %s", framework)
+		}
+	}
+	if ParserFor("nodejs") != nil || ParserFor("") != nil {
+		t.Error("expected no parser for unknown frameworks")
+	}
+}
+
+func TestMatcherWithParser(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"ERROR", "Exception"}, 3, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetParser(ParserFor("laravel"))
+
+	// "error" in an INFO message or its context isn't an error
+	matcher.ProcessLine("laravel.log", `[2026-01-13 17:52:45] production.INFO: Retrying after error {"attempt":2}`)
+	matcher.ProcessLine("laravel.log", `[2026-01-13 17:52:46] production.ERROR: Payment failed {"order":1001}`)
+	matcher.ProcessLine("laravel.log", `[2026-01-13 17:52:47] production.WARNING: Slow query {"ms":1200}`)
+	matcher.Flush()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d", len(matches))
+	}
+	if matches[0].Severity != "ERROR" || matches[0].Message != "Payment failed" {
+		t.Errorf("expected parsed fields on match, got %+v", matches[0])
+	}
+
+	// Context differs per occurrence but the signature doesn't
+	other := Match{ErrorLine: `[2026-01-13 18:00:00] production.ERROR: Payment failed {"order":1002}`, Severity: "ERROR", Message: "Payment failed"}
+	if matches[0].signatureLine() != other.signatureLine() {
+		t.Errorf("expected equal signatures, got %q and %q", matches[0].signatureLine(), other.signatureLine())
+	}
+}
+
+func TestMatcherWithParser_RawLineFallback(t *testing.T) {
+	var matches []Match
+	matcher := NewMatcher([]string{"production.ERROR", "PDOException"}, 3, func(m Match) {
+		matches = append(matches, m)
+	})
+	matcher.SetParser(ParserFor("laravel"))
+
+	// Patterns on the channel or the context still match the raw line
+	matcher.ProcessLine("laravel.log", `[2026-01-13 17:52:46] production.ERROR: Payment failed {"order":1001}`)
+	matcher.ProcessLine("laravel.log", `[2026-01-13 17:52:47] staging.WARNING: Query failed {"exception":"[object] (PDOException(code: 2002))"}`)
+	matcher.ProcessLine("laravel.log", `[2026-01-13 17:52:48] staging.ERROR: Payment failed {"order":1002}`)
+	matcher.Flush()
+
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 matches, got %d: %+v", len(matches), matches)
+	}
+	if matches[0].Message != "Payment failed" || matches[1].Message != "Query failed" {
+		t.Errorf("expected the channel and context matches, got %+v", matches)
+	}
+}
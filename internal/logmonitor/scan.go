@@ -0,0 +1,113 @@
+package logmonitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxScanMatches caps the matches a one-off scan returns
+const MaxScanMatches = 100
+
+// MaxScanBytes is how much of the end of a log file a one-off scan reads
+const MaxScanBytes = 10 * 1024 * 1024
+
+// ScanResult is the outcome of running a matcher over a log file once
+type ScanResult struct {
+	Path         string
+	Matches      []Match
+	LinesScanned int
+	Truncated    bool // the file was larger than MaxScanBytes or had more than MaxScanMatches matches
+}
+
+// ResolveLogPath returns the full path of a log given relative to an app,
+// rejecting paths (including symlinks) that lead outside the app
+func ResolveLogPath(appPath, logPath string) (string, error) {
+	if logPath == "" {
+		return "", fmt.Errorf("log path is required")
+	}
+	if strings.Contains(logPath, "\x00") {
+		return "", fmt.Errorf("log path contains null byte")
+	}
+	if filepath.IsAbs(logPath) {
+		return "", fmt.Errorf("log path %s must be relative to the app", logPath)
+	}
+
+	root, err := filepath.EvalSymlinks(appPath)
+	if err != nil {
+		return "", err
+	}
+	fullPath, err := filepath.EvalSymlinks(filepath.Join(appPath, logPath))
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("log path %s is outside the app", logPath)
+	}
+	return fullPath, nil
+}
+
+// ScanFile runs the patterns over a log file once, the way a tailer and
+// matcher would, without monitoring it further. Only the last MaxScanBytes
+// are read.
+func ScanFile(path string, patterns []string, contextLines int, parser Parser) (*ScanResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	result := &ScanResult{Path: path, Matches: []Match{}}
+
+	reader := bufio.NewReader(file)
+	if info.Size() > MaxScanBytes {
+		if _, err := file.Seek(-MaxScanBytes, io.SeekEnd); err != nil {
+			return nil, err
+		}
+		reader.Reset(file)
+		// Drop the partial line at the seek point
+		reader.ReadString('\n')
+		result.Truncated = true
+	}
+
+	matcher := NewMatcher(patterns, contextLines, func(match Match) {
+		if len(result.Matches) >= MaxScanMatches {
+			result.Truncated = true
+			return
+		}
+		result.Matches = append(result.Matches, match)
+	})
+	matcher.SetParser(parser)
+
+	source := filepath.Base(path)
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSuffix(line, "\n")
+		if line != "" {
+			result.LinesScanned++
+			matcher.ProcessLine(source, line)
+		}
+		if err != nil {
+			if err != io.EOF {
+				return nil, err
+			}
+			break
+		}
+	}
+	matcher.Flush()
+
+	return result, nil
+}
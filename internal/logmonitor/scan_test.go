@@ -0,0 +1,47 @@
+package logmonitor
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolveLogPath_RejectsSymlinkOutsideApp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+
+	appPath := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret.log")
+	if err := os.WriteFile(outside, []byte("ERROR secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(appPath, "app.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ResolveLogPath(appPath, "app.log"); err == nil {
+		t.Error("expected symlink leaving the app to be rejected")
+	}
+}
+
+func TestScanFile_CapsMatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := strings.Repeat("ERROR something broke\n", MaxScanMatches+10)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := ScanFile(path, []string{"ERROR"}, 1, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Matches) != MaxScanMatches || !result.Truncated {
+		t.Errorf("expected %d matches and truncated, got %d (truncated=%v)", MaxScanMatches, len(result.Matches), result.Truncated)
+	}
+	if result.LinesScanned != MaxScanMatches+10 {
+		t.Errorf("expected %d lines scanned, got %d", MaxScanMatches+10, result.LinesScanned)
+	}
+}
@@ -2,17 +2,23 @@ package logmonitor
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/crash"
 )
 
 // LineHandler is called when a new line is read from a log file
 type LineHandler func(source string, line string)
 
+// openLogFile opens a log file for tailing (replaced in tests)
+var openLogFile = os.Open
+
 // Tailer tails a single log file, handling rotation
 type Tailer struct {
 	path    string
@@ -23,6 +29,11 @@ type Tailer struct {
 	position int64
 	inode    uint64
 
+	growth *GrowthDetector // optional growth anomaly detection
+
+	backfillBytes int64 // read from the end of the file on first open
+	opened        bool  // the file has been opened before (later opens are rotations)
+
 	stopCh chan struct{}
 	wg     sync.WaitGroup
 	mu     sync.Mutex
@@ -37,24 +48,53 @@ func NewTailer(path string, handler LineHandler) *Tailer {
 	}
 }
 
-// Start begins tailing the file
+// SetGrowthDetector enables growth anomaly detection (call before Start)
+func (t *Tailer) SetGrowthDetector(detector *GrowthDetector) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.growth = detector
+}
+
+// MaxBackfillBytes caps how much of a file is read back on first open
+const MaxBackfillBytes = 1024 * 1024
+
+// SetBackfill makes the first open read up to n bytes before the end of the
+// file, from the first full line, so errors logged just before monitoring
+// started are matched too (call before Start). Reopens after rotation always
+// start at the end.
+func (t *Tailer) SetBackfill(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.backfillBytes = min(max(n, 0), MaxBackfillBytes)
+}
+
+// Start begins tailing the file. If the file can't be opened yet it is polled
+// for, and the open error is returned so callers can report it.
 func (t *Tailer) Start() error {
-	if err := t.openFile(); err != nil {
-		// File might not exist yet - that's OK, we'll poll for it
-		log.Printf("Log file not found (will poll): %s", t.path)
+	err := t.openFile()
+	if err != nil {
+		// File might not exist yet (or be readable yet) - we'll poll for it
+		log.Printf("Log file not available (will poll): %s: %v", t.path, err)
 	}
 
 	t.wg.Add(1)
 	go t.tailLoop()
 
-	return nil
+	return err
 }
 
-// Stop stops tailing
+// Stop stops tailing, first reading any lines already written to the file
 func (t *Tailer) Stop() {
 	close(t.stopCh)
 	t.wg.Wait()
 
+	t.mu.Lock()
+	hasFile := t.file != nil
+	t.mu.Unlock()
+	if hasFile {
+		t.readLines()
+	}
+
 	t.mu.Lock()
 	if t.file != nil {
 		t.file.Close()
@@ -67,7 +107,7 @@ func (t *Tailer) openFile() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	file, err := os.Open(t.path)
+	file, err := openLogFile(t.path)
 	if err != nil {
 		return err
 	}
@@ -79,8 +119,8 @@ func (t *Tailer) openFile() error {
 		return err
 	}
 
-	// Seek to end - we only want new lines
-	offset, err := file.Seek(0, io.SeekEnd)
+	// Seek to end - we only want new lines (apart from any backfill)
+	offset, err := t.seekStart(file, info.Size())
 	if err != nil {
 		file.Close()
 		return err
@@ -92,13 +132,52 @@ func (t *Tailer) openFile() error {
 	t.inode = getInode(info)
 
 	log.Printf("Tailing log file: %s (position: %d)", t.path, offset)
+	t.backfill(info.Size())
 
 	return nil
 }
 
+// seekStart positions a newly opened file: at the end, or on the first open
+// with backfill set, just before the last backfillBytes (caller must hold
+// lock)
+func (t *Tailer) seekStart(file *os.File, size int64) (int64, error) {
+	if t.opened || t.backfillBytes <= 0 || size == 0 {
+		return file.Seek(0, io.SeekEnd)
+	}
+
+	// Start one byte early so a backfill beginning on a line boundary keeps
+	// that line; the partial line before it is dropped by backfill
+	return file.Seek(max(size-t.backfillBytes-1, 0), io.SeekStart)
+}
+
+// backfill reads the lines between the start position and the end of a newly
+// opened file, then marks the file as opened (caller must hold lock)
+func (t *Tailer) backfill(size int64) {
+	if t.opened {
+		return
+	}
+	t.opened = true
+	if t.position >= size {
+		return
+	}
+
+	if t.position > 0 {
+		partial, err := t.reader.ReadString('\n')
+		t.position += int64(len(partial))
+		if err != nil {
+			return
+		}
+	}
+
+	start := t.position
+	t.readAvailable()
+	log.Printf("Backfilled %d bytes of %s", t.position-start, t.path)
+}
+
 // tailLoop continuously reads new lines from the file
 func (t *Tailer) tailLoop() {
 	defer t.wg.Done()
+	defer crash.Recover(fmt.Sprintf("log tailer for %s", t.path))
 
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
@@ -130,6 +209,17 @@ func (t *Tailer) readLines() {
 		}
 	}
 
+	read := t.readAvailable()
+	if t.growth != nil {
+		t.growth.Observe(read, time.Now())
+	}
+}
+
+// readAvailable passes the complete lines available in the file to the
+// handler and returns how many bytes were read (caller must hold lock)
+func (t *Tailer) readAvailable() int64 {
+	startPosition := t.position
+
 	for {
 		line, err := t.reader.ReadString('\n')
 		if err != nil {
@@ -155,11 +245,20 @@ func (t *Tailer) readLines() {
 		// Get relative source path
 		source := filepath.Base(t.path)
 
-		// Call handler
-		if t.handler != nil {
-			t.handler(source, line)
-		}
+		t.handle(source, line)
+	}
+
+	return t.position - startPosition
+}
+
+// handle passes a line to the handler. A panic in the handler is logged and
+// tailing carries on with the next line.
+func (t *Tailer) handle(source, line string) {
+	if t.handler == nil {
+		return
 	}
+	defer crash.Recover(fmt.Sprintf("log handler for %s", t.path))
+	t.handler(source, line)
 }
 
 // checkRotation checks if the file has been rotated
@@ -212,7 +311,7 @@ func (t *Tailer) checkRotation() {
 
 // openFileUnlocked opens the file without locking (caller must hold lock)
 func (t *Tailer) openFileUnlocked() error {
-	file, err := os.Open(t.path)
+	file, err := openLogFile(t.path)
 	if err != nil {
 		return err
 	}
@@ -223,8 +322,8 @@ func (t *Tailer) openFileUnlocked() error {
 		return err
 	}
 
-	// Seek to end
-	offset, err := file.Seek(0, io.SeekEnd)
+	// Seek to end (apart from any backfill on the first open)
+	offset, err := t.seekStart(file, info.Size())
 	if err != nil {
 		file.Close()
 		return err
@@ -236,14 +335,13 @@ func (t *Tailer) openFileUnlocked() error {
 	t.inode = getInode(info)
 
 	log.Printf("Opened log file: %s (position: %d)", t.path, offset)
+	t.backfill(info.Size())
 
 	return nil
 }
 
-// getInode gets the inode of a file (for rotation detection)
-// This is platform-specific
-func getInode(info os.FileInfo) uint64 {
-	// Use the file modification time as a fallback "inode" on platforms
-	// where we can't get the real inode
+// modTimeInode stands in for an inode where the platform has none: the file
+// modification time, which misses a replacement file with the same mtime
+func modTimeInode(info os.FileInfo) uint64 {
 	return uint64(info.ModTime().UnixNano())
 }
@@ -0,0 +1,88 @@
+package logmonitor
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTailer_Backfill(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "laravel.log")
+	content := strings.Repeat("x", 100) + "\nERROR: before start\nINFO: done\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		backfill int64
+		want     []string
+	}{
+		{"off", 0, nil},
+		{"partial line dropped", 40, []string{"ERROR: before start", "INFO: done"}},
+		{"starts on line boundary", int64(len("ERROR: before start\nINFO: done\n")), []string{"ERROR: before start", "INFO: done"}},
+		{"whole file", 1024, []string{strings.Repeat("x", 100), "ERROR: before start", "INFO: done"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			tailer := NewTailer(path, func(source, line string) {
+				lines = append(lines, line)
+			})
+			tailer.SetBackfill(tt.backfill)
+			if err := tailer.openFile(); err != nil {
+				t.Fatalf("failed to open log: %v", err)
+			}
+			defer tailer.file.Close()
+
+			if !reflect.DeepEqual(lines, tt.want) {
+				t.Errorf("expected backfilled lines %q, got %q", tt.want, lines)
+			}
+			if tailer.position != int64(len(content)) {
+				t.Errorf("expected position at end of file (%d), got %d", len(content), tailer.position)
+			}
+		})
+	}
+}
+
+func TestTailer_NoBackfillAfterRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "laravel.log")
+	if err := os.WriteFile(path, []byte("ERROR: old file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	tailer := NewTailer(path, func(source, line string) {
+		lines = append(lines, line)
+	})
+	tailer.SetBackfill(1024)
+	if err := tailer.openFile(); err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer func() { tailer.file.Close() }()
+
+	// The rotated-in file already has a line; reopening starts after it
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("ERROR: new file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	tailer.checkRotation()
+	tailer.readLines()
+
+	if !reflect.DeepEqual(lines, []string{"ERROR: old file"}) {
+		t.Errorf("expected only the first open to backfill, got %q", lines)
+	}
+}
@@ -0,0 +1,54 @@
+package messages
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxTimestampClamp is how far the system clock may step back (e.g. an NTP
+// correction) while timestamps are held at the last one handed out. Larger
+// steps are accepted as a correction of a clock that was wrong.
+const maxTimestampClamp = time.Minute
+
+// clock returns the current time (replaced in tests)
+var clock = time.Now
+
+var (
+	lastTimestamp time.Time
+	timestampMu   sync.Mutex
+)
+
+// now returns the time for message timestamps, which never go backwards by
+// less than maxTimestampClamp
+func now() time.Time {
+	timestampMu.Lock()
+	defer timestampMu.Unlock()
+
+	// Compare wall clocks: the monotonic clock never sees the step
+	current := clock().Round(0)
+	if current.Before(lastTimestamp) {
+		back := lastTimestamp.Sub(current)
+		if back <= maxTimestampClamp {
+			return lastTimestamp
+		}
+		log.Printf("Warning: system clock stepped back by %v", back.Round(time.Second))
+	}
+	lastTimestamp = current
+	return current
+}
+
+// timestamp formats the current time for a message
+func timestamp() string {
+	return now().UTC().Format(time.RFC3339)
+}
+
+// ElapsedMs returns the milliseconds from start to end, never negative.
+// Times from time.Now() carry a monotonic reading, so clock steps don't
+// affect the result; the clamp covers times that lost it (e.g. via UTC()).
+func ElapsedMs(start, end time.Time) int64 {
+	if elapsed := end.Sub(start); elapsed > 0 {
+		return elapsed.Milliseconds()
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package messages
+
+import (
+	"testing"
+	"time"
+)
+
+// setClock replaces the message clock for a test
+func setClock(t *testing.T, fn func() time.Time) {
+	t.Helper()
+
+	original := clock
+	clock = fn
+	t.Cleanup(func() {
+		clock = original
+		timestampMu.Lock()
+		lastTimestamp = time.Time{}
+		timestampMu.Unlock()
+	})
+}
+
+func TestTimestamp_ClockStepsBack(t *testing.T) {
+	current := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	setClock(t, func() time.Time { return current })
+
+	if got := NewHeartbeatMessage(0, 0, time.Time{}).Timestamp; got != "2026-03-01T12:00:00Z" {
+		t.Fatalf("unexpected timestamp %s", got)
+	}
+
+	// A small step back (NTP slew) holds timestamps at the last one
+	current = current.Add(-20 * time.Second)
+	if got := NewHeartbeatMessage(0, 0, time.Time{}).Timestamp; got != "2026-03-01T12:00:00Z" {
+		t.Errorf("expected the timestamp not to go backwards, got %s", got)
+	}
+
+	// A large step is a correction and is accepted
+	current = current.Add(-2 * time.Hour)
+	if got := NewHeartbeatMessage(0, 0, time.Time{}).Timestamp; got != "2026-03-01T09:59:40Z" {
+		t.Errorf("expected a large correction to be accepted, got %s", got)
+	}
+}
+
+func TestElapsedMs_NeverNegative(t *testing.T) {
+	start := time.Now()
+
+	// Without a monotonic reading, a clock stepped back an hour looks like
+	// the end came before the start
+	end := start.Add(-time.Hour).Round(0)
+	if got := ElapsedMs(start, end); got != 0 {
+		t.Errorf("expected 0 for a backwards clock, got %d", got)
+	}
+
+	if got := ElapsedMs(start, start.Add(1500*time.Millisecond)); got != 1500 {
+		t.Errorf("expected 1500, got %d", got)
+	}
+}
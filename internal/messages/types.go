@@ -20,6 +20,31 @@ const (
 	TypeHeartbeat        = "heartbeat"
 	TypeMonitoringConfig = "monitoring_config"
 	TypeErrorEvent       = "error_event"
+	TypeSetDrain         = "set_drain"
+	TypeDrainStatus      = "drain_status"
+	TypeAgentInfoRequest = "agent_info_request"
+	TypeAgentInfo        = "agent_info"
+	TypeFlushOutput      = "flush_output"
+	TypeMonitoringStatus = "monitoring_status"
+	TypeDedupDump        = "dedup_dump"
+	TypeDedupEntries     = "dedup_entries"
+	TypeLogGrowthAnomaly = "log_growth_anomaly"
+	TypeCollectBundle    = "collect_bundle"
+	TypeBundle           = "bundle"
+	TypeTestPattern      = "test_pattern"
+	TypePatternResults   = "pattern_results"
+	TypeRefreshPaths     = "refresh_paths"
+	TypeStatusRequest    = "status_request"
+	TypeStatusResponse   = "status_response"
+	TypeCancel           = "cancel"
+	TypeCancelResult     = "cancel_result"
+	TypeAck              = "ack"
+	TypeHeartbeatAck     = "heartbeat_ack"
+	TypeUpdatePatterns   = "update_patterns"
+	TypePatternsUpdated  = "patterns_updated"
+	TypeErrorSummary     = "error_summary"
+
+	TypeDiscoverySchedule = "discovery_schedule"
 )
 
 // BaseMessage contains common fields
@@ -52,6 +77,23 @@ func NewAuthMessage(token, version, hostname, os, arch string) *AuthMessage {
 type AuthOKMessage struct {
 	Type     string `json:"type"`
 	ServerID string `json:"server_id"`
+
+	// Optional cap on commands running at once, overriding the agent's own
+	// setting (0 = use the agent's). May be re-sent to change it live.
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+
+	// Set when the server acknowledges command results with ack messages;
+	// unacknowledged complete and rejected messages are then resent after a
+	// reconnect
+	ResultAcks bool `json:"result_acks,omitempty"`
+}
+
+func ParseAuthOKMessage(data []byte) (*AuthOKMessage, error) {
+	var msg AuthOKMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
 }
 
 // AuthErrorMessage - cloud rejects authentication
@@ -74,11 +116,63 @@ type DiscoveryMessage struct {
 	Distro     string            `json:"distro,omitempty"`
 	Kernel     string            `json:"kernel,omitempty"`
 	Uptime     int64             `json:"uptime"`
+	InitSystem string            `json:"init_system,omitempty"`
 	Services   []ServiceInfo     `json:"services"`
 	Languages  []LanguageInfo    `json:"languages"`
 	Apps       []AppInfo         `json:"apps"`
 	Docker     *DockerInfo       `json:"docker,omitempty"`
 	System     SystemInfo        `json:"system"`
+
+	AccessControl *AccessControlInfo `json:"access_control,omitempty"` // nil when neither SELinux nor AppArmor is present
+	Container     *Containerization  `json:"container,omitempty"`      // nil when not running in a container
+
+	// SearchPath is the PATH (in order) language binaries were resolved with
+	SearchPath []string `json:"search_path,omitempty"`
+
+	// VirtualHosts are the nginx and apache sites configured on the host
+	VirtualHosts []VirtualHostInfo `json:"virtual_hosts,omitempty"`
+}
+
+// Web servers a virtual host can be configured in
+const (
+	WebServerNginx  = "nginx"
+	WebServerApache = "apache"
+)
+
+// VirtualHostInfo - a web server site: the domains it serves and where
+// requests go
+type VirtualHostInfo struct {
+	Server      string   `json:"server"`      // nginx or apache
+	ConfigFile  string   `json:"config_file"` // file the site is defined in
+	ServerNames []string `json:"server_names,omitempty"`
+	Listen      []string `json:"listen,omitempty"`
+	Root        string   `json:"root,omitempty"`       // document root
+	ProxyPass   []string `json:"proxy_pass,omitempty"` // upstreams requests are proxied to
+	AppPath     string   `json:"app_path,omitempty"`   // discovered app the root is in
+}
+
+// Containerization reports the container the agent runs in, where paths and
+// service control differ from a plain host
+type Containerization struct {
+	Runtime    string          `json:"runtime"` // docker, podman, containerd, cri-o, lxc, ... or unknown
+	Kubernetes *KubernetesInfo `json:"kubernetes,omitempty"`
+}
+
+// KubernetesInfo identifies the pod the agent runs in
+type KubernetesInfo struct {
+	Pod       string `json:"pod,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// AccessControlInfo reports mandatory access control status, which affects
+// what commands can do
+type AccessControlInfo struct {
+	SELinux  string `json:"selinux,omitempty"`  // enforcing, permissive, disabled; empty if not present
+	AppArmor string `json:"apparmor,omitempty"` // enabled, disabled; empty if not present
+
+	// Loaded AppArmor profiles by mode (only readable when the agent runs as root)
+	AppArmorEnforce  int `json:"apparmor_enforce,omitempty"`
+	AppArmorComplain int `json:"apparmor_complain,omitempty"`
 }
 
 func NewDiscoveryMessage() *DiscoveryMessage {
@@ -96,7 +190,13 @@ type ServiceInfo struct {
 type LanguageInfo struct {
 	Name    string `json:"name"` // php, node, python, ruby, go
 	Version string `json:"version"`
-	Path    string `json:"path"`
+	Path    string `json:"path"` // first match on PATH, the binary that was probed
+
+	// Where Path really points (e.g. through /etc/alternatives), when different
+	ResolvedPath string `json:"resolved_path,omitempty"`
+
+	// Other binaries of the same name later on PATH, hidden by Path
+	Shadowed []string `json:"shadowed,omitempty"`
 }
 
 type AppInfo struct {
@@ -106,6 +206,32 @@ type AppInfo struct {
 	GitBranch string     `json:"git_branch,omitempty"`
 	GitCommit string     `json:"git_commit,omitempty"`
 	Config    *AppConfig `json:"config,omitempty"` // parsed from antidote.yml
+
+	// DefaultLogs are the framework's usual log paths and error patterns, a
+	// starting point for the app's monitoring config
+	DefaultLogs *AppLogConfig `json:"default_logs,omitempty"`
+
+	// PID is the app's main process, when one runs from the app's directory
+	PID int32 `json:"pid,omitempty"`
+
+	// Connections are the remote "host:port"s PID has established outbound
+	// TCP connections to (databases, caches, upstream APIs)
+	Connections []string `json:"connections,omitempty"`
+
+	// From a node app's package.json: its name, version and scripts (so
+	// they can be offered as commands), and the installed version of its
+	// framework (else the declared range)
+	PackageName      string            `json:"package_name,omitempty"`
+	PackageVersion   string            `json:"package_version,omitempty"`
+	Scripts          map[string]string `json:"scripts,omitempty"`
+	FrameworkVersion string            `json:"framework_version,omitempty"`
+}
+
+// AppLogConfig - log files (relative to the app, globs allowed) and error
+// patterns to monitor them with
+type AppLogConfig struct {
+	LogPaths      []string `json:"log_paths"`
+	ErrorPatterns []string `json:"error_patterns"`
 }
 
 // AppConfig represents the parsed antidote.yml configuration
@@ -116,8 +242,35 @@ type AppConfig struct {
 	Actions          map[string]AppConfigAction `json:"actions" yaml:"actions"`
 	ApprovalRequired []AppConfigApproval       `json:"approval_required" yaml:"approval_required"`
 	Deny             []string                  `json:"deny" yaml:"deny"`
+	Redact           []string                  `json:"redact,omitempty" yaml:"redact"`
 	Logs             []string                  `json:"logs" yaml:"logs"`
 	Health           *AppConfigHealth          `json:"health,omitempty" yaml:"health"`
+	LoginShell       bool                      `json:"login_shell,omitempty" yaml:"login_shell"` // run commands via bash -lc
+	WorkingDirs      []string                  `json:"working_dirs,omitempty" yaml:"working_dirs"` // if set, the only dirs (relative to the app, "." = root) commands may run in
+	DenyOptOut       []string                  `json:"deny_opt_out,omitempty" yaml:"deny_opt_out"` // names of the agent's global deny rules that don't apply to this app
+	AllowList        bool                      `json:"allow_list,omitempty" yaml:"allow_list"` // only run commands matching an action or an allow pattern
+	Allow            []string                  `json:"allow,omitempty" yaml:"allow"` // patterns of commands permitted in allow-list mode, on top of the actions
+	MaxTimeout       int                       `json:"max_timeout,omitempty" yaml:"max_timeout"` // seconds; longer command timeouts are rejected and the default is capped to it
+	Profiles         map[string]AppConfigProfile `json:"-" yaml:"profiles"` // overrides keyed by environment
+	Profile          string                    `json:"profile,omitempty" yaml:"-"` // the profile applied, if any
+}
+
+// AppConfigProfile overrides base config fields when the agent's environment
+// matches; unset fields keep the base value and actions are merged by name
+type AppConfigProfile struct {
+	TrustLevel       string                     `yaml:"trust_level"`
+	Actions          map[string]AppConfigAction `yaml:"actions"`
+	ApprovalRequired []AppConfigApproval        `yaml:"approval_required"`
+	Deny             []string                   `yaml:"deny"`
+	DenyOptOut       []string                   `yaml:"deny_opt_out"`
+	AllowList        *bool                      `yaml:"allow_list"`
+	Allow            []string                   `yaml:"allow"`
+	Redact           []string                   `yaml:"redact"`
+	Logs             []string                   `yaml:"logs"`
+	Health           *AppConfigHealth           `yaml:"health"`
+	LoginShell       *bool                      `yaml:"login_shell"`
+	WorkingDirs      []string                   `yaml:"working_dirs"`
+	MaxTimeout       *int                       `yaml:"max_timeout"`
 }
 
 type AppConfigApp struct {
@@ -130,6 +283,14 @@ type AppConfigAction struct {
 	Label   string `json:"label" yaml:"label"`
 	Icon    string `json:"icon,omitempty" yaml:"icon"`
 	Confirm bool   `json:"confirm,omitempty" yaml:"confirm"`
+
+	// Only one run of the action per app at a time; another run while one is
+	// in flight is rejected with ALREADY_RUNNING
+	Exclusive bool `json:"exclusive,omitempty" yaml:"exclusive"`
+
+	// The action writes a lot to disk (builds, artifacts); it is rejected with
+	// LOW_DISK_SPACE while free space is below the agent's minimum
+	DiskHeavy bool `json:"disk_heavy,omitempty" yaml:"disk_heavy"`
 }
 
 type AppConfigApproval struct {
@@ -161,6 +322,11 @@ type SystemInfo struct {
 	DiskTotal   uint64  `json:"disk_total"`
 	DiskFree    uint64  `json:"disk_free"`
 	LoadAvg     float64 `json:"load_avg"`
+
+	// Whether each metric could be collected (zeros otherwise mean failure)
+	MemoryAvailable bool `json:"memory_available"`
+	DiskAvailable   bool `json:"disk_available"`
+	LoadAvailable   bool `json:"load_available"`
 }
 
 // CommandMessage - cloud tells agent to run a command
@@ -171,6 +337,39 @@ type CommandMessage struct {
 	WorkingDir string            `json:"working_dir,omitempty"`
 	Env        map[string]string `json:"env,omitempty"`
 	Timeout    int               `json:"timeout,omitempty"` // seconds, 0 = default
+	Priority   int               `json:"priority,omitempty"` // niceness 0-19, higher = lower priority
+	Pipefail   bool              `json:"pipefail,omitempty"` // run with bash pipefail and report pipeline stage exit codes
+
+	// App, when set, makes WorkingDir relative to that app's root. It names a
+	// discovered app by path, antidote.yml name or directory name.
+	App string `json:"app,omitempty"`
+
+	// Script is a multi-line script run from a temp file instead of Command
+	// (exactly one of the two is set); each line is validated on its own
+	Script string `json:"script,omitempty"`
+
+	// Stdin is written to the command's standard input (closed afterwards);
+	// without it the command reads from an empty stdin
+	Stdin         string `json:"stdin,omitempty"`
+	StdinEncoding string `json:"stdin_encoding,omitempty"` // empty for UTF-8 text, or EncodingBase64
+
+	// MaxOutputBytes overrides the agent's limit on how much output the
+	// command may send (0 = agent default)
+	MaxOutputBytes int64 `json:"max_output_bytes,omitempty"`
+
+	// DiskHeavy marks a command that writes a lot to disk (builds, artifacts);
+	// it is rejected with LOW_DISK_SPACE while free space is below the
+	// agent's minimum
+	DiskHeavy bool `json:"disk_heavy,omitempty"`
+
+	// Optional grouping of related commands (e.g. the steps of a deploy),
+	// echoed on the command's output and complete messages
+	GroupID string `json:"group_id,omitempty"`
+	Step    int    `json:"step,omitempty"`
+
+	// AdminSigned is set by the agent (never by the server) when the command
+	// was signed with the admin key, letting it bypass the allow-list
+	AdminSigned bool `json:"-"`
 }
 
 func ParseCommandMessage(data []byte) (*CommandMessage, error) {
@@ -187,16 +386,27 @@ type OutputMessage struct {
 	ID        string `json:"id"`
 	Stream    string `json:"stream"` // stdout or stderr
 	Data      string `json:"data"`
+	Encoding  string `json:"encoding,omitempty"` // empty for UTF-8 text, or EncodingBase64
 	Timestamp string `json:"timestamp"`
+	GroupID   string `json:"group_id,omitempty"` // from the command
+	Step      int    `json:"step,omitempty"`
+
+	// LineTimestamps, for coalesced output, holds the timestamp of each line
+	// in Data, in order
+	LineTimestamps []string `json:"line_timestamps,omitempty"`
 }
 
+// EncodingBase64 marks output data (or command stdin) that is base64-encoded
+// (binary data)
+const EncodingBase64 = "base64"
+
 func NewOutputMessage(id, stream, data string) *OutputMessage {
 	return &OutputMessage{
 		Type:      TypeOutput,
 		ID:        id,
 		Stream:    stream,
 		Data:      data,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Timestamp: timestamp(),
 	}
 }
 
@@ -207,15 +417,42 @@ type CompleteMessage struct {
 	ExitCode   int    `json:"exit_code"`
 	DurationMs int64  `json:"duration_ms"`
 	Timestamp  string `json:"timestamp"`
+
+	// Set for pipefail commands: exit code of each stage of the last pipeline
+	// and the 1-based index of the first stage that failed (0 if none)
+	PipeStatus  []int `json:"pipe_status,omitempty"`
+	FailedStage int   `json:"failed_stage,omitempty"`
+
+	GroupID string `json:"group_id,omitempty"` // from the command
+	Step    int    `json:"step,omitempty"`
+
+	// Peak resident memory of the largest process the command ran (the shell
+	// or a child it waited for), 0 if the platform doesn't report it
+	PeakMemoryBytes int64 `json:"peak_memory_bytes,omitempty"`
+
+	// Set when output beyond the command's output limit was dropped
+	OutputTruncated bool `json:"output_truncated,omitempty"`
+
+	// Why the command stopped early, if it was stopped on request
+	// (ReasonInterrupted)
+	Reason string `json:"reason,omitempty"`
+
+	// Explains a likely cause of the failure, e.g. a binary that wasn't on
+	// PATH
+	Diagnostic string `json:"diagnostic,omitempty"`
 }
 
+// ReasonInterrupted marks a command stopped with SIGINT (and killed if it
+// didn't exit within the grace period)
+const ReasonInterrupted = "interrupted"
+
 func NewCompleteMessage(id string, exitCode int, durationMs int64) *CompleteMessage {
 	return &CompleteMessage{
 		Type:       TypeComplete,
 		ID:         id,
 		ExitCode:   exitCode,
 		DurationMs: durationMs,
-		Timestamp:  time.Now().UTC().Format(time.RFC3339),
+		Timestamp:  timestamp(),
 	}
 }
 
@@ -223,8 +460,9 @@ func NewCompleteMessage(id string, exitCode int, durationMs int64) *CompleteMess
 type RejectedMessage struct {
 	Type      string `json:"type"`
 	ID        string `json:"id"`
-	Code      string `json:"code"`    // Error code (e.g., COMMAND_DENIED, PATH_TRAVERSAL)
-	Message   string `json:"message"` // Human-readable error message
+	Code      string `json:"code"`              // Error code (e.g., COMMAND_DENIED, PATH_TRAVERSAL)
+	Subcode   string `json:"subcode,omitempty"` // Finer reason, e.g. expired or invalid for SIGNATURE_INVALID
+	Message   string `json:"message"`           // Human-readable error message
 	Timestamp string `json:"timestamp"`
 }
 
@@ -234,7 +472,7 @@ func NewRejectedMessage(id, code, message string) *RejectedMessage {
 		ID:        id,
 		Code:      code,
 		Message:   message,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Timestamp: timestamp(),
 	}
 }
 
@@ -247,9 +485,28 @@ type HealthMessage struct {
 	DiskUsed    uint64  `json:"disk_used"`
 	DiskTotal   uint64  `json:"disk_total"`
 	LoadAvg     float64 `json:"load_avg"`
+	LoadPerCore float64 `json:"load_per_core"` // load_avg / CPU cores
+	Status      string  `json:"status"`        // ok or degraded
 	Timestamp   string  `json:"timestamp"`
+
+	// Whether each metric could be collected; when false its values are
+	// zero because collection failed, not because they are really zero
+	CPUAvailable    bool `json:"cpu_available"`
+	MemoryAvailable bool `json:"memory_available"`
+	DiskAvailable   bool `json:"disk_available"`
+	LoadAvailable   bool `json:"load_available"`
+
+	// Metrics no longer collected every interval after failing repeatedly,
+	// only probed occasionally until they recover
+	OpenCircuits []string `json:"open_circuits,omitempty"`
 }
 
+// Health statuses
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded"
+)
+
 func NewHealthMessage(cpu float64, memUsed, memTotal, diskUsed, diskTotal uint64, load float64) *HealthMessage {
 	return &HealthMessage{
 		Type:        TypeHealth,
@@ -259,21 +516,47 @@ func NewHealthMessage(cpu float64, memUsed, memTotal, diskUsed, diskTotal uint64
 		DiskUsed:    diskUsed,
 		DiskTotal:   diskTotal,
 		LoadAvg:     load,
-		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Timestamp:   timestamp(),
 	}
 }
 
-// HeartbeatMessage - keep connection alive
+// HeartbeatMessage - keep connection alive, with a summary of the agent's
+// command load
 type HeartbeatMessage struct {
-	Type      string `json:"type"`
-	Timestamp string `json:"timestamp"`
+	Type          string `json:"type"`
+	Timestamp     string `json:"timestamp"`
+	Seq           uint64 `json:"seq"` // echoed back in heartbeat_ack
+	Running       int    `json:"running"`
+	Queued        int    `json:"queued"`                    // waiting for a concurrency slot
+	LastCommandAt string `json:"last_command_at,omitempty"` // when the last command was accepted
 }
 
-func NewHeartbeatMessage() *HeartbeatMessage {
-	return &HeartbeatMessage{
+func NewHeartbeatMessage(running, queued int, lastCommandAt time.Time) *HeartbeatMessage {
+	msg := &HeartbeatMessage{
 		Type:      TypeHeartbeat,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Timestamp: timestamp(),
+		Running:   running,
+		Queued:    queued,
+	}
+	if !lastCommandAt.IsZero() {
+		msg.LastCommandAt = lastCommandAt.UTC().Format(time.RFC3339)
+	}
+	return msg
+}
+
+// HeartbeatAckMessage - cloud answers a heartbeat, letting the agent measure
+// round-trip time
+type HeartbeatAckMessage struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+func ParseHeartbeatAckMessage(data []byte) (*HeartbeatAckMessage, error) {
+	var msg HeartbeatAckMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
 	}
+	return &msg, nil
 }
 
 // ParseMessage extracts the message type
@@ -298,6 +581,44 @@ type MonitoringAppConfig struct {
 	LogPaths      []string `json:"log_paths"`
 	ErrorPatterns []string `json:"error_patterns"`
 	ContextLines  int      `json:"context_lines"`
+	ContextBefore int      `json:"context_before,omitempty"` // overrides context_lines before a match
+	ContextAfter  int      `json:"context_after,omitempty"`  // overrides context_lines after a match
+	GrowthFactor  float64  `json:"growth_factor,omitempty"`  // report log growth above this multiple of the baseline (0 = off)
+	GrowthMinRate int64    `json:"growth_min_rate,omitempty"` // bytes/sec below which growth is never reported
+
+	// ScopedPatterns apply only to matching log sources, replacing ErrorPatterns there
+	ScopedPatterns []ScopedErrorPatterns `json:"scoped_patterns,omitempty"`
+
+	// MultilineContinuation is a regex for lines (e.g. stack frames) grouped
+	// into the error before them ("default" for common stack traces, "" = off)
+	MultilineContinuation string `json:"multiline_continuation,omitempty"`
+
+	// MinLevel drops matches below this level, e.g. "error" ("" = keep all)
+	MinLevel string `json:"min_level,omitempty"`
+
+	// PatternLevels gives the matches of error patterns a level, keyed by
+	// pattern, e.g. {"FATAL": "critical"} (other matches get the parsed
+	// severity, else "error")
+	PatternLevels map[string]string `json:"pattern_levels,omitempty"`
+
+	// BackfillBytes is how much of the end of each log file to read when
+	// monitoring starts, so recent errors are reported too (0 = new lines only)
+	BackfillBytes int64 `json:"backfill_bytes,omitempty"`
+
+	// LogStreams names the output stream a log path holds, for processes
+	// whose stdout and stderr are logged to separate files (e.g. supervisor's
+	// stdout_logfile and stderr_logfile): log path -> "stdout" or "stderr"
+	LogStreams map[string]string `json:"log_streams,omitempty"`
+
+	// MatchStreams limits matching to lines from these streams, with lines
+	// from other streams kept as context (empty = every line can match)
+	MatchStreams []string `json:"match_streams,omitempty"`
+}
+
+// ScopedErrorPatterns - error patterns that only apply to one log source
+type ScopedErrorPatterns struct {
+	Source   string   `json:"source"` // log file name or glob, e.g. "laravel.log" or "worker-*.log"
+	Patterns []string `json:"patterns"`
 }
 
 func ParseMonitoringConfigMessage(data []byte) (*MonitoringConfigMessage, error) {
@@ -321,6 +642,77 @@ type ErrorEventMessage struct {
 	OccurrenceCount int      `json:"occurrence_count"`
 	FirstSeen       string   `json:"first_seen"`
 	SignatureHash   string   `json:"signature_hash"`
+	Severity        string   `json:"severity,omitempty"` // parsed by the framework log parser
+	Message         string   `json:"message,omitempty"`
+	Level           string   `json:"level,omitempty"`  // debug ... emergency, from the matching pattern or the parsed severity
+	Stream          string   `json:"stream,omitempty"` // stdout or stderr, for logs given a stream in log_streams
+}
+
+// MonitoringStatusMessage - agent reports error events it could not deliver,
+// and after applying a monitoring config, whether each log path is tailed
+type MonitoringStatusMessage struct {
+	Type          string          `json:"type"`
+	DroppedEvents uint64          `json:"dropped_events"`       // since the last status message
+	DroppedTotal  uint64          `json:"dropped_events_total"` // since agent start
+	LogPaths      []LogPathStatus `json:"log_paths,omitempty"`
+	UnmatchedApps []string        `json:"unmatched_apps,omitempty"` // repos configured but not discovered on this server
+	Timestamp     string          `json:"timestamp"`
+}
+
+// Log path statuses
+const (
+	LogPathStarted          = "started"
+	LogPathNotFound         = "not_found"
+	LogPathFailedPermission = "failed_permission"
+	LogPathFailed           = "failed"
+)
+
+// LogPathStatus - whether a monitored log file could be opened
+type LogPathStatus struct {
+	AppPath string `json:"app_path"`
+	Path    string `json:"path"`
+	Status  string `json:"status"` // started, not_found, failed_permission, failed
+	Error   string `json:"error,omitempty"`
+}
+
+// ErrorSummaryMessage - agent reports how often rate-limited error signatures
+// occurred without an error_event being sent for them
+type ErrorSummaryMessage struct {
+	Type       string              `json:"type"`
+	Signatures []ErrorSummaryEntry `json:"signatures"`
+	Timestamp  string              `json:"timestamp"`
+}
+
+// ErrorSummaryEntry - occurrences of one error signature suppressed by rate
+// limiting since the last summary
+type ErrorSummaryEntry struct {
+	SignatureHash   string `json:"signature_hash"`
+	AppPath         string `json:"app_path,omitempty"` // where the signature was first seen
+	RepoFullName    string `json:"repo_full_name,omitempty"`
+	Source          string `json:"source,omitempty"`
+	SuppressedCount int    `json:"suppressed_count"` // since the last summary
+	OccurrenceCount int    `json:"occurrence_count"` // since first seen
+	FirstSeen       string `json:"first_seen"`
+	LastSeen        string `json:"last_seen"`
+	SampleLine      string `json:"sample_line"`
+	Level           string `json:"level,omitempty"`
+}
+
+func NewErrorSummaryMessage(signatures []ErrorSummaryEntry) *ErrorSummaryMessage {
+	return &ErrorSummaryMessage{
+		Type:       TypeErrorSummary,
+		Signatures: signatures,
+		Timestamp:  timestamp(),
+	}
+}
+
+func NewMonitoringStatusMessage(dropped, droppedTotal uint64) *MonitoringStatusMessage {
+	return &MonitoringStatusMessage{
+		Type:          TypeMonitoringStatus,
+		DroppedEvents: dropped,
+		DroppedTotal:  droppedTotal,
+		Timestamp:     timestamp(),
+	}
 }
 
 func NewErrorEventMessage(appPath, repoFullName, source, errorLine string, contextBefore, contextAfter []string, occurrenceCount int, firstSeen, signatureHash string) *ErrorEventMessage {
@@ -329,7 +721,7 @@ func NewErrorEventMessage(appPath, repoFullName, source, errorLine string, conte
 		AppPath:         appPath,
 		RepoFullName:    repoFullName,
 		Source:          source,
-		Timestamp:       time.Now().UTC().Format(time.RFC3339),
+		Timestamp:       timestamp(),
 		ErrorLine:       errorLine,
 		ContextBefore:   contextBefore,
 		ContextAfter:    contextAfter,
@@ -338,3 +730,420 @@ func NewErrorEventMessage(appPath, repoFullName, source, errorLine string, conte
 		SignatureHash:   signatureHash,
 	}
 }
+
+// LogGrowthAnomalyMessage - agent reports a log file growing abnormally fast
+type LogGrowthAnomalyMessage struct {
+	Type         string  `json:"type"`
+	AppPath      string  `json:"app_path"`
+	RepoFullName string  `json:"repo_full_name,omitempty"`
+	Source       string  `json:"source"`
+	BytesPerSec  float64 `json:"bytes_per_sec"`
+	BaselineRate float64 `json:"baseline_bytes_per_sec"`
+	Timestamp    string  `json:"timestamp"`
+}
+
+func NewLogGrowthAnomalyMessage(appPath, repoFullName, source string, rate, baseline float64) *LogGrowthAnomalyMessage {
+	return &LogGrowthAnomalyMessage{
+		Type:         TypeLogGrowthAnomaly,
+		AppPath:      appPath,
+		RepoFullName: repoFullName,
+		Source:       source,
+		BytesPerSec:  rate,
+		BaselineRate: baseline,
+		Timestamp:    timestamp(),
+	}
+}
+
+// CollectBundleMessage - cloud asks for a diagnostic bundle written to disk
+type CollectBundleMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Dir  string `json:"dir,omitempty"` // directory for the bundle (default: the first allowed path)
+}
+
+func ParseCollectBundleMessage(data []byte) (*CollectBundleMessage, error) {
+	var msg CollectBundleMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// BundleMessage - agent reports where a diagnostic bundle was written
+type BundleMessage struct {
+	Type      string   `json:"type"`
+	ID        string   `json:"id"`
+	Path      string   `json:"path,omitempty"`
+	Size      int64    `json:"size,omitempty"` // compressed size in bytes
+	Sections  []string `json:"sections,omitempty"`
+	Truncated []string `json:"truncated,omitempty"` // sections cut to the size limits
+	Error     string   `json:"error,omitempty"`
+	Timestamp string   `json:"timestamp"`
+}
+
+func NewBundleMessage(id string) *BundleMessage {
+	return &BundleMessage{
+		Type:      TypeBundle,
+		ID:        id,
+		Timestamp: timestamp(),
+	}
+}
+
+// TestPatternMessage - cloud asks what patterns would match in an existing log,
+// without starting monitoring
+type TestPatternMessage struct {
+	Type         string   `json:"type"`
+	ID           string   `json:"id"`
+	AppPath      string   `json:"app_path"`
+	LogPath      string   `json:"log_path"` // relative to the app
+	Patterns     []string `json:"patterns"`
+	ContextLines int      `json:"context_lines,omitempty"`
+}
+
+func ParseTestPatternMessage(data []byte) (*TestPatternMessage, error) {
+	var msg TestPatternMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// PatternMatch - a line matched by a pattern test, with its context
+type PatternMatch struct {
+	ErrorLine     string   `json:"error_line"`
+	ContextBefore []string `json:"context_before"`
+	ContextAfter  []string `json:"context_after"`
+	Severity      string   `json:"severity,omitempty"`
+	Message       string   `json:"message,omitempty"`
+	Level         string   `json:"level,omitempty"`
+}
+
+// PatternResultsMessage - agent reports the matches of a pattern test
+type PatternResultsMessage struct {
+	Type         string         `json:"type"`
+	ID           string         `json:"id"`
+	AppPath      string         `json:"app_path"`
+	LogPath      string         `json:"log_path"`
+	Matches      []PatternMatch `json:"matches"`
+	LinesScanned int            `json:"lines_scanned"`
+	Truncated    bool           `json:"truncated"` // only the end of the file was read, or matches were capped
+	Error        string         `json:"error,omitempty"`
+	Timestamp    string         `json:"timestamp"`
+}
+
+func NewPatternResultsMessage(id, appPath, logPath string) *PatternResultsMessage {
+	return &PatternResultsMessage{
+		Type:      TypePatternResults,
+		ID:        id,
+		AppPath:   appPath,
+		LogPath:   logPath,
+		Matches:   []PatternMatch{},
+		Timestamp: timestamp(),
+	}
+}
+
+// UpdatePatternsMessage - cloud replaces the error patterns of an app that is
+// already monitored, without restarting its tailers
+type UpdatePatternsMessage struct {
+	Type           string                `json:"type"`
+	RepoFullName   string                `json:"repo_full_name"`
+	ErrorPatterns  []string              `json:"error_patterns"`
+	ScopedPatterns []ScopedErrorPatterns `json:"scoped_patterns,omitempty"`
+}
+
+func ParseUpdatePatternsMessage(data []byte) (*UpdatePatternsMessage, error) {
+	var msg UpdatePatternsMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// PatternIssue - a pushed pattern that was rejected
+type PatternIssue struct {
+	Source  string `json:"source,omitempty"` // set for scoped patterns
+	Pattern string `json:"pattern"`
+	Message string `json:"message"`
+}
+
+// PatternsUpdatedMessage - agent reports whether pushed patterns were applied.
+// Nothing is applied if any pattern is invalid.
+type PatternsUpdatedMessage struct {
+	Type         string         `json:"type"`
+	RepoFullName string         `json:"repo_full_name"`
+	Applied      bool           `json:"applied"`
+	Issues       []PatternIssue `json:"issues,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	Timestamp    string         `json:"timestamp"`
+}
+
+func NewPatternsUpdatedMessage(repoFullName string) *PatternsUpdatedMessage {
+	return &PatternsUpdatedMessage{
+		Type:         TypePatternsUpdated,
+		RepoFullName: repoFullName,
+		Timestamp:    timestamp(),
+	}
+}
+
+// DedupDumpRequest - cloud asks for the error signatures the deduplicator has seen
+type DedupDumpRequest struct {
+	Type string `json:"type"`
+}
+
+// DedupEntry - a recurring error signature
+type DedupEntry struct {
+	SignatureHash   string `json:"signature_hash"`
+	FirstSeen       string `json:"first_seen"`
+	LastSeen        string `json:"last_seen"`
+	OccurrenceCount int    `json:"occurrence_count"`
+	SampleLine      string `json:"sample_line"`
+	Level           string `json:"level,omitempty"`
+}
+
+// DedupEntriesMessage - agent reports deduplicator entries, most frequent first
+type DedupEntriesMessage struct {
+	Type      string       `json:"type"`
+	Entries   []DedupEntry `json:"entries"`
+	Total     int          `json:"total"`     // entries tracked, including any left out
+	Truncated bool         `json:"truncated"` // true if Entries doesn't hold all of them
+	Timestamp string       `json:"timestamp"`
+}
+
+func NewDedupEntriesMessage(entries []DedupEntry, total int) *DedupEntriesMessage {
+	return &DedupEntriesMessage{
+		Type:      TypeDedupEntries,
+		Entries:   entries,
+		Total:     total,
+		Truncated: len(entries) < total,
+		Timestamp: timestamp(),
+	}
+}
+
+// SetDrainMessage - cloud toggles drain mode (finish running work, reject new commands)
+type SetDrainMessage struct {
+	Type     string `json:"type"`
+	Draining bool   `json:"draining"`
+}
+
+func ParseSetDrainMessage(data []byte) (*SetDrainMessage, error) {
+	var msg SetDrainMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// DiscoverMessage - cloud asks for discovery results
+type DiscoverMessage struct {
+	Type  string `json:"type"`
+	Force bool   `json:"force,omitempty"` // scan again even if a recent scan is cached
+}
+
+func ParseDiscoverMessage(data []byte) (*DiscoverMessage, error) {
+	var msg DiscoverMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// DiscoveryScheduleMessage - cloud sets how often the agent re-runs discovery
+// in the background
+type DiscoveryScheduleMessage struct {
+	Type            string `json:"type"`
+	IntervalSeconds int    `json:"interval_seconds"` // 0 disables background discovery
+}
+
+func ParseDiscoveryScheduleMessage(data []byte) (*DiscoveryScheduleMessage, error) {
+	var msg DiscoveryScheduleMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// FlushOutputMessage - cloud asks for a running command's buffered partial output
+type FlushOutputMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func ParseFlushOutputMessage(data []byte) (*FlushOutputMessage, error) {
+	var msg FlushOutputMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// AckMessage - cloud confirms it has received the results (complete or
+// rejected messages) of the given commands
+type AckMessage struct {
+	Type string   `json:"type"`
+	IDs  []string `json:"ids"`
+}
+
+func ParseAckMessage(data []byte) (*AckMessage, error) {
+	var msg AckMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// CancelMessage - cloud asks the agent to stop a running command
+type CancelMessage struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Mode string `json:"mode,omitempty"` // kill (default) or interrupt
+}
+
+// Cancel modes
+const (
+	CancelModeKill      = "kill"      // kill the command's process group right away
+	CancelModeInterrupt = "interrupt" // SIGINT first, kill after the agent's interrupt grace period
+)
+
+func ParseCancelMessage(data []byte) (*CancelMessage, error) {
+	var msg CancelMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// Cancel result statuses
+const (
+	CancelStatusCancelled = "cancelled" // the command was stopped; its complete message follows
+	CancelStatusNotFound  = "not_found" // no such command is running
+)
+
+// CancelResultMessage - agent reports whether a cancel took effect
+type CancelResultMessage struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Timestamp string `json:"timestamp"`
+}
+
+func NewCancelResultMessage(id, status string) *CancelResultMessage {
+	return &CancelResultMessage{
+		Type:      TypeCancelResult,
+		ID:        id,
+		Status:    status,
+		Timestamp: timestamp(),
+	}
+}
+
+// DrainStatusMessage - agent reports drain state and whether it has gone idle
+type DrainStatusMessage struct {
+	Type      string `json:"type"`
+	Draining  bool   `json:"draining"`
+	Running   int    `json:"running"`
+	Drained   bool   `json:"drained"` // draining and no commands left running
+	Timestamp string `json:"timestamp"`
+}
+
+func NewDrainStatusMessage(draining bool, running int) *DrainStatusMessage {
+	return &DrainStatusMessage{
+		Type:      TypeDrainStatus,
+		Draining:  draining,
+		Running:   running,
+		Drained:   draining && running == 0,
+		Timestamp: timestamp(),
+	}
+}
+
+// StatusRequest - cloud asks which commands the agent is running
+type StatusRequest struct {
+	Type string `json:"type"`
+}
+
+// RunningCommandStatus - a command in flight
+type RunningCommandStatus struct {
+	ID        string `json:"id"`
+	Command   string `json:"command"`
+	StartedAt string `json:"started_at"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// StatusResponseMessage - agent reports its running commands, oldest first
+type StatusResponseMessage struct {
+	Type      string                 `json:"type"`
+	Running   []RunningCommandStatus `json:"running"`
+	Draining  bool                   `json:"draining"`
+	Timestamp string                 `json:"timestamp"`
+}
+
+func NewStatusResponseMessage(running []RunningCommandStatus, draining bool) *StatusResponseMessage {
+	return &StatusResponseMessage{
+		Type:      TypeStatusResponse,
+		Running:   running,
+		Draining:  draining,
+		Timestamp: timestamp(),
+	}
+}
+
+// AgentInfoRequest - cloud asks the agent to report its own state
+type AgentInfoRequest struct {
+	Type string `json:"type"`
+}
+
+// AgentInfoMessage - agent reports details about itself (sent on connect and on request)
+type AgentInfoMessage struct {
+	Type       string                 `json:"type"`
+	Connection *ConnectionDiagnostics `json:"connection,omitempty"`
+	Processes  *ProcessInfo           `json:"processes,omitempty"`
+	User       *AgentUser             `json:"user,omitempty"`
+	Monitoring *MonitoringInfo        `json:"monitoring,omitempty"`
+	Container  *Containerization      `json:"container,omitempty"` // nil when not running in a container
+	MaxConcurrent int                 `json:"max_concurrent,omitempty"` // limit on concurrent commands in effect (0 = unlimited)
+	Timestamp  string                 `json:"timestamp"`
+}
+
+// MonitoringInfo - log monitoring health of the agent
+type MonitoringInfo struct {
+	ErrorEventsDropped uint64 `json:"error_events_dropped"` // since agent start
+}
+
+// AgentUser - the identity and privileges the agent runs with
+type AgentUser struct {
+	UID          int           `json:"uid"` // -1 where not supported (Windows)
+	GID          int           `json:"gid"`
+	Username     string        `json:"username,omitempty"`
+	Root         bool          `json:"root"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"` // Linux only
+}
+
+// Capabilities - Linux capability sets of the agent process (lowercase names, e.g. cap_net_admin)
+type Capabilities struct {
+	Effective []string `json:"effective"`
+	Permitted []string `json:"permitted"`
+	Bounding  []string `json:"bounding"`
+}
+
+// ProcessInfo - process-level health of the agent (Linux only)
+type ProcessInfo struct {
+	DefunctTotal    int `json:"defunct_total"`    // zombie processes on the system
+	DefunctChildren int `json:"defunct_children"` // zombies parented by the agent (reaping leak)
+}
+
+func NewAgentInfoMessage() *AgentInfoMessage {
+	return &AgentInfoMessage{
+		Type:      TypeAgentInfo,
+		Timestamp: timestamp(),
+	}
+}
+
+// ConnectionDiagnostics - details of the current connection for troubleshooting
+type ConnectionDiagnostics struct {
+	Endpoint             string `json:"endpoint"`
+	RemoteAddr           string `json:"remote_addr,omitempty"` // resolved server IP:port
+	TLSVersion           string `json:"tls_version,omitempty"`
+	CipherSuite          string `json:"cipher_suite,omitempty"`
+	HandshakeMs          int64  `json:"handshake_ms"`
+	ConnectedAt          string `json:"connected_at,omitempty"`
+	LastDisconnectReason string `json:"last_disconnect_reason,omitempty"`
+	LastDisconnectAt     string `json:"last_disconnect_at,omitempty"`
+	MessagesDropped      uint64 `json:"messages_dropped"` // outgoing messages dropped on a full send buffer, since agent start
+}
@@ -3,6 +3,7 @@ package messages
 import (
 	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestNewDiscoveryMessage(t *testing.T) {
@@ -68,7 +69,7 @@ func TestNewHealthMessage(t *testing.T) {
 }
 
 func TestNewHeartbeatMessage(t *testing.T) {
-	msg := NewHeartbeatMessage()
+	msg := NewHeartbeatMessage(0, 0, time.Time{})
 
 	if msg.Type != TypeHeartbeat {
 		t.Errorf("Type = %q, expected %q", msg.Type, TypeHeartbeat)
@@ -76,6 +77,15 @@ func TestNewHeartbeatMessage(t *testing.T) {
 	if msg.Timestamp == "" {
 		t.Error("Timestamp should not be empty")
 	}
+	if msg.LastCommandAt != "" {
+		t.Errorf("LastCommandAt = %q, expected empty before any command", msg.LastCommandAt)
+	}
+
+	last := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	msg = NewHeartbeatMessage(2, 1, last)
+	if msg.Running != 2 || msg.Queued != 1 || msg.LastCommandAt != "2026-03-01T12:00:00Z" {
+		t.Errorf("unexpected load summary %+v", msg)
+	}
 }
 
 func TestParseCommandMessage(t *testing.T) {
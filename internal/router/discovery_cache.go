@@ -0,0 +1,91 @@
+package router
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// DefaultDiscoveryCacheTTL is how long a discovery answers discover requests
+// before they trigger a new scan
+const DefaultDiscoveryCacheTTL = 60 * time.Second
+
+// clock returns the current time (replaced in tests)
+var clock = time.Now
+
+// discoveryCache is the latest discovery as of when it was scanned, and the
+// modification times of its apps' antidote.yml files then
+type discoveryCache struct {
+	msg       *messages.DiscoveryMessage
+	scannedAt time.Time
+	configs   map[string]time.Time // app path -> antidote.yml mtime (zero if missing)
+}
+
+// SetDiscoveryCacheTTL sets how long a discovery answers discover requests
+// without a new scan (0 disables the cache)
+func (r *Router) SetDiscoveryCacheTTL(ttl time.Duration) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.discoveryCacheTTL = ttl
+}
+
+// cacheDiscovery records a fresh scan for later discover requests
+func (r *Router) cacheDiscovery(msg *messages.DiscoveryMessage) {
+	cache := &discoveryCache{msg: msg, scannedAt: clock(), configs: appConfigStamps(msg.Apps)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.discoveryCache = cache
+}
+
+// invalidateDiscoveryCache makes the next discover request scan again
+func (r *Router) invalidateDiscoveryCache() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.discoveryCache = nil
+}
+
+// cachedDiscovery returns the cached discovery if it is younger than the TTL
+// and none of its apps' antidote.yml files changed since, or nil
+func (r *Router) cachedDiscovery() *messages.DiscoveryMessage {
+	r.mu.Lock()
+	cache, ttl := r.discoveryCache, r.discoveryCacheTTL
+	r.mu.Unlock()
+
+	if cache == nil || ttl <= 0 || clock().Sub(cache.scannedAt) >= ttl {
+		return nil
+	}
+	for path, stamp := range cache.configs {
+		if !appConfigStamp(path).Equal(stamp) {
+			log.Printf("App config changed in %s, discarding cached discovery", path)
+			r.invalidateDiscoveryCache()
+			return nil
+		}
+	}
+	return cache.msg
+}
+
+// appConfigStamps returns the antidote.yml modification time of each app
+func appConfigStamps(apps []messages.AppInfo) map[string]time.Time {
+	stamps := make(map[string]time.Time, len(apps))
+	for _, app := range apps {
+		stamps[app.Path] = appConfigStamp(app.Path)
+	}
+	return stamps
+}
+
+// appConfigStamp returns the modification time of an app's antidote.yml, or
+// the zero time if it has none
+func appConfigStamp(appPath string) time.Time {
+	info, err := os.Stat(filepath.Join(appPath, "antidote.yml"))
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
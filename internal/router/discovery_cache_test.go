@@ -0,0 +1,163 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// fakeClock pins the router clock for the rest of a test and returns a
+// function that moves it forward
+func fakeClock(t *testing.T) func(time.Duration) {
+	t.Helper()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	original := clock
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = original })
+	return func(d time.Duration) { now = now.Add(d) }
+}
+
+func discoverData(t *testing.T, force bool) []byte {
+	return mustMarshal(t, messages.DiscoverMessage{Type: messages.TypeDiscover, Force: force})
+}
+
+func TestRouter_DiscoveryCache_HitWithinTTL(t *testing.T) {
+	advance := fakeClock(t)
+	r, rec := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{
+		discoveryWithApps("/var/www/app"),
+		discoveryWithApps("/var/www/app", "/var/www/api"),
+	}}
+	r.discover = fake.discover
+
+	r.Handle(messages.TypeDiscover, discoverData(t, false))
+	advance(30 * time.Second)
+	r.Handle(messages.TypeDiscover, nil)
+
+	if calls := fake.callCount(); calls != 1 {
+		t.Errorf("expected the second request to be answered from the cache, got %d scans", calls)
+	}
+	sent := rec.sentDiscoveries()
+	if len(sent) != 2 {
+		t.Fatalf("expected both requests to be answered, got %d pushes", len(sent))
+	}
+	if sent[1] != sent[0] {
+		t.Errorf("expected the cached discovery to be sent again, got %+v", sent[1])
+	}
+}
+
+func TestRouter_DiscoveryCache_ExpiresAfterTTL(t *testing.T) {
+	advance := fakeClock(t)
+	r, rec := newTestRouter(t)
+	r.SetDiscoveryCacheTTL(10 * time.Second)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{
+		discoveryWithApps("/var/www/app"),
+		discoveryWithApps("/var/www/app", "/var/www/api"),
+	}}
+	r.discover = fake.discover
+
+	r.Handle(messages.TypeDiscover, nil)
+	advance(10 * time.Second)
+	r.Handle(messages.TypeDiscover, nil)
+
+	if calls := fake.callCount(); calls != 2 {
+		t.Errorf("expected an expired cache to scan again, got %d scans", calls)
+	}
+	if sent := rec.sentDiscoveries(); len(sent) != 2 || len(sent[1].Apps) != 2 {
+		t.Errorf("expected the new scan to be sent, got %+v", sent)
+	}
+
+	// Disabled, every request scans
+	r.SetDiscoveryCacheTTL(0)
+	r.Handle(messages.TypeDiscover, nil)
+	if calls := fake.callCount(); calls != 3 {
+		t.Errorf("expected a scan with the cache disabled, got %d scans", calls)
+	}
+}
+
+func TestRouter_DiscoveryCache_ForceBypasses(t *testing.T) {
+	fakeClock(t)
+	r, rec := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{
+		discoveryWithApps("/var/www/app"),
+		discoveryWithApps("/var/www/app", "/var/www/api"),
+	}}
+	r.discover = fake.discover
+
+	r.Handle(messages.TypeDiscover, nil)
+	r.Handle(messages.TypeDiscover, discoverData(t, true))
+
+	if calls := fake.callCount(); calls != 2 {
+		t.Errorf("expected a forced request to scan, got %d scans", calls)
+	}
+
+	// The forced scan refreshes the cache
+	r.Handle(messages.TypeDiscover, nil)
+	if calls := fake.callCount(); calls != 2 {
+		t.Errorf("expected the forced scan to be cached, got %d scans", calls)
+	}
+	sent := rec.sentDiscoveries()
+	if len(sent) != 3 || len(sent[2].Apps) != 2 {
+		t.Errorf("expected the forced scan's results to be answered from the cache, got %+v", sent)
+	}
+}
+
+func TestRouter_DiscoveryCache_InvalidatedByConfigChange(t *testing.T) {
+	fakeClock(t)
+	r, _ := newTestRouter(t)
+
+	appDir := t.TempDir()
+	configPath := filepath.Join(appDir, "antidote.yml")
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{discoveryWithApps(appDir)}}
+	r.discover = fake.discover
+
+	// A config appearing
+	r.Handle(messages.TypeDiscover, nil)
+	if err := os.WriteFile(configPath, []byte("version: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle(messages.TypeDiscover, nil)
+	if calls := fake.callCount(); calls != 2 {
+		t.Fatalf("expected a new antidote.yml to invalidate the cache, got %d scans", calls)
+	}
+
+	// A config being edited
+	r.Handle(messages.TypeDiscover, nil)
+	if calls := fake.callCount(); calls != 2 {
+		t.Fatalf("expected an unchanged config to keep the cache, got %d scans", calls)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(configPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle(messages.TypeDiscover, nil)
+	if calls := fake.callCount(); calls != 3 {
+		t.Errorf("expected an edited antidote.yml to invalidate the cache, got %d scans", calls)
+	}
+}
+
+func TestRouter_DiscoveryCache_InvalidatedByRefreshPaths(t *testing.T) {
+	fakeClock(t)
+	r, _ := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{discoveryWithApps("/var/www/app")}}
+	r.discover = fake.discover
+	r.discoverApps = func() []messages.AppInfo {
+		return []messages.AppInfo{{Path: "/var/www/app"}, {Path: "/var/www/api"}}
+	}
+
+	r.Handle(messages.TypeDiscover, nil)
+	r.Handle(messages.TypeRefreshPaths, nil)
+	r.Handle(messages.TypeDiscover, nil)
+
+	if calls := fake.callCount(); calls != 2 {
+		t.Errorf("expected refresh_paths to invalidate the cache, got %d scans", calls)
+	}
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/crash"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// MinDiscoveryInterval is the shortest background discovery interval the
+// server may set; shorter ones are raised to it
+const MinDiscoveryInterval = time.Minute
+
+// minDiscoveryInterval is MinDiscoveryInterval (overridden in tests)
+var minDiscoveryInterval = MinDiscoveryInterval
+
+// StartDiscoverySchedule re-runs discovery in the background at the interval
+// set by the server's discovery_schedule messages, pushing results only when
+// they changed. It is off until the server sets an interval. Cancelling ctx
+// stops it.
+func (r *Router) StartDiscoverySchedule(ctx context.Context) {
+	go r.runDiscoverySchedule(ctx)
+}
+
+// runDiscoverySchedule is the background discovery loop
+func (r *Router) runDiscoverySchedule(ctx context.Context) {
+	defer crash.Recover("scheduled discovery")
+
+	var timer *time.Timer
+	var tick <-chan time.Time
+	reset := func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		tick = nil
+		if interval := r.DiscoveryInterval(); interval > 0 {
+			timer = time.NewTimer(interval)
+			tick = timer.C
+		}
+	}
+	reset()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-r.scheduleCh:
+			reset()
+		case <-tick:
+			r.applyDiscovery(r.runDiscovery(), false)
+			reset()
+		}
+	}
+}
+
+// handleDiscoverySchedule applies a discovery_schedule message
+func (r *Router) handleDiscoverySchedule(data []byte) {
+	scheduleMsg, err := messages.ParseDiscoveryScheduleMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse discovery_schedule message: %v", err)
+		return
+	}
+	if scheduleMsg.IntervalSeconds < 0 {
+		log.Printf("Ignoring discovery_schedule with negative interval %d", scheduleMsg.IntervalSeconds)
+		return
+	}
+
+	r.SetDiscoveryInterval(time.Duration(scheduleMsg.IntervalSeconds) * time.Second)
+}
+
+// SetDiscoveryInterval sets how often discovery re-runs in the background
+// (0 disables it). The next run is a full interval from now.
+func (r *Router) SetDiscoveryInterval(interval time.Duration) {
+	if interval < 0 {
+		interval = 0
+	}
+	if interval > 0 && interval < minDiscoveryInterval {
+		interval = minDiscoveryInterval
+	}
+
+	r.mu.Lock()
+	r.discoveryInterval = interval
+	r.mu.Unlock()
+
+	if interval == 0 {
+		log.Printf("Background discovery disabled")
+	} else {
+		log.Printf("Background discovery every %s", interval)
+	}
+
+	// Wake the loop to pick up the new interval; one pending wake-up is enough
+	select {
+	case r.scheduleCh <- struct{}{}:
+	default:
+	}
+}
+
+// DiscoveryInterval returns how often discovery re-runs in the background
+// (0 = disabled)
+func (r *Router) DiscoveryInterval() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.discoveryInterval
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// shortDiscoveryIntervals allows sub-minute intervals for the rest of a test
+func shortDiscoveryIntervals(t *testing.T) {
+	t.Helper()
+
+	original := minDiscoveryInterval
+	minDiscoveryInterval = time.Millisecond
+	t.Cleanup(func() { minDiscoveryInterval = original })
+}
+
+func scheduleData(t *testing.T, seconds int) []byte {
+	return mustMarshal(t, messages.DiscoveryScheduleMessage{
+		Type:            messages.TypeDiscoverySchedule,
+		IntervalSeconds: seconds,
+	})
+}
+
+func TestRouter_DiscoverySchedule_SetsInterval(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	if interval := r.DiscoveryInterval(); interval != 0 {
+		t.Fatalf("expected background discovery off by default, got %s", interval)
+	}
+
+	r.Handle(messages.TypeDiscoverySchedule, scheduleData(t, 300))
+	if interval := r.DiscoveryInterval(); interval != 5*time.Minute {
+		t.Errorf("expected a 5m interval, got %s", interval)
+	}
+
+	// Too short is raised to the minimum, negative is ignored, 0 disables
+	r.Handle(messages.TypeDiscoverySchedule, scheduleData(t, 1))
+	if interval := r.DiscoveryInterval(); interval != MinDiscoveryInterval {
+		t.Errorf("expected the minimum interval, got %s", interval)
+	}
+	r.Handle(messages.TypeDiscoverySchedule, scheduleData(t, -5))
+	if interval := r.DiscoveryInterval(); interval != MinDiscoveryInterval {
+		t.Errorf("expected a negative interval to be ignored, got %s", interval)
+	}
+	r.Handle(messages.TypeDiscoverySchedule, scheduleData(t, 0))
+	if interval := r.DiscoveryInterval(); interval != 0 {
+		t.Errorf("expected background discovery to be disabled, got %s", interval)
+	}
+}
+
+func TestRouter_DiscoverySchedule_RunsAtInterval(t *testing.T) {
+	shortDiscoveryIntervals(t)
+	r, rec := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{
+		discoveryWithApps("/var/www/app"),
+		discoveryWithApps("/var/www/app", "/var/www/api"),
+	}}
+	r.discover = fake.discover
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.StartDiscoverySchedule(ctx)
+
+	// Off until the server sets an interval
+	time.Sleep(50 * time.Millisecond)
+	if calls := fake.callCount(); calls != 0 {
+		t.Fatalf("expected no background discovery before a schedule, got %d runs", calls)
+	}
+
+	r.SetDiscoveryInterval(20 * time.Millisecond)
+	waitForCalls(t, fake, 3)
+
+	// Only changed results are pushed
+	if sent := rec.sentDiscoveries(); len(sent) != 2 {
+		t.Errorf("expected 2 discovery pushes for 2 distinct results, got %d", len(sent))
+	}
+
+	// Disabling stops it
+	r.SetDiscoveryInterval(0)
+	time.Sleep(30 * time.Millisecond)
+	calls := fake.callCount()
+	time.Sleep(100 * time.Millisecond)
+	if fake.callCount() != calls {
+		t.Errorf("expected no runs once disabled, went from %d to %d", calls, fake.callCount())
+	}
+}
+
+func TestRouter_DiscoverySchedule_IntervalChangeApplies(t *testing.T) {
+	shortDiscoveryIntervals(t)
+	r, _ := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{discoveryWithApps()}}
+	r.discover = fake.discover
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.StartDiscoverySchedule(ctx)
+
+	// A long interval, then a short one takes effect without waiting it out
+	r.SetDiscoveryInterval(time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	r.SetDiscoveryInterval(10 * time.Millisecond)
+	waitForCalls(t, fake, 2)
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultLifetimeDrainTimeout bounds how long an expired agent waits for
+// running commands before exiting anyway
+const DefaultLifetimeDrainTimeout = 10 * time.Minute
+
+// drainPollInterval is how often an expired agent checks for running commands
+var drainPollInterval = time.Second
+
+// StartMaxLifetime restarts the agent periodically: once lifetime has elapsed
+// it enables drain mode, waits for running commands to finish (up to
+// drainTimeout) and then calls expired, which should shut the agent down so
+// its supervisor starts a fresh process. Cancelling ctx (e.g. a normal
+// shutdown) stops the timer.
+func (r *Router) StartMaxLifetime(ctx context.Context, lifetime, drainTimeout time.Duration, expired func()) {
+	if lifetime <= 0 {
+		return
+	}
+	if drainTimeout <= 0 {
+		drainTimeout = DefaultLifetimeDrainTimeout
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(lifetime):
+		}
+
+		log.Printf("Max lifetime of %s reached, draining before restart", lifetime)
+		r.SetDraining(true)
+
+		if !r.waitForIdle(ctx, drainTimeout) {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Drain timeout of %s reached with %d commands still running, exiting anyway",
+				drainTimeout, r.executor.RunningCount())
+		}
+
+		expired()
+	}()
+}
+
+// waitForIdle waits until no commands are running, returning false on timeout
+// or cancellation
+func (r *Router) waitForIdle(ctx context.Context, timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if r.executor.RunningCount() == 0 {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-deadline.C:
+			return false
+		case <-ticker.C:
+		}
+	}
+}
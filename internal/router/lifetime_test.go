@@ -0,0 +1,91 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func shortDrainPoll(t *testing.T) {
+	original := drainPollInterval
+	drainPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { drainPollInterval = original })
+}
+
+func TestRouter_MaxLifetime_DrainsThenExits(t *testing.T) {
+	shortDrainPoll(t)
+	r, rec := newTestRouter(t)
+
+	// A command that outlives the agent's lifetime
+	r.Handle(messages.TypeCommand, commandData(t, "cmd_long", "sleep 0.3"))
+
+	expired := make(chan time.Time, 1)
+	start := time.Now()
+	r.StartMaxLifetime(context.Background(), 50*time.Millisecond, time.Minute, func() {
+		expired <- time.Now()
+	})
+
+	// Drain mode kicks in once the lifetime elapses
+	rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+		status, ok := msg.(*messages.DrainStatusMessage)
+		return ok && status.Draining
+	})
+	if !r.IsDraining() {
+		t.Error("expected drain mode after max lifetime")
+	}
+
+	select {
+	case at := <-expired:
+		// Exit waits for the running command to finish
+		if at.Sub(start) < 300*time.Millisecond {
+			t.Errorf("expected exit after running command finished, got %s", at.Sub(start))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for lifetime expiry")
+	}
+
+	if n := r.executor.RunningCount(); n != 0 {
+		t.Errorf("expected no running commands at exit, got %d", n)
+	}
+}
+
+func TestRouter_MaxLifetime_DrainTimeout(t *testing.T) {
+	shortDrainPoll(t)
+	r, _ := newTestRouter(t)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd_stuck", "sleep 5"))
+	defer r.executor.Cancel("cmd_stuck")
+
+	expired := make(chan struct{})
+	r.StartMaxLifetime(context.Background(), 10*time.Millisecond, 100*time.Millisecond, func() {
+		close(expired)
+	})
+
+	select {
+	case <-expired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected exit once the drain timeout passed")
+	}
+}
+
+func TestRouter_MaxLifetime_CancelledByShutdown(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	expired := make(chan struct{}, 1)
+	r.StartMaxLifetime(ctx, 100*time.Millisecond, time.Minute, func() {
+		expired <- struct{}{}
+	})
+	cancel()
+
+	select {
+	case <-expired:
+		t.Fatal("expiry must not fire after shutdown started")
+	case <-time.After(300 * time.Millisecond):
+	}
+	if r.IsDraining() {
+		t.Error("expected no drain after shutdown cancelled the lifetime")
+	}
+}
@@ -0,0 +1,61 @@
+package router
+
+import "sync"
+
+// outputGovernor shares the send buffer between running commands: output is
+// sent one message at a time, and when several commands have output waiting
+// they take turns, so a chatty command can't starve a quiet one of the room
+// freed as the buffer drains
+type outputGovernor struct {
+	mu      sync.Mutex
+	sending bool
+	order   []string                   // commands with output waiting, next turn first
+	waiting map[string][]chan struct{} // per command, its waiting sends in arrival order
+}
+
+func newOutputGovernor() *outputGovernor {
+	return &outputGovernor{waiting: make(map[string][]chan struct{})}
+}
+
+// acquire waits for a command's turn to send output. Each acquire must be
+// followed by a release.
+func (g *outputGovernor) acquire(id string) {
+	g.mu.Lock()
+	if !g.sending {
+		g.sending = true
+		g.mu.Unlock()
+		return
+	}
+
+	turn := make(chan struct{})
+	if len(g.waiting[id]) == 0 {
+		g.order = append(g.order, id)
+	}
+	g.waiting[id] = append(g.waiting[id], turn)
+	g.mu.Unlock()
+
+	<-turn
+}
+
+// release ends a turn, handing the next one to the command whose turn is
+// next. A command with more output waiting goes to the back of the line.
+func (g *outputGovernor) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if len(g.order) == 0 {
+		g.sending = false
+		return
+	}
+
+	id := g.order[0]
+	g.order = g.order[1:]
+	turns := g.waiting[id]
+	if len(turns) > 1 {
+		g.waiting[id] = turns[1:]
+		g.order = append(g.order, id)
+	} else {
+		delete(g.waiting, id)
+	}
+	close(turns[0])
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// waitForWaiting polls until a command has n sends waiting for their turn
+func waitForWaiting(t *testing.T, g *outputGovernor, id string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		waiting := len(g.waiting[id])
+		g.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d sends of %s to wait", n, id)
+}
+
+func TestOutputGovernor_TakesTurns(t *testing.T) {
+	g := newOutputGovernor()
+	g.acquire("chatty")
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	send := func(id string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.acquire(id)
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+			g.release()
+		}()
+	}
+
+	// The chatty command queues up more output before the quiet one has any
+	send("chatty")
+	waitForWaiting(t, g, "chatty", 1)
+	send("chatty")
+	waitForWaiting(t, g, "chatty", 2)
+	send("quiet")
+	waitForWaiting(t, g, "quiet", 1)
+
+	g.release()
+	wg.Wait()
+
+	expected := []string{"chatty", "quiet", "chatty"}
+	if len(order) != len(expected) {
+		t.Fatalf("expected turns %v, got %v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expected turns %v, got %v", expected, order)
+		}
+	}
+
+	// Idle again: the next send goes straight through
+	done := make(chan struct{})
+	go func() {
+		g.acquire("quiet")
+		g.release()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected an idle governor not to block")
+	}
+}
+
+func TestRouter_QuietOutputNotStarved(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	// A slowly draining connection: each send waits for the test to let it
+	// through
+	var mu sync.Mutex
+	var sent []string
+	gate := make(chan struct{})
+	sending := make(chan struct{}, 1)
+	r.SetTimedSend(func(msg interface{}, timeout time.Duration) error {
+		if output, ok := msg.(*messages.OutputMessage); ok {
+			select {
+			case sending <- struct{}{}:
+			default:
+			}
+			<-gate
+			mu.Lock()
+			sent = append(sent, output.ID)
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	const chattyLines, quietLines = 50, 3
+	chattyDone := make(chan struct{})
+	go func() {
+		defer close(chattyDone)
+		for i := 0; i < chattyLines; i++ {
+			r.handleOutput(messages.NewOutputMessage("chatty", "stdout", "spam\n"))
+		}
+	}()
+
+	// The quiet command starts while the chatty one is mid-send
+	<-sending
+	quietDone := make(chan struct{})
+	go func() {
+		defer close(quietDone)
+		for i := 0; i < quietLines; i++ {
+			r.handleOutput(messages.NewOutputMessage("quiet", "stdout", "progress\n"))
+		}
+	}()
+	waitForWaiting(t, r.outputGovernor, "quiet", 1)
+
+	for i := 0; i < chattyLines+quietLines; i++ {
+		time.Sleep(time.Millisecond)
+		gate <- struct{}{}
+	}
+	<-chattyDone
+	<-quietDone
+
+	// Taking turns, the quiet command's output is interleaved with the
+	// chatty command's rather than queued behind all of it
+	last := -1
+	for i, id := range sent {
+		if id == "quiet" {
+			last = i
+		}
+	}
+	if limit := 2*quietLines + 2; last < 0 || last > limit {
+		t.Errorf("expected the quiet command's output within the first %d sends, last was at %d: %v", limit+1, last, sent)
+	}
+}
@@ -1,44 +1,136 @@
 package router
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/codebasehealth/antidote-agent/internal/connection"
+	"github.com/codebasehealth/antidote-agent/internal/crash"
+	"github.com/codebasehealth/antidote-agent/internal/diagnostics"
 	"github.com/codebasehealth/antidote-agent/internal/discovery"
 	"github.com/codebasehealth/antidote-agent/internal/executor"
+	"github.com/codebasehealth/antidote-agent/internal/health"
 	"github.com/codebasehealth/antidote-agent/internal/logmonitor"
 	"github.com/codebasehealth/antidote-agent/internal/messages"
 	"github.com/codebasehealth/antidote-agent/internal/security"
 	"github.com/codebasehealth/antidote-agent/internal/signing"
 )
 
+// RediscoverInterval is how often commands for the same unknown app directory
+// may trigger an app path refresh
+const RediscoverInterval = 30 * time.Second
+
+// DefaultOutputSendWait is how long command output and completion messages
+// wait for room in a full send buffer before they are dropped
+const DefaultOutputSendWait = 5 * time.Second
+
+// outputRetryInterval is how often a full send buffer is retried
+const outputRetryInterval = 10 * time.Millisecond
+
 // SendFunc is a function that sends a message
 type SendFunc func(msg interface{}) error
 
+// TimedSendFunc sends a message, waiting up to timeout for room in a full
+// send buffer
+type TimedSendFunc func(msg interface{}, timeout time.Duration) error
+
+// DiagnosticsFunc returns the current connection diagnostics
+type DiagnosticsFunc func() messages.ConnectionDiagnostics
+
+// MetricsFunc returns the most recent health metrics (nil if none yet)
+type MetricsFunc func() *messages.HealthMessage
+
 // Router routes incoming messages to appropriate handlers
 type Router struct {
 	executor          *executor.Executor
 	validator         *security.Validator
 	verifier          *signing.Verifier
+	adminVerifier     *signing.Verifier // break-glass key whose commands bypass the allow-list
 	logMonitor        *logmonitor.Monitor
 	discoveryProvider *discoveryProvider
 	send              SendFunc
+	timedSend         TimedSendFunc // optional; without it full buffers are retried by polling send
+	diagnostics       DiagnosticsFunc
+	metrics           MetricsFunc
+	discover          func() *messages.DiscoveryMessage
+	discoverApps      func() []messages.AppInfo
+	discoveryRun      *discoveryCall // in-flight discovery, shared by concurrent requests
+	discoveryRunMu    sync.Mutex
+	outputSendWait    time.Duration
+	outputBlocked     bool // a wait for send buffer room timed out; don't wait again until a send succeeds
+	outputGovernor    *outputGovernor
+	rediscoverApps    bool                 // refresh app paths when a command targets an unknown app directory
+	appSearchPaths    []string             // base directories apps are discovered in
+	rediscoveredAt    map[string]time.Time // per directory, when a command last triggered a refresh
+
+	// Drain mode: finish running commands, reject new ones
+	draining bool
+	mu       sync.Mutex
+
+	// Background discovery interval set by the server (0 = off), and the
+	// wake-up for the loop when it changes
+	discoveryInterval time.Duration
+	scheduleCh        chan struct{}
+
+	// Most recent discovery results, and the content hash of the last
+	// successful push
+	lastDiscovery *messages.DiscoveryMessage
+	lastPushHash  string
+
+	// Latest scan, answering discover requests until discoveryCacheTTL
+	// passes (0 = off) or an app config changes
+	discoveryCache    *discoveryCache
+	discoveryCacheTTL time.Duration
+}
+
+// discoveryCall is a discovery run whose result is shared by every caller
+// that asked for discovery while it was in flight
+type discoveryCall struct {
+	done   chan struct{}
+	result *messages.DiscoveryMessage
 }
 
 // discoveryProvider implements logmonitor.AppDiscovery
 type discoveryProvider struct {
 	apps []messages.AppInfo
+	mu   sync.RWMutex
 }
 
 func (p *discoveryProvider) GetApps() []messages.AppInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.apps
 }
 
+func (p *discoveryProvider) setApps(apps []messages.AppInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.apps = apps
+}
+
 // NewRouter creates a new message router
 func NewRouter(send SendFunc, publicKey string) *Router {
 	r := &Router{
-		send:      send,
-		validator: security.NewValidator(),
+		send:              send,
+		validator:         security.NewValidator(),
+		discover:          runDiscover,
+		discoverApps:      discovery.DiscoverApps,
+		outputSendWait:    DefaultOutputSendWait,
+		outputGovernor:    newOutputGovernor(),
+		rediscoverApps:    true,
+		appSearchPaths:    discovery.AppSearchPaths(),
+		rediscoveredAt:    make(map[string]time.Time),
+		scheduleCh:        make(chan struct{}, 1),
+		discoveryCacheTTL: DefaultDiscoveryCacheTTL,
 	}
 
 	// Initialize signature verifier
@@ -60,6 +152,7 @@ func NewRouter(send SendFunc, publicKey string) *Router {
 		r.handleRejected,
 		r.validator,
 	)
+	r.executor.SetIdleHandler(r.handleIdle)
 
 	// Create discovery provider and log monitor
 	r.discoveryProvider = &discoveryProvider{}
@@ -75,18 +168,84 @@ func (r *Router) Handle(msgType string, data []byte) {
 	case messages.TypeCommand:
 		r.handleCommand(data)
 	case messages.TypeDiscover:
-		r.handleDiscover()
+		r.handleDiscover(data)
+	case messages.TypeRefreshPaths:
+		r.handleRefreshPaths()
 	case messages.TypeMonitoringConfig:
 		r.handleMonitoringConfig(data)
-	case messages.TypeAuthOK, messages.TypeAuthError:
+	case messages.TypeSetDrain:
+		r.handleSetDrain(data)
+	case messages.TypeAgentInfoRequest:
+		r.SendAgentInfo()
+	case messages.TypeStatusRequest:
+		r.handleStatusRequest()
+	case messages.TypeFlushOutput:
+		r.handleFlushOutput(data)
+	case messages.TypeCancel:
+		r.handleCancel(data)
+	case messages.TypeDedupDump:
+		r.handleDedupDump()
+	case messages.TypeCollectBundle:
+		r.handleCollectBundle(data)
+	case messages.TypeTestPattern:
+		r.handleTestPattern(data)
+	case messages.TypeUpdatePatterns:
+		r.handleUpdatePatterns(data)
+	case messages.TypeDiscoverySchedule:
+		r.handleDiscoverySchedule(data)
+	case messages.TypeAuthOK:
+		r.handleAuthOK(data)
+	case messages.TypeAuthError:
 		// Already handled by connection manager
 	default:
 		log.Printf("Unhandled message type: %s", msgType)
 	}
 }
 
+// handleAuthOK applies settings the server sends with auth_ok, on connect or
+// re-sent later
+func (r *Router) handleAuthOK(data []byte) {
+	authOK, err := messages.ParseAuthOKMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse auth_ok message: %v", err)
+		return
+	}
+
+	before := r.executor.MaxConcurrent()
+	r.executor.SetServerMaxConcurrent(authOK.MaxConcurrent)
+	if after := r.executor.MaxConcurrent(); after != before {
+		log.Printf("Concurrent command limit changed from %d to %d (0 = unlimited)", before, after)
+	}
+}
+
 // handleCommand processes a command message
 func (r *Router) handleCommand(data []byte) {
+	// Reject new work while draining
+	if r.IsDraining() {
+		if cmdID := extractCommandID(data); cmdID != "" {
+			r.handleRejected(messages.NewRejectedMessage(
+				cmdID,
+				"DRAINING",
+				"agent is draining and not accepting new commands",
+			))
+		}
+		return
+	}
+
+	// Commands signed with the admin key bypass the allow-list (deny checks
+	// still apply), so they are audited loudly
+	if r.adminVerifier != nil && r.adminVerifier.IsEnabled() {
+		if signedCmd, err := r.adminVerifier.VerifyCommand(data); err == nil {
+			cmdMsg := commandFromSigned(signedCmd)
+			cmdMsg.AdminSigned = true
+
+			log.Printf("AUDIT: Command %s signed with ADMIN key, bypassing allow-list: %s", cmdMsg.ID, cmdMsg.Command)
+
+			r.execute(cmdMsg)
+			return
+		}
+	}
+
 	// Verify signature if verifier is enabled
 	if r.verifier != nil && r.verifier.IsEnabled() {
 		signedCmd, err := r.verifier.VerifyCommand(data)
@@ -96,32 +255,24 @@ func (r *Router) handleCommand(data []byte) {
 			// Try to extract command ID for rejection message
 			cmdID := extractCommandID(data)
 			if cmdID != "" {
-				r.handleRejected(messages.NewRejectedMessage(
+				rejected := messages.NewRejectedMessage(
 					cmdID,
 					"SIGNATURE_INVALID",
 					err.Error(),
-				))
+				)
+				rejected.Subcode = signatureSubcode(err)
+				r.handleRejected(rejected)
 			}
 			return
 		}
 
 		log.Printf("Command %s signature verified", signedCmd.ID)
 
-		// Convert SignedCommand to CommandMessage
-		cmdMsg := &messages.CommandMessage{
-			Type:       signedCmd.Type,
-			ID:         signedCmd.ID,
-			Command:    signedCmd.Command,
-			WorkingDir: signedCmd.WorkingDir,
-			Env:        signedCmd.Env,
-			Timeout:    signedCmd.Timeout,
-		}
+		cmdMsg := commandFromSigned(signedCmd)
 
 		log.Printf("Received command %s: %s", cmdMsg.ID, cmdMsg.Command)
 
-		if err := r.executor.Execute(cmdMsg); err != nil {
-			log.Printf("Failed to execute command: %v", err)
-		}
+		r.execute(cmdMsg)
 		return
 	}
 
@@ -134,11 +285,121 @@ func (r *Router) handleCommand(data []byte) {
 
 	log.Printf("Received command %s: %s (unsigned)", cmdMsg.ID, cmdMsg.Command)
 
+	r.execute(cmdMsg)
+}
+
+// execute hands a command to the executor. A command for an app deployed
+// since the last discovery would be rejected with INVALID_WORKING_DIR, so if
+// its working directory looks like an app, app paths are refreshed first and
+// the command is validated once more against them.
+func (r *Router) execute(cmdMsg *messages.CommandMessage) {
+	if cmdMsg.App == "" && r.shouldRediscoverFor(cmdMsg.WorkingDir) {
+		log.Printf("Command %s targets unknown app directory %s, refreshing app paths", cmdMsg.ID, cmdMsg.WorkingDir)
+		r.handleRefreshPaths()
+	}
+
 	if err := r.executor.Execute(cmdMsg); err != nil {
 		log.Printf("Failed to execute command: %v", err)
 	}
 }
 
+// shouldRediscoverFor reports whether dir is rejected only for being outside
+// the known app paths while it exists, looks like an app and is somewhere
+// discovery would find it. Each directory triggers at most one refresh per
+// RediscoverInterval, so a burst of commands for it can't stall routing.
+func (r *Router) shouldRediscoverFor(dir string) bool {
+	r.mu.Lock()
+	enabled := r.rediscoverApps
+	r.mu.Unlock()
+
+	if !enabled || r.validator == nil || dir == "" {
+		return false
+	}
+
+	var vErr *security.ValidationError
+	if err := r.validator.ValidateDir(dir); !errors.As(err, &vErr) || vErr.Code != "INVALID_WORKING_DIR" {
+		return false
+	}
+
+	cleanDir := filepath.Clean(dir)
+	if !r.inAppSearchPath(cleanDir) || !discovery.LooksLikeApp(cleanDir) {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := clock()
+	if last, ok := r.rediscoveredAt[cleanDir]; ok && now.Sub(last) < RediscoverInterval {
+		log.Printf("Not refreshing app paths for %s again within %v", cleanDir, RediscoverInterval)
+		return false
+	}
+	for d, last := range r.rediscoveredAt {
+		if now.Sub(last) >= RediscoverInterval {
+			delete(r.rediscoveredAt, d)
+		}
+	}
+	r.rediscoveredAt[cleanDir] = now
+	return true
+}
+
+// inAppSearchPath reports whether dir is inside one of the directories apps
+// are discovered in
+func (r *Router) inAppSearchPath(dir string) bool {
+	for _, base := range r.appSearchPaths {
+		if strings.HasPrefix(dir, filepath.Clean(base)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureSubcode maps a verification error to the rejection subcode that
+// lets the cloud tell clock skew (expired, from_future) from tampering
+// (invalid)
+func signatureSubcode(err error) string {
+	switch {
+	case errors.Is(err, signing.ErrMissingSignature):
+		return "missing_signature"
+	case errors.Is(err, signing.ErrMissingTimestamp):
+		return "missing_timestamp"
+	case errors.Is(err, signing.ErrMissingNonce):
+		return "missing_nonce"
+	case errors.Is(err, signing.ErrMessageExpired):
+		return "expired"
+	case errors.Is(err, signing.ErrMessageFromFuture):
+		return "from_future"
+	case errors.Is(err, signing.ErrReplayedNonce):
+		return "replayed"
+	case errors.Is(err, signing.ErrInvalidSignature):
+		return "invalid"
+	default:
+		return "malformed"
+	}
+}
+
+// commandFromSigned converts a verified SignedCommand to a CommandMessage
+func commandFromSigned(signedCmd *signing.SignedCommand) *messages.CommandMessage {
+	return &messages.CommandMessage{
+		Type:           signedCmd.Type,
+		ID:             signedCmd.ID,
+		Command:        signedCmd.Command,
+		WorkingDir:     signedCmd.WorkingDir,
+		App:            signedCmd.App,
+		Env:            signedCmd.Env,
+		Timeout:        signedCmd.Timeout,
+		Priority:       signedCmd.Priority,
+		Pipefail:       signedCmd.Pipefail,
+		Script:         signedCmd.Script,
+		Stdin:          signedCmd.Stdin,
+		StdinEncoding:  signedCmd.StdinEncoding,
+		MaxOutputBytes: signedCmd.MaxOutputBytes,
+		GroupID:        signedCmd.GroupID,
+		Step:           signedCmd.Step,
+		DiskHeavy:      signedCmd.DiskHeavy,
+	}
+}
+
 // extractCommandID tries to extract the command ID from raw JSON data
 func extractCommandID(data []byte) string {
 	// Simple extraction for rejection messages
@@ -152,11 +413,95 @@ func extractCommandID(data []byte) string {
 	return msg.ID
 }
 
-// handleDiscover runs server discovery and sends results
-func (r *Router) handleDiscover() {
+// runDiscover runs server discovery
+func runDiscover() *messages.DiscoveryMessage {
 	log.Printf("Running server discovery...")
+	return discovery.Discover()
+}
+
+// runDiscovery runs discovery, or waits for the run already in flight and
+// shares its result, so overlapping requests (on-demand and background) never
+// scan the filesystem concurrently
+func (r *Router) runDiscovery() *messages.DiscoveryMessage {
+	r.discoveryRunMu.Lock()
+	if call := r.discoveryRun; call != nil {
+		r.discoveryRunMu.Unlock()
+		<-call.done
+		return call.result
+	}
+	call := &discoveryCall{done: make(chan struct{})}
+	r.discoveryRun = call
+	r.discoveryRunMu.Unlock()
+
+	call.result = r.discover()
+
+	r.discoveryRunMu.Lock()
+	r.discoveryRun = nil
+	r.discoveryRunMu.Unlock()
+	close(call.done)
+
+	return call.result
+}
+
+// handleDiscover sends discovery results (always, since the cloud asked for
+// them): the cached ones if still fresh, unless the request sets force
+func (r *Router) handleDiscover(data []byte) {
+	force := false
+	if len(data) > 0 {
+		discoverMsg, err := messages.ParseDiscoverMessage(data)
+		if err != nil {
+			log.Printf("Failed to parse discover message: %v", err)
+		} else {
+			force = discoverMsg.Force
+		}
+	}
+
+	if !force {
+		if cached := r.cachedDiscovery(); cached != nil {
+			log.Printf("Answering discover with cached discovery")
+			if err := r.send(cached); err != nil {
+				log.Printf("Failed to send discovery: %v", err)
+			}
+			return
+		}
+	}
+
+	r.applyDiscovery(r.runDiscovery(), true)
+}
+
+// handleRefreshPaths re-runs just app discovery and updates the validator's
+// allowed paths and the log monitor's apps, without sending a discovery
+func (r *Router) handleRefreshPaths() {
+	apps := r.discoverApps()
 
-	discoveryMsg := discovery.Discover()
+	// Keep the current paths rather than locking every command out if no
+	// apps are found (same as a full discovery)
+	if r.validator != nil && len(apps) > 0 {
+		r.validator.UpdateApps(apps)
+	}
+	if r.discoveryProvider != nil {
+		r.discoveryProvider.setApps(apps)
+	}
+	if r.logMonitor != nil {
+		r.logMonitor.AppsUpdated()
+	}
+
+	r.mu.Lock()
+	if r.lastDiscovery != nil {
+		refreshed := *r.lastDiscovery
+		refreshed.Apps = apps
+		r.lastDiscovery = &refreshed
+	}
+	r.discoveryCache = nil // the apps moved under it
+	r.mu.Unlock()
+
+	log.Printf("Refreshed app paths: %d apps", len(apps))
+}
+
+// applyDiscovery updates the validator and log monitor with discovery results
+// and sends them to the cloud. Unless force is set, the push is skipped when
+// the payload hasn't changed since the last successful push.
+func (r *Router) applyDiscovery(discoveryMsg *messages.DiscoveryMessage, force bool) error {
 
 	// Update security validator with discovered apps
 	if r.validator != nil && len(discoveryMsg.Apps) > 0 {
@@ -166,34 +511,164 @@ func (r *Router) handleDiscover() {
 
 	// Update discovery provider for log monitor
 	if r.discoveryProvider != nil {
-		r.discoveryProvider.apps = discoveryMsg.Apps
+		r.discoveryProvider.setApps(discoveryMsg.Apps)
 		log.Printf("Discovery provider updated with %d apps", len(discoveryMsg.Apps))
 	}
+	if r.logMonitor != nil {
+		r.logMonitor.AppsUpdated()
+	}
+
+	r.cacheDiscovery(discoveryMsg)
+	hash := discoveryHash(discoveryMsg)
+
+	r.mu.Lock()
+	r.lastDiscovery = discoveryMsg
+	unchanged := hash != "" && hash == r.lastPushHash
+	r.mu.Unlock()
+
+	if unchanged && !force {
+		log.Printf("Discovery unchanged since last push, not sending")
+		return nil
+	}
 
 	if err := r.send(discoveryMsg); err != nil {
 		log.Printf("Failed to send discovery: %v", err)
-	} else {
-		log.Printf("Discovery sent: %d services, %d languages, %d apps",
-			len(discoveryMsg.Services),
-			len(discoveryMsg.Languages),
-			len(discoveryMsg.Apps))
+		return err
 	}
+
+	r.mu.Lock()
+	r.lastPushHash = hash
+	r.mu.Unlock()
+
+	log.Printf("Discovery sent: %d services, %d languages, %d apps",
+		len(discoveryMsg.Services),
+		len(discoveryMsg.Languages),
+		len(discoveryMsg.Apps))
+	return nil
 }
 
-// handleOutput sends command output to the cloud
+// discoveryHash returns a content hash of a discovery payload, ignoring fields
+// that change on every scan (uptime, free memory and disk, load, container
+// status like "Up 3 hours", and app processes and their connections)
+func discoveryHash(msg *messages.DiscoveryMessage) string {
+	stable := *msg
+	stable.Uptime = 0
+	stable.System.MemoryFree = 0
+	stable.System.DiskFree = 0
+	stable.System.LoadAvg = 0
+
+	if msg.Apps != nil {
+		stable.Apps = make([]messages.AppInfo, len(msg.Apps))
+		for i, app := range msg.Apps {
+			app.PID = 0
+			app.Connections = nil
+			stable.Apps[i] = app
+		}
+	}
+
+	if msg.Docker != nil {
+		docker := *msg.Docker
+		docker.Containers = make([]messages.ContainerInfo, len(msg.Docker.Containers))
+		for i, container := range msg.Docker.Containers {
+			container.Status = ""
+			docker.Containers[i] = container
+		}
+		stable.Docker = &docker
+	}
+
+	data, err := json.Marshal(stable)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// handleOutput sends command output to the cloud, taking turns with the
+// output of other running commands
 func (r *Router) handleOutput(msg *messages.OutputMessage) {
-	if err := r.send(msg); err != nil {
+	r.outputGovernor.acquire(msg.ID)
+	defer r.outputGovernor.release()
+
+	if err := r.sendCommandMessage(msg); err != nil {
 		log.Printf("Failed to send output: %v", err)
 	}
 }
 
 // handleComplete sends command completion to the cloud
 func (r *Router) handleComplete(msg *messages.CompleteMessage) {
-	if err := r.send(msg); err != nil {
+	if err := r.sendCommandMessage(msg); err != nil {
 		log.Printf("Failed to send complete: %v", err)
 	}
 }
 
+// sendCommandMessage sends command output or completion, waiting for room when
+// the send buffer is full. The executor emits a command's last output before
+// its complete message, so waiting here (rather than dropping) keeps the cloud
+// from seeing a completion with trailing output missing.
+func (r *Router) sendCommandMessage(msg interface{}) error {
+	r.mu.Lock()
+	wait := r.outputSendWait
+	if r.outputBlocked {
+		// The buffer isn't draining (e.g. while disconnected); don't stall
+		// every line of output for the full wait
+		wait = 0
+	}
+	timedSend := r.timedSend
+	r.mu.Unlock()
+
+	if timedSend != nil {
+		err := timedSend(msg, wait)
+		r.mu.Lock()
+		r.outputBlocked = errors.Is(err, connection.ErrSendBufferFull)
+		r.mu.Unlock()
+		return err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err := r.send(msg)
+		full := errors.Is(err, connection.ErrSendBufferFull)
+		if !full || !time.Now().Before(deadline) {
+			r.mu.Lock()
+			r.outputBlocked = full
+			r.mu.Unlock()
+			return err
+		}
+		time.Sleep(outputRetryInterval)
+	}
+}
+
+// SetTimedSend sets how command output and completions wait for room in a
+// full send buffer, instead of retrying send
+func (r *Router) SetTimedSend(send TimedSendFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timedSend = send
+}
+
+// SetRediscoverApps sets whether a command for an unknown directory that
+// looks like an app triggers an app path refresh before it is validated
+// (on by default)
+func (r *Router) SetRediscoverApps(enabled bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rediscoverApps = enabled
+}
+
+// SetOutputSendWait sets how long command output and completion wait for room
+// in a full send buffer (0 drops them immediately, negative restores the
+// default)
+func (r *Router) SetOutputSendWait(wait time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if wait < 0 {
+		wait = DefaultOutputSendWait
+	}
+	r.outputSendWait = wait
+}
+
 // handleRejected sends command rejection to the cloud
 func (r *Router) handleRejected(msg *messages.RejectedMessage) {
 	log.Printf("Command %s rejected: [%s] %s", msg.ID, msg.Code, msg.Message)
@@ -217,6 +692,430 @@ func (r *Router) handleMonitoringConfig(data []byte) {
 	}
 }
 
+// handleFlushOutput emits a running command's buffered partial output
+func (r *Router) handleFlushOutput(data []byte) {
+	flushMsg, err := messages.ParseFlushOutputMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse flush_output message: %v", err)
+		return
+	}
+
+	if !r.executor.FlushOutput(flushMsg.ID) {
+		log.Printf("Flush requested for unknown command %s", flushMsg.ID)
+	}
+}
+
+// handleCancel stops a running command and reports whether it was found
+func (r *Router) handleCancel(data []byte) {
+	cancelMsg, err := messages.ParseCancelMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse cancel message: %v", err)
+		return
+	}
+
+	var found bool
+	switch cancelMsg.Mode {
+	case messages.CancelModeInterrupt:
+		found = r.executor.Interrupt(cancelMsg.ID)
+	case "", messages.CancelModeKill:
+		found = r.executor.Cancel(cancelMsg.ID)
+	default:
+		log.Printf("Unknown cancel mode %q for command %s, killing it", cancelMsg.Mode, cancelMsg.ID)
+		found = r.executor.Cancel(cancelMsg.ID)
+	}
+
+	status := messages.CancelStatusCancelled
+	if found {
+		log.Printf("Command %s cancelled by the server", cancelMsg.ID)
+	} else {
+		log.Printf("Cancel requested for unknown command %s", cancelMsg.ID)
+		status = messages.CancelStatusNotFound
+	}
+
+	if err := r.send(messages.NewCancelResultMessage(cancelMsg.ID, status)); err != nil {
+		log.Printf("Failed to send cancel result: %v", err)
+	}
+}
+
+// handleDedupDump sends the error signatures seen by the log monitor
+func (r *Router) handleDedupDump() {
+	if r.logMonitor == nil {
+		return
+	}
+
+	if err := r.send(r.logMonitor.DedupDump()); err != nil {
+		log.Printf("Failed to send dedup entries: %v", err)
+	}
+}
+
+// handleStatusRequest reports the commands currently running and how long
+// each has been running
+func (r *Router) handleStatusRequest() {
+	now := time.Now()
+	running := []messages.RunningCommandStatus{}
+	for _, cmd := range r.executor.Running() {
+		running = append(running, messages.RunningCommandStatus{
+			ID:        cmd.ID,
+			Command:   cmd.Command,
+			StartedAt: cmd.StartedAt.UTC().Format(time.RFC3339),
+			ElapsedMs: messages.ElapsedMs(cmd.StartedAt, now),
+		})
+	}
+
+	if err := r.send(messages.NewStatusResponseMessage(running, r.IsDraining())); err != nil {
+		log.Printf("Failed to send status: %v", err)
+	}
+}
+
+// handleSetDrain toggles drain mode and reports the resulting state
+func (r *Router) handleSetDrain(data []byte) {
+	drainMsg, err := messages.ParseSetDrainMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse set_drain message: %v", err)
+		return
+	}
+
+	r.SetDraining(drainMsg.Draining)
+}
+
+// SetDraining enables or disables drain mode and sends the drain status
+func (r *Router) SetDraining(draining bool) {
+	r.mu.Lock()
+	r.draining = draining
+	r.mu.Unlock()
+
+	if draining {
+		log.Printf("Drain mode enabled: finishing %d running commands, rejecting new ones", r.executor.RunningCount())
+	} else {
+		log.Printf("Drain mode disabled: accepting new commands")
+	}
+
+	r.sendDrainStatus()
+}
+
+// IsDraining returns whether drain mode is enabled
+func (r *Router) IsDraining() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+// handleIdle reports the drained state once the last running command finishes
+func (r *Router) handleIdle() {
+	if r.IsDraining() {
+		log.Printf("Drain complete: no commands running")
+		r.sendDrainStatus()
+	}
+}
+
+// sendDrainStatus sends the current drain state to the cloud
+func (r *Router) sendDrainStatus() {
+	msg := messages.NewDrainStatusMessage(r.IsDraining(), r.executor.RunningCount())
+	if err := r.send(msg); err != nil {
+		log.Printf("Failed to send drain status: %v", err)
+	}
+}
+
+// SetProtectedEnvVars adds environment variables commands may not set and
+// removes defaults that may be overridden
+func (r *Router) SetProtectedEnvVars(extra []string, allowOverride []string) {
+	r.validator.SetProtectedEnvVars(extra, allowOverride)
+}
+
+// SetMaxEnvTotalSize sets the combined size limit of the env vars a command
+// may set
+func (r *Router) SetMaxEnvTotalSize(size int) {
+	r.validator.SetMaxEnvTotalSize(size)
+}
+
+// SetAllowListMode turns on allow-list mode for every app: only commands
+// matching an app's actions or allow patterns run
+func (r *Router) SetAllowListMode(enabled bool) {
+	r.validator.SetAllowListMode(enabled)
+}
+
+// SetAdminKey sets the base64 Ed25519 public key whose signed commands bypass
+// the allow-list (empty disables it)
+func (r *Router) SetAdminKey(publicKey string) error {
+	verifier, err := signing.NewVerifier(publicKey)
+	if err != nil {
+		return err
+	}
+
+	r.adminVerifier = verifier
+	if verifier.IsEnabled() {
+		log.Printf("Admin command signing key is CONFIGURED (admin-signed commands bypass the allow-list)")
+	}
+	return nil
+}
+
+// SetDiagnosticsProvider sets the source of connection diagnostics for agent info
+func (r *Router) SetDiagnosticsProvider(diagnostics DiagnosticsFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.diagnostics = diagnostics
+}
+
+// SetMetricsProvider sets the source of health metrics for diagnostic bundles
+func (r *Router) SetMetricsProvider(metrics MetricsFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = metrics
+}
+
+// SetGlobalDenyRules sets the named deny rules applied to every app's commands
+func (r *Router) SetGlobalDenyRules(rules []security.DenyRule) {
+	r.validator.SetGlobalDenyRules(rules)
+}
+
+// SendAgentInfo builds and sends an agent info message
+func (r *Router) SendAgentInfo() {
+	if err := r.send(r.agentInfo()); err != nil {
+		log.Printf("Failed to send agent info: %v", err)
+	}
+}
+
+// agentInfo builds an agent info message
+func (r *Router) agentInfo() *messages.AgentInfoMessage {
+	msg := messages.NewAgentInfoMessage()
+
+	r.mu.Lock()
+	diagnostics := r.diagnostics
+	r.mu.Unlock()
+
+	if diagnostics != nil {
+		connInfo := diagnostics()
+		msg.Connection = &connInfo
+	}
+
+	if total, own, err := health.DefunctCounts(); err == nil {
+		msg.Processes = &messages.ProcessInfo{
+			DefunctTotal:    total,
+			DefunctChildren: own,
+		}
+	}
+
+	msg.User = health.CurrentUser()
+	msg.Container = discovery.Container()
+
+	msg.MaxConcurrent = r.executor.MaxConcurrent()
+
+	if r.logMonitor != nil {
+		msg.Monitoring = &messages.MonitoringInfo{
+			ErrorEventsDropped: r.logMonitor.DroppedEvents(),
+		}
+	}
+
+	return msg
+}
+
+// handleCollectBundle writes a diagnostic bundle and reports where it went
+func (r *Router) handleCollectBundle(data []byte) {
+	bundleMsg, err := messages.ParseCollectBundleMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse collect_bundle message: %v", err)
+		return
+	}
+
+	// Collection reads logs from disk, so keep it off the message loop
+	go r.collectBundle(bundleMsg)
+}
+
+// collectBundle writes a diagnostic bundle and sends the result
+func (r *Router) collectBundle(bundleMsg *messages.CollectBundleMessage) {
+	defer crash.Recover("diagnostic bundle collection")
+	msg := messages.NewBundleMessage(bundleMsg.ID)
+
+	dir := bundleMsg.Dir
+	if dir == "" {
+		dir = r.defaultBundleDir()
+	}
+	if err := r.validator.ValidateDir(dir); err != nil {
+		msg.Error = err.Error()
+		r.sendBundle(msg)
+		return
+	}
+
+	r.mu.Lock()
+	metrics := r.metrics
+	r.mu.Unlock()
+
+	src := diagnostics.Sources{
+		Version:   connection.Version,
+		Discovery: r.Discovery(),
+		AgentInfo: r.agentInfo(),
+		History:   r.executor.History(),
+		Redactor:  r.validator.Redactor,
+	}
+	if metrics != nil {
+		src.Metrics = metrics()
+	}
+	if r.logMonitor != nil {
+		src.LogFiles = r.logMonitor.LogFiles()
+	}
+
+	result, err := diagnostics.Collect(dir, src)
+	if err != nil {
+		msg.Error = err.Error()
+	} else {
+		log.Printf("Wrote diagnostic bundle %s (%d bytes)", result.Path, result.Size)
+		msg.Path = result.Path
+		msg.Size = result.Size
+		msg.Sections = result.Sections
+		msg.Truncated = result.Truncated
+	}
+
+	r.sendBundle(msg)
+}
+
+// defaultBundleDir is where a bundle goes when the request names no dir: the
+// first allowed path, so the bundle can be fetched with a command afterwards,
+// or the system temp dir when every path is allowed
+func (r *Router) defaultBundleDir() string {
+	if allowed := r.validator.AllowedPaths(); len(allowed) > 0 {
+		return allowed[0]
+	}
+	return os.TempDir()
+}
+
+// sendBundle sends a bundle result to the cloud
+func (r *Router) sendBundle(msg *messages.BundleMessage) {
+	if err := r.send(msg); err != nil {
+		log.Printf("Failed to send bundle result: %v", err)
+	}
+}
+
+// handleUpdatePatterns applies pushed error patterns to a monitored app and
+// reports whether they were valid
+func (r *Router) handleUpdatePatterns(data []byte) {
+	updateMsg, err := messages.ParseUpdatePatternsMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse update_patterns message: %v", err)
+		return
+	}
+
+	var result *messages.PatternsUpdatedMessage
+	if r.logMonitor != nil {
+		result = r.logMonitor.UpdatePatterns(updateMsg)
+	} else {
+		result = messages.NewPatternsUpdatedMessage(updateMsg.RepoFullName)
+		result.Error = "log monitoring is not running"
+	}
+	if result.Error != "" {
+		log.Printf("Pattern update for %s not applied: %s", updateMsg.RepoFullName, result.Error)
+	}
+
+	if err := r.send(result); err != nil {
+		log.Printf("Failed to send pattern update result: %v", err)
+	}
+}
+
+// handleTestPattern runs patterns over an existing log once and reports what
+// they match
+func (r *Router) handleTestPattern(data []byte) {
+	testMsg, err := messages.ParseTestPatternMessage(data)
+	if err != nil {
+		log.Printf("Failed to parse test_pattern message: %v", err)
+		return
+	}
+
+	// Scanning reads the log from disk, so keep it off the message loop
+	go r.testPattern(testMsg)
+}
+
+// testPattern scans the requested log of a discovered app and sends the matches
+func (r *Router) testPattern(testMsg *messages.TestPatternMessage) {
+	defer crash.Recover("pattern test")
+	msg := messages.NewPatternResultsMessage(testMsg.ID, testMsg.AppPath, testMsg.LogPath)
+
+	app := r.findApp(testMsg.AppPath)
+	switch {
+	case app == nil:
+		msg.Error = fmt.Sprintf("%s is not a discovered app", testMsg.AppPath)
+	case len(testMsg.Patterns) == 0:
+		msg.Error = "no patterns given"
+	}
+	if msg.Error != "" {
+		r.sendPatternResults(msg)
+		return
+	}
+
+	path, err := logmonitor.ResolveLogPath(app.Path, testMsg.LogPath)
+	if err != nil {
+		msg.Error = err.Error()
+		r.sendPatternResults(msg)
+		return
+	}
+
+	result, err := logmonitor.ScanFile(path, testMsg.Patterns, testMsg.ContextLines, logmonitor.ParserFor(app.Framework))
+	if err != nil {
+		msg.Error = err.Error()
+		r.sendPatternResults(msg)
+		return
+	}
+
+	redactor := r.validator.Redactor(app.Path)
+	for _, match := range result.Matches {
+		msg.Matches = append(msg.Matches, messages.PatternMatch{
+			ErrorLine:     redactor.Redact(match.ErrorLine),
+			ContextBefore: redactLines(redactor, match.ContextBefore),
+			ContextAfter:  redactLines(redactor, match.ContextAfter),
+			Severity:      match.Severity,
+			Message:       redactor.Redact(match.Message),
+			Level:         match.Level,
+		})
+	}
+	msg.LinesScanned = result.LinesScanned
+	msg.Truncated = result.Truncated
+
+	r.sendPatternResults(msg)
+}
+
+// findApp returns the discovered app at path, or nil
+func (r *Router) findApp(path string) *messages.AppInfo {
+	disc := r.Discovery()
+	if disc == nil || path == "" {
+		return nil
+	}
+
+	cleanPath := filepath.Clean(path)
+	for i := range disc.Apps {
+		if filepath.Clean(disc.Apps[i].Path) == cleanPath {
+			return &disc.Apps[i]
+		}
+	}
+	return nil
+}
+
+// redactLines redacts each line of log context
+func redactLines(redactor *security.Redactor, lines []string) []string {
+	redacted := make([]string, len(lines))
+	for i, line := range lines {
+		redacted[i] = redactor.Redact(line)
+	}
+	return redacted
+}
+
+// sendPatternResults sends a pattern test result to the cloud
+func (r *Router) sendPatternResults(msg *messages.PatternResultsMessage) {
+	if err := r.send(msg); err != nil {
+		log.Printf("Failed to send pattern results: %v", err)
+	}
+}
+
+// Discovery returns the most recent discovery results (nil if discovery hasn't run)
+func (r *Router) Discovery() *messages.DiscoveryMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastDiscovery
+}
+
+// Heartbeat builds a heartbeat summarizing the executor's load
+func (r *Router) Heartbeat() *messages.HeartbeatMessage {
+	load := r.executor.Workload()
+	return messages.NewHeartbeatMessage(load.Running, load.Queued, load.LastCommandAt)
+}
+
 // Executor returns the executor
 func (r *Router) Executor() *executor.Executor {
 	return r.executor
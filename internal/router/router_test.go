@@ -0,0 +1,929 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/connection"
+	"github.com/codebasehealth/antidote-agent/internal/discovery"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+	"github.com/codebasehealth/antidote-agent/internal/signing"
+)
+
+// recorder captures messages sent by the router
+type recorder struct {
+	mu   sync.Mutex
+	msgs []interface{}
+}
+
+func (r *recorder) send(msg interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, msg)
+	return nil
+}
+
+// waitFor polls until a sent message satisfies match, or fails the test
+func (r *recorder) waitFor(t *testing.T, timeout time.Duration, match func(msg interface{}) bool) interface{} {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		for _, msg := range r.msgs {
+			if match(msg) {
+				r.mu.Unlock()
+				return msg
+			}
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("timeout waiting for message")
+	return nil
+}
+
+func newTestRouter(t *testing.T) (*Router, *recorder) {
+	t.Helper()
+
+	rec := &recorder{}
+	r := NewRouter(rec.send, "")
+	t.Cleanup(r.Stop)
+	return r, rec
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	return data
+}
+
+func commandData(t *testing.T, id, command string) []byte {
+	return mustMarshal(t, messages.CommandMessage{
+		Type:    messages.TypeCommand,
+		ID:      id,
+		Command: command,
+	})
+}
+
+// =============================================================================
+// DRAIN MODE TESTS
+// =============================================================================
+
+func TestRouter_Drain_RejectsNewCommands(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeSetDrain, mustMarshal(t, messages.SetDrainMessage{
+		Type:     messages.TypeSetDrain,
+		Draining: true,
+	}))
+
+	if !r.IsDraining() {
+		t.Fatal("expected router to be draining")
+	}
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-drained", "echo hello"))
+
+	msg := rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+		rejected, ok := msg.(*messages.RejectedMessage)
+		return ok && rejected.ID == "cmd-drained"
+	})
+
+	if code := msg.(*messages.RejectedMessage).Code; code != "DRAINING" {
+		t.Errorf("expected code DRAINING, got %q", code)
+	}
+}
+
+func TestRouter_Drain_AcceptsAfterCleared(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.SetDraining(true)
+	r.SetDraining(false)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-accepted", "echo hello"))
+
+	msg := rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		complete, ok := msg.(*messages.CompleteMessage)
+		return ok && complete.ID == "cmd-accepted"
+	})
+
+	if exitCode := msg.(*messages.CompleteMessage).ExitCode; exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+}
+
+func TestRouter_Drain_ReportsDrainedWhenIdle(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-running", "sleep 0.3"))
+
+	// Give the command time to start
+	time.Sleep(50 * time.Millisecond)
+
+	r.SetDraining(true)
+
+	first := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.DrainStatusMessage)
+		return ok
+	}).(*messages.DrainStatusMessage)
+
+	if first.Drained {
+		t.Error("expected drained=false while a command is running")
+	}
+	if first.Running != 1 {
+		t.Errorf("expected 1 running command, got %d", first.Running)
+	}
+
+	rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		status, ok := msg.(*messages.DrainStatusMessage)
+		return ok && status.Drained
+	})
+}
+
+// =============================================================================
+// REFRESH PATHS TESTS
+// =============================================================================
+
+func TestRouter_RefreshPaths_AllowsNewApp(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	root := t.TempDir()
+	r.discoverApps = func() []messages.AppInfo { return discovery.DiscoverAppsIn(root) }
+
+	existing := filepath.Join(root, "existing")
+	if err := os.MkdirAll(existing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(existing, "go.mod"), []byte("module existing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle(messages.TypeRefreshPaths, nil)
+
+	// Deployed after the last discovery
+	newApp := filepath.Join(root, "new-app")
+	if err := os.MkdirAll(newApp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(newApp, "artisan"), []byte("<?php\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.validator.ValidateDir(newApp); err == nil {
+		t.Fatal("expected new app to be rejected before refresh")
+	}
+
+	r.Handle(messages.TypeRefreshPaths, nil)
+
+	if err := r.validator.ValidateDir(newApp); err != nil {
+		t.Errorf("expected new app to be allowed after refresh, got %v", err)
+	}
+	if err := r.validator.ValidateDir(existing); err != nil {
+		t.Errorf("expected existing app to stay allowed, got %v", err)
+	}
+	if apps := r.discoveryProvider.GetApps(); len(apps) != 2 {
+		t.Errorf("expected log monitor provider to see 2 apps, got %d", len(apps))
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.msgs) != 0 {
+		t.Errorf("expected nothing sent on refresh, got %+v", rec.msgs)
+	}
+}
+
+// writeApp creates a Laravel-looking app directory under root
+func writeApp(t *testing.T, root, name string) string {
+	t.Helper()
+
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "artisan"), []byte("<?php\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// workingDirCommand is a command message run in dir
+func workingDirCommand(t *testing.T, id, dir string) []byte {
+	return mustMarshal(t, messages.CommandMessage{
+		Type:       messages.TypeCommand,
+		ID:         id,
+		Command:    "pwd",
+		WorkingDir: dir,
+	})
+}
+
+func TestRouter_UnknownAppDirTriggersRediscovery(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	root := t.TempDir()
+	r.appSearchPaths = []string{root}
+	r.discoverApps = func() []messages.AppInfo { return discovery.DiscoverAppsIn(root) }
+	writeApp(t, root, "existing")
+	r.Handle(messages.TypeRefreshPaths, nil)
+
+	// Deployed after the last discovery
+	newApp := writeApp(t, root, "new-app")
+	r.Handle(messages.TypeCommand, workingDirCommand(t, "cmd-new-app", newApp))
+
+	msg := rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		switch m := msg.(type) {
+		case *messages.CompleteMessage:
+			return m.ID == "cmd-new-app"
+		case *messages.RejectedMessage:
+			return m.ID == "cmd-new-app"
+		}
+		return false
+	})
+	if rejected, ok := msg.(*messages.RejectedMessage); ok {
+		t.Fatalf("expected the new app's command to run after rediscovery, got rejected: %s", rejected.Message)
+	}
+	if err := r.validator.ValidateDir(newApp); err != nil {
+		t.Errorf("expected new app to be allowed after rediscovery, got %v", err)
+	}
+}
+
+func TestRouter_UnknownDirRejectedWithoutRediscovery(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	root := t.TempDir()
+	r.appSearchPaths = []string{root}
+	refreshes := 0
+	r.discoverApps = func() []messages.AppInfo {
+		refreshes++
+		return discovery.DiscoverAppsIn(root)
+	}
+	writeApp(t, root, "existing")
+	r.Handle(messages.TypeRefreshPaths, nil)
+
+	// Not an app: rejected without rediscovering
+	plain := filepath.Join(root, "uploads")
+	if err := os.MkdirAll(plain, 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.Handle(messages.TypeCommand, workingDirCommand(t, "cmd-plain", plain))
+
+	// Looks like an app, but rediscovery is turned off
+	r.SetRediscoverApps(false)
+	newApp := writeApp(t, root, "new-app")
+	r.Handle(messages.TypeCommand, workingDirCommand(t, "cmd-disabled", newApp))
+
+	for _, id := range []string{"cmd-plain", "cmd-disabled"} {
+		msg := rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+			rejected, ok := msg.(*messages.RejectedMessage)
+			return ok && rejected.ID == id
+		})
+		if code := msg.(*messages.RejectedMessage).Code; code != "INVALID_WORKING_DIR" {
+			t.Errorf("%s: expected INVALID_WORKING_DIR, got %s", id, code)
+		}
+	}
+	if refreshes != 1 {
+		t.Errorf("expected no rediscovery beyond the initial refresh, got %d refreshes", refreshes)
+	}
+}
+
+func TestRouter_RediscoveryLimited(t *testing.T) {
+	advance := fakeClock(t)
+	r, rec := newTestRouter(t)
+
+	root := t.TempDir()
+	r.appSearchPaths = []string{root}
+	existing := writeApp(t, root, "existing")
+	refreshes := 0
+	r.discoverApps = func() []messages.AppInfo {
+		refreshes++
+		return []messages.AppInfo{{Path: existing}} // new apps never show up
+	}
+	r.Handle(messages.TypeRefreshPaths, nil)
+
+	// Outside the search paths, even an app isn't rediscovered for
+	elsewhere := writeApp(t, t.TempDir(), "elsewhere")
+	r.Handle(messages.TypeCommand, workingDirCommand(t, "cmd-elsewhere", elsewhere))
+	if refreshes != 1 {
+		t.Errorf("expected no rediscovery outside the search paths, got %d refreshes", refreshes)
+	}
+
+	// A burst for the same directory refreshes once per interval
+	missing := writeApp(t, root, "missing")
+	for i := 0; i < 5; i++ {
+		r.Handle(messages.TypeCommand, workingDirCommand(t, fmt.Sprintf("cmd-burst-%d", i), missing))
+	}
+	if refreshes != 2 {
+		t.Errorf("expected one rediscovery for a burst of commands, got %d refreshes", refreshes)
+	}
+
+	advance(RediscoverInterval)
+	r.Handle(messages.TypeCommand, workingDirCommand(t, "cmd-later", missing))
+	if refreshes != 3 {
+		t.Errorf("expected another rediscovery after the interval, got %d refreshes", refreshes)
+	}
+
+	for _, id := range []string{"cmd-elsewhere", "cmd-burst-4", "cmd-later"} {
+		msg := rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+			rejected, ok := msg.(*messages.RejectedMessage)
+			return ok && rejected.ID == id
+		})
+		if code := msg.(*messages.RejectedMessage).Code; code != "INVALID_WORKING_DIR" {
+			t.Errorf("%s: expected INVALID_WORKING_DIR, got %s", id, code)
+		}
+	}
+}
+
+// =============================================================================
+// STATUS TESTS
+// =============================================================================
+
+func TestRouter_StatusRequest_ListsRunningCommands(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-busy", "sleep 10"))
+	defer r.Executor().Cancel("cmd-busy")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Executor().RunningCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	r.Handle(messages.TypeStatusRequest, nil)
+
+	msg := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.StatusResponseMessage)
+		return ok
+	})
+	status := msg.(*messages.StatusResponseMessage)
+	if len(status.Running) != 1 {
+		t.Fatalf("expected 1 running command, got %+v", status.Running)
+	}
+	running := status.Running[0]
+	if running.ID != "cmd-busy" || running.Command != "sleep 10" {
+		t.Errorf("unexpected running command %+v", running)
+	}
+	if running.ElapsedMs < 20 || running.StartedAt == "" {
+		t.Errorf("expected start time and elapsed duration, got %+v", running)
+	}
+}
+
+func TestRouter_Heartbeat_ReflectsLoad(t *testing.T) {
+	r, _ := newTestRouter(t)
+	r.Executor().SetMaxConcurrent(1)
+
+	if heartbeat := r.Heartbeat(); heartbeat.Running != 0 || heartbeat.Queued != 0 || heartbeat.LastCommandAt != "" {
+		t.Fatalf("expected an idle heartbeat, got %+v", heartbeat)
+	}
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-running", "sleep 10"))
+	defer r.Executor().Cancel("cmd-running")
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-waiting", "sleep 10"))
+	defer r.Executor().Cancel("cmd-waiting")
+
+	deadline := time.Now().Add(2 * time.Second)
+	heartbeat := r.Heartbeat()
+	for (heartbeat.Running != 1 || heartbeat.Queued != 1) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		heartbeat = r.Heartbeat()
+	}
+
+	if heartbeat.Running != 1 || heartbeat.Queued != 1 {
+		t.Errorf("expected 1 running and 1 queued, got %+v", heartbeat)
+	}
+	if heartbeat.LastCommandAt == "" {
+		t.Error("expected the last command time to be set")
+	}
+}
+
+func TestRouter_StatusRequest_Idle(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeStatusRequest, nil)
+
+	msg := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.StatusResponseMessage)
+		return ok
+	})
+	if running := msg.(*messages.StatusResponseMessage).Running; running == nil || len(running) != 0 {
+		t.Errorf("expected an empty (not null) running list, got %#v", running)
+	}
+}
+
+// =============================================================================
+// PATTERN UPDATE TESTS
+// =============================================================================
+
+func TestRouter_UpdatePatterns_ReportsResult(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeUpdatePatterns, mustMarshal(t, messages.UpdatePatternsMessage{
+		Type:          messages.TypeUpdatePatterns,
+		RepoFullName:  "acme/app",
+		ErrorPatterns: []string{""},
+	}))
+
+	msg := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.PatternsUpdatedMessage)
+		return ok
+	})
+	result := msg.(*messages.PatternsUpdatedMessage)
+	if result.Applied || result.RepoFullName != "acme/app" || len(result.Issues) != 1 {
+		t.Errorf("expected the empty pattern to be rejected, got %+v", result)
+	}
+}
+
+// =============================================================================
+// CANCEL TESTS
+// =============================================================================
+
+func cancelData(t *testing.T, id string) []byte {
+	return mustMarshal(t, messages.CancelMessage{Type: messages.TypeCancel, ID: id})
+}
+
+func TestRouter_Cancel_StopsRunningCommand(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-long", "sleep 10"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Executor().RunningCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	r.Handle(messages.TypeCancel, cancelData(t, "cmd-long"))
+
+	result := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.CancelResultMessage)
+		return ok
+	}).(*messages.CancelResultMessage)
+	if result.ID != "cmd-long" || result.Status != messages.CancelStatusCancelled {
+		t.Errorf("expected cmd-long to be cancelled, got %+v", result)
+	}
+
+	complete := rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		complete, ok := msg.(*messages.CompleteMessage)
+		return ok && complete.ID == "cmd-long"
+	}).(*messages.CompleteMessage)
+	if complete.ExitCode == 0 {
+		t.Error("expected a cancelled command to complete with a non-zero exit code")
+	}
+}
+
+func TestRouter_Cancel_UnknownCommand(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	r.Handle(messages.TypeCancel, cancelData(t, "cmd-missing"))
+
+	result := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.CancelResultMessage)
+		return ok
+	}).(*messages.CancelResultMessage)
+	if result.ID != "cmd-missing" || result.Status != messages.CancelStatusNotFound {
+		t.Errorf("expected not_found for an unknown command, got %+v", result)
+	}
+}
+
+// =============================================================================
+// PATTERN TEST TESTS
+// =============================================================================
+
+func patternData(t *testing.T, appPath, logPath string) []byte {
+	return mustMarshal(t, messages.TestPatternMessage{
+		Type:         messages.TypeTestPattern,
+		ID:           "pt-1",
+		AppPath:      appPath,
+		LogPath:      logPath,
+		Patterns:     []string{"ERROR"},
+		ContextLines: 1,
+	})
+}
+
+func waitForPatternResults(t *testing.T, rec *recorder) *messages.PatternResultsMessage {
+	t.Helper()
+
+	msg := rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.PatternResultsMessage)
+		return ok
+	})
+	return msg.(*messages.PatternResultsMessage)
+}
+
+func TestRouter_TestPattern_ReturnsMatches(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	appPath := t.TempDir()
+	logDir := filepath.Join(appPath, "storage", "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := "[2026-01-13 17:52:45] production.INFO: Starting job\n" +
+		"[2026-01-13 17:52:46] production.ERROR: Payment failed {\"order\":1001}\n" +
+		"#0 /var/www/app/Payments.php(42): charge()\n" +
+		"[2026-01-13 17:52:47] production.INFO: Job finished\n"
+	if err := os.WriteFile(filepath.Join(logDir, "laravel.log"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r.mu.Lock()
+	r.lastDiscovery = &messages.DiscoveryMessage{
+		Apps: []messages.AppInfo{{Path: appPath, Framework: "laravel"}},
+	}
+	r.mu.Unlock()
+
+	r.Handle(messages.TypeTestPattern, patternData(t, appPath, "storage/logs/laravel.log"))
+	result := waitForPatternResults(t, rec)
+
+	if result.Error != "" {
+		t.Fatalf("unexpected error: %s", result.Error)
+	}
+	if result.ID != "pt-1" || result.LinesScanned != 4 {
+		t.Errorf("expected id pt-1 and 4 lines scanned, got %+v", result)
+	}
+	if len(result.Matches) != 1 {
+		t.Fatalf("expected 1 match, got %+v", result.Matches)
+	}
+	match := result.Matches[0]
+	if match.Message != "Payment failed" || match.Severity != "ERROR" {
+		t.Errorf("expected parsed Laravel error, got %+v", match)
+	}
+	if len(match.ContextBefore) != 1 || len(match.ContextAfter) != 1 || match.ContextAfter[0] != "#0 /var/www/app/Payments.php(42): charge()" {
+		t.Errorf("expected one line of context each side, got %+v", match)
+	}
+
+	// The log monitor wasn't started for the app
+	if files := r.logMonitor.LogFiles(); len(files) != 0 {
+		t.Errorf("expected no monitored files, got %v", files)
+	}
+}
+
+func TestRouter_TestPattern_RejectsPaths(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	appPath := t.TempDir()
+	r.mu.Lock()
+	r.lastDiscovery = &messages.DiscoveryMessage{
+		Apps: []messages.AppInfo{{Path: appPath, Framework: "laravel"}},
+	}
+	r.mu.Unlock()
+
+	tests := []struct {
+		appPath string
+		logPath string
+	}{
+		{t.TempDir(), "app.log"},         // not a discovered app
+		{appPath, "../../etc/passwd"},    // leaves the app
+		{appPath, "/etc/passwd"},         // absolute
+		{appPath, "storage/missing.log"}, // doesn't exist
+	}
+
+	for _, tt := range tests {
+		rec.mu.Lock()
+		rec.msgs = nil
+		rec.mu.Unlock()
+
+		r.Handle(messages.TypeTestPattern, patternData(t, tt.appPath, tt.logPath))
+		result := waitForPatternResults(t, rec)
+		if result.Error == "" || len(result.Matches) != 0 {
+			t.Errorf("expected %s in %s to be rejected, got %+v", tt.logPath, tt.appPath, result)
+		}
+	}
+}
+
+// =============================================================================
+// OUTPUT ORDERING TESTS
+// =============================================================================
+
+// congestedSender reports a full send buffer for the first attempts at each
+// message, like a connection whose queue drains slowly
+type congestedSender struct {
+	recorder
+	fullAttempts int
+	attempts     map[interface{}]int
+}
+
+func (c *congestedSender) send(msg interface{}) error {
+	c.mu.Lock()
+	c.attempts[msg]++
+	full := c.attempts[msg] <= c.fullAttempts
+	c.mu.Unlock()
+
+	if full {
+		return connection.ErrSendBufferFull
+	}
+	return c.recorder.send(msg)
+}
+
+func TestRouter_TrailingOutputArrivesBeforeComplete(t *testing.T) {
+	sender := &congestedSender{fullAttempts: 3, attempts: make(map[interface{}]int)}
+	r := NewRouter(sender.send, "")
+	t.Cleanup(r.Stop)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-trailing", "printf 'first\\ntrailing'"))
+
+	sender.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.CompleteMessage)
+		return ok
+	})
+
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+
+	var output string
+	for _, msg := range sender.msgs {
+		switch m := msg.(type) {
+		case *messages.OutputMessage:
+			output += m.Data
+		case *messages.CompleteMessage:
+			if output != "first\ntrailing\n" {
+				t.Errorf("expected all output before complete, got %q", output)
+			}
+			return
+		}
+	}
+}
+
+func TestRouter_OutputSendWaitStopsWhileBlocked(t *testing.T) {
+	sender := &congestedSender{fullAttempts: 1 << 30, attempts: make(map[interface{}]int)}
+	r := NewRouter(sender.send, "")
+	t.Cleanup(r.Stop)
+	r.SetOutputSendWait(100 * time.Millisecond)
+
+	// The first message waits for the buffer, later ones don't while it stays full
+	start := time.Now()
+	r.handleOutput(messages.NewOutputMessage("cmd-1", "stdout", "a\n"))
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the first send to wait, took %v", elapsed)
+	}
+
+	start = time.Now()
+	for i := 0; i < 5; i++ {
+		r.handleOutput(messages.NewOutputMessage("cmd-1", "stdout", "b\n"))
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected later sends not to wait while blocked, took %v", elapsed)
+	}
+}
+
+func TestRouter_TimedSendWaitsOnce(t *testing.T) {
+	r, rec := newTestRouter(t)
+	r.SetOutputSendWait(50 * time.Millisecond)
+
+	var waits []time.Duration
+	r.SetTimedSend(func(msg interface{}, timeout time.Duration) error {
+		waits = append(waits, timeout)
+		return connection.ErrSendBufferFull
+	})
+
+	r.handleOutput(messages.NewOutputMessage("cmd-1", "stdout", "a\n"))
+	r.handleOutput(messages.NewOutputMessage("cmd-1", "stdout", "b\n"))
+
+	if len(waits) != 2 || waits[0] != 50*time.Millisecond || waits[1] != 0 {
+		t.Errorf("expected one wait then none while blocked, got %v", waits)
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.msgs) != 0 {
+		t.Errorf("expected the plain send not to be retried, got %+v", rec.msgs)
+	}
+}
+
+func TestRouter_AuthOKSetsMaxConcurrent(t *testing.T) {
+	r, _ := newTestRouter(t)
+	r.Executor().SetMaxConcurrent(4)
+
+	authOK := func(maxConcurrent int) []byte {
+		return mustMarshal(t, messages.AuthOKMessage{
+			Type:          messages.TypeAuthOK,
+			ServerID:      "srv_test",
+			MaxConcurrent: maxConcurrent,
+		})
+	}
+
+	r.Handle(messages.TypeAuthOK, authOK(2))
+	if got := r.Executor().MaxConcurrent(); got != 2 {
+		t.Errorf("expected server limit 2, got %d", got)
+	}
+	if info := r.agentInfo(); info.MaxConcurrent != 2 {
+		t.Errorf("expected agent info to report limit 2, got %d", info.MaxConcurrent)
+	}
+
+	// Re-sent without a limit: back to the local setting
+	r.Handle(messages.TypeAuthOK, authOK(0))
+	if got := r.Executor().MaxConcurrent(); got != 4 {
+		t.Errorf("expected local limit 4, got %d", got)
+	}
+}
+
+func TestRouter_AdminSignedCommandAccepted(t *testing.T) {
+	signer, err := signing.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	admin, err := signing.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("failed to generate admin key: %v", err)
+	}
+
+	rec := &recorder{}
+	r := NewRouter(rec.send, signer.PublicKeyBase64())
+	t.Cleanup(r.Stop)
+	if err := r.SetAdminKey(admin.PublicKeyBase64()); err != nil {
+		t.Fatalf("SetAdminKey failed: %v", err)
+	}
+
+	signed := admin.CreateSignedCommand("cmd-admin", "echo break-glass", "", nil, 0, "nonce-admin")
+	r.Handle(messages.TypeCommand, mustMarshal(t, signed))
+
+	msg := rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		complete, ok := msg.(*messages.CompleteMessage)
+		return ok && complete.ID == "cmd-admin"
+	})
+	if exitCode := msg.(*messages.CompleteMessage).ExitCode; exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+
+	// The same command without a signature is still rejected
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-unsigned", "echo break-glass"))
+
+	msg = rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+		rejected, ok := msg.(*messages.RejectedMessage)
+		return ok && rejected.ID == "cmd-unsigned"
+	})
+	if code := msg.(*messages.RejectedMessage).Code; code != "SIGNATURE_INVALID" {
+		t.Errorf("expected code SIGNATURE_INVALID, got %q", code)
+	}
+}
+
+func TestRouter_AdminSignedCommandBypassesAllowList(t *testing.T) {
+	admin, err := signing.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("failed to generate admin key: %v", err)
+	}
+
+	r, rec := newTestRouter(t)
+	if err := r.SetAdminKey(admin.PublicKeyBase64()); err != nil {
+		t.Fatalf("SetAdminKey failed: %v", err)
+	}
+	r.SetAllowListMode(true)
+
+	signed := admin.CreateSignedCommand("cmd-admin", "echo break-glass", "", nil, 0, "nonce-admin")
+	r.Handle(messages.TypeCommand, mustMarshal(t, signed))
+
+	msg := rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		complete, ok := msg.(*messages.CompleteMessage)
+		return ok && complete.ID == "cmd-admin"
+	})
+	if exitCode := msg.(*messages.CompleteMessage).ExitCode; exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-unsigned", "echo break-glass"))
+
+	msg = rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+		rejected, ok := msg.(*messages.RejectedMessage)
+		return ok && rejected.ID == "cmd-unsigned"
+	})
+	if code := msg.(*messages.RejectedMessage).Code; code != "COMMAND_NOT_ALLOWED" {
+		t.Errorf("expected code COMMAND_NOT_ALLOWED, got %q", code)
+	}
+}
+
+func TestRouter_SignatureRejectionSubcodes(t *testing.T) {
+	signer, err := signing.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	rec := &recorder{}
+	r := NewRouter(rec.send, signer.PublicKeyBase64())
+	t.Cleanup(r.Stop)
+
+	expired := signer.CreateSignedCommand("cmd-expired", "echo hi", "", nil, 0, "nonce-expired")
+	expired.Timestamp = time.Now().Add(-10 * time.Minute).UTC().Format(time.RFC3339)
+	expired.Signature = signer.SignCommand(expired)
+
+	tampered := signer.CreateSignedCommand("cmd-tampered", "echo hi", "", nil, 0, "nonce-tampered")
+	tampered.Command = "echo pwned"
+
+	tests := []struct {
+		id      string
+		data    []byte
+		subcode string
+	}{
+		{"cmd-expired", mustMarshal(t, expired), "expired"},
+		{"cmd-tampered", mustMarshal(t, tampered), "invalid"},
+		{"cmd-unsigned", commandData(t, "cmd-unsigned", "echo hi"), "missing_signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			r.Handle(messages.TypeCommand, tt.data)
+
+			msg := rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+				rejected, ok := msg.(*messages.RejectedMessage)
+				return ok && rejected.ID == tt.id
+			})
+			rejected := msg.(*messages.RejectedMessage)
+			if rejected.Code != "SIGNATURE_INVALID" {
+				t.Errorf("expected code SIGNATURE_INVALID, got %q", rejected.Code)
+			}
+			if rejected.Subcode != tt.subcode {
+				t.Errorf("expected subcode %q, got %q", tt.subcode, rejected.Subcode)
+			}
+		})
+	}
+}
+
+func TestRouter_SignedDiskHeavyCommand(t *testing.T) {
+	signer, err := signing.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	rec := &recorder{}
+	r := NewRouter(rec.send, signer.PublicKeyBase64())
+	t.Cleanup(r.Stop)
+	// More free space than any filesystem has
+	r.Executor().SetMinFreeDisk(1 << 62)
+
+	signed := signer.CreateSignedCommand("cmd-heavy", "echo build", "", nil, 0, "nonce-heavy")
+	signed.DiskHeavy = true
+	signed.Signature = signer.SignCommand(signed)
+	r.Handle(messages.TypeCommand, mustMarshal(t, signed))
+
+	msg := rec.waitFor(t, 2*time.Second, func(msg interface{}) bool {
+		rejected, ok := msg.(*messages.RejectedMessage)
+		return ok && rejected.ID == "cmd-heavy"
+	})
+	if code := msg.(*messages.RejectedMessage).Code; code != "LOW_DISK_SPACE" {
+		t.Errorf("expected code LOW_DISK_SPACE, got %q", code)
+	}
+
+	// Without disk_heavy the same command runs
+	light := signer.CreateSignedCommand("cmd-light", "echo build", "", nil, 0, "nonce-light")
+	r.Handle(messages.TypeCommand, mustMarshal(t, light))
+
+	msg = rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		complete, ok := msg.(*messages.CompleteMessage)
+		return ok && complete.ID == "cmd-light"
+	})
+	if exitCode := msg.(*messages.CompleteMessage).ExitCode; exitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", exitCode)
+	}
+}
+
+// =============================================================================
+// DIAGNOSTIC BUNDLE TESTS
+// =============================================================================
+
+func waitForBundle(t *testing.T, rec *recorder) *messages.BundleMessage {
+	t.Helper()
+	return rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.BundleMessage)
+		return ok
+	}).(*messages.BundleMessage)
+}
+
+func TestRouter_CollectBundle_DefaultDirAllowed(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	appPath := t.TempDir()
+	r.validator.UpdateApps([]messages.AppInfo{{Path: appPath}})
+
+	r.Handle(messages.TypeCollectBundle, mustMarshal(t, messages.CollectBundleMessage{
+		Type: messages.TypeCollectBundle,
+		ID:   "bundle-1",
+	}))
+	result := waitForBundle(t, rec)
+
+	if result.Error != "" {
+		t.Fatalf("expected the default request to succeed, got %s", result.Error)
+	}
+	if filepath.Dir(result.Path) != appPath {
+		t.Errorf("expected the bundle in the allowed path %s, got %s", appPath, result.Path)
+	}
+}
+
+func TestRouter_CollectBundle_WithoutLogMonitor(t *testing.T) {
+	r, rec := newTestRouter(t)
+	r.logMonitor.Stop()
+	r.logMonitor = nil
+
+	r.Handle(messages.TypeCollectBundle, mustMarshal(t, messages.CollectBundleMessage{
+		Type: messages.TypeCollectBundle,
+		ID:   "bundle-2",
+		Dir:  t.TempDir(),
+	}))
+	result := waitForBundle(t, rec)
+
+	if result.Error != "" || result.Path == "" {
+		t.Errorf("expected a bundle without log monitoring, got %+v", result)
+	}
+}
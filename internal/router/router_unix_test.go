@@ -0,0 +1,53 @@
+//go:build !windows
+
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func TestRouter_Cancel_InterruptMode(t *testing.T) {
+	r, rec := newTestRouter(t)
+	r.Executor().SetInterruptGrace(5 * time.Second)
+
+	r.Handle(messages.TypeCommand, commandData(t, "cmd-tests",
+		"trap 'kill $!; echo partial results; exit 130' INT; sleep 10 & echo ready; wait"))
+	rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		output, ok := msg.(*messages.OutputMessage)
+		return ok && strings.Contains(output.Data, "ready")
+	})
+
+	start := time.Now()
+	r.Handle(messages.TypeCancel, mustMarshal(t, messages.CancelMessage{
+		Type: messages.TypeCancel,
+		ID:   "cmd-tests",
+		Mode: messages.CancelModeInterrupt,
+	}))
+
+	result := rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		_, ok := msg.(*messages.CancelResultMessage)
+		return ok
+	}).(*messages.CancelResultMessage)
+	if result.Status != messages.CancelStatusCancelled {
+		t.Errorf("expected the interrupt to take effect, got %+v", result)
+	}
+
+	complete := rec.waitFor(t, 5*time.Second, func(msg interface{}) bool {
+		complete, ok := msg.(*messages.CompleteMessage)
+		return ok && complete.ID == "cmd-tests"
+	}).(*messages.CompleteMessage)
+	if complete.ExitCode != 130 || complete.Reason != messages.ReasonInterrupted {
+		t.Errorf("expected a graceful exit 130 with reason interrupted, got %+v", complete)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("expected the command to exit on SIGINT, not after the grace period, took %v", elapsed)
+	}
+	rec.waitFor(t, time.Second, func(msg interface{}) bool {
+		output, ok := msg.(*messages.OutputMessage)
+		return ok && strings.Contains(output.Data, "partial results")
+	})
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/crash"
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// Default startup discovery settings
+const (
+	DefaultStartupDiscoveryAttempts = 6
+	DefaultStartupDiscoveryDelay    = 10 * time.Second
+	MaxStartupDiscoveryDelay        = 2 * time.Minute
+)
+
+// StartupDiscoveryConfig controls discovery retries after the agent starts.
+// Services and apps may not be up yet when the agent boots, so discovery is
+// repeated with exponential backoff until the results stop changing.
+type StartupDiscoveryConfig struct {
+	MaxAttempts  int           // total discovery runs, including the first
+	InitialDelay time.Duration // delay before the first retry, doubled after each
+	MaxDelay     time.Duration // upper bound for the retry delay
+}
+
+// DefaultStartupDiscoveryConfig returns the default startup discovery settings
+func DefaultStartupDiscoveryConfig() StartupDiscoveryConfig {
+	return StartupDiscoveryConfig{
+		MaxAttempts:  DefaultStartupDiscoveryAttempts,
+		InitialDelay: DefaultStartupDiscoveryDelay,
+		MaxDelay:     MaxStartupDiscoveryDelay,
+	}
+}
+
+// StartStartupDiscovery runs discovery in the background until the results
+// are stable (unchanged since the last successful push) or MaxAttempts is
+// reached. Discovery is only pushed when its content changed since the last
+// successful push.
+func (r *Router) StartStartupDiscovery(ctx context.Context, cfg StartupDiscoveryConfig) {
+	if cfg.MaxAttempts <= 0 {
+		return
+	}
+	if cfg.InitialDelay <= 0 {
+		cfg.InitialDelay = DefaultStartupDiscoveryDelay
+	}
+	if cfg.MaxDelay < cfg.InitialDelay {
+		cfg.MaxDelay = cfg.InitialDelay
+	}
+
+	go r.runStartupDiscovery(ctx, cfg)
+}
+
+// runStartupDiscovery is the startup discovery loop
+func (r *Router) runStartupDiscovery(ctx context.Context, cfg StartupDiscoveryConfig) {
+	defer crash.Recover("startup discovery")
+	var lastSent string
+	delay := cfg.InitialDelay
+
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			delay *= 2
+			if delay > cfg.MaxDelay {
+				delay = cfg.MaxDelay
+			}
+		}
+
+		discoveryMsg := r.runDiscovery()
+		signature := discoverySignature(discoveryMsg)
+
+		if attempt > 1 && signature == lastSent {
+			log.Printf("Startup discovery stable after %d attempts", attempt)
+			return
+		}
+
+		if err := r.applyDiscovery(discoveryMsg, false); err == nil {
+			lastSent = signature
+		}
+	}
+
+	log.Printf("Startup discovery finished after %d attempts", cfg.MaxAttempts)
+}
+
+// discoverySignature summarizes the parts of a discovery result that change
+// as services and apps come up during boot
+func discoverySignature(msg *messages.DiscoveryMessage) string {
+	var parts []string
+
+	for _, service := range msg.Services {
+		parts = append(parts, fmt.Sprintf("service:%s=%s", service.Name, service.Status))
+	}
+	for _, language := range msg.Languages {
+		parts = append(parts, fmt.Sprintf("language:%s=%s", language.Name, language.Version))
+	}
+	for _, app := range msg.Apps {
+		parts = append(parts, fmt.Sprintf("app:%s=%s,config=%t", app.Path, app.Framework, app.Config != nil))
+	}
+	if msg.Docker != nil {
+		for _, container := range msg.Docker.Containers {
+			parts = append(parts, fmt.Sprintf("container:%s", container.ID))
+		}
+	}
+
+	sort.Strings(parts)
+	return strings.Join(parts, "\n")
+}
@@ -0,0 +1,287 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// fakeDiscovery returns a scripted sequence of discovery results, repeating
+// the last one once the script runs out
+type fakeDiscovery struct {
+	mu      sync.Mutex
+	results []*messages.DiscoveryMessage
+	calls   int
+}
+
+func (f *fakeDiscovery) discover() *messages.DiscoveryMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	idx := f.calls
+	if idx >= len(f.results) {
+		idx = len(f.results) - 1
+	}
+	f.calls++
+	return f.results[idx]
+}
+
+func (f *fakeDiscovery) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func discoveryWithApps(paths ...string) *messages.DiscoveryMessage {
+	msg := &messages.DiscoveryMessage{Type: messages.TypeDiscovery}
+	for _, path := range paths {
+		msg.Apps = append(msg.Apps, messages.AppInfo{Path: path, Framework: "laravel"})
+	}
+	return msg
+}
+
+// sentDiscoveries returns the discovery messages sent so far
+func (r *recorder) sentDiscoveries() []*messages.DiscoveryMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []*messages.DiscoveryMessage
+	for _, msg := range r.msgs {
+		if d, ok := msg.(*messages.DiscoveryMessage); ok {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// waitForCalls polls until discovery has run n times
+func waitForCalls(t *testing.T, fake *fakeDiscovery, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if fake.callCount() >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d discovery runs (got %d)", n, fake.callCount())
+}
+
+func testStartupConfig(attempts int) StartupDiscoveryConfig {
+	return StartupDiscoveryConfig{
+		MaxAttempts:  attempts,
+		InitialDelay: 10 * time.Millisecond,
+		MaxDelay:     20 * time.Millisecond,
+	}
+}
+
+func TestRouter_StartupDiscovery_PushesWhenAppAppears(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{
+		discoveryWithApps(),
+		discoveryWithApps("/var/www/app"),
+	}}
+	r.discover = fake.discover
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r.StartStartupDiscovery(ctx, testStartupConfig(10))
+
+	// Empty, then app appears, then unchanged (stable) -> stop
+	waitForCalls(t, fake, 3)
+	time.Sleep(100 * time.Millisecond)
+
+	if calls := fake.callCount(); calls != 3 {
+		t.Errorf("expected discovery to stop once stable after 3 runs, got %d", calls)
+	}
+
+	sent := rec.sentDiscoveries()
+	if len(sent) != 2 {
+		t.Fatalf("expected 2 discovery pushes, got %d", len(sent))
+	}
+	if len(sent[0].Apps) != 0 {
+		t.Errorf("expected initial push to have no apps, got %d", len(sent[0].Apps))
+	}
+	if len(sent[1].Apps) != 1 || sent[1].Apps[0].Path != "/var/www/app" {
+		t.Errorf("expected updated push with the new app, got %+v", sent[1].Apps)
+	}
+
+	if apps := r.discoveryProvider.GetApps(); len(apps) != 1 {
+		t.Errorf("expected log monitor apps to be updated, got %d", len(apps))
+	}
+}
+
+func TestRouter_StartupDiscovery_StopsAtMaxAttempts(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	// Results keep changing, so only the attempt limit stops the retries
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{
+		discoveryWithApps("/a"),
+		discoveryWithApps("/a", "/b"),
+		discoveryWithApps("/a", "/b", "/c"),
+		discoveryWithApps("/a", "/b", "/c", "/d"),
+		discoveryWithApps("/a", "/b", "/c", "/d", "/e"),
+	}}
+	r.discover = fake.discover
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r.StartStartupDiscovery(ctx, testStartupConfig(3))
+
+	waitForCalls(t, fake, 3)
+	time.Sleep(100 * time.Millisecond)
+
+	if calls := fake.callCount(); calls != 3 {
+		t.Errorf("expected 3 discovery runs, got %d", calls)
+	}
+	if sent := rec.sentDiscoveries(); len(sent) != 3 {
+		t.Errorf("expected 3 discovery pushes, got %d", len(sent))
+	}
+}
+
+func TestRouter_StartupDiscovery_RetriesFailedPush(t *testing.T) {
+	var mu sync.Mutex
+	var sent int
+	failures := 1
+
+	r := NewRouter(func(msg interface{}) error {
+		if _, ok := msg.(*messages.DiscoveryMessage); !ok {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return errors.New("not connected")
+		}
+		sent++
+		return nil
+	}, "")
+	t.Cleanup(r.Stop)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{discoveryWithApps("/a")}}
+	r.discover = fake.discover
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r.StartStartupDiscovery(ctx, testStartupConfig(10))
+
+	// Failed push, successful push of the same result, then stable
+	waitForCalls(t, fake, 3)
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sent != 1 {
+		t.Errorf("expected unchanged result to be pushed once after the failure, got %d", sent)
+	}
+	if calls := fake.callCount(); calls != 3 {
+		t.Errorf("expected 3 discovery runs, got %d", calls)
+	}
+}
+
+func TestRouter_StartupDiscovery_Disabled(t *testing.T) {
+	r, _ := newTestRouter(t)
+
+	fake := &fakeDiscovery{results: []*messages.DiscoveryMessage{discoveryWithApps()}}
+	r.discover = fake.discover
+
+	r.StartStartupDiscovery(context.Background(), testStartupConfig(0))
+	time.Sleep(50 * time.Millisecond)
+
+	if calls := fake.callCount(); calls != 0 {
+		t.Errorf("expected no discovery runs when disabled, got %d", calls)
+	}
+}
+
+func TestRouter_Discovery_SkipsUnchangedPush(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	scan := func(uptime int64, memoryFree uint64, status string, apps ...string) *messages.DiscoveryMessage {
+		msg := discoveryWithApps(apps...)
+		msg.Uptime = uptime
+		msg.System = messages.SystemInfo{CPUCores: 4, MemoryFree: memoryFree}
+		msg.Docker = &messages.DockerInfo{Containers: []messages.ContainerInfo{{ID: "abc123", Name: "redis", Status: status}}}
+		return msg
+	}
+
+	// Identical apart from volatile fields
+	r.applyDiscovery(scan(100, 2048, "Up 3 hours", "/var/www/app"), false)
+	r.applyDiscovery(scan(160, 1024, "Up 4 hours", "/var/www/app"), false)
+
+	if sent := rec.sentDiscoveries(); len(sent) != 1 {
+		t.Fatalf("expected identical scans to be pushed once, got %d", len(sent))
+	}
+
+	// Still applied locally
+	if d := r.Discovery(); d.Uptime != 160 {
+		t.Errorf("expected latest discovery to be kept, got uptime %d", d.Uptime)
+	}
+
+	r.applyDiscovery(scan(220, 1024, "Up 4 hours", "/var/www/app", "/var/www/api"), false)
+	if sent := rec.sentDiscoveries(); len(sent) != 2 {
+		t.Fatalf("expected changed scan to be pushed, got %d pushes", len(sent))
+	}
+
+	// An explicit request from the cloud is always answered
+	r.applyDiscovery(scan(280, 1024, "Up 4 hours", "/var/www/app", "/var/www/api"), true)
+	if sent := rec.sentDiscoveries(); len(sent) != 3 {
+		t.Errorf("expected forced push, got %d pushes", len(sent))
+	}
+}
+
+func TestRouter_Discovery_ConcurrentRequestsShareOneScan(t *testing.T) {
+	r, rec := newTestRouter(t)
+
+	var mu sync.Mutex
+	var startOnce sync.Once
+	calls := 0
+	started := make(chan struct{})
+	release := make(chan struct{})
+	r.discover = func() *messages.DiscoveryMessage {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+
+		startOnce.Do(func() { close(started) })
+		<-release
+		return discoveryWithApps("/var/www/app")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		r.Handle(messages.TypeDiscover, nil)
+	}()
+
+	// The second request arrives while the first scan is running
+	<-started
+	go func() {
+		defer wg.Done()
+		r.Handle(messages.TypeDiscover, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("expected one scan for concurrent requests, got %d", calls)
+	}
+
+	// Both requests are still answered
+	if sent := rec.sentDiscoveries(); len(sent) != 2 {
+		t.Errorf("expected both requests to be answered, got %d pushes", len(sent))
+	}
+}
@@ -0,0 +1,137 @@
+package security
+
+import (
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func TestRedactor_DefaultPatterns(t *testing.T) {
+	r := NewRedactor(DefaultRedactPatterns)
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"env password", "DB_PASSWORD=hunter2\n", "DB_PASSWORD=***\n"},
+		{"env token", "ANTIDOTE_TOKEN=ant_abc123", "ANTIDOTE_TOKEN=***"},
+		{"quoted api key", `api_key: "sk_live_123"`, `api_key: "***"`},
+		{"url credentials", "DATABASE_URL=mysql://app:s3cret@db:3306/app", "DATABASE_URL=mysql://app:***@db:3306/app"},
+		{"bearer header", "Authorization: Bearer eyJhbGci", "Authorization: Bearer ***"},
+		{"multiple on one line", "SECRET=a TOKEN=b", "SECRET=*** TOKEN=***"},
+		{"no secrets", "Migrated: 2024_01_01_create_users_table\n", "Migrated: 2024_01_01_create_users_table\n"},
+		{"key without value", "password reset sent", "password reset sent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Redact(tt.input); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactor_CustomPatterns(t *testing.T) {
+	r := NewRedactor([]string{
+		`sk_live_[A-Za-z0-9]+`, // no group: whole match masked
+		`license=(\w+)`,        // group: only the value masked
+		`[invalid`,             // invalid regex: matched literally
+	})
+
+	input := "charge sk_live_abc123 license=XYZ9 [invalid"
+	want := "charge *** license=*** ***"
+	if got := r.Redact(input); got != want {
+		t.Errorf("Redact(%q) = %q, want %q", input, got, want)
+	}
+}
+
+func TestRedactor_Nil(t *testing.T) {
+	var r *Redactor
+	if got := r.Redact("PASSWORD=x"); got != "PASSWORD=x" {
+		t.Errorf("nil redactor should not change output, got %q", got)
+	}
+}
+
+func TestValidator_Redactor(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{
+		{
+			Path: "/var/www/app",
+			Config: &messages.AppConfig{
+				Redact: []string{`STRIPE_KEY_\w+`},
+			},
+		},
+		{Path: "/var/www/other"},
+	})
+
+	line := "STRIPE_KEY_abc PASSWORD=x"
+
+	tests := []struct {
+		name       string
+		workingDir string
+		want       string
+	}{
+		{"app dir", "/var/www/app", "*** PASSWORD=***"},
+		{"app subdir", "/var/www/app/storage", "*** PASSWORD=***"},
+		{"other app", "/var/www/other", "STRIPE_KEY_abc PASSWORD=***"},
+		{"no working dir", "", "STRIPE_KEY_abc PASSWORD=***"},
+		{"sibling prefix", "/var/www/application", "STRIPE_KEY_abc PASSWORD=***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := v.Redactor(tt.workingDir).Redact(line); got != tt.want {
+				t.Errorf("Redact(%q) = %q, want %q", line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidator_RedactorNestedApps(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{
+		{Path: "/var/www/app", Config: &messages.AppConfig{Redact: []string{`OUTER_\w+`}}},
+		{Path: "/var/www/app/admin", Config: &messages.AppConfig{Redact: []string{`INNER_\w+`}}},
+	})
+
+	// The innermost app's redactor applies, however the map is ordered
+	for i := 0; i < 20; i++ {
+		if got := v.Redactor("/var/www/app/admin/storage").Redact("INNER_a OUTER_b"); got != "*** OUTER_b" {
+			t.Fatalf("expected the nested app's patterns, got %q", got)
+		}
+	}
+	if got := v.Redactor("/var/www/app/storage").Redact("INNER_a OUTER_b"); got != "INNER_a ***" {
+		t.Errorf("expected the outer app's patterns, got %q", got)
+	}
+}
+
+func TestRedactor_RedactFrom(t *testing.T) {
+	r := NewRedactor(DefaultRedactPatterns)
+
+	tests := []struct {
+		name string
+		sent string
+		rest string
+		want string
+	}{
+		{"value continues", "API_TOKEN=ant_ab", "c123 done", "*** done"},
+		{"key continues", "API_TO", "KEN=ant_abc123", "KEN=***"},
+		{"unrelated", "Migrating: ", "2024_01_01_create_users_table", "2024_01_01_create_users_table"},
+		{"new value", "step 1 ", "SECRET=a", "SECRET=***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.RedactFrom(tt.sent+tt.rest, len(tt.sent)); got != tt.want {
+				t.Errorf("RedactFrom(%q, %d) = %q, want %q", tt.sent+tt.rest, len(tt.sent), got, tt.want)
+			}
+		})
+	}
+
+	var nilRedactor *Redactor
+	if got := nilRedactor.RedactFrom("PASSWORD=x", 3); got != "SWORD=x" {
+		t.Errorf("nil redactor should only slice, got %q", got)
+	}
+}
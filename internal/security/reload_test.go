@@ -0,0 +1,149 @@
+package security
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+// largeRuleSet returns an app with many deny patterns
+func largeRuleSet(n int) []messages.AppInfo {
+	deny := make([]string, n)
+	for i := range deny {
+		deny[i] = fmt.Sprintf(`(^|;|\||&&)\s*forbidden_%d\s+--(force|yes)\s+\S+`, i)
+	}
+	return []messages.AppInfo{
+		{Path: "/var/www/app", Config: &messages.AppConfig{Deny: deny}},
+	}
+}
+
+func TestValidator_UpdateApps_DoesNotBlockValidation(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{{Path: "/var/www/app"}})
+
+	// Hold the recompile until validations have run
+	compiling := make(chan struct{})
+	release := make(chan struct{})
+	original := compileRules
+	compileRules = func(patterns []string) []*regexp.Regexp {
+		close(compiling)
+		<-release
+		return original(patterns)
+	}
+	defer func() { compileRules = original }()
+
+	updated := make(chan struct{})
+	go func() {
+		v.UpdateApps([]messages.AppInfo{
+			{Path: "/var/www/app", Config: &messages.AppConfig{Deny: []string{"artisan down"}}},
+		})
+		close(updated)
+	}()
+	<-compiling
+
+	// Validations proceed against the old rules while the new set compiles
+	done := make(chan error, 1)
+	go func() {
+		done <- v.ValidateCommand(&messages.CommandMessage{
+			ID:         "during-reload",
+			Command:    "php artisan down",
+			WorkingDir: "/var/www/app",
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected old rules to allow command during reload, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("validation blocked while rules were compiling")
+	}
+
+	close(release)
+	<-updated
+
+	// New rules apply once swapped in
+	err := v.ValidateCommand(&messages.CommandMessage{
+		ID:         "after-reload",
+		Command:    "php artisan down",
+		WorkingDir: "/var/www/app",
+	})
+	if err == nil {
+		t.Error("expected new deny pattern to apply after reload")
+	}
+}
+
+func TestValidator_UpdateApps_ConcurrentReloads(t *testing.T) {
+	v := NewValidator()
+	apps := largeRuleSet(200)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			v.UpdateApps(apps)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			v.ValidateCommand(&messages.CommandMessage{
+				ID:         fmt.Sprintf("cmd-%d", i),
+				Command:    "ls -la",
+				WorkingDir: "/var/www/app",
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	err := v.ValidateCommand(&messages.CommandMessage{
+		ID:         "final",
+		Command:    "forbidden_199 --force now",
+		WorkingDir: "/var/www/app",
+	})
+	if err == nil {
+		t.Error("expected reloaded deny pattern to apply")
+	}
+}
+
+// BenchmarkValidateCommand_DuringReload measures validation latency while a
+// large rule set is continuously recompiled in the background
+func BenchmarkValidateCommand_DuringReload(b *testing.B) {
+	v := NewValidator()
+	apps := largeRuleSet(2000)
+	v.UpdateApps(apps)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				v.UpdateApps(apps)
+			}
+		}
+	}()
+
+	cmd := &messages.CommandMessage{
+		ID:         "bench",
+		Command:    "php artisan cache:clear",
+		WorkingDir: "/var/www/app",
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.ValidateCommand(cmd)
+	}
+	b.StopTimer()
+
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+package security
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DenyRule is a named deny pattern shared by every app on the server. Apps
+// can opt out of a rule by name with deny_opt_out in antidote.yml.
+type DenyRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// denyRule is a compiled DenyRule
+type denyRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// LoadDenyRules reads named deny rules from a YAML file holding a list of
+// name/pattern pairs. Unlike antidote.yml patterns, invalid regexes are an
+// error: the file belongs to the operator, who can fix it before starting.
+func LoadDenyRules(path string) ([]DenyRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []DenyRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for i, rule := range rules {
+		name := strings.TrimSpace(rule.Name)
+		if name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("rule %s: duplicate name", name)
+		}
+		seen[name] = true
+
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("rule %s: pattern is required", name)
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid regex: %w", name, err)
+		}
+		rules[i].Name = name
+	}
+
+	return rules, nil
+}
+
+// SetGlobalDenyRules sets the named deny rules applied to every command on top
+// of the built-in defaults and the apps' own deny patterns
+func (v *Validator) SetGlobalDenyRules(rules []DenyRule) {
+	compiled := make([]denyRule, 0, len(rules))
+	for _, rule := range rules {
+		re, _ := CompilePattern(rule.Pattern)
+		if re == nil {
+			continue
+		}
+		compiled = append(compiled, denyRule{name: rule.Name, pattern: re})
+	}
+
+	v.mu.Lock()
+	v.globalRules = compiled
+	v.mu.Unlock()
+}
+
+// checkGlobalRules checks a command line against the global rules the app
+// hasn't opted out of (caller must hold lock)
+func (v *Validator) checkGlobalRules(cmdToCheck, normalizedCmd string, optOut []string) error {
+	for _, rule := range v.globalRules {
+		if containsString(optOut, rule.name) {
+			continue
+		}
+		if rule.pattern.MatchString(cmdToCheck) || rule.pattern.MatchString(normalizedCmd) {
+			return &ValidationError{
+				Code:    "COMMAND_DENIED",
+				Message: fmt.Sprintf("command matches denied rule %s: %s", rule.name, rule.pattern.String()),
+			}
+		}
+	}
+	return nil
+}
+
+// optOutFor returns the global rules a command may skip: those the working
+// dir's app opted out of, and that every other app whose paths the command
+// refers to opted out of too, so `cd /var/www/tools && ...` from another app
+// gets no more leeway than running in tools itself. Paths hidden in variables
+// or command substitutions can't be resolved. (caller must hold lock)
+func (v *Validator) optOutFor(workingDir, command string) []string {
+	if workingDir == "" {
+		return nil
+	}
+	cleanDir := filepath.Clean(workingDir)
+	appPath, config := v.appFor(cleanDir)
+	if config == nil || len(config.DenyOptOut) == 0 {
+		return nil
+	}
+
+	optOut := config.DenyOptOut
+	for _, ref := range referencedPaths(command, cleanDir) {
+		refApp, refConfig := v.appFor(ref)
+		if refApp == "" || refApp == appPath {
+			continue
+		}
+		var shared []string
+		if refConfig != nil {
+			for _, name := range optOut {
+				if containsString(refConfig.DenyOptOut, name) {
+					shared = append(shared, name)
+				}
+			}
+		}
+		optOut = shared
+	}
+	return optOut
+}
+
+// referencedPaths returns the clean paths a command's words refer to,
+// relative ones resolved against the working dir
+func referencedPaths(command, workingDir string) []string {
+	words := strings.FieldsFunc(command, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(";&|()<>`'\"=", r)
+	})
+
+	var paths []string
+	for _, word := range words {
+		if word != "." && word != ".." && !strings.Contains(word, "/") {
+			continue
+		}
+		if !filepath.IsAbs(word) {
+			word = filepath.Join(workingDir, word)
+		}
+		paths = append(paths, filepath.Clean(word))
+	}
+	return paths
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/messages"
+)
+
+func TestValidator_GlobalDenyRules(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{
+		{Path: "/var/www/shop", Config: &messages.AppConfig{}},
+		{Path: "/var/www/blog", Config: &messages.AppConfig{}},
+		{Path: "/var/www/tools", Config: &messages.AppConfig{DenyOptOut: []string{"no-force-push"}}},
+	})
+	v.SetGlobalDenyRules([]DenyRule{
+		{Name: "no-force-push", Pattern: `git\s+push\s+.*--force`},
+		{Name: "no-cache-flush", Pattern: `redis-cli\s+flushall`},
+	})
+
+	forcePush := func(dir string) *messages.CommandMessage {
+		return &messages.CommandMessage{ID: "cmd-1", Command: "git push origin main --force", WorkingDir: dir}
+	}
+
+	// Applies to every app, and to commands outside any app
+	for _, dir := range []string{"/var/www/shop", "/var/www/blog", ""} {
+		err := v.ValidateCommand(forcePush(dir))
+		if err == nil || !strings.Contains(err.Error(), "no-force-push") {
+			t.Errorf("expected no-force-push to deny the command in %q, got %v", dir, err)
+		}
+	}
+
+	// The opted out app may force push...
+	if err := v.ValidateCommand(forcePush("/var/www/tools")); err != nil {
+		t.Errorf("expected tools to opt out of no-force-push, got %v", err)
+	}
+
+	// ...but still gets the rules it didn't opt out of, and the defaults
+	flush := &messages.CommandMessage{ID: "cmd-2", Command: "redis-cli FLUSHALL", WorkingDir: "/var/www/tools"}
+	if err := v.ValidateCommand(flush); err == nil || !strings.Contains(err.Error(), "no-cache-flush") {
+		t.Errorf("expected no-cache-flush to still apply to tools, got %v", err)
+	}
+	rmRoot := &messages.CommandMessage{ID: "cmd-3", Command: "rm -rf /", WorkingDir: "/var/www/tools"}
+	if err := v.ValidateCommand(rmRoot); err == nil {
+		t.Error("expected default deny patterns to still apply to tools")
+	}
+
+	// Reaching into the opted out app from another one doesn't get around the
+	// rule, nor does the opted out app reaching into another one
+	for _, c := range []struct{ command, dir string }{
+		{"cd /var/www/tools && git push origin main --force", "/var/www/shop"},
+		{"cd ../tools && git push origin main --force", "/var/www/shop"},
+		{"cd /var/www/shop; git push origin main --force", "/var/www/tools"},
+	} {
+		cmd := &messages.CommandMessage{ID: "cmd-4", Command: c.command, WorkingDir: c.dir}
+		if err := v.ValidateCommand(cmd); err == nil || !strings.Contains(err.Error(), "no-force-push") {
+			t.Errorf("expected no-force-push to deny %q in %s, got %v", c.command, c.dir, err)
+		}
+	}
+
+	// Paths outside every app, or in the same app, keep the opt out
+	tidy := &messages.CommandMessage{ID: "cmd-5", Command: "cd ./deploy && /usr/bin/git push origin main --force", WorkingDir: "/var/www/tools"}
+	if err := v.ValidateCommand(tidy); err != nil {
+		t.Errorf("expected tools to keep its opt out for its own paths, got %v", err)
+	}
+}
+
+func TestLoadDenyRules(t *testing.T) {
+	write := func(content string) string {
+		path := filepath.Join(t.TempDir(), "deny-rules.yml")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	rules, err := LoadDenyRules(write(`- name: no-force-push
+  pattern: 'git\s+push\s+.*--force'
+- name: no-cache-flush
+  pattern: 'redis-cli\s+flushall'
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rules) != 2 || rules[0].Name != "no-force-push" || rules[1].Pattern != `redis-cli\s+flushall` {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+
+	invalid := map[string]string{
+		"missing name":  "- pattern: 'rm'\n",
+		"duplicate":     "- name: a\n  pattern: x\n- name: a\n  pattern: y\n",
+		"bad regex":     "- name: a\n  pattern: '(unclosed'\n",
+		"empty pattern": "- name: a\n",
+	}
+	for name, content := range invalid {
+		if _, err := LoadDenyRules(write(content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package security
 
 import (
+	"encoding/base64"
 	"fmt"
+	"io"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -115,6 +117,20 @@ const (
 	MaxEnvVarNameLen = 256     // Max env var name length
 	MaxEnvVarValueLen = 32768  // 32KB max env var value
 	MaxTimeout       = 3600    // 1 hour max timeout
+	MaxPriority      = 19      // Lowest scheduling priority (max niceness)
+
+	// Scripts are validated line by line, so they may be longer than a
+	// single command but are capped in lines as well as bytes
+	MaxScriptLength = 262144 // 256KB
+	MaxScriptLines  = 2000
+
+	// MaxStdinLength caps a command's stdin as sent (base64 included)
+	MaxStdinLength = 8388608 // 8MB
+
+	// DefaultMaxEnvTotalSize caps the combined size of all command env vars
+	// (name + value + "=" + NUL each), kept well below the OS ARG_MAX which is
+	// shared with the agent's own environment and argv
+	DefaultMaxEnvTotalSize = 131072 // 128KB
 )
 
 // ValidationError represents a security validation failure
@@ -130,32 +146,52 @@ func (e *ValidationError) Error() string {
 // Validator validates commands before execution
 type Validator struct {
 	mu           sync.RWMutex
+	reloadMu     sync.Mutex                      // serializes UpdateApps
 	appConfigs   map[string]*messages.AppConfig // path -> config
 	allowedPaths []string                        // paths where commands can run
 	denyPatterns []*regexp.Regexp                // compiled deny patterns
+	globalRules  []denyRule                      // named rules for every app (apps may opt out)
+	redactors    map[string]*Redactor            // path -> output redactor (default + app patterns)
+	redactor     *Redactor                       // output redactor for commands outside any app
+
+	allowListMode    bool                 // every command must match an allow pattern
+	allowPatterns    allowList            // every app's actions and allow patterns
+	appAllowPatterns map[string]allowList // path -> the app's actions and allow patterns
+
+	maxEnvTotalSize  int             // aggregate env size limit in bytes
+	protectedEnvVars map[string]bool // upper-cased names commands may not set
 }
 
 // NewValidator creates a new security validator
 func NewValidator() *Validator {
 	v := &Validator{
-		appConfigs:   make(map[string]*messages.AppConfig),
-		allowedPaths: []string{},
+		appConfigs:      make(map[string]*messages.AppConfig),
+		allowedPaths:    []string{},
+		maxEnvTotalSize: DefaultMaxEnvTotalSize,
+		redactors:       make(map[string]*Redactor),
+		redactor:        NewRedactor(DefaultRedactPatterns),
 	}
+	v.SetProtectedEnvVars(nil, nil)
 
 	// Compile default deny patterns
-	v.compileDenyPatterns(DefaultDenyPatterns)
+	v.denyPatterns = compileDenyPatterns(DefaultDenyPatterns)
 
 	return v
 }
 
-// UpdateApps updates the validator with discovered apps
+// UpdateApps updates the validator with discovered apps. The new rule set is
+// compiled without holding the lock, so in-flight validations keep using the
+// old rules until the compiled set is swapped in.
 func (v *Validator) UpdateApps(apps []messages.AppInfo) {
-	v.mu.Lock()
-	defer v.mu.Unlock()
+	// Serialize reloads so an older rule set can't be swapped in last
+	v.reloadMu.Lock()
+	defer v.reloadMu.Unlock()
 
-	// Clear existing
-	v.appConfigs = make(map[string]*messages.AppConfig)
-	v.allowedPaths = []string{}
+	appConfigs := make(map[string]*messages.AppConfig)
+	allowedPaths := []string{}
+	redactors := make(map[string]*Redactor)
+	var allowPatterns allowList
+	appAllowPatterns := make(map[string]allowList)
 
 	// Collect all deny patterns (default + per-app)
 	allPatterns := make([]string, len(DefaultDenyPatterns))
@@ -164,38 +200,180 @@ func (v *Validator) UpdateApps(apps []messages.AppInfo) {
 	for _, app := range apps {
 		// Normalize path
 		cleanPath := filepath.Clean(app.Path)
-		v.allowedPaths = append(v.allowedPaths, cleanPath)
+		allowedPaths = append(allowedPaths, cleanPath)
+		appAllowPatterns[cleanPath] = allowList{}
 
 		if app.Config != nil {
-			v.appConfigs[cleanPath] = app.Config
+			appConfigs[cleanPath] = app.Config
 
 			// Add app-specific deny patterns
 			for _, pattern := range app.Config.Deny {
 				allPatterns = append(allPatterns, pattern)
 			}
+
+			// App-specific redaction patterns only apply to that app's output
+			if len(app.Config.Redact) > 0 {
+				redactPatterns := append(append([]string{}, DefaultRedactPatterns...), app.Config.Redact...)
+				redactors[cleanPath] = NewRedactor(redactPatterns)
+			}
+
+			appAllow := compileAllowPatterns(app.Config)
+			appAllowPatterns[cleanPath] = appAllow
+			allowPatterns.actions = append(allowPatterns.actions, appAllow.actions...)
+			allowPatterns.patterns = append(allowPatterns.patterns, appAllow.patterns...)
 		}
 	}
 
 	// Recompile all deny patterns
-	v.compileDenyPatterns(allPatterns)
+	denyPatterns := compileRules(allPatterns)
+
+	// Swap in the new rule set
+	v.mu.Lock()
+	v.appConfigs = appConfigs
+	v.allowedPaths = allowedPaths
+	v.redactors = redactors
+	v.denyPatterns = denyPatterns
+	v.allowPatterns = allowPatterns
+	v.appAllowPatterns = appAllowPatterns
+	v.mu.Unlock()
 }
 
+// SetAllowListMode turns allow-list mode on or off for every app. When on, a
+// command must match one of its app's actions or allow patterns, or any app's
+// when it runs outside an app; apps can also opt in alone with allow_list.
+func (v *Validator) SetAllowListMode(enabled bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.allowListMode = enabled
+}
+
+// allowList holds the commands permitted in allow-list mode
+type allowList struct {
+	actions  []*regexp.Regexp // action commands, matched against the whole command
+	patterns []*regexp.Regexp // allow patterns, matched against each command of a pipeline or list
+}
+
+// allows reports whether a command runs an action, or consists only of
+// commands that each match an action or allow pattern in full, so an allowed
+// command can't be chained with one that isn't
+func (a allowList) allows(command string) bool {
+	if matchesAny(a.actions, command) {
+		return true
+	}
+
+	matched := false
+	for _, stage := range stageSeparators.Split(command, -1) {
+		stage = strings.TrimSpace(stage)
+		if stage == "" {
+			continue
+		}
+		if !matchesAny(a.patterns, stage) && !matchesAny(a.actions, stage) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// compileAllowPatterns compiles an app's allow patterns plus its actions'
+// commands, both of which must match in full. Invalid allow patterns are
+// skipped rather than matched as literals.
+func compileAllowPatterns(config *messages.AppConfig) allowList {
+	var compiled allowList
+	for _, pattern := range config.Allow {
+		if re, err := CompileAllowPattern(pattern); err == nil {
+			compiled.patterns = append(compiled.patterns, re)
+		}
+	}
+
+	for _, action := range config.Actions {
+		command := strings.TrimSpace(action.Command)
+		if command == "" {
+			continue
+		}
+		compiled.actions = append(compiled.actions, regexp.MustCompile(`^`+regexp.QuoteMeta(command)+`$`))
+	}
+
+	return compiled
+}
+
+// CompileAllowPattern compiles an allow pattern the way the validator does:
+// anchored, so it must match a whole command. Unlike deny patterns, an invalid
+// regex is an error, since matching it as a literal could allow more than meant.
+func CompileAllowPattern(pattern string) (*regexp.Regexp, error) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// SetMaxEnvTotalSize sets the aggregate env size limit (<= 0 restores the default)
+func (v *Validator) SetMaxEnvTotalSize(size int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if size <= 0 {
+		size = DefaultMaxEnvTotalSize
+	}
+	v.maxEnvTotalSize = size
+}
+
+// SetProtectedEnvVars sets the environment variables commands may not set:
+// the defaults in ProtectedEnvVars plus extra, minus allowOverride. Names are
+// matched case-insensitively.
+func (v *Validator) SetProtectedEnvVars(extra []string, allowOverride []string) {
+	protected := make(map[string]bool, len(ProtectedEnvVars)+len(extra))
+	for name := range ProtectedEnvVars {
+		protected[name] = true
+	}
+	for _, name := range extra {
+		if name = strings.ToUpper(strings.TrimSpace(name)); name != "" {
+			protected[name] = true
+		}
+	}
+	for _, name := range allowOverride {
+		delete(protected, strings.ToUpper(strings.TrimSpace(name)))
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.protectedEnvVars = protected
+}
+
+// compileRules compiles deny patterns on reload (replaced in tests)
+var compileRules = compileDenyPatterns
+
 // compileDenyPatterns compiles regex patterns
-func (v *Validator) compileDenyPatterns(patterns []string) {
-	v.denyPatterns = make([]*regexp.Regexp, 0, len(patterns))
+func compileDenyPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
 
 	for _, pattern := range patterns {
-		re, err := regexp.Compile(pattern)
-		if err != nil {
-			// Log but don't fail - treat invalid patterns as literal strings
-			// Try escaping as literal
-			escaped := regexp.QuoteMeta(pattern)
-			if re, err = regexp.Compile(escaped); err != nil {
-				continue
-			}
+		re, _ := CompilePattern(pattern)
+		if re == nil {
+			continue
 		}
-		v.denyPatterns = append(v.denyPatterns, re)
+		compiled = append(compiled, re)
 	}
+
+	return compiled
+}
+
+// CompilePattern compiles a deny/approval pattern the way the validator does.
+// Invalid regexes don't fail - they fall back to matching the pattern as a
+// literal string. The returned error reports the original regex problem, if any,
+// so callers (e.g. config validation) can surface it.
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err == nil {
+		return re, nil
+	}
+
+	// Treat invalid patterns as literal strings
+	literal, litErr := regexp.Compile(regexp.QuoteMeta(pattern))
+	if litErr != nil {
+		return nil, err
+	}
+	return literal, err
 }
 
 // ValidateCommand checks if a command is safe to execute
@@ -227,11 +405,38 @@ func (v *Validator) ValidateCommand(cmd *messages.CommandMessage) error {
 		}
 	}
 
+	if cmd.Script != "" && cmd.Command != "" {
+		return &ValidationError{
+			Code:    "INVALID_COMMAND",
+			Message: "command and script are mutually exclusive",
+		}
+	}
+
+	if err := validateStdin(cmd.Stdin, cmd.StdinEncoding); err != nil {
+		return err
+	}
+
+	// Check priority bounds (commands can only be deprioritized, never boosted)
+	if cmd.Priority < 0 || cmd.Priority > MaxPriority {
+		return &ValidationError{
+			Code:    "INVALID_PRIORITY",
+			Message: fmt.Sprintf("priority must be between 0 and %d", MaxPriority),
+		}
+	}
+
 	// Validate working directory
 	if cmd.WorkingDir != "" {
 		if err := v.validateWorkingDir(cmd.WorkingDir); err != nil {
 			return err
 		}
+
+		// Apps may cap timeouts below MaxTimeout
+		if _, config := v.appFor(filepath.Clean(cmd.WorkingDir)); config != nil && config.MaxTimeout > 0 && cmd.Timeout > config.MaxTimeout {
+			return &ValidationError{
+				Code:    "TIMEOUT_TOO_LONG",
+				Message: fmt.Sprintf("timeout exceeds the app's maximum of %d seconds", config.MaxTimeout),
+			}
+		}
 	}
 
 	// Validate environment variables
@@ -240,13 +445,258 @@ func (v *Validator) ValidateCommand(cmd *messages.CommandMessage) error {
 	}
 
 	// Check against deny patterns
-	if err := v.checkDenyPatterns(cmd.Command); err != nil {
-		return err
+	readsStdin := false
+	if cmd.Script != "" {
+		if err := v.checkScript(cmd.Script, cmd.WorkingDir, cmd.AdminSigned); err != nil {
+			return err
+		}
+		readsStdin = scriptReadsStdin(cmd.Script)
+	} else {
+		if err := v.checkDenyPatterns(cmd.Command, cmd.WorkingDir, cmd.AdminSigned); err != nil {
+			return err
+		}
+		readsStdin = shellReadsStdin(cmd.Command)
+	}
+
+	// A shell reading its script from stdin runs it like a script
+	if cmd.Stdin != "" && readsStdin {
+		script := cmd.Stdin
+		if cmd.StdinEncoding == messages.EncodingBase64 {
+			decoded, _ := base64.StdEncoding.DecodeString(cmd.Stdin)
+			script = string(decoded)
+		}
+		return v.checkScript(script, cmd.WorkingDir, cmd.AdminSigned)
+	}
+
+	return nil
+}
+
+// stdinShells are shells that run commands read from stdin
+var stdinShells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "ash": true,
+}
+
+// stdinPaths name stdin when given to a shell as its script file
+var stdinPaths = map[string]bool{
+	"-": true, "/dev/stdin": true, "/dev/fd/0": true, "/proc/self/fd/0": true,
+}
+
+// commandWrappers run the command that follows them (after their options)
+var commandWrappers = map[string]bool{
+	"exec": true, "env": true, "command": true, "nohup": true, "sudo": true, "time": true, "nice": true,
+}
+
+// wrapperValueOptions are wrapper options that take a value (sudo -u www)
+var wrapperValueOptions = map[string]bool{"-u": true, "-g": true, "-n": true}
+
+// shellValueOptions are shell options that take a value (bash -o pipefail)
+var shellValueOptions = map[string]bool{
+	"-o": true, "+o": true, "-O": true, "+O": true, "--rcfile": true, "--init-file": true,
+}
+
+// stageSeparators split a command line into the commands of its pipelines
+// and lists. Quotes aren't honored, so a shell quoted inside another
+// command's arguments also counts.
+var stageSeparators = regexp.MustCompile(`[|;&\n()` + "`" + `]+`)
+
+// assignmentPattern matches a variable assignment in front of a command
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// shellReadsStdin reports whether any command in a command line is a shell
+// that may read its commands from stdin: anywhere in a pipeline (cat | sh),
+// with -s, or with stdin as its script file (sh /dev/stdin). A shell later
+// in a pipeline reads the output of the commands before it, which may be
+// the command's stdin.
+func shellReadsStdin(command string) bool {
+	for _, stage := range stageSeparators.Split(command, -1) {
+		if stageReadsStdin(strings.Fields(stage)) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptReadsStdin reports whether any line of a script runs a shell that
+// may read its commands from stdin, which the script's commands inherit
+func scriptReadsStdin(script string) bool {
+	for _, line := range splitScript(script) {
+		if shellReadsStdin(line.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// stageReadsStdin reports whether a single command is a shell reading its
+// commands from stdin
+func stageReadsStdin(fields []string) bool {
+	for i := range fields {
+		fields[i] = strings.Trim(fields[i], `'"`)
+	}
+
+	fields = skipWrappers(fields)
+	if len(fields) == 0 || !stdinShells[filepath.Base(fields[0])] {
+		return false
+	}
+
+	args := fields[1:]
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			// Only the script file may follow
+			return i+1 >= len(args) || stdinPaths[args[i+1]]
+		case shellValueOptions[arg]:
+			i++
+		case strings.HasPrefix(arg, "--"):
+		case len(arg) > 1 && (arg[0] == '-' || arg[0] == '+'):
+			// Combined flags like -lc or -xs
+			if strings.Contains(arg[1:], "c") {
+				return false
+			}
+			if strings.Contains(arg[1:], "s") {
+				return true
+			}
+		default:
+			// A script file, unless it names stdin
+			return stdinPaths[arg]
+		}
+	}
+	return true
+}
+
+// skipWrappers drops the variable assignments and wrappers (with their
+// options) in front of a command
+func skipWrappers(fields []string) []string {
+	for len(fields) > 0 {
+		switch {
+		case assignmentPattern.MatchString(fields[0]):
+			fields = fields[1:]
+		case commandWrappers[fields[0]]:
+			fields = fields[1:]
+			for len(fields) > 0 && strings.HasPrefix(fields[0], "-") {
+				if wrapperValueOptions[fields[0]] && len(fields) > 1 {
+					fields = fields[1:]
+				}
+				fields = fields[1:]
+			}
+		default:
+			return fields
+		}
+	}
+	return fields
+}
+
+// validateStdin checks a command's stdin size and encoding
+func validateStdin(stdin, encoding string) error {
+	if len(stdin) > MaxStdinLength {
+		return &ValidationError{
+			Code:    "STDIN_TOO_LONG",
+			Message: fmt.Sprintf("stdin exceeds maximum length of %d bytes", MaxStdinLength),
+		}
+	}
+
+	switch encoding {
+	case "":
+		return nil
+	case messages.EncodingBase64:
+		// Decode in chunks, so large inputs aren't held twice
+		decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(stdin))
+		if _, err := io.Copy(io.Discard, decoder); err != nil {
+			return &ValidationError{
+				Code:    "INVALID_STDIN",
+				Message: "stdin is not valid base64",
+			}
+		}
+		return nil
+	default:
+		return &ValidationError{
+			Code:    "INVALID_STDIN",
+			Message: fmt.Sprintf("unknown stdin encoding %q", encoding),
+		}
+	}
+}
+
+// scriptLine is a logical line of a script and the line number it starts on
+type scriptLine struct {
+	number int
+	text   string
+}
+
+// splitScript splits a script into logical lines, joining lines continued
+// with a trailing backslash so a command can't be split to dodge a pattern
+func splitScript(script string) []scriptLine {
+	var lines []scriptLine
+	var current strings.Builder
+	start := 0
+
+	for i, line := range strings.Split(strings.ReplaceAll(script, "\r\n", "\n"), "\n") {
+		if current.Len() == 0 {
+			start = i + 1
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+		lines = append(lines, scriptLine{number: start, text: current.String()})
+		current.Reset()
+	}
+	if current.Len() > 0 {
+		lines = append(lines, scriptLine{number: start, text: current.String()})
+	}
+
+	return lines
+}
+
+// checkScript validates a script's size, then checks each line against the
+// allow-list and deny rules as if it were a command of its own (caller must
+// hold lock)
+func (v *Validator) checkScript(script, workingDir string, bypassAllowList bool) error {
+	if len(script) > MaxScriptLength {
+		return &ValidationError{
+			Code:    "SCRIPT_TOO_LONG",
+			Message: fmt.Sprintf("script exceeds maximum length of %d bytes", MaxScriptLength),
+		}
+	}
+
+	lines := splitScript(script)
+	if len(lines) > MaxScriptLines {
+		return &ValidationError{
+			Code:    "SCRIPT_TOO_LONG",
+			Message: fmt.Sprintf("script exceeds maximum of %d lines", MaxScriptLines),
+		}
+	}
+
+	for _, line := range lines {
+		text := strings.TrimSpace(line.text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		if err := v.checkDenyPatterns(text, workingDir, bypassAllowList); err != nil {
+			if verr, ok := err.(*ValidationError); ok {
+				return &ValidationError{
+					Code:    verr.Code,
+					Message: fmt.Sprintf("script line %d: %s", line.number, verr.Message),
+				}
+			}
+			return err
+		}
 	}
 
 	return nil
 }
 
+// ValidateDir checks a directory against the same rules as a command's
+// working directory
+func (v *Validator) ValidateDir(dir string) error {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.validateWorkingDir(dir)
+}
+
 // validateWorkingDir ensures the working directory is within allowed paths
 func (v *Validator) validateWorkingDir(dir string) error {
 	cleanDir := filepath.Clean(dir)
@@ -273,10 +723,11 @@ func (v *Validator) validateWorkingDir(dir string) error {
 		return nil
 	}
 
-	// Check if the directory is within an allowed path
+	// Check if the directory is an allowed path or inside one (not merely
+	// sharing a prefix, like /var/www/app-secret for /var/www/app)
 	for _, allowed := range v.allowedPaths {
-		if strings.HasPrefix(cleanDir, allowed) {
-			return nil
+		if withinPath(cleanDir, allowed) {
+			return v.checkAppWorkingDirs(cleanDir)
 		}
 	}
 
@@ -286,6 +737,51 @@ func (v *Validator) validateWorkingDir(dir string) error {
 	}
 }
 
+// checkAppWorkingDirs enforces an app's working_dirs restriction: when set, a
+// command may only run in the app root or one of the listed subdirectories,
+// never elsewhere inside the app (e.g. storage/, vendor/, node_modules/)
+func (v *Validator) checkAppWorkingDirs(cleanDir string) error {
+	appPath, config := v.appFor(cleanDir)
+	if config == nil || len(config.WorkingDirs) == 0 {
+		return nil
+	}
+
+	for _, allowed := range config.WorkingDirs {
+		if cleanDir == filepath.Join(appPath, allowed) {
+			return nil
+		}
+	}
+
+	return &ValidationError{
+		Code:    "WORKING_DIR_NOT_ALLOWED",
+		Message: fmt.Sprintf("working directory %s is not one of the app's allowed working dirs", cleanDir),
+	}
+}
+
+// appFor returns the innermost app containing a directory and its config
+// (caller must hold lock)
+func (v *Validator) appFor(cleanDir string) (string, *messages.AppConfig) {
+	var appPath string
+	var config *messages.AppConfig
+	for path, c := range v.appConfigs {
+		if !withinPath(cleanDir, path) {
+			continue
+		}
+		if len(path) > len(appPath) {
+			appPath, config = path, c
+		}
+	}
+	return appPath, config
+}
+
+// withinPath reports whether a clean directory is root or a subdirectory of it
+func withinPath(dir, root string) bool {
+	if dir == root {
+		return true
+	}
+	return strings.HasPrefix(dir, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
+}
+
 // containsPathTraversal checks if a path contains actual ".." traversal components
 func containsPathTraversal(path string) bool {
 	// Split path by directory separator
@@ -330,6 +826,8 @@ func isSpaceObfuscatedTraversal(s string) bool {
 
 // validateEnvVars checks environment variables for security issues
 func (v *Validator) validateEnvVars(env map[string]string) error {
+	totalSize := 0
+
 	for name, value := range env {
 		// Check name length
 		if len(name) > MaxEnvVarNameLen {
@@ -349,7 +847,7 @@ func (v *Validator) validateEnvVars(env map[string]string) error {
 
 		// Check for protected variables
 		upperName := strings.ToUpper(name)
-		if ProtectedEnvVars[upperName] {
+		if v.protectedEnvVars[upperName] {
 			return &ValidationError{
 				Code:    "PROTECTED_ENV_VAR",
 				Message: fmt.Sprintf("cannot override protected environment variable: %s", name),
@@ -363,15 +861,43 @@ func (v *Validator) validateEnvVars(env map[string]string) error {
 				Message: fmt.Sprintf("environment variable name contains invalid characters: %s", name),
 			}
 		}
+
+		// Each entry is passed to exec as "NAME=value\x00"
+		totalSize += len(name) + len(value) + 2
+	}
+
+	if totalSize > v.maxEnvTotalSize {
+		return &ValidationError{
+			Code:    "ENV_TOO_LARGE",
+			Message: fmt.Sprintf("environment variables total %d bytes, exceeding maximum of %d", totalSize, v.maxEnvTotalSize),
+		}
 	}
 
 	return nil
 }
 
-// checkDenyPatterns checks if command matches any deny pattern
-func (v *Validator) checkDenyPatterns(command string) error {
+// checkDenyPatterns checks if command matches any deny pattern or any global
+// rule the working dir's app hasn't opted out of. In allow-list mode a command
+// matching no allow pattern is rejected first, unless bypassAllowList is set
+// (admin-signed commands).
+func (v *Validator) checkDenyPatterns(command, workingDir string, bypassAllowList bool) error {
 	trimmedCmd := strings.TrimSpace(command)
 
+	var config *messages.AppConfig
+	if workingDir != "" {
+		_, config = v.appFor(filepath.Clean(workingDir))
+	}
+	optOut := v.optOutFor(workingDir, trimmedCmd)
+
+	if !bypassAllowList && (v.allowListMode || (config != nil && config.AllowList)) {
+		if !v.allowPatternsFor(workingDir).allows(trimmedCmd) {
+			return &ValidationError{
+				Code:    "COMMAND_NOT_ALLOWED",
+				Message: "command does not match any allowed action or pattern",
+			}
+		}
+	}
+
 	// Skip pure comment lines - they're not executable
 	if strings.HasPrefix(trimmedCmd, "#") {
 		return nil
@@ -402,11 +928,51 @@ func (v *Validator) checkDenyPatterns(command string) error {
 				}
 			}
 		}
+
+		if err := v.checkGlobalRules(cmdToCheck, normalizedCmd, optOut); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// allowPatternsFor returns the allow patterns of the innermost app containing
+// a working dir, or every app's when it runs outside an app (caller must hold lock)
+func (v *Validator) allowPatternsFor(workingDir string) allowList {
+	if workingDir == "" {
+		return v.allowPatterns
+	}
+
+	cleanDir := filepath.Clean(workingDir)
+	var appPath string
+	var patterns allowList
+	found := false
+	for path, p := range v.appAllowPatterns {
+		if !withinPath(cleanDir, path) {
+			continue
+		}
+		if !found || len(path) > len(appPath) {
+			appPath, patterns, found = path, p, true
+		}
+	}
+
+	if !found {
+		return v.allowPatterns
+	}
+	return patterns
+}
+
+// matchesAny reports whether command matches any of the patterns
+func matchesAny(patterns []*regexp.Regexp, command string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(command) {
+			return true
+		}
+	}
+	return false
+}
+
 // stripInlineComments removes comments that appear after the command
 // but preserves # inside quotes
 func stripInlineComments(cmd string) string {
@@ -455,7 +1021,7 @@ func (v *Validator) GetAppConfig(path string) *messages.AppConfig {
 
 	// Check if path is within an app directory
 	for appPath, config := range v.appConfigs {
-		if strings.HasPrefix(cleanPath, appPath) {
+		if withinPath(cleanPath, appPath) {
 			return config
 		}
 	}
@@ -463,6 +1029,163 @@ func (v *Validator) GetAppConfig(path string) *messages.AppConfig {
 	return nil
 }
 
+// ResolveAppDir resolves a working directory given relative to the root of
+// app ("" or "." for the root itself). The app is identified by its path, its
+// antidote.yml name, or its directory name (the project directory for a
+// current/ release layout). The result still has to pass working directory
+// validation.
+func (v *Validator) ResolveAppDir(app, relDir string) (string, error) {
+	if filepath.IsAbs(relDir) {
+		return "", &ValidationError{
+			Code:    "INVALID_WORKING_DIR",
+			Message: fmt.Sprintf("working directory %s must be relative when an app is given", relDir),
+		}
+	}
+	if strings.Contains(relDir, "\x00") || containsPathTraversal(relDir) {
+		return "", &ValidationError{
+			Code:    "PATH_TRAVERSAL",
+			Message: fmt.Sprintf("working directory %s escapes app %s", relDir, app),
+		}
+	}
+
+	v.mu.RLock()
+	var matches []string
+	for _, appPath := range v.allowedPaths {
+		if appMatches(appPath, v.appConfigs[appPath], app) {
+			matches = append(matches, appPath)
+		}
+	}
+	v.mu.RUnlock()
+
+	switch len(matches) {
+	case 0:
+		return "", &ValidationError{
+			Code:    "UNKNOWN_APP",
+			Message: fmt.Sprintf("no discovered app matches %s", app),
+		}
+	case 1:
+		return filepath.Join(matches[0], relDir), nil
+	default:
+		return "", &ValidationError{
+			Code:    "AMBIGUOUS_APP",
+			Message: fmt.Sprintf("%s matches %d apps (%s); use the app path", app, len(matches), strings.Join(matches, ", ")),
+		}
+	}
+}
+
+// appMatches reports whether app identifies the app at appPath
+func appMatches(appPath string, config *messages.AppConfig, app string) bool {
+	if app == "" {
+		return false
+	}
+	if filepath.Clean(app) == appPath {
+		return true
+	}
+	if config != nil && config.App.Name == app {
+		return true
+	}
+
+	name := filepath.Base(appPath)
+	if name == "current" {
+		name = filepath.Base(filepath.Dir(appPath))
+	}
+	return name == app
+}
+
+// ExclusiveAction returns the app and name of the exclusive action a command
+// (or script) runs, matched by its command text, or empty strings if it runs
+// none
+func (v *Validator) ExclusiveAction(workingDir, command string) (string, string) {
+	return v.matchAction(workingDir, command, func(action messages.AppConfigAction) bool {
+		return action.Exclusive
+	})
+}
+
+// DiskHeavyAction reports whether a command (or script) runs an action marked
+// disk_heavy, matched by its command text
+func (v *Validator) DiskHeavyAction(workingDir, command string) bool {
+	_, name := v.matchAction(workingDir, command, func(action messages.AppConfigAction) bool {
+		return action.DiskHeavy
+	})
+	return name != ""
+}
+
+// matchAction returns the app and name of the action selected by want that a
+// command runs, or empty strings if it runs none. Any line, or command of a
+// pipeline or list, running the action's command (see runsAction) counts.
+func (v *Validator) matchAction(workingDir, command string, want func(messages.AppConfigAction) bool) (string, string) {
+	if workingDir == "" {
+		return "", ""
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	appPath, config := v.appFor(filepath.Clean(workingDir))
+	if config == nil {
+		return "", ""
+	}
+
+	for name, action := range config.Actions {
+		if want(action) && runsAction(command, action.Command) {
+			return appPath, name
+		}
+	}
+	return "", ""
+}
+
+// runsAction reports whether a command or script runs an action's command:
+// on a line of its own or as a command of a pipeline or list, after any
+// variable assignments and wrappers (nohup, sudo, ...), however spaced and
+// with any extra arguments
+func runsAction(command, actionCommand string) bool {
+	words := strings.Fields(actionCommand)
+	if len(words) == 0 {
+		return false
+	}
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
+	}
+	pattern := regexp.MustCompile(`^` + strings.Join(words, `\s+`) + `(\s|$)`)
+
+	for _, line := range splitScript(command) {
+		if pattern.MatchString(strings.TrimSpace(line.text)) {
+			return true
+		}
+		for _, stage := range stageSeparators.Split(line.text, -1) {
+			if pattern.MatchString(strings.Join(skipWrappers(strings.Fields(stage)), " ")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Redactor returns the output redactor for a command's working directory
+func (v *Validator) Redactor(workingDir string) *Redactor {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if workingDir == "" {
+		return v.redactor
+	}
+
+	cleanPath := filepath.Clean(workingDir)
+
+	if redactor, ok := v.redactors[cleanPath]; ok {
+		return redactor
+	}
+
+	// With nested apps, the innermost one's patterns apply
+	redactor, longest := v.redactor, 0
+	for appPath, appRedactor := range v.redactors {
+		if len(appPath) > longest && withinPath(cleanPath, appPath) {
+			redactor, longest = appRedactor, len(appPath)
+		}
+	}
+	return redactor
+}
+
 // AllowedPaths returns the list of allowed working directories
 func (v *Validator) AllowedPaths() []string {
 	v.mu.RLock()
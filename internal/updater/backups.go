@@ -0,0 +1,130 @@
+package updater
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeepBackups is how many previous binaries are kept for rollback
+const DefaultKeepBackups = 3
+
+// backupSuffix separates the executable path from the backed up version
+const backupSuffix = ".backup-"
+
+// unsafeVersionChars are replaced when a version is used in a file name
+var unsafeVersionChars = regexp.MustCompile(`[^0-9A-Za-z._-]`)
+
+var (
+	keepBackups   = DefaultKeepBackups
+	keepBackupsMu sync.Mutex
+)
+
+// Backup is a previous agent binary kept after an update
+type Backup struct {
+	Version    string
+	Path       string
+	BackedUpAt time.Time
+}
+
+// SetKeepBackups sets how many previous binaries are kept after an update
+// (0 keeps none, negative restores the default)
+func SetKeepBackups(n int) {
+	keepBackupsMu.Lock()
+	defer keepBackupsMu.Unlock()
+
+	if n < 0 {
+		n = DefaultKeepBackups
+	}
+	keepBackups = n
+}
+
+func getKeepBackups() int {
+	keepBackupsMu.Lock()
+	defer keepBackupsMu.Unlock()
+	return keepBackups
+}
+
+// ListBackups returns the backups of the running executable, newest first
+func ListBackups() ([]Backup, error) {
+	execPath, err := executablePath()
+	if err != nil {
+		return nil, err
+	}
+	return listBackups(execPath)
+}
+
+// executablePath returns the path of the binary updates replace (overridden
+// in tests)
+var executablePath = resolveExecutablePath
+
+// resolveExecutablePath returns the resolved path of the running executable
+func resolveExecutablePath() (string, error) {
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	execPath, err = filepath.EvalSymlinks(execPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve executable path: %w", err)
+	}
+	return execPath, nil
+}
+
+// backupPath returns where the binary of a version is backed up
+func backupPath(execPath, version string) string {
+	if version == "" {
+		version = "unknown"
+	}
+	return execPath + backupSuffix + unsafeVersionChars.ReplaceAllString(version, "_")
+}
+
+// listBackups returns the backups next to execPath, newest first
+func listBackups(execPath string) ([]Backup, error) {
+	entries, err := os.ReadDir(filepath.Dir(execPath))
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := filepath.Base(execPath) + backupSuffix
+	backups := []Backup{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{
+			Version:    strings.TrimPrefix(entry.Name(), prefix),
+			Path:       filepath.Join(filepath.Dir(execPath), entry.Name()),
+			BackedUpAt: info.ModTime(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].BackedUpAt.After(backups[j].BackedUpAt)
+	})
+	return backups, nil
+}
+
+// pruneBackups removes all but the newest keep backups
+func pruneBackups(execPath string, keep int) error {
+	backups, err := listBackups(execPath)
+	if err != nil {
+		return err
+	}
+
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i].Path); err != nil {
+			return fmt.Errorf("failed to remove backup %s: %w", backups[i].Path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setKeepBackups sets the backup retention for the duration of a test
+func setKeepBackups(t *testing.T, n int) {
+	t.Helper()
+
+	orig := getKeepBackups()
+	SetKeepBackups(n)
+	t.Cleanup(func() { SetKeepBackups(orig) })
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func TestReplaceBinary_KeepsLastNBackups(t *testing.T) {
+	const keep = 2
+	setKeepBackups(t, keep)
+
+	dir := t.TempDir()
+	execPath := filepath.Join(dir, "antidote-agent")
+	writeFile(t, execPath, "v0.1.0")
+
+	versions := []string{"v0.1.0", "v0.2.0", "v0.3.0", "v0.4.0"} // keep+2 updates
+	for i, current := range versions {
+		next := filepath.Join(dir, "download")
+		if i+1 < len(versions) {
+			writeFile(t, next, versions[i+1])
+		} else {
+			writeFile(t, next, "v0.5.0")
+		}
+
+		if err := replaceBinary(execPath, next, current); err != nil {
+			t.Fatalf("update %d failed: %v", i+1, err)
+		}
+		time.Sleep(10 * time.Millisecond) // distinct backup times
+	}
+
+	backups, err := listBackups(execPath)
+	if err != nil {
+		t.Fatalf("failed to list backups: %v", err)
+	}
+	if len(backups) != keep {
+		t.Fatalf("expected %d backups, got %d: %+v", keep, len(backups), backups)
+	}
+
+	// Newest first
+	if backups[0].Version != "v0.4.0" || backups[1].Version != "v0.3.0" {
+		t.Errorf("expected backups of v0.4.0 and v0.3.0, got %s and %s", backups[0].Version, backups[1].Version)
+	}
+	if data, _ := os.ReadFile(backups[0].Path); string(data) != "v0.4.0" {
+		t.Errorf("expected backup to hold the replaced binary, got %q", data)
+	}
+	if data, _ := os.ReadFile(execPath); string(data) != "v0.5.0" {
+		t.Errorf("expected new binary in place, got %q", data)
+	}
+}
+
+func TestReplaceBinary_KeepNone(t *testing.T) {
+	setKeepBackups(t, 0)
+
+	dir := t.TempDir()
+	execPath := filepath.Join(dir, "antidote-agent")
+	writeFile(t, execPath, "old")
+	next := filepath.Join(dir, "download")
+	writeFile(t, next, "new")
+
+	if err := replaceBinary(execPath, next, "v0.1.0"); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	if backups, _ := listBackups(execPath); len(backups) != 0 {
+		t.Errorf("expected no backups, got %+v", backups)
+	}
+}
+
+func TestListBackups_IgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	execPath := filepath.Join(dir, "antidote-agent")
+	writeFile(t, execPath, "current")
+	writeFile(t, execPath+".backup-v0.3.0", "old")
+	writeFile(t, filepath.Join(dir, "other.backup-v0.1.0"), "unrelated")
+	writeFile(t, execPath+".backup", "legacy")
+
+	backups, err := listBackups(execPath)
+	if err != nil {
+		t.Fatalf("failed to list backups: %v", err)
+	}
+	if len(backups) != 1 || backups[0].Version != "v0.3.0" {
+		t.Errorf("expected only the v0.3.0 backup, got %+v", backups)
+	}
+}
+
+func TestBackupPath_SanitizesVersion(t *testing.T) {
+	if got := backupPath("/usr/local/bin/antidote-agent", "v1.0/../x"); got != "/usr/local/bin/antidote-agent.backup-v1.0_.._x" {
+		t.Errorf("unexpected backup path %s", got)
+	}
+	if got := backupPath("/usr/local/bin/antidote-agent", ""); got != "/usr/local/bin/antidote-agent.backup-unknown" {
+		t.Errorf("unexpected backup path %s", got)
+	}
+}
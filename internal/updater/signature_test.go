@@ -0,0 +1,196 @@
+package updater
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/codebasehealth/antidote-agent/internal/signing"
+)
+
+// testReleaseSigner signs test releases; its public key replaces the release
+// key for all tests in this package
+var testReleaseSigner = newTestReleaseSigner()
+
+func newTestReleaseSigner() *signing.Signer {
+	signer, err := signing.GenerateKeyPair()
+	if err != nil {
+		panic(err)
+	}
+	releasePublicKey = signer.PublicKeyBase64()
+	return signer
+}
+
+// setVerifySignatures sets signature verification for the duration of a test
+func setVerifySignatures(t *testing.T, verify bool) {
+	t.Helper()
+
+	orig := getVerifySignatures()
+	SetVerifySignatures(verify)
+	t.Cleanup(func() { SetVerifySignatures(orig) })
+}
+
+func TestReleasePublicKeyIsValid(t *testing.T) {
+	if _, err := signing.NewVerifier(ReleasePublicKey); err != nil {
+		t.Errorf("ReleasePublicKey is not a valid Ed25519 public key: %v", err)
+	}
+}
+
+func TestVerifyReleaseSignature(t *testing.T) {
+	binary := []byte("new binary")
+	signature := testReleaseSigner.Sign(binary)
+
+	if err := verifyReleaseSignature(binary, signature); err != nil {
+		t.Errorf("expected raw signature to verify, got %v", err)
+	}
+	encoded := []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+	if err := verifyReleaseSignature(binary, encoded); err != nil {
+		t.Errorf("expected base64 signature to verify, got %v", err)
+	}
+	if err := verifyReleaseSignature([]byte("tampered binary"), signature); !errors.Is(err, ErrInvalidReleaseSignature) {
+		t.Errorf("expected ErrInvalidReleaseSignature for tampered binary, got %v", err)
+	}
+
+	other, _ := signing.GenerateKeyPair()
+	if err := verifyReleaseSignature(binary, other.Sign(binary)); !errors.Is(err, ErrInvalidReleaseSignature) {
+		t.Errorf("expected ErrInvalidReleaseSignature for another key, got %v", err)
+	}
+	if err := verifyReleaseSignature(binary, []byte("garbage")); !errors.Is(err, ErrInvalidReleaseSignature) {
+		t.Errorf("expected ErrInvalidReleaseSignature for garbage, got %v", err)
+	}
+}
+
+func TestSelfUpdate_BadSignatureAborts(t *testing.T) {
+	// A compromised pipeline can publish a matching checksum, but not a
+	// signature from the release key
+	other, _ := signing.GenerateKeyPair()
+
+	newReleaseServer(t, releaseWithAsset("v0.5.0"))
+	serveDownloads(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, signatureSuffix) {
+			w.Write(other.Sign([]byte("evil binary")))
+			return
+		}
+		serveBinary("evil binary", sha256Hex("evil binary"))(w, r)
+	})
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	result, err := SelfUpdate()
+	if !errors.Is(err, ErrInvalidReleaseSignature) {
+		t.Fatalf("expected ErrInvalidReleaseSignature, got %v", err)
+	}
+	if result.Updated {
+		t.Error("expected no update with a bad signature")
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestSelfUpdate_MissingSignatureAborts(t *testing.T) {
+	release := releaseWithAsset("v0.5.0")
+	release.Assets = release.Assets[:3]
+	newReleaseServer(t, release)
+	serveDownloads(t, serveBinary("new binary", sha256Hex("new binary")))
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	if _, err := SelfUpdate(); err == nil || !strings.Contains(err.Error(), signatureSuffix) {
+		t.Fatalf("expected missing signature error, got %v", err)
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestSelfUpdate_SkipSignatureCheck(t *testing.T) {
+	release := releaseWithAsset("v0.5.0")
+	release.Assets = release.Assets[:3]
+	newReleaseServer(t, release)
+	serveDownloads(t, serveBinary("new binary", sha256Hex("new binary")))
+	setVersion(t, "v0.4.0")
+	setKeepBackups(t, 1)
+	setVerifySignatures(t, false)
+	execPath := fakeExecutable(t)
+
+	if _, err := SelfUpdate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(execPath); string(data) != "new binary" {
+		t.Errorf("expected new binary to be installed, got %q", data)
+	}
+}
+
+func TestUpdateTo_UnsignedOlderRelease(t *testing.T) {
+	// Published before release signing, so it has no .sig asset
+	release := releaseWithAsset("v0.4.0")
+	release.Assets = release.Assets[:3]
+	newReleaseServer(t, release)
+	serveDownloads(t, serveBinary("old binary", sha256Hex("old binary")))
+	setVersion(t, "v0.5.1")
+	setKeepBackups(t, 1)
+	execPath := fakeExecutable(t)
+
+	result, err := UpdateTo("v0.4.0", true)
+	if err != nil {
+		t.Fatalf("expected an unsigned pre-signing release to install, got %v", err)
+	}
+	if !result.Updated || !result.Unsigned {
+		t.Errorf("expected an update marked unsigned, got %+v", result)
+	}
+	if data, _ := os.ReadFile(execPath); string(data) != "old binary" {
+		t.Errorf("expected old binary to be installed, got %q", data)
+	}
+}
+
+func TestUpdateTo_UnsignedOlderReleaseStillChecksummed(t *testing.T) {
+	release := releaseWithAsset("v0.4.0")
+	release.Assets = release.Assets[:3]
+	newReleaseServer(t, release)
+	serveDownloads(t, serveBinary("evil binary", sha256Hex("old binary")))
+	setVersion(t, "v0.5.1")
+	execPath := fakeExecutable(t)
+
+	if _, err := UpdateTo("v0.4.0", true); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestUpdateTo_SignedOlderReleaseVerified(t *testing.T) {
+	// A release older than the current one but signed must still verify
+	other, _ := signing.GenerateKeyPair()
+
+	newReleaseServer(t, releaseWithAsset("v0.4.0"))
+	serveDownloads(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, signatureSuffix) {
+			w.Write(other.Sign([]byte("old binary")))
+			return
+		}
+		serveBinary("old binary", sha256Hex("old binary"))(w, r)
+	})
+	setVersion(t, "v0.5.1")
+	execPath := fakeExecutable(t)
+
+	if _, err := UpdateTo("v0.4.0", true); !errors.Is(err, ErrInvalidReleaseSignature) {
+		t.Fatalf("expected ErrInvalidReleaseSignature, got %v", err)
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestPredatesSigning(t *testing.T) {
+	for tag, expected := range map[string]bool{
+		"v0.3.2":     true,
+		"v0.4.9":     true,
+		"v0.5.0":     false,
+		"v0.5.1":     false,
+		"v1.0.0":     false,
+		"v0.10.0":    false,
+		"0.4.0":      true,
+		"v0.5.0-rc1": false,
+	} {
+		if got := predatesSigning(tag); got != expected {
+			t.Errorf("predatesSigning(%q) = %v, expected %v", tag, got, expected)
+		}
+	}
+}
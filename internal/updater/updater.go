@@ -1,30 +1,119 @@
 package updater
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/codebasehealth/antidote-agent/internal/connection"
+	"github.com/codebasehealth/antidote-agent/internal/discovery"
 )
 
 const (
-	GitHubRepo   = "codebasehealth/antidote-agent"
-	GitHubAPIURL = "https://api.github.com/repos/" + GitHubRepo + "/releases/latest"
+	GitHubRepo        = "codebasehealth/antidote-agent"
+	GitHubReleasesURL = "https://api.github.com/repos/" + GitHubRepo + "/releases"
+	GitHubAPIURL      = GitHubReleasesURL + "/latest"
 )
 
+// releasesURL is the release API base URL (overridden in tests)
+var releasesURL = GitHubReleasesURL
+
+// httpClient fetches release data and downloads (overridden in tests)
+var httpClient = newHTTPClient(nil)
+
+// newHTTPClient returns a client that goes through the same proxy as the
+// cloud connection
+func newHTTPClient(proxyURL *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = connection.ProxyFunc(proxyURL)
+	return &http.Client{Transport: transport}
+}
+
+// SetProxy sets the proxy used to reach GitHub (nil uses the proxy
+// environment variables)
+func SetProxy(proxyURL *url.URL) {
+	httpClient = newHTTPClient(proxyURL)
+}
+
+// ErrUpdatesDisabled is returned by every update path when self-update is
+// turned off, e.g. on fleets where a package manager owns the binary
+var ErrUpdatesDisabled = errors.New("self-update is disabled (--no-self-update)")
+
+var (
+	updatesDisabled   bool
+	updatesDisabledMu sync.Mutex
+)
+
+// SetDisabled turns self-update off: checks and installs return
+// ErrUpdatesDisabled without contacting GitHub
+func SetDisabled(disabled bool) {
+	updatesDisabledMu.Lock()
+	defer updatesDisabledMu.Unlock()
+	updatesDisabled = disabled
+}
+
+// Disabled reports whether self-update is turned off
+func Disabled() bool {
+	updatesDisabledMu.Lock()
+	defer updatesDisabledMu.Unlock()
+	return updatesDisabled
+}
+
+// ErrReleaseUnavailable is returned when a release or its binary was removed
+// (e.g. yanked) between the update check and the download
+var ErrReleaseUnavailable = errors.New("release is no longer available")
+
+// ErrChecksumMismatch is returned when a downloaded binary does not match the
+// SHA-256 checksum published with its release
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ChecksumsAsset is the release asset listing SHA-256 checksums of all binaries
+const ChecksumsAsset = "checksums.txt"
+
+// maxSmallAssetSize limits how much of a checksum or signature file is read
+const maxSmallAssetSize = 64 * 1024
+
+// versionTagPattern matches release tags like v0.4.0 or v1.2.0-rc.1
+var versionTagPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*(-[0-9A-Za-z.]+)?$`)
+
+// Hosts release binaries may be downloaded from
+var allowedDownloadHosts = []string{
+	"github.com",
+	"objects.githubusercontent.com",
+	"github-releases.githubusercontent.com",
+}
+
 // Release represents a GitHub release
 type Release struct {
-	TagName string  `json:"tag_name"`
-	Assets  []Asset `json:"assets"`
+	TagName     string  `json:"tag_name"`
+	Name        string  `json:"name"`
+	Body        string  `json:"body"`
+	PublishedAt string  `json:"published_at"`
+	Assets      []Asset `json:"assets"`
 }
 
+// SecurityMarker flags a release as a security release when it appears in
+// the release name or notes
+const SecurityMarker = "[security]"
+
+// MaxChangelogLines limits the changelog printed by --check-update
+const MaxChangelogLines = 20
+
 // Asset represents a release asset
 type Asset struct {
 	Name               string `json:"name"`
@@ -34,10 +123,26 @@ type Asset struct {
 // UpdateResult contains the result of an update check or update
 type UpdateResult struct {
 	CurrentVersion  string
-	LatestVersion   string
+	LatestVersion   string // latest release, or the pinned target for UpdateTo
 	UpdateAvailable bool
+	Downgrade       bool // target is older than the current version
 	Updated         bool
+	Unsigned        bool // installed without a signature, as a release from before FirstSignedRelease
 	Error           error
+
+	// Release details for the latest/target version
+	ReleaseNotes    string
+	PublishedAt     time.Time
+	SecurityRelease bool
+}
+
+// ValidationError is returned when release data fails validation
+type ValidationError struct {
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
 }
 
 // CheckForUpdate checks if a newer version is available
@@ -45,6 +150,10 @@ func CheckForUpdate() (*UpdateResult, error) {
 	result := &UpdateResult{
 		CurrentVersion: connection.Version,
 	}
+	if Disabled() {
+		result.Error = ErrUpdatesDisabled
+		return result, result.Error
+	}
 
 	release, err := fetchLatestRelease()
 	if err != nil {
@@ -54,15 +163,58 @@ func CheckForUpdate() (*UpdateResult, error) {
 
 	result.LatestVersion = release.TagName
 	result.UpdateAvailable = isNewerVersion(release.TagName, connection.Version)
+	applyReleaseDetails(result, release)
 
 	return result, nil
 }
 
+// applyReleaseDetails copies release notes, date and security flag into a result
+func applyReleaseDetails(result *UpdateResult, release *Release) {
+	result.ReleaseNotes = strings.TrimSpace(release.Body)
+	result.SecurityRelease = isSecurityRelease(release)
+
+	if release.PublishedAt != "" {
+		if published, err := time.Parse(time.RFC3339, release.PublishedAt); err == nil {
+			result.PublishedAt = published
+		}
+	}
+}
+
+// isSecurityRelease reports whether a release is marked as a security release
+func isSecurityRelease(release *Release) bool {
+	marker := strings.ToLower(SecurityMarker)
+	return strings.Contains(strings.ToLower(release.Name), marker) ||
+		strings.Contains(strings.ToLower(release.Body), marker)
+}
+
+// TrimChangelog returns at most maxLines non-empty lines of release notes,
+// noting how many lines were cut
+func TrimChangelog(notes string, maxLines int) string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(notes, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	if maxLines <= 0 || len(lines) <= maxLines {
+		return strings.Join(lines, "\n")
+	}
+
+	return strings.Join(lines[:maxLines], "\n") + fmt.Sprintf("\n... (%d more lines)", len(lines)-maxLines)
+}
+
 // SelfUpdate downloads and installs the latest version
 func SelfUpdate() (*UpdateResult, error) {
 	result := &UpdateResult{
 		CurrentVersion: connection.Version,
 	}
+	if Disabled() {
+		result.Error = ErrUpdatesDisabled
+		return result, result.Error
+	}
 
 	// Fetch latest release info
 	release, err := fetchLatestRelease()
@@ -73,38 +225,95 @@ func SelfUpdate() (*UpdateResult, error) {
 
 	result.LatestVersion = release.TagName
 	result.UpdateAvailable = isNewerVersion(release.TagName, connection.Version)
+	applyReleaseDetails(result, release)
 
 	if !result.UpdateAvailable {
 		return result, nil
 	}
 
-	// Find the asset for current OS/arch
-	assetName := fmt.Sprintf("antidote-agent-%s-%s", runtime.GOOS, runtime.GOARCH)
-	var downloadURL string
-	for _, asset := range release.Assets {
-		if asset.Name == assetName {
-			downloadURL = asset.BrowserDownloadURL
-			break
-		}
+	return installRelease(result, release)
+}
+
+// UpdateTo downloads and installs a specific release version. Installing an
+// older version than the current one requires allowDowngrade.
+func UpdateTo(version string, allowDowngrade bool) (*UpdateResult, error) {
+	result := &UpdateResult{
+		CurrentVersion: connection.Version,
+	}
+	if Disabled() {
+		result.Error = ErrUpdatesDisabled
+		return result, result.Error
 	}
 
-	if downloadURL == "" {
-		result.Error = fmt.Errorf("no binary found for %s/%s", runtime.GOOS, runtime.GOARCH)
+	release, err := fetchReleaseByTag(version)
+	if err != nil {
+		result.Error = fmt.Errorf("failed to fetch release %s: %w", version, err)
+		return result, result.Error
+	}
+
+	result.LatestVersion = release.TagName
+	applyReleaseDetails(result, release)
+
+	// Same version - nothing to do
+	if strings.TrimPrefix(release.TagName, "v") == strings.TrimPrefix(connection.Version, "v") {
+		return result, nil
+	}
+
+	result.UpdateAvailable = true
+	result.Downgrade = !isNewerVersion(release.TagName, connection.Version)
+
+	if result.Downgrade && !allowDowngrade {
+		result.Error = fmt.Errorf("%s is older than the current version %s (downgrade not allowed)", release.TagName, connection.Version)
+		return result, result.Error
+	}
+
+	return installRelease(result, release)
+}
+
+// installRelease downloads the release binary for this platform and replaces
+// the current executable with it
+func installRelease(result *UpdateResult, release *Release) (*UpdateResult, error) {
+	execPath, err := executablePath()
+	if err != nil {
+		result.Error = err
+		return result, result.Error
+	}
+
+	// The release may have been yanked or its assets replaced since it was
+	// fetched, so look it up again right before downloading
+	current, err := refetchRelease(release)
+	if err != nil {
+		result.Error = err
 		return result, result.Error
 	}
 
-	// Get current executable path
-	execPath, err := os.Executable()
+	downloadURL, err := findAsset(current)
 	if err != nil {
-		result.Error = fmt.Errorf("failed to get executable path: %w", err)
+		result.Error = err
 		return result, result.Error
 	}
-	execPath, err = filepath.EvalSymlinks(execPath)
+
+	checksum, err := fetchChecksum(current, binaryAssetName())
 	if err != nil {
-		result.Error = fmt.Errorf("failed to resolve executable path: %w", err)
+		result.Error = fmt.Errorf("failed to fetch checksum: %w", err)
 		return result, result.Error
 	}
 
+	var signature []byte
+	if getVerifySignatures() {
+		signature, err = fetchReleaseSignature(current, binaryAssetName())
+		if errors.Is(err, errNoSignature) && predatesSigning(current.TagName) {
+			log.Printf("WARNING: %s is an unsigned release (published before %s, when signing started); verifying it by checksum only",
+				current.TagName, FirstSignedRelease)
+			result.Unsigned = true
+			err = nil
+		}
+		if err != nil {
+			result.Error = fmt.Errorf("failed to fetch signature: %w", err)
+			return result, result.Error
+		}
+	}
+
 	// Download to temp file
 	tempFile, err := downloadToTemp(downloadURL)
 	if err != nil {
@@ -113,56 +322,337 @@ func SelfUpdate() (*UpdateResult, error) {
 	}
 	defer os.Remove(tempFile)
 
+	// Verify before touching the current binary
+	if err := verifyChecksum(tempFile, checksum); err != nil {
+		result.Error = fmt.Errorf("failed to verify update: %w", err)
+		return result, result.Error
+	}
+	if signature != nil {
+		binary, err := os.ReadFile(tempFile)
+		if err != nil {
+			result.Error = fmt.Errorf("failed to read update: %w", err)
+			return result, result.Error
+		}
+		if err := verifyReleaseSignature(binary, signature); err != nil {
+			result.Error = fmt.Errorf("failed to verify update: %w", err)
+			return result, result.Error
+		}
+	}
+
 	// Make executable
 	if err := os.Chmod(tempFile, 0755); err != nil {
 		result.Error = fmt.Errorf("failed to make update executable: %w", err)
 		return result, result.Error
 	}
 
-	// Backup current binary
-	backupPath := execPath + ".backup"
-	if err := os.Rename(execPath, backupPath); err != nil {
-		result.Error = fmt.Errorf("failed to backup current binary: %w", err)
+	if err := replaceBinary(execPath, tempFile, result.CurrentVersion); err != nil {
+		result.Error = err
 		return result, result.Error
 	}
 
+	result.Updated = true
+	return result, nil
+}
+
+// replaceBinary installs newBinary at execPath. The current binary is kept as
+// a versioned backup for rollback, and backups beyond the retention limit are
+// pruned once the new binary is in place.
+func replaceBinary(execPath, newBinary, currentVersion string) error {
+	// Backup current binary
+	backup := backupPath(execPath, currentVersion)
+	os.Remove(backup) // a re-install of the same version replaces its backup
+	if err := os.Rename(execPath, backup); err != nil {
+		return fmt.Errorf("failed to backup current binary: %w", err)
+	}
+
 	// Move new binary into place
-	if err := copyFile(tempFile, execPath); err != nil {
+	if err := copyFile(newBinary, execPath); err != nil {
 		// Restore backup on failure
-		os.Rename(backupPath, execPath)
-		result.Error = fmt.Errorf("failed to install update: %w", err)
-		return result, result.Error
+		os.Rename(backup, execPath)
+		return fmt.Errorf("failed to install update: %w", err)
 	}
 
 	// Make new binary executable
 	if err := os.Chmod(execPath, 0755); err != nil {
 		// Restore backup on failure
 		os.Remove(execPath)
-		os.Rename(backupPath, execPath)
-		result.Error = fmt.Errorf("failed to set permissions: %w", err)
-		return result, result.Error
+		os.Rename(backup, execPath)
+		return fmt.Errorf("failed to set permissions: %w", err)
 	}
 
-	// Remove backup
-	os.Remove(backupPath)
+	// Order backups by when they were replaced, not when they were installed
+	now := time.Now()
+	os.Chtimes(backup, now, now)
 
-	result.Updated = true
-	return result, nil
+	if err := pruneBackups(execPath, getKeepBackups()); err != nil {
+		log.Printf("Warning: failed to prune update backups: %v", err)
+	}
+
+	return nil
 }
 
-// RestartService attempts to restart the antidote-agent systemd service
+// binaryAssetName returns the release asset name of the binary for this OS/arch
+func binaryAssetName() string {
+	return fmt.Sprintf("antidote-agent-%s-%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// findAsset returns the validated download URL of the binary for this OS/arch
+func findAsset(release *Release) (string, error) {
+	assetName := binaryAssetName()
+
+	for _, asset := range release.Assets {
+		if asset.Name != assetName {
+			continue
+		}
+		if !isValidAssetName(asset.Name) {
+			return "", &ValidationError{Message: fmt.Sprintf("invalid asset name %q", asset.Name)}
+		}
+		if err := validateDownloadURL(asset.BrowserDownloadURL); err != nil {
+			return "", fmt.Errorf("invalid download URL for %s: %w", asset.Name, err)
+		}
+		return asset.BrowserDownloadURL, nil
+	}
+
+	return "", fmt.Errorf("no binary found for %s/%s in release %s", runtime.GOOS, runtime.GOARCH, release.TagName)
+}
+
+// fetchChecksum returns the published SHA-256 checksum of assetName, read
+// from a "<asset>.sha256" sidecar if the release has one, or from the
+// release's checksums.txt otherwise
+func fetchChecksum(release *Release, assetName string) (string, error) {
+	var sidecar, checksums *Asset
+	for i := range release.Assets {
+		switch release.Assets[i].Name {
+		case assetName + ".sha256":
+			sidecar = &release.Assets[i]
+		case ChecksumsAsset:
+			checksums = &release.Assets[i]
+		}
+	}
+
+	source := sidecar
+	if source == nil {
+		source = checksums
+	}
+	if source == nil {
+		return "", fmt.Errorf("release %s has no %s", release.TagName, ChecksumsAsset)
+	}
+	data, err := fetchSmallAsset(source)
+	if err != nil {
+		return "", err
+	}
+
+	checksum, err := parseChecksum(bytes.NewReader(data), assetName, source == sidecar)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", source.Name, err)
+	}
+	return checksum, nil
+}
+
+// fetchSmallAsset downloads a small release asset, such as a checksum or
+// signature file, into memory
+func fetchSmallAsset(asset *Asset) ([]byte, error) {
+	if err := validateDownloadURL(asset.BrowserDownloadURL); err != nil {
+		return nil, fmt.Errorf("invalid download URL for %s: %w", asset.Name, err)
+	}
+
+	resp, err := httpClient.Get(asset.BrowserDownloadURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s %w", asset.Name, ErrReleaseUnavailable)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s download returned status %d", asset.Name, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, maxSmallAssetSize))
+}
+
+// parseChecksum finds the checksum of assetName in sha256sum output. A
+// sidecar file holds a single checksum, optionally followed by the file name.
+func parseChecksum(r io.Reader, assetName string, sidecar bool) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		// sha256sum marks binary-mode entries with a leading '*'
+		if !sidecar && (len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != assetName) {
+			continue
+		}
+
+		if !isSHA256Hex(fields[0]) {
+			return "", fmt.Errorf("malformed checksum for %s", assetName)
+		}
+		return strings.ToLower(fields[0]), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("no checksum listed for %s", assetName)
+}
+
+// isSHA256Hex reports whether s is a hex-encoded SHA-256 digest
+func isSHA256Hex(s string) bool {
+	decoded, err := hex.DecodeString(s)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// verifyChecksum checks that the SHA-256 of the file at path matches the
+// expected hex digest
+func verifyChecksum(path, expected string) error {
+	if !isSHA256Hex(expected) {
+		return fmt.Errorf("invalid expected checksum %q", expected)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, strings.ToLower(expected), actual)
+	}
+
+	return nil
+}
+
+// isValidAssetName checks that an asset name is a plain antidote-agent binary name
+func isValidAssetName(name string) bool {
+	// Must not be empty
+	if name == "" {
+		return false
+	}
+
+	// Must not contain path traversal
+	if strings.Contains(name, "..") {
+		return false
+	}
+
+	// Must not be absolute path
+	if strings.HasPrefix(name, "/") {
+		return false
+	}
+
+	// Must match expected format: antidote-agent-{os}-{arch}
+	if !strings.HasPrefix(name, "antidote-agent-") {
+		return false
+	}
+
+	// Must not have unexpected extensions
+	if strings.HasSuffix(name, ".sh") || strings.HasSuffix(name, ".bat") {
+		return false
+	}
+
+	return true
+}
+
+// validateDownloadURL checks that a download URL is HTTPS from GitHub
+func validateDownloadURL(downloadURL string) error {
+	if downloadURL == "" {
+		return &ValidationError{Message: "empty URL"}
+	}
+
+	parsed, err := url.Parse(downloadURL)
+	if err != nil {
+		return &ValidationError{Message: "invalid URL"}
+	}
+
+	// Must use HTTPS
+	if parsed.Scheme != "https" {
+		return &ValidationError{Message: "must use HTTPS"}
+	}
+
+	// Must be from GitHub or GitHub CDN
+	hostAllowed := false
+	for _, allowed := range allowedDownloadHosts {
+		if parsed.Host == allowed || strings.HasSuffix(parsed.Host, "."+allowed) {
+			hostAllowed = true
+			break
+		}
+	}
+
+	if !hostAllowed {
+		return &ValidationError{Message: "URL must be from GitHub"}
+	}
+
+	// Check for path traversal
+	if strings.Contains(parsed.Path, "..") {
+		return &ValidationError{Message: "URL contains path traversal"}
+	}
+
+	return nil
+}
+
+// RestartService attempts to restart the antidote-agent service using the
+// host's init system
 func RestartService() error {
-	cmd := exec.Command("systemctl", "restart", "antidote-agent")
+	args := discovery.ServiceRestartCommand("antidote-agent")
+	cmd := exec.Command(args[0], args[1:]...)
 	return cmd.Run()
 }
 
+// refetchRelease fetches a release again by tag, failing with
+// ErrReleaseUnavailable if it was deleted
+func refetchRelease(release *Release) (*Release, error) {
+	current, err := fetchReleaseByTag(release.TagName)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%s: %w", release.TagName, ErrReleaseUnavailable)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-check release %s: %w", release.TagName, err)
+	}
+	return current, nil
+}
+
 func fetchLatestRelease() (*Release, error) {
-	resp, err := http.Get(GitHubAPIURL)
+	return fetchRelease(releasesURL + "/latest")
+}
+
+// fetchReleaseByTag fetches a release by its tag (e.g. v0.4.0)
+func fetchReleaseByTag(tag string) (*Release, error) {
+	if !versionTagPattern.MatchString(tag) {
+		return nil, &ValidationError{Message: fmt.Sprintf("invalid version %q", tag)}
+	}
+
+	release, err := fetchRelease(releasesURL + "/tags/" + url.PathEscape(tag))
+	if err != nil {
+		return nil, err
+	}
+	if release.TagName != tag {
+		return nil, fmt.Errorf("GitHub API returned release %q for tag %q", release.TagName, tag)
+	}
+
+	return release, nil
+}
+
+// errNotFound is returned for release API and download 404s
+var errNotFound = errors.New("not found")
+
+func fetchRelease(apiURL string) (*Release, error) {
+	resp, err := httpClient.Get(apiURL)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("release %w", errNotFound)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
 	}
@@ -175,13 +665,19 @@ func fetchLatestRelease() (*Release, error) {
 	return &release, nil
 }
 
+// downloadToTemp downloads a release binary to a temp file. Missing assets,
+// empty bodies and truncated downloads are errors, so a partial binary is
+// never installed.
 func downloadToTemp(url string) (string, error) {
-	resp, err := http.Get(url)
+	resp, err := httpClient.Get(url)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("release binary %w", ErrReleaseUnavailable)
+	}
 	if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("download returned status %d", resp.StatusCode)
 	}
@@ -192,7 +688,15 @@ func downloadToTemp(url string) (string, error) {
 	}
 	defer tempFile.Close()
 
-	if _, err := io.Copy(tempFile, resp.Body); err != nil {
+	written, err := io.Copy(tempFile, resp.Body)
+	if err == nil && written == 0 {
+		err = errors.New("download was empty")
+	}
+	if err == nil && resp.ContentLength >= 0 && written != resp.ContentLength {
+		err = fmt.Errorf("download truncated: got %d of %d bytes", written, resp.ContentLength)
+	}
+	if err != nil {
+		tempFile.Close()
 		os.Remove(tempFile.Name())
 		return "", err
 	}
@@ -1,9 +1,22 @@
 package updater
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/codebasehealth/antidote-agent/internal/connection"
 )
 
 func TestIsNewerVersion(t *testing.T) {
@@ -234,36 +247,6 @@ func TestAssetNameValidation(t *testing.T) {
 	}
 }
 
-// Helper function for asset name validation
-func isValidAssetName(name string) bool {
-	// Must not be empty
-	if name == "" {
-		return false
-	}
-
-	// Must not contain path traversal
-	if strings.Contains(name, "..") {
-		return false
-	}
-
-	// Must not be absolute path
-	if strings.HasPrefix(name, "/") {
-		return false
-	}
-
-	// Must match expected format: antidote-agent-{os}-{arch}
-	if !strings.HasPrefix(name, "antidote-agent-") {
-		return false
-	}
-
-	// Must not have unexpected extensions
-	if strings.HasSuffix(name, ".sh") || strings.HasSuffix(name, ".bat") {
-		return false
-	}
-
-	return true
-}
-
 // TestDownloadURLValidation validates download URL security
 func TestDownloadURLValidation(t *testing.T) {
 	tests := []struct {
@@ -326,58 +309,6 @@ func TestDownloadURLValidation(t *testing.T) {
 	}
 }
 
-// validateDownloadURL checks if a download URL is safe
-func validateDownloadURL(downloadURL string) error {
-	if downloadURL == "" {
-		return &ValidationError{Message: "empty URL"}
-	}
-
-	parsed, err := url.Parse(downloadURL)
-	if err != nil {
-		return &ValidationError{Message: "invalid URL"}
-	}
-
-	// Must use HTTPS
-	if parsed.Scheme != "https" {
-		return &ValidationError{Message: "must use HTTPS"}
-	}
-
-	// Must be from GitHub or GitHub CDN
-	allowedHosts := []string{
-		"github.com",
-		"objects.githubusercontent.com",
-		"github-releases.githubusercontent.com",
-	}
-
-	hostAllowed := false
-	for _, allowed := range allowedHosts {
-		if parsed.Host == allowed || strings.HasSuffix(parsed.Host, "."+allowed) {
-			hostAllowed = true
-			break
-		}
-	}
-
-	if !hostAllowed {
-		return &ValidationError{Message: "URL must be from GitHub"}
-	}
-
-	// Check for path traversal
-	if strings.Contains(parsed.Path, "..") {
-		return &ValidationError{Message: "URL contains path traversal"}
-	}
-
-	return nil
-}
-
-// ValidationError for URL validation
-type ValidationError struct {
-	Message string
-}
-
-func (e *ValidationError) Error() string {
-	return e.Message
-}
-
 // TestReleaseStructValidation validates Release struct parsing
 func TestReleaseStructValidation(t *testing.T) {
 	// Test that Release struct handles missing/malformed data gracefully
@@ -412,3 +343,525 @@ func TestReleaseStructValidation(t *testing.T) {
 		t.Errorf("expected 1 asset, got %d", len(release.Assets))
 	}
 }
+
+// =============================================================================
+// PINNED VERSION TESTS
+// =============================================================================
+
+// newReleaseServer serves the given releases by tag from a fake GitHub API;
+// the last release is served as the latest
+func newReleaseServer(t *testing.T, releases ...Release) {
+	t.Helper()
+
+	byTag := make(map[string]Release)
+	for _, release := range releases {
+		byTag[release.TagName] = release
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest" && len(releases) > 0 {
+			json.NewEncoder(w).Encode(releases[len(releases)-1])
+			return
+		}
+
+		tag := strings.TrimPrefix(r.URL.Path, "/tags/")
+		release, ok := byTag[tag]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(release)
+	}))
+	t.Cleanup(server.Close)
+
+	original := releasesURL
+	releasesURL = server.URL
+	t.Cleanup(func() { releasesURL = original })
+}
+
+// setVersion sets the running agent version for the duration of a test
+func setVersion(t *testing.T, version string) {
+	t.Helper()
+
+	original := connection.Version
+	connection.Version = version
+	t.Cleanup(func() { connection.Version = original })
+}
+
+func releaseWithAsset(tag string) Release {
+	name := fmt.Sprintf("antidote-agent-%s-%s", runtime.GOOS, runtime.GOARCH)
+	return Release{
+		TagName: tag,
+		Assets: []Asset{
+			{Name: "antidote-agent-plan9-mips", BrowserDownloadURL: "https://github.com/" + GitHubRepo + "/releases/download/" + tag + "/antidote-agent-plan9-mips"},
+			{Name: name, BrowserDownloadURL: "https://github.com/" + GitHubRepo + "/releases/download/" + tag + "/" + name},
+			{Name: ChecksumsAsset, BrowserDownloadURL: "https://github.com/" + GitHubRepo + "/releases/download/" + tag + "/" + ChecksumsAsset},
+			{Name: name + signatureSuffix, BrowserDownloadURL: "https://github.com/" + GitHubRepo + "/releases/download/" + tag + "/" + name + signatureSuffix},
+		},
+	}
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// serveBinary serves binary as the release download, listed in checksums.txt
+// under the given checksum and signed by the test release key
+func serveBinary(binary, checksum string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/"+ChecksumsAsset):
+			fmt.Fprintf(w, "%s  antidote-agent-plan9-mips\n%s  %s\n", sha256Hex("other"), checksum, binaryAssetName())
+		case strings.HasSuffix(r.URL.Path, signatureSuffix):
+			w.Write(testReleaseSigner.Sign([]byte(binary)))
+		default:
+			w.Write([]byte(binary))
+		}
+	}
+}
+
+func TestFetchReleaseByTag_SelectsTag(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.3.0"), releaseWithAsset("v0.4.0"))
+
+	release, err := fetchReleaseByTag("v0.3.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if release.TagName != "v0.3.0" {
+		t.Errorf("expected v0.3.0, got %q", release.TagName)
+	}
+
+	downloadURL, err := findAsset(release)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasSuffix(downloadURL, fmt.Sprintf("/v0.3.0/antidote-agent-%s-%s", runtime.GOOS, runtime.GOARCH)) {
+		t.Errorf("expected asset for this platform from v0.3.0, got %q", downloadURL)
+	}
+}
+
+func TestFetchReleaseByTag_NotFound(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.4.0"))
+
+	_, err := fetchReleaseByTag("v9.9.9")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestFetchReleaseByTag_InvalidTag(t *testing.T) {
+	newReleaseServer(t)
+
+	for _, tag := range []string{"", "latest", "../../v1.0.0", "v1.0.0/../x", "v1.0.0; rm -rf /"} {
+		if _, err := fetchReleaseByTag(tag); err == nil {
+			t.Errorf("SECURITY: expected error for tag %q", tag)
+		}
+	}
+}
+
+func TestFindAsset_Missing(t *testing.T) {
+	release := &Release{
+		TagName: "v0.4.0",
+		Assets:  []Asset{{Name: "antidote-agent-plan9-mips", BrowserDownloadURL: "https://github.com/x"}},
+	}
+
+	if _, err := findAsset(release); err == nil {
+		t.Error("expected error when no asset matches this platform")
+	}
+}
+
+func TestFindAsset_RejectsUntrustedURL(t *testing.T) {
+	release := releaseWithAsset("v0.4.0")
+	release.Assets[1].BrowserDownloadURL = "https://evil.com/antidote-agent"
+
+	if _, err := findAsset(&release); err == nil {
+		t.Error("SECURITY: expected error for asset hosted outside GitHub")
+	}
+}
+
+func TestUpdateTo_NonexistentVersion(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.4.0"))
+	setVersion(t, "v0.4.0")
+
+	result, err := UpdateTo("v0.1.99", true)
+	if err == nil {
+		t.Fatal("expected error for nonexistent version")
+	}
+	if result.Updated {
+		t.Error("expected no update for nonexistent version")
+	}
+}
+
+func TestUpdateTo_DowngradeRequiresConfirmation(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.3.0"))
+	setVersion(t, "v0.4.0")
+
+	result, err := UpdateTo("v0.3.0", false)
+	if err == nil || !strings.Contains(err.Error(), "downgrade") {
+		t.Fatalf("expected downgrade error, got %v", err)
+	}
+	if !result.Downgrade {
+		t.Error("expected result to be marked as a downgrade")
+	}
+	if result.Updated {
+		t.Error("expected no update without downgrade confirmation")
+	}
+}
+
+func TestUpdateTo_SameVersion(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.4.0"))
+	setVersion(t, "v0.4.0")
+
+	result, err := UpdateTo("v0.4.0", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.UpdateAvailable || result.Updated {
+		t.Errorf("expected no-op for current version, got %+v", result)
+	}
+}
+
+// =============================================================================
+// YANKED RELEASE TESTS
+// =============================================================================
+
+// downloadTransport sends requests for GitHub download hosts to a test
+// server, and everything else (the fake release API) through unchanged
+type downloadTransport struct {
+	host string
+}
+
+func (dt downloadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if validateDownloadURL(req.URL.String()) == nil {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+		req.URL.Host = dt.host
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// serveDownloads routes release downloads to handler for the duration of a test
+func serveDownloads(t *testing.T, handler http.HandlerFunc) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	original := httpClient
+	httpClient = &http.Client{Transport: downloadTransport{host: strings.TrimPrefix(server.URL, "http://")}}
+	t.Cleanup(func() { httpClient = original })
+}
+
+// fakeExecutable points updates at a stand-in binary for the duration of a test
+func fakeExecutable(t *testing.T) string {
+	t.Helper()
+
+	execPath := filepath.Join(t.TempDir(), "antidote-agent")
+	writeFile(t, execPath, "current binary")
+
+	original := executablePath
+	executablePath = func() (string, error) { return execPath, nil }
+	t.Cleanup(func() { executablePath = original })
+	return execPath
+}
+
+// assertUntouched checks that a failed update left the binary alone
+func assertUntouched(t *testing.T, execPath string) {
+	t.Helper()
+
+	data, err := os.ReadFile(execPath)
+	if err != nil || string(data) != "current binary" {
+		t.Errorf("expected binary to be untouched, got %q (%v)", data, err)
+	}
+	if backups, _ := listBackups(execPath); len(backups) != 0 {
+		t.Errorf("expected no backups, got %+v", backups)
+	}
+}
+
+func TestSelfUpdate_AssetNotFoundAborts(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.5.0"))
+	// Its checksum and signature are still published, only the binary is gone
+	published := serveBinary("new binary", sha256Hex("new binary"))
+	serveDownloads(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/"+binaryAssetName()) {
+			http.NotFound(w, r)
+			return
+		}
+		published(w, r)
+	})
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	result, err := SelfUpdate()
+	if !errors.Is(err, ErrReleaseUnavailable) || !strings.Contains(err.Error(), "failed to download update") {
+		t.Fatalf("expected the binary download to fail with ErrReleaseUnavailable, got %v", err)
+	}
+	if result.Updated {
+		t.Error("expected no update when the binary is gone")
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestSelfUpdate_YankedReleaseAborts(t *testing.T) {
+	// Listed as latest, but looking it up by tag now 404s
+	release := releaseWithAsset("v0.5.0")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest" {
+			json.NewEncoder(w).Encode(release)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	original := releasesURL
+	releasesURL = server.URL
+	t.Cleanup(func() { releasesURL = original })
+
+	downloads := 0
+	serveDownloads(t, func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("new binary"))
+	})
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	_, err := SelfUpdate()
+	if !errors.Is(err, ErrReleaseUnavailable) {
+		t.Fatalf("expected ErrReleaseUnavailable, got %v", err)
+	}
+	if downloads != 0 {
+		t.Errorf("expected no download of a yanked release, got %d", downloads)
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestSelfUpdate_InstallsRecheckedRelease(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.5.0"))
+	serveDownloads(t, serveBinary("new binary", sha256Hex("new binary")))
+	setVersion(t, "v0.4.0")
+	setKeepBackups(t, 1)
+	execPath := fakeExecutable(t)
+
+	result, err := SelfUpdate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Updated {
+		t.Error("expected update to be installed")
+	}
+	if data, _ := os.ReadFile(execPath); string(data) != "new binary" {
+		t.Errorf("expected new binary to be installed, got %q", data)
+	}
+}
+
+// =============================================================================
+// CHECKSUM TESTS
+// =============================================================================
+
+func TestVerifyChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "binary")
+	writeFile(t, path, "new binary")
+
+	if err := verifyChecksum(path, sha256Hex("new binary")); err != nil {
+		t.Errorf("expected matching checksum to verify, got %v", err)
+	}
+	if err := verifyChecksum(path, strings.ToUpper(sha256Hex("new binary"))); err != nil {
+		t.Errorf("expected checksum comparison to ignore case, got %v", err)
+	}
+	if err := verifyChecksum(path, sha256Hex("tampered")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if err := verifyChecksum(path, "not-a-checksum"); err == nil || errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected malformed checksum error, got %v", err)
+	}
+	if err := verifyChecksum(filepath.Join(t.TempDir(), "missing"), sha256Hex("new binary")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	sum := sha256Hex("binary")
+	checksums := sha256Hex("other") + "  antidote-agent-plan9-mips\n" + sum + " *antidote-agent-linux-amd64\n"
+
+	if got, err := parseChecksum(strings.NewReader(checksums), "antidote-agent-linux-amd64", false); err != nil || got != sum {
+		t.Errorf("expected %s, got %q (%v)", sum, got, err)
+	}
+	if _, err := parseChecksum(strings.NewReader(checksums), "antidote-agent-darwin-arm64", false); err == nil {
+		t.Error("expected error for unlisted asset")
+	}
+	if got, err := parseChecksum(strings.NewReader(strings.ToUpper(sum)+"\n"), "antidote-agent-linux-amd64", true); err != nil || got != sum {
+		t.Errorf("expected sidecar checksum %s, got %q (%v)", sum, got, err)
+	}
+	if _, err := parseChecksum(strings.NewReader("deadbeef  antidote-agent-linux-amd64\n"), "antidote-agent-linux-amd64", false); err == nil {
+		t.Error("expected error for malformed checksum")
+	}
+}
+
+func TestSelfUpdate_ChecksumMismatchAborts(t *testing.T) {
+	newReleaseServer(t, releaseWithAsset("v0.5.0"))
+	serveDownloads(t, serveBinary("tampered binary", sha256Hex("new binary")))
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	result, err := SelfUpdate()
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if result.Updated {
+		t.Error("expected no update when the checksum does not match")
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestSelfUpdate_MissingChecksumsAborts(t *testing.T) {
+	release := releaseWithAsset("v0.5.0")
+	release.Assets = append(release.Assets[:2], release.Assets[3])
+	newReleaseServer(t, release)
+	serveDownloads(t, serveBinary("new binary", sha256Hex("new binary")))
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	if _, err := SelfUpdate(); err == nil || !strings.Contains(err.Error(), ChecksumsAsset) {
+		t.Fatalf("expected missing checksums error, got %v", err)
+	}
+	assertUntouched(t, execPath)
+}
+
+func TestSelfUpdate_SidecarChecksum(t *testing.T) {
+	name := binaryAssetName()
+	release := releaseWithAsset("v0.5.0")
+	release.Assets[2] = Asset{
+		Name:               name + ".sha256",
+		BrowserDownloadURL: "https://github.com/" + GitHubRepo + "/releases/download/v0.5.0/" + name + ".sha256",
+	}
+	newReleaseServer(t, release)
+	serveDownloads(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".sha256") {
+			fmt.Fprintf(w, "%s  %s\n", sha256Hex("new binary"), name)
+			return
+		}
+		serveBinary("new binary", "")(w, r)
+	})
+	setVersion(t, "v0.4.0")
+	setKeepBackups(t, 1)
+	execPath := fakeExecutable(t)
+
+	if _, err := SelfUpdate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, _ := os.ReadFile(execPath); string(data) != "new binary" {
+		t.Errorf("expected new binary to be installed, got %q", data)
+	}
+}
+
+// =============================================================================
+// CHANGELOG TESTS
+// =============================================================================
+
+func TestCheckForUpdate_ReleaseNotes(t *testing.T) {
+	release := releaseWithAsset("v0.5.0")
+	release.Name = "v0.5.0 [security]"
+	release.Body = "## Changes\r\n\r\n- Fix command injection in deny patterns\r\n- Add --update-to\r\n"
+	release.PublishedAt = "2026-03-01T12:00:00Z"
+
+	newReleaseServer(t, release)
+	setVersion(t, "v0.4.0")
+
+	result, err := CheckForUpdate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !result.UpdateAvailable {
+		t.Error("expected update to be available")
+	}
+	if !result.SecurityRelease {
+		t.Error("expected security release to be detected from the release name")
+	}
+	if !strings.HasPrefix(result.ReleaseNotes, "## Changes") || !strings.Contains(result.ReleaseNotes, "- Add --update-to") {
+		t.Errorf("unexpected release notes %q", result.ReleaseNotes)
+	}
+	if want := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC); !result.PublishedAt.Equal(want) {
+		t.Errorf("expected published date %v, got %v", want, result.PublishedAt)
+	}
+}
+
+func TestIsSecurityRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  Release
+		expected bool
+	}{
+		{"marker in name", Release{Name: "v0.5.0 [Security]"}, true},
+		{"marker in body", Release{Body: "[security] Fixes CVE-2026-0001"}, true},
+		{"regular release", Release{Name: "v0.5.0", Body: "- Improve security validator docs"}, false},
+		{"empty", Release{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSecurityRelease(&tt.release); got != tt.expected {
+				t.Errorf("isSecurityRelease() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrimChangelog(t *testing.T) {
+	notes := "line1\n\nline2\r\nline3\nline4\n"
+
+	if got := TrimChangelog(notes, 10); got != "line1\nline2\nline3\nline4" {
+		t.Errorf("unexpected untrimmed changelog %q", got)
+	}
+	if got := TrimChangelog(notes, 2); got != "line1\nline2\n... (2 more lines)" {
+		t.Errorf("unexpected trimmed changelog %q", got)
+	}
+}
+
+func TestSetProxy(t *testing.T) {
+	original := httpClient
+	t.Cleanup(func() { httpClient = original })
+
+	proxyURL, _ := url.Parse("http://proxy.internal:3128")
+	SetProxy(proxyURL)
+
+	req, _ := http.NewRequest(http.MethodGet, GitHubAPIURL, nil)
+	got, err := httpClient.Transport.(*http.Transport).Proxy(req)
+	if err != nil || got == nil || got.String() != proxyURL.String() {
+		t.Errorf("expected GitHub requests to use %s, got %v (%v)", proxyURL, got, err)
+	}
+}
+
+func TestSetDisabled_RefusesUpdates(t *testing.T) {
+	SetDisabled(true)
+	t.Cleanup(func() { SetDisabled(false) })
+
+	newReleaseServer(t, releaseWithAsset("v0.5.0"))
+	serveDownloads(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected download of %s with self-update disabled", r.URL)
+	})
+	setVersion(t, "v0.4.0")
+	execPath := fakeExecutable(t)
+
+	if _, err := CheckForUpdate(); !errors.Is(err, ErrUpdatesDisabled) {
+		t.Errorf("expected CheckForUpdate to be refused, got %v", err)
+	}
+	result, err := SelfUpdate()
+	if !errors.Is(err, ErrUpdatesDisabled) {
+		t.Errorf("expected SelfUpdate to be refused, got %v", err)
+	}
+	if result.UpdateAvailable || result.Updated {
+		t.Errorf("expected no update, got %+v", result)
+	}
+	if _, err := UpdateTo("v0.5.0", false); !errors.Is(err, ErrUpdatesDisabled) {
+		t.Errorf("expected UpdateTo to be refused, got %v", err)
+	}
+	assertUntouched(t, execPath)
+
+	SetDisabled(false)
+	if result, err := CheckForUpdate(); err != nil || !result.UpdateAvailable {
+		t.Errorf("expected updates to work once re-enabled, got %+v (%v)", result, err)
+	}
+}